@@ -0,0 +1,42 @@
+package db
+
+import "fmt"
+
+type Cmd struct {
+	Init  InitCmd  `kong:"cmd,name='init',help='Create a database with the expected schema'"`
+	Check CheckCmd `kong:"cmd,name='check',help='Check a database has the expected schema'"`
+}
+
+// dbArgs are the arguments shared by the subcommands.
+type dbArgs struct {
+	Type string `kong:"arg,enum='nix-v10,binary-cache-v6,eval-cache-v5,fetcher-cache-v2',help='The kind of database (${enum})'"`
+	Path string `kong:"arg,help='The database file, or the database directory (like /nix/var/nix/db) for nix-v10'"`
+}
+
+type InitCmd struct {
+	dbArgs
+}
+
+func (cmd *InitCmd) Run() error {
+	db, err := openDB(cmd.Type, cmd.Path, true)
+	if err != nil {
+		return err
+	}
+
+	return db.Close()
+}
+
+type CheckCmd struct {
+	dbArgs
+}
+
+func (cmd *CheckCmd) Run() error {
+	db, err := openDB(cmd.Type, cmd.Path, false)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: ok\n", cmd.Path)
+
+	return db.Close()
+}
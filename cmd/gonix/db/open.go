@@ -0,0 +1,48 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+
+	"github.com/nix-community/go-nix/pkg/sqlite"
+)
+
+// openDB opens the database of the given type at path, creating it if init is set.
+func openDB(typ string, path string, init bool) (*sql.DB, error) {
+	var (
+		db  *sql.DB
+		err error
+	)
+
+	switch typ {
+	case "nix-v10":
+		if init {
+			db, _, err = sqlite.InitNixV10(path)
+		} else {
+			db, _, err = sqlite.NixV10Strict(fmt.Sprintf("file:%s?mode=ro", filepath.Join(path, "db.sqlite")))
+		}
+	case "binary-cache-v6":
+		if init {
+			db, _, err = sqlite.InitBinaryCacheV6(path)
+		} else {
+			db, _, err = sqlite.BinaryCacheV6Strict(fmt.Sprintf("file:%s?mode=ro", path))
+		}
+	case "eval-cache-v5":
+		if init {
+			db, _, err = sqlite.InitEvalCacheV5(path)
+		} else {
+			db, _, err = sqlite.EvalCacheV5Strict(fmt.Sprintf("file:%s?mode=ro", path))
+		}
+	case "fetcher-cache-v2":
+		if init {
+			db, _, err = sqlite.InitFetcherCacheV2(path)
+		} else {
+			db, _, err = sqlite.FetcherCacheV2Strict(fmt.Sprintf("file:%s?mode=ro", path))
+		}
+	default:
+		return nil, fmt.Errorf("unknown database type %v", typ)
+	}
+
+	return db, err
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/nix-community/go-nix/pkg/derivation"
+	"github.com/nix-community/go-nix/pkg/drvname"
 )
 
 type Cmd struct {
@@ -16,8 +17,9 @@ type Cmd struct {
 }
 
 type ShowCmd struct {
-	Drv    string `kong:"arg,type='string',help='Path to the Derivation'"`
-	Format string `kong:"default='json-pretty',help='The format to use to show (aterm,json-pretty,json)'"`
+	Drv      string `kong:"arg,type='string',help='Path to the Derivation'"`
+	Format   string `kong:"default='json-pretty',help='The format to use to show (aterm,json-pretty,json)'"`
+	NameOnly bool   `kong:"help='Only print the name and version of the derivation, separated by a tab'"`
 }
 
 func (cmd *ShowCmd) Run(drvCmd *Cmd) error {
@@ -28,6 +30,14 @@ func (cmd *ShowCmd) Run(drvCmd *Cmd) error {
 		return err
 	}
 
+	if cmd.NameOnly {
+		n := drvname.Parse(drv.Name())
+
+		_, err = fmt.Printf("%s\t%s\n", n.Name, n.Version)
+
+		return err
+	}
+
 	// Keep in mind `nix show-derivation` started sorting all of the JSON alphabetically,
 	// while this still preserves the previous order of keys, as  encoding/json
 	// preserves struct element definition order when serializing.
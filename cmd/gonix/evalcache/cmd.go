@@ -0,0 +1,17 @@
+package evalcache
+
+import (
+	"fmt"
+
+	"github.com/nix-community/go-nix/pkg/evalcache"
+)
+
+type Cmd struct {
+	Ls  LsCmd  `kong:"cmd,name='ls',help='List cached attributes'"`
+	Get GetCmd `kong:"cmd,name='get',help='Print a cached attribute'"`
+}
+
+// openCache opens the eval cache at path read-only.
+func openCache(path string) (*evalcache.Cache, error) {
+	return evalcache.Open(fmt.Sprintf("file:%s?mode=ro", path))
+}
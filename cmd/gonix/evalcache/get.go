@@ -0,0 +1,55 @@
+package evalcache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/evalcache"
+)
+
+type GetCmd struct {
+	Cache    string `kong:"arg,type='existingfile',help='Path to the eval cache (~/.cache/nix/eval-cache-v5/….sqlite)'"`
+	AttrPath string `kong:"arg,help='The attribute to print, like packages.x86_64-linux.hello.drvPath'"`
+	Context  bool   `kong:"help='Also print the context of strings, one element per line'"`
+}
+
+func (cmd *GetCmd) Run() error {
+	c, err := openCache(cmd.Cache)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	path, err := evalcache.ParseAttrPath(cmd.AttrPath)
+	if err != nil {
+		return err
+	}
+
+	attr, err := c.Get(context.Background(), path)
+	if err != nil {
+		return err
+	}
+
+	switch attr.Type { //nolint:exhaustive
+	case evalcache.AttrString:
+		// print strings as is, so they can be used in scripts
+		fmt.Println(attr.String)
+
+		if cmd.Context {
+			for _, elem := range attr.Context {
+				fmt.Println(elem)
+			}
+		}
+	case evalcache.AttrListOfStrings:
+		fmt.Println(strings.Join(attr.Strings, "\n"))
+	case evalcache.AttrFailed:
+		return fmt.Errorf("evaluation of %v failed when it was cached", cmd.AttrPath)
+	case evalcache.AttrMissing:
+		return fmt.Errorf("attribute %v is missing", cmd.AttrPath)
+	default:
+		fmt.Println(attr.FormatValue())
+	}
+
+	return nil
+}
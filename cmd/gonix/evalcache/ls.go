@@ -0,0 +1,67 @@
+package evalcache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/nix-community/go-nix/pkg/evalcache"
+)
+
+type LsCmd struct {
+	Cache     string `kong:"arg,type='existingfile',help='Path to the eval cache (~/.cache/nix/eval-cache-v5/….sqlite)'"`
+	AttrPath  string `kong:"arg,optional,help='The attribute to list, like packages.x86_64-linux'"`
+	Recursive bool   `kong:"short='R',help='List all cached descendants'"`
+}
+
+func (cmd *LsCmd) Run() error {
+	ctx := context.Background()
+
+	c, err := openCache(cmd.Cache)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	path, err := evalcache.ParseAttrPath(cmd.AttrPath)
+	if err != nil {
+		return err
+	}
+
+	attr, err := c.Get(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+
+	printAttr := func(relPath []string, a *evalcache.Attr) {
+		fmt.Fprintf(w, "%s\t%s\t%s\n",
+			evalcache.FormatAttrPath(append(path[:len(path):len(path)], relPath...)),
+			a.Type, a.FormatValue())
+	}
+
+	if cmd.Recursive {
+		err = c.Walk(ctx, attr, func(relPath []string, a *evalcache.Attr) error {
+			if len(relPath) != 0 {
+				printAttr(relPath, a)
+			}
+
+			return nil
+		})
+	} else {
+		var children []*evalcache.Attr
+
+		children, err = c.Children(ctx, attr)
+		for _, child := range children {
+			printAttr([]string{child.Name}, child)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,43 @@
+package fetchercache
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/nix-community/go-nix/pkg/fetchercache"
+)
+
+type Cmd struct {
+	Ls    LsCmd    `kong:"cmd,name='ls',help='List cache entries'"`
+	Prune PruneCmd `kong:"cmd,name='prune',help='Delete expired cache entries'"`
+}
+
+// cacheFlags are the flags shared by the subcommands.
+type cacheFlags struct {
+	Cache string        `kong:"help='Path to the fetcher cache (default: ~/.cache/nix/fetcher-cache-v2.sqlite)'"`
+	TTL   time.Duration `kong:"name='ttl',default='1h',help='Time after which entries expire, like the tarball-ttl setting'"`
+}
+
+// open opens the fetcher cache, read-only unless write is set.
+func (f *cacheFlags) open(write bool) (*fetchercache.Cache, error) {
+	path := f.Cache
+	if path == "" {
+		path = filepath.Join(xdg.CacheHome, "nix", "fetcher-cache-v2.sqlite")
+	}
+
+	dsn := path
+	if !write {
+		dsn = fmt.Sprintf("file:%s?mode=ro", path)
+	}
+
+	c, err := fetchercache.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	c.TTL = f.TTL
+
+	return c, nil
+}
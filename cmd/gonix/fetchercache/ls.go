@@ -0,0 +1,51 @@
+package fetchercache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+type LsCmd struct {
+	cacheFlags
+	Domain  string `kong:"help='Only list entries of this domain, like file, tarball or gitRevCount'"`
+	Expired bool   `kong:"help='Only list expired entries'"`
+}
+
+func (cmd *LsCmd) Run() error {
+	c, err := cmd.open(false)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	entries, err := c.Entries(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+
+	for _, e := range entries {
+		if cmd.Domain != "" && e.Domain != cmd.Domain {
+			continue
+		}
+
+		expired := c.Expired(e.Timestamp)
+		if cmd.Expired && !expired {
+			continue
+		}
+
+		status := "valid"
+		if expired {
+			status = "expired"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			e.Domain, e.Key, e.Value, e.Timestamp.UTC().Format(time.RFC3339), status)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,38 @@
+package fetchercache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type PruneCmd struct {
+	cacheFlags
+	OlderThan time.Duration `kong:"help='Delete the entries older than this, instead of the expired ones'"`
+}
+
+func (cmd *PruneCmd) Run() error {
+	ctx := context.Background()
+
+	c, err := cmd.open(true)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var n int64
+
+	if cmd.OlderThan != 0 {
+		n, err = c.Prune(ctx, time.Now().Add(-cmd.OlderThan))
+	} else {
+		n, err = c.PruneExpired(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("deleted %d entries\n", n)
+
+	return nil
+}
@@ -0,0 +1,6 @@
+package key
+
+type Cmd struct {
+	Generate GenerateCmd `kong:"cmd,name='generate',help='Generate a secret key for signing store paths'"`
+	ToPublic ToPublicCmd `kong:"cmd,name='to-public',help='Print the public key of the secret key read from stdin'"`
+}
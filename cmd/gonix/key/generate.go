@@ -0,0 +1,29 @@
+package key
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+type GenerateCmd struct {
+	KeyName string `kong:"name='key-name',required,help='The name of the key, usually the host name of the cache with a suffix, like cache.example.org-1'"`
+}
+
+func (cmd *GenerateCmd) Run() error {
+	// the name is separated from the key by a colon
+	if cmd.KeyName == "" || strings.Contains(cmd.KeyName, ":") {
+		return fmt.Errorf("invalid key name '%s'", cmd.KeyName)
+	}
+
+	sk, _, err := signature.GenerateKeypair(cmd.KeyName, nil)
+	if err != nil {
+		return err
+	}
+
+	// like nix key generate-secret, there's no trailing newline
+	fmt.Print(sk.String())
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package key
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+// LoadSecretKeyFile reads the secret key in the file at p.
+func LoadSecretKeyFile(p string) (signature.SecretKey, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return signature.SecretKey{}, err
+	}
+
+	sk, err := signature.LoadSecretKey(strings.TrimSpace(string(b)))
+	if err != nil {
+		return signature.SecretKey{}, fmt.Errorf("unable to load secret key from %v: %w", p, err)
+	}
+
+	return sk, nil
+}
+
+// ExternalSignerFlags are the flags to sign with a key held by another
+// process, shared by the commands signing narinfos.
+type ExternalSignerFlags struct {
+	SignerCommand   string `kong:"name='signer-command',xor='signer',help='Sign by running this command (split on spaces), which reads the fingerprint on stdin and prints the signature'"`
+	SignerSocket    string `kong:"name='signer-socket',xor='signer',help='Sign by sending the fingerprint line to this Unix socket, which replies with the signature'"`
+	SignerPublicKey string `kong:"name='signer-public-key',help='The public key of the external signer'"`
+}
+
+// Enabled returns true if an external signer is configured.
+func (f *ExternalSignerFlags) Enabled() bool {
+	return f.SignerCommand != "" || f.SignerSocket != ""
+}
+
+// Signer returns the external signer configured by the flags.
+func (f *ExternalSignerFlags) Signer() (*signature.CryptoSigner, error) {
+	if f.SignerPublicKey == "" {
+		return nil, fmt.Errorf("--signer-public-key is required with an external signer")
+	}
+
+	pub, err := signature.ParsePublicKey(f.SignerPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --signer-public-key: %w", err)
+	}
+
+	var e *signature.ExternalSigner
+
+	if f.SignerSocket != "" {
+		e = signature.NewSocketSigner(pub, f.SignerSocket)
+	} else {
+		args := strings.Fields(f.SignerCommand)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("--signer-command is empty")
+		}
+
+		e = signature.NewCommandSigner(pub, args[0], args[1:]...)
+	}
+
+	return signature.NewCryptoSigner(pub.Name, e)
+}
@@ -0,0 +1,29 @@
+package key
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+type ToPublicCmd struct{}
+
+func (cmd *ToPublicCmd) Run() error {
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	sk, err := signature.LoadSecretKey(strings.TrimSpace(string(b)))
+	if err != nil {
+		return err
+	}
+
+	// like nix key convert-secret-to-public, there's no trailing newline
+	fmt.Print(sk.ToPublicKey().String())
+
+	return nil
+}
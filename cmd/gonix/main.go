@@ -4,14 +4,28 @@ import (
 	"os"
 
 	"github.com/alecthomas/kong"
+	"github.com/nix-community/go-nix/cmd/gonix/db"
 	"github.com/nix-community/go-nix/cmd/gonix/drv"
+	"github.com/nix-community/go-nix/cmd/gonix/evalcache"
+	"github.com/nix-community/go-nix/cmd/gonix/fetchercache"
+	"github.com/nix-community/go-nix/cmd/gonix/key"
 	"github.com/nix-community/go-nix/cmd/gonix/nar"
+	"github.com/nix-community/go-nix/cmd/gonix/narinfo"
+	"github.com/nix-community/go-nix/cmd/gonix/store"
 )
 
 //nolint:gochecknoglobals
 var cli struct {
-	Nar nar.Cmd `kong:"cmd,name='nar',help='Create or inspect NAR files'"`
-	Drv drv.Cmd `kong:"cmd,name='drv',help='Inspect NAR files'"`
+	Nar          nar.Cmd             `kong:"cmd,name='nar',help='Create or inspect NAR files'"`
+	Drv          drv.Cmd             `kong:"cmd,name='drv',help='Inspect NAR files'"`
+	Store        store.Cmd           `kong:"cmd,name='store',help='Export and import store paths'"`
+	Copy         store.CopyCmd       `kong:"cmd,name='copy',help='Copy store paths into a binary cache'"`
+	ServeCache   store.ServeCacheCmd `kong:"cmd,name='serve-cache',help='Serve the local store as a binary cache over HTTP'"`
+	Key          key.Cmd             `kong:"cmd,name='key',help='Generate and convert signing keys'"`
+	NarInfo      narinfo.Cmd         `kong:"cmd,name='narinfo',help='Sign or verify .narinfo files'"`
+	DB           db.Cmd              `kong:"cmd,name='db',help='Create or check Nix databases'"`
+	EvalCache    evalcache.Cmd       `kong:"cmd,name='eval-cache',help='Inspect flake evaluation caches'"`
+	FetcherCache fetchercache.Cmd    `kong:"cmd,name='fetcher-cache',help='Inspect or prune the fetcher cache'"`
 }
 
 func main() {
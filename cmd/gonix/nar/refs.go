@@ -0,0 +1,186 @@
+package nar
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/derivation"
+	"github.com/nix-community/go-nix/pkg/sqlite"
+	"github.com/nix-community/go-nix/pkg/storepath/references"
+)
+
+type RefsCmd struct {
+	Nar string `kong:"arg,type='existingfile',help='Path to the NAR'"`
+
+	CandidatesFile string           `kong:"xor='candidates',type='existingfile',help='Read candidate store paths from a file, one per line'"`
+	Drv            string           `kong:"xor='candidates',help='Use the input closure and outputs of this derivation as candidates'"`
+	DrvStore       derivation.Store `kong:"type='drv-store-uri',default='',help='Path where derivations are read from.'"`
+	NixDB          string           `kong:"xor='candidates',name='nix-db',type='existingfile',help='Use all valid paths in this Nix database (nix_v10) as candidates'"`
+
+	Files  bool   `kong:"help='Also show the files inside the NAR containing each reference'"`
+	Format string `kong:"default='text',enum='text,json',help='The format to use to show (text,json)'"`
+}
+
+func (cmd *RefsCmd) Run() error {
+	ctx := context.Background()
+
+	candidates, err := cmd.candidates(ctx)
+	if err != nil {
+		return err
+	}
+
+	refScanner, err := references.NewReferenceScanner(candidates)
+	if err != nil {
+		return err
+	}
+
+	// hits are only needed to show files, and can take a lot of memory
+	if cmd.Files {
+		refScanner.RecordHits()
+	}
+
+	f, err := os.Open(cmd.Nar)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = refScanner.ScanNAR(f)
+	if err != nil {
+		return fmt.Errorf("error scanning NAR: %w", err)
+	}
+
+	// collect the (unique) files containing each reference, Hits is empty
+	// without --files
+	files := make(map[string][]string)
+
+	for _, hit := range refScanner.Hits() {
+		paths := files[hit.StorePath]
+		if len(paths) == 0 || paths[len(paths)-1] != hit.Path {
+			files[hit.StorePath] = append(paths, hit.Path)
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+
+	switch cmd.Format {
+	case "json":
+		enc := json.NewEncoder(w)
+		if cmd.Files {
+			err = enc.Encode(files)
+		} else {
+			err = enc.Encode(refScanner.References())
+		}
+	default:
+		// errors are sticky in bufio.Writer, and returned on Flush.
+		for _, ref := range refScanner.References() {
+			if cmd.Files {
+				for _, p := range files[ref] {
+					fmt.Fprintf(w, "%s\t%s\n", ref, p)
+				}
+			} else {
+				fmt.Fprintln(w, ref)
+			}
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// candidates returns the list of candidate store paths, from whichever source was specified.
+func (cmd *RefsCmd) candidates(ctx context.Context) ([]string, error) {
+	switch {
+	case cmd.CandidatesFile != "":
+		return readCandidatesFile(cmd.CandidatesFile)
+	case cmd.Drv != "":
+		return drvCandidates(ctx, cmd.DrvStore, cmd.Drv)
+	case cmd.NixDB != "":
+		db, queries, err := sqlite.NixV10Strict(fmt.Sprintf("file:%s?mode=ro", cmd.NixDB))
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+
+		return queries.QueryValidPaths(ctx)
+	default:
+		return nil, fmt.Errorf("one of --candidates-file, --drv or --nix-db is required")
+	}
+}
+
+// readCandidatesFile reads store paths from a file, one per line.
+// Empty lines are ignored.
+func readCandidatesFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var candidates []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			candidates = append(candidates, line)
+		}
+	}
+
+	return candidates, scanner.Err()
+}
+
+// drvCandidates returns the outputs of the derivation at drvPath,
+// as well as all input sources and outputs of its (transitive) input derivations.
+// Outputs without a known path (content-addressed derivations) are skipped.
+func drvCandidates(ctx context.Context, drvStore derivation.Store, drvPath string) ([]string, error) {
+	seen := make(map[string]struct{})
+	candidates := make(map[string]struct{})
+
+	queue := []string{drvPath}
+	seen[drvPath] = struct{}{}
+
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		drv, err := drvStore.Get(ctx, p)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get derivation %v: %w", p, err)
+		}
+
+		for _, o := range drv.Outputs {
+			if o.Path != "" {
+				candidates[o.Path] = struct{}{}
+			}
+		}
+
+		for _, src := range drv.InputSources {
+			candidates[src] = struct{}{}
+		}
+
+		for inputDrvPath := range drv.InputDerivations {
+			if _, ok := seen[inputDrvPath]; !ok {
+				seen[inputDrvPath] = struct{}{}
+				queue = append(queue, inputDrvPath)
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(candidates))
+	for p := range candidates {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
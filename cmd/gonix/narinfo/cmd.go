@@ -0,0 +1,6 @@
+package narinfo
+
+type Cmd struct {
+	Sign   SignCmd   `kong:"cmd,name='sign',help='Sign .narinfo files in place'"`
+	Verify VerifyCmd `kong:"cmd,name='verify',help='Check .narinfo files are signed by a trusted key'"`
+}
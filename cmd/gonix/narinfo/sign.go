@@ -0,0 +1,133 @@
+package narinfo
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nix-community/go-nix/cmd/gonix/key"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+type SignCmd struct {
+	KeyFiles []string `kong:"name='key-file',type='existingfile',help='Sign with the secret key in this file, can be repeated'"`
+	Files    []string `kong:"arg,type='existingfile',help='The .narinfo files to sign'"`
+
+	key.ExternalSignerFlags
+}
+
+func (cmd *SignCmd) Run() error {
+	keys := make([]signature.Signer, 0, len(cmd.KeyFiles)+1)
+
+	for _, p := range cmd.KeyFiles {
+		sk, err := key.LoadSecretKeyFile(p)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, sk)
+	}
+
+	if cmd.Enabled() {
+		signer, err := cmd.Signer()
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, signer)
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("no key to sign with, use --key-file or an external signer")
+	}
+
+	for _, p := range cmd.Files {
+		if err := signFile(p, keys); err != nil {
+			return fmt.Errorf("unable to sign %v: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+// signFile adds the signatures of keys to the narinfo at p. The narinfo
+// keeps its fields and their order, new Sig: lines go after the existing ones.
+func signFile(p string, keys []signature.Signer) error {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+
+	ni, err := narinfo.Parse(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	if err := ni.Check(); err != nil {
+		return err
+	}
+
+	if ni.NarHash == nil {
+		return fmt.Errorf("narinfo has no NarHash")
+	}
+
+	for _, sk := range keys {
+		sig, err := sk.Sign(nil, ni.Fingerprint())
+		if err != nil {
+			return err
+		}
+
+		if !hasSignature(ni.Signatures, sig) {
+			ni.Signatures = append(ni.Signatures, sig)
+		}
+	}
+
+	return writeFileAtomic(p, []byte(ni.String()))
+}
+
+// writeFileAtomic writes contents to a temporary file next to p, and renames
+// it to p, so a crash never leaves a truncated file. The permissions of the
+// existing file are kept.
+func writeFileAtomic(p string, contents []byte) error {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(contents); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(f.Name(), fi.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), p)
+}
+
+func hasSignature(sigs []signature.Signature, sig signature.Signature) bool {
+	for _, s := range sigs {
+		if s.String() == sig.String() {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,101 @@
+package narinfo
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+type VerifyCmd struct {
+	TrustedPublicKeys string   `kong:"name='trusted-public-keys',xor='policy',help='The public keys to trust, separated by spaces'"`
+	Policy            string   `kong:"name='policy',xor='policy',type='existingfile',help='Read the keys to trust, and the number of signatures required, from this file'"`
+	Files             []string `kong:"arg,type='existingfile',help='The .narinfo files to verify'"`
+}
+
+func (cmd *VerifyCmd) Run() error {
+	policy, err := cmd.loadPolicy()
+	if err != nil {
+		return err
+	}
+
+	untrusted := 0
+
+	for _, p := range cmd.Files {
+		report, err := verifyFile(p, policy)
+		if err != nil {
+			return fmt.Errorf("unable to verify %v: %w", p, err)
+		}
+
+		if report.Trusted() {
+			fmt.Printf("%v: trusted\n", p)
+		} else {
+			fmt.Printf("%v: untrusted, %v\n", p, report.Err())
+
+			untrusted++
+		}
+
+		for _, res := range report.Results {
+			fmt.Printf("  %v: %v\n", res.Signature.Name, res.Status)
+		}
+	}
+
+	if untrusted != 0 {
+		return fmt.Errorf("%d of %d narinfos are untrusted", untrusted, len(cmd.Files))
+	}
+
+	return nil
+}
+
+func (cmd *VerifyCmd) loadPolicy() (*signature.Policy, error) {
+	if cmd.Policy == "" {
+		pubKeys, err := signature.ParseTrustedPublicKeys(cmd.TrustedPublicKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(pubKeys) == 0 {
+			return nil, fmt.Errorf("either --trusted-public-keys or --policy is required")
+		}
+
+		return signature.NewPolicy(pubKeys), nil
+	}
+
+	f, err := os.Open(cmd.Policy)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	policy, err := signature.ParsePolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load %v: %w", cmd.Policy, err)
+	}
+
+	return policy, nil
+}
+
+// verifyFile checks the signatures of the narinfo at p against policy.
+func verifyFile(p string, policy *signature.Policy) (*signature.Report, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ni, err := narinfo.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ni.Check(); err != nil {
+		return nil, err
+	}
+
+	if ni.NarHash == nil {
+		return nil, fmt.Errorf("narinfo has no NarHash")
+	}
+
+	return ni.CheckSignatures(policy), nil
+}
@@ -0,0 +1,6 @@
+package store
+
+type Cmd struct {
+	Export ExportCmd `kong:"cmd,name='export',help='Serialise store paths to stdout in nix-store --export format'"`
+	Import ImportCmd `kong:"cmd,name='import',help='Import the output of nix-store --export into a binary cache'"`
+}
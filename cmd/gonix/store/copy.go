@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/nix-community/go-nix/cmd/gonix/key"
+	"github.com/nix-community/go-nix/pkg/binarycache"
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/nixdb"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+type CopyCmd struct {
+	Paths           []string `kong:"arg,help='The store paths to copy, with their closure'"`
+	To              string   `kong:"required,help='The binary cache to copy to (file://…)'"`
+	NixDB           string   `kong:"name='nix-db',default='/nix/var/nix/db/db.sqlite',help='Path to the Nix database (nix_v10) to read path metadata from'"`
+	Compression     string   `kong:"enum='none,xz,zstd,bzip2',default='xz',help='Compress NARs with this method (none, xz, zstd, bzip2)'"`
+	SecretKeyFile   string   `kong:"name='secret-key-file',xor='signer',help='Sign narinfos with the secret key in this file'"`
+	WriteNARListing bool     `kong:"name='write-nar-listing',help='Write a .ls listing of the contents of each NAR'"`
+
+	key.ExternalSignerFlags
+}
+
+func (cmd *CopyCmd) Run() error {
+	ctx := context.Background()
+
+	w, err := binarycache.NewWriter(cmd.To)
+	if err != nil {
+		return err
+	}
+
+	w.Compression = cmd.Compression
+	w.WriteNARListing = cmd.WriteNARListing
+
+	switch {
+	case cmd.SecretKeyFile != "":
+		if w.Signer, err = key.LoadSecretKeyFile(cmd.SecretKeyFile); err != nil {
+			return err
+		}
+	case cmd.Enabled():
+		if w.Signer, err = cmd.Signer(); err != nil {
+			return err
+		}
+	}
+
+	db, err := nixdb.Open(fmt.Sprintf("file:%s?mode=ro", cmd.NixDB), nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	closure, err := db.QueryClosure(ctx, cmd.Paths)
+	if err != nil {
+		return err
+	}
+
+	infos := make(map[string]*nixdb.PathInfo, len(closure))
+
+	for _, p := range closure {
+		info, err := db.QueryPathInfo(ctx, p)
+		if err != nil {
+			return err
+		}
+
+		infos[p] = info
+	}
+
+	// copy references first, so the binary cache never refers to missing paths
+	for _, p := range topoSort(closure, func(p string) []string { return infos[p].References }) {
+		if err := copyPath(ctx, w, infos[p]); err != nil {
+			return fmt.Errorf("unable to copy %v: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+// copyPath adds the store path described by info to w, unless it's there already.
+func copyPath(ctx context.Context, w *binarycache.Writer, info *nixdb.PathInfo) error {
+	sp, err := storepath.FromAbsolutePath(info.Path)
+	if err != nil {
+		return err
+	}
+
+	has, err := w.Has(sp.String()[:32])
+	if err != nil || has {
+		return err
+	}
+
+	ni := &narinfo.NarInfo{
+		StorePath:  info.Path,
+		NarHash:    nixhash.MustNewHashWithEncoding(info.NarHash.Algo(), info.NarHash.Digest(), nixhash.NixBase32, true),
+		Signatures: info.Signatures,
+	}
+
+	for _, ref := range info.References {
+		ni.References = append(ni.References, path.Base(ref))
+	}
+
+	if info.Deriver != "" {
+		ni.Deriver = path.Base(info.Deriver)
+	}
+
+	if info.CA != nil {
+		ni.CA = info.CA.String()
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(nar.DumpPath(pw, info.Path))
+	}()
+
+	_, err = w.AddNar(ctx, ni, pr)
+
+	// unblock the dumping goroutine, in case adding failed.
+	pr.Close()
+
+	return err
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nix-community/go-nix/pkg/export"
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/sqlite"
+)
+
+type ExportCmd struct {
+	Paths []string `kong:"arg,help='The store paths to export'"`
+	NixDB string   `kong:"name='nix-db',default='/nix/var/nix/db/db.sqlite',help='Path to the Nix database (nix_v10) to read path metadata from'"`
+}
+
+func (cmd *ExportCmd) Run() error {
+	ctx := context.Background()
+
+	db, queries, err := sqlite.NixV10Strict(fmt.Sprintf("file:%s?mode=ro", cmd.NixDB))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	entries := make(map[string]*export.Entry, len(cmd.Paths))
+
+	for _, p := range cmd.Paths {
+		info, err := queries.QueryPathInfo(ctx, p)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("path '%s' is not valid", p)
+			}
+
+			return err
+		}
+
+		references, err := queries.QueryReferences(ctx, info.ID)
+		if err != nil {
+			return err
+		}
+
+		entries[p] = &export.Entry{
+			Path:       p,
+			References: references,
+			Deriver:    info.Deriver.String,
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	ew := export.NewWriter(w)
+
+	for _, p := range topoSort(cmd.Paths, func(p string) []string { return entries[p].References }) {
+		if err := writeEntry(ew, entries[p]); err != nil {
+			return fmt.Errorf("unable to export %v: %w", p, err)
+		}
+	}
+
+	if err := ew.Close(); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// writeEntry writes e, with the NAR serialization of its path.
+func writeEntry(ew *export.Writer, e *export.Entry) error {
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(nar.DumpPath(pw, e.Path))
+	}()
+
+	err := ew.WriteEntry(e, pr)
+
+	// unblock the dumping goroutine, in case writing failed.
+	pr.Close()
+
+	return err
+}
+
+// topoSort sorts paths so that references come before their referrers,
+// as Nix does when exporting.
+// Only references contained in paths are considered.
+func topoSort(paths []string, references func(p string) []string) []string {
+	sorted := make([]string, 0, len(paths))
+	visited := make(map[string]bool, len(paths))
+
+	inPaths := make(map[string]struct{}, len(paths))
+	for _, p := range paths {
+		inPaths[p] = struct{}{}
+	}
+
+	var visit func(p string)
+	visit = func(p string) {
+		if visited[p] {
+			return
+		}
+
+		visited[p] = true
+
+		for _, ref := range references(p) {
+			if _, ok := inPaths[ref]; ok {
+				visit(ref)
+			}
+		}
+
+		sorted = append(sorted, p)
+	}
+
+	for _, p := range paths {
+		visit(p)
+	}
+
+	return sorted
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nix-community/go-nix/pkg/binarycache"
+	"github.com/nix-community/go-nix/pkg/export"
+)
+
+type ImportCmd struct {
+	File        string `kong:"arg,optional,type='existingfile',help='Read the export from this file instead of stdin'"`
+	To          string `kong:"required,help='The binary cache to import into (file://…)'"`
+	Compression string `kong:"enum='none,xz,zstd,bzip2',default='xz',help='Compress NARs with this method (none, xz, zstd, bzip2)'"`
+}
+
+func (cmd *ImportCmd) Run() error {
+	ctx := context.Background()
+
+	w, err := binarycache.NewWriter(cmd.To)
+	if err != nil {
+		return err
+	}
+
+	w.Compression = cmd.Compression
+
+	var r io.Reader = os.Stdin
+
+	if cmd.File != "" {
+		f, err := os.Open(cmd.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	er := export.NewReader(bufio.NewReader(r))
+
+	for {
+		e, err := importEntry(ctx, er, w)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		// print imported paths, like nix-store --import does.
+		fmt.Println(e.Path)
+	}
+}
+
+// importEntry reads the next entry from er, and adds it to w.
+func importEntry(ctx context.Context, er *export.Reader, w *binarycache.Writer) (*export.Entry, error) {
+	// the store path of the NAR comes after it in the export, so write it
+	// to a temporary file first.
+	f, err := os.CreateTemp("", "gonix-import-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+
+	e, err := er.Next(bw)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	ni, err := e.NarInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.AddNar(ctx, ni, bufio.NewReader(f)); err != nil {
+		return nil, fmt.Errorf("unable to import %v: %w", e.Path, err)
+	}
+
+	return e, nil
+}
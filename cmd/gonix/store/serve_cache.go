@@ -0,0 +1,57 @@
+package store
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nix-community/go-nix/cmd/gonix/key"
+	"github.com/nix-community/go-nix/pkg/binarycache"
+	"github.com/nix-community/go-nix/pkg/nixdb"
+)
+
+type ServeCacheCmd struct {
+	Listen        string `kong:"short='l',default=':5000',help='The address to listen on'"`
+	NixDB         string `kong:"name='nix-db',default='/nix/var/nix/db/db.sqlite',help='Path to the Nix database (nix_v10) to read path metadata from'"`
+	StoreDir      string `kong:"name='store-dir',default='/nix/store',type='existingdir',help='The directory to read store paths from'"`
+	LogDir        string `kong:"name='log-dir',default='/nix/var/log/nix',help='The directory to read build logs from'"`
+	SecretKeyFile string `kong:"name='secret-key-file',xor='signer',help='Sign narinfos with the secret key in this file'"`
+	Priority      int    `kong:"default='30',help='The priority advertised in nix-cache-info'"`
+
+	key.ExternalSignerFlags
+}
+
+func (cmd *ServeCacheCmd) Run() error {
+	db, err := nixdb.Open(fmt.Sprintf("file:%s?mode=ro", cmd.NixDB), nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	s := binarycache.NewServer(db)
+	s.RealStoreDir = cmd.StoreDir
+	s.LogDir = cmd.LogDir
+	s.Priority = cmd.Priority
+
+	switch {
+	case cmd.SecretKeyFile != "":
+		if s.Signer, err = key.LoadSecretKeyFile(cmd.SecretKeyFile); err != nil {
+			return err
+		}
+	case cmd.Enabled():
+		if s.Signer, err = cmd.Signer(); err != nil {
+			return err
+		}
+	}
+
+	srv := &http.Server{
+		Addr:              cmd.Listen,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	fmt.Fprintf(os.Stderr, "serving the binary cache on %v\n", cmd.Listen)
+
+	return srv.ListenAndServe()
+}
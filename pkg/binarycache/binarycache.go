@@ -0,0 +1,37 @@
+// Package binarycache implements access to Nix binary caches, and the disk
+// cache Nix keeps of their contents in ~/.cache/nix/binary-cache-v6.sqlite.
+package binarycache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+)
+
+// ErrNotFound is returned when a binary cache doesn't have a narinfo.
+var ErrNotFound = errors.New("not found in binary cache")
+
+// Getter is a binary cache narinfos can be looked up from.
+type Getter interface {
+	// URL returns the URL of the binary cache, like https://cache.nixos.org.
+	URL() string
+	// CacheInfo returns the info of the binary cache, from its
+	// nix-cache-info file.
+	CacheInfo(ctx context.Context) (*CacheInfo, error)
+	// GetNarInfo returns the narinfo of the store path with the given hash
+	// part, or ErrNotFound if the binary cache doesn't have it.
+	GetNarInfo(ctx context.Context, hashPart string) (*narinfo.NarInfo, error)
+}
+
+// CacheInfo describes a binary cache.
+type CacheInfo struct {
+	// StoreDir is the store directory of the paths in the binary cache.
+	StoreDir string
+	// WantMassQuery is set if the binary cache should be queried for many
+	// paths at once, like when evaluating what to substitute.
+	WantMassQuery bool
+	// Priority is the priority of the binary cache, lower values are
+	// preferred.
+	Priority int
+}
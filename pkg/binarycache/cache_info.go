@@ -0,0 +1,92 @@
+package binarycache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CacheInfoFile is the path of the file describing a binary cache,
+// relative to its root.
+const CacheInfoFile = "nix-cache-info"
+
+// ParseCacheInfo parses a nix-cache-info file, like:
+//
+//	StoreDir: /nix/store
+//	WantMassQuery: 1
+//	Priority: 40
+//
+// Unknown keys and lines without a colon are ignored, like Nix does. Only
+// StoreDir and Priority are validated.
+func ParseCacheInfo(r io.Reader) (*CacheInfo, error) {
+	info := &CacheInfo{}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// like Nix, skip lines without a colon
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+
+		v = strings.TrimSpace(v)
+
+		switch strings.TrimSpace(k) {
+		case "StoreDir":
+			if !strings.HasPrefix(v, "/") {
+				return nil, fmt.Errorf("invalid StoreDir in %v: %v", CacheInfoFile, v)
+			}
+
+			info.StoreDir = v
+		case "WantMassQuery":
+			// Nix treats everything but 1 as false
+			info.WantMassQuery = v == "1"
+		case "Priority":
+			priority, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Priority in %v: %w", CacheInfoFile, err)
+			}
+
+			info.Priority = priority
+		}
+	}
+
+	return info, scanner.Err()
+}
+
+// String renders the nix-cache-info file. WantMassQuery and Priority are
+// left out when unset.
+func (info *CacheInfo) String() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "StoreDir: %v\n", info.StoreDir)
+
+	if info.WantMassQuery {
+		buf.WriteString("WantMassQuery: 1\n")
+	}
+
+	if info.Priority != 0 {
+		fmt.Fprintf(&buf, "Priority: %d\n", info.Priority)
+	}
+
+	return buf.String()
+}
+
+// Check returns an error if the binary cache is for a store directory other
+// than storeDir, as its paths can't be used then.
+// A missing StoreDir is accepted, like Nix does.
+func (info *CacheInfo) Check(storeDir string) error {
+	if info.StoreDir != "" && info.StoreDir != storeDir {
+		return fmt.Errorf("binary cache is for Nix stores with prefix '%s', not '%s'", info.StoreDir, storeDir)
+	}
+
+	return nil
+}
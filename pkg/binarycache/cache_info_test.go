@@ -0,0 +1,55 @@
+package binarycache_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/binarycache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCacheInfo(t *testing.T) {
+	t.Run("full", func(t *testing.T) {
+		s := "StoreDir: /nix/store\nWantMassQuery: 1\nPriority: 40\n"
+
+		info, err := binarycache.ParseCacheInfo(strings.NewReader(s))
+		if assert.NoError(t, err) {
+			assert.Equal(t, &binarycache.CacheInfo{StoreDir: "/nix/store", WantMassQuery: true, Priority: 40}, info)
+			assert.Equal(t, s, info.String())
+		}
+	})
+
+	t.Run("minimal", func(t *testing.T) {
+		info, err := binarycache.ParseCacheInfo(strings.NewReader("StoreDir: /nix/store\nWantMassQuery: 0\nFoo: bar\n"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, &binarycache.CacheInfo{StoreDir: "/nix/store"}, info)
+			assert.Equal(t, "StoreDir: /nix/store\n", info.String())
+		}
+	})
+
+	t.Run("lenient", func(t *testing.T) {
+		// Nix skips lines without a colon, and only accepts 1 as true
+		info, err := binarycache.ParseCacheInfo(strings.NewReader("StoreDir: /nix/store\nfoo\nWantMassQuery: yes\n"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, &binarycache.CacheInfo{StoreDir: "/nix/store"}, info)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{
+			"StoreDir: nix/store\n",
+			"Priority: high\n",
+		} {
+			_, err := binarycache.ParseCacheInfo(strings.NewReader(s))
+			assert.Error(t, err, s)
+		}
+	})
+}
+
+func TestCacheInfoCheck(t *testing.T) {
+	assert.NoError(t, (&binarycache.CacheInfo{StoreDir: "/nix/store"}).Check("/nix/store"))
+	assert.NoError(t, (&binarycache.CacheInfo{}).Check("/nix/store"))
+
+	err := (&binarycache.CacheInfo{StoreDir: "/gnu/store"}).Check("/nix/store")
+	assert.EqualError(t, err, "binary cache is for Nix stores with prefix '/gnu/store', not '/nix/store'")
+}
@@ -0,0 +1,94 @@
+package binarycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+)
+
+// CachingGetter looks up narinfos in a disk cache first, and records what
+// the underlying Getter returns there, like Nix does.
+type CachingGetter struct {
+	getter Getter
+	cache  *DiskCache
+}
+
+var _ Getter = &CachingGetter{}
+
+// NewCachingGetter returns a Getter caching the lookups of getter in cache.
+func NewCachingGetter(getter Getter, cache *DiskCache) *CachingGetter {
+	return &CachingGetter{
+		getter: getter,
+		cache:  cache,
+	}
+}
+
+// URL returns the URL of the underlying binary cache.
+func (cg *CachingGetter) URL() string {
+	return cg.getter.URL()
+}
+
+// GetNarInfo returns the narinfo from the disk cache if it has an unexpired
+// entry, and asks the underlying Getter otherwise.
+// Errors other than ErrNotFound aren't cached.
+func (cg *CachingGetter) GetNarInfo(ctx context.Context, hashPart string) (*narinfo.NarInfo, error) {
+	url := cg.getter.URL()
+
+	outcome, ni, err := cg.cache.LookupNarInfo(ctx, url, hashPart)
+	if err != nil {
+		return nil, err
+	}
+
+	switch outcome {
+	case OutcomeValid:
+		return ni, nil
+	case OutcomeInvalid:
+		return nil, ErrNotFound
+	case OutcomeUnknown:
+	}
+
+	ni, err = cg.getter.GetNarInfo(ctx, hashPart)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	// registers the binary cache in the disk cache, if needed
+	if _, err := cg.CacheInfo(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := cg.cache.UpsertNarInfo(ctx, url, hashPart, ni); err != nil {
+		return nil, fmt.Errorf("unable to record narinfo in disk cache: %w", err)
+	}
+
+	if ni == nil {
+		return nil, ErrNotFound
+	}
+
+	return ni, nil
+}
+
+// CacheInfo returns the info of the binary cache from the disk cache if it
+// has an unexpired entry, and asks the underlying Getter and records it
+// otherwise.
+func (cg *CachingGetter) CacheInfo(ctx context.Context) (*CacheInfo, error) {
+	url := cg.getter.URL()
+
+	info, err := cg.cache.CacheInfo(ctx, url)
+	if !errors.Is(err, ErrNotFound) {
+		return info, err
+	}
+
+	info, err = cg.getter.CacheInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cg.cache.RegisterCache(ctx, url, info); err != nil {
+		return nil, fmt.Errorf("unable to record cache info in disk cache: %w", err)
+	}
+
+	return info, nil
+}
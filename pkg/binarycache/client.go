@@ -0,0 +1,235 @@
+package binarycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+// ErrUntrusted is returned for narinfos without a valid signature by
+// a trusted key.
+var ErrUntrusted = errors.New("no signature by a trusted key")
+
+// Client fetches narinfos and NARs from a binary cache over http://,
+// https:// or file:// URLs, and verifies them.
+type Client struct {
+	url *url.URL
+
+	// HTTPClient is used for http:// and https:// URLs.
+	HTTPClient *http.Client
+	// TrustedPublicKeys are the keys narinfos need to be signed by.
+	TrustedPublicKeys []signature.PublicKey
+	// Policy, if set, is used instead of TrustedPublicKeys to decide
+	// whether narinfos are trusted.
+	Policy *signature.Policy
+	// RequireSigs is set if narinfos need a valid signature by one of
+	// TrustedPublicKeys, like Nix's require-sigs setting.
+	RequireSigs bool
+	// StoreDir is the store directory the binary cache needs to be for.
+	StoreDir string
+}
+
+var _ Getter = &Client{}
+
+// NewClient returns a Client for the binary cache at rawURL, accepting
+// narinfos signed by one of trustedPublicKeys.
+func NewClient(rawURL string, trustedPublicKeys []signature.PublicKey) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(rawURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid binary cache URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "file":
+	default:
+		return nil, fmt.Errorf("unsupported binary cache URL scheme: %v", rawURL)
+	}
+
+	return &Client{
+		url:               u,
+		HTTPClient:        http.DefaultClient,
+		TrustedPublicKeys: trustedPublicKeys,
+		RequireSigs:       true,
+		StoreDir:          storepath.StoreDir,
+	}, nil
+}
+
+// URL returns the URL of the binary cache.
+func (c *Client) URL() string {
+	return c.url.String()
+}
+
+// CacheInfo fetches and parses the nix-cache-info file of the binary cache,
+// and checks the binary cache is for StoreDir.
+// A missing nix-cache-info file is treated as one only containing StoreDir.
+func (c *Client) CacheInfo(ctx context.Context) (*CacheInfo, error) {
+	body, err := c.get(ctx, CacheInfoFile)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return &CacheInfo{StoreDir: c.StoreDir}, nil
+		}
+
+		return nil, err
+	}
+	defer body.Close()
+
+	info, err := ParseCacheInfo(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := info.Check(c.StoreDir); err != nil {
+		return nil, fmt.Errorf("%v: %w", c.URL(), err)
+	}
+
+	return info, nil
+}
+
+// GetNarInfo fetches the narinfo of the store path with the given hash part,
+// and checks it's consistent and, if RequireSigs is set, trusted.
+func (c *Client) GetNarInfo(ctx context.Context, hashPart string) (*narinfo.NarInfo, error) {
+	body, err := c.get(ctx, hashPart+".narinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	ni, err := narinfo.Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse narinfo of %v: %w", hashPart, err)
+	}
+
+	if err := ni.Check(); err != nil {
+		return nil, fmt.Errorf("invalid narinfo of %v: %w", hashPart, err)
+	}
+
+	if !strings.HasPrefix(path.Base(ni.StorePath), hashPart+"-") {
+		return nil, fmt.Errorf("narinfo of %v is for a different path: %v", hashPart, ni.StorePath)
+	}
+
+	if ni.NarHash == nil {
+		return nil, fmt.Errorf("narinfo of %v has no NarHash", ni.StorePath)
+	}
+
+	if c.RequireSigs {
+		if c.Policy != nil {
+			if err := ni.CheckSignatures(c.Policy).Err(); err != nil {
+				return nil, fmt.Errorf("narinfo of %v: %w: %v", ni.StorePath, ErrUntrusted, err)
+			}
+		} else if !signature.VerifyFirst(ni.Fingerprint(), ni.Signatures, c.TrustedPublicKeys) {
+			return nil, fmt.Errorf("narinfo of %v: %w", ni.StorePath, ErrUntrusted)
+		}
+	}
+
+	return ni, nil
+}
+
+// GetNar fetches the NAR described by ni, and returns a reader decompressing
+// it. The file and NAR hashes and sizes are verified while reading:
+// reading returns an error wrapping ErrHashMismatch instead of io.EOF
+// when they don't match.
+func (c *Client) GetNar(ctx context.Context, ni *narinfo.NarInfo) (io.ReadCloser, error) {
+	body, err := c.get(ctx, ni.URL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch NAR of %v: %w", ni.StorePath, err)
+	}
+
+	nr := &narReader{
+		body:     body,
+		fileSize: ni.FileSize,
+		narSize:  ni.NarSize,
+	}
+
+	if ni.FileHash != nil {
+		nr.fileHash = &ni.FileHash.Hash
+	}
+
+	if ni.NarHash != nil {
+		nr.narHash = &ni.NarHash.Hash
+	}
+
+	nr.file = newHashingReader(body, nr.fileHash)
+
+	nr.dec, err = Decompress(ni.Compression, nr.file)
+	if err != nil {
+		body.Close()
+
+		return nil, fmt.Errorf("unable to fetch NAR of %v: %w", ni.StorePath, err)
+	}
+
+	nr.nar = newHashingReader(nr.dec, nr.narHash)
+
+	return nr, nil
+}
+
+// get returns the file at p, relative to the binary cache URL.
+// It returns ErrNotFound if it doesn't exist.
+func (c *Client) get(ctx context.Context, p string) (io.ReadCloser, error) {
+	if c.url.Scheme == "file" {
+		return c.getFile(p)
+	}
+
+	ref, err := url.Parse(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %v: %w", p, err)
+	}
+
+	// resolve p relative to the cache URL, not its parent
+	base := *c.url
+	base.Path += "/"
+	u := base.ResolveReference(ref)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error constructing request: %w", err)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error doing request: %w", err)
+	}
+
+	// like Nix, treat 403 as missing, as S3 returns it for missing files
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("%v: %w", u, ErrNotFound)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("%v: bad status code: %v", u, resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+func (c *Client) getFile(p string) (io.ReadCloser, error) {
+	p = path.Clean(p)
+	if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return nil, fmt.Errorf("invalid path %v: outside of the binary cache", p)
+	}
+
+	f, err := os.Open(filepath.Join(filepath.FromSlash(c.url.Path), filepath.FromSlash(p)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%v: %w", p, ErrNotFound)
+		}
+
+		return nil, err
+	}
+
+	return f, nil
+}
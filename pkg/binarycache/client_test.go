@@ -0,0 +1,288 @@
+package binarycache_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nix-community/go-nix/pkg/binarycache"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	narPath = "../../test/testdata/nar_1094wph9z4nwlgvsd53abfz8i117ykiv5dwnq9nnhz846s7xqd7d.nar"
+
+	//nolint:gosec
+	test1SecretKey = "test1:jbX9NxZp8WB/coK8k7yLf0gNYmBbIbCrOFwgJgI7OV+0sASf4R5oFQlioSlN3bJ56uvshzr7S9Z75pcv+9hSkQ=="
+)
+
+// fixtureHashParts are the hash parts of the paths in the fixture cache,
+// one per compression method.
+//
+//nolint:gochecknoglobals
+var fixtureHashParts = map[string]string{
+	binarycache.CompressionNone: "00bgd045z0d4icpbc2yyz4gx48ak44la",
+	binarycache.CompressionXz:   "11bgd045z0d4icpbc2yyz4gx48ak44la",
+	binarycache.CompressionZstd: "22bgd045z0d4icpbc2yyz4gx48ak44la",
+}
+
+func compress(t *testing.T, compression string, b []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	var w io.WriteCloser
+
+	var err error
+
+	switch compression {
+	case binarycache.CompressionXz:
+		w, err = xz.NewWriter(&buf)
+	case binarycache.CompressionZstd:
+		w, err = zstd.NewWriter(&buf)
+	default:
+		return b
+	}
+
+	require.NoError(t, err)
+
+	_, err = w.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func sha256Hash(b []byte) *nixhash.HashWithEncoding {
+	digest := sha256.Sum256(b)
+
+	return nixhash.MustNewHashWithEncoding(nixhash.SHA256, digest[:], nixhash.NixBase32, true)
+}
+
+// writeFixtureCache writes a binary cache to dir, with the test NAR
+// compressed with each method, signed by sk.
+func writeFixtureCache(t *testing.T, dir string, sk signature.SecretKey) []byte {
+	t.Helper()
+
+	nar, err := os.ReadFile(narPath)
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "nar"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nix-cache-info"),
+		[]byte("StoreDir: /nix/store\nWantMassQuery: 1\nPriority: 30\n"), 0o644))
+
+	for compression, hashPart := range fixtureHashParts {
+		file := compress(t, compression, nar)
+		fileHash := sha256Hash(file)
+
+		url := "nar/" + nixbase32.EncodeToString(fileHash.Digest()) + ".nar"
+		if compression != binarycache.CompressionNone {
+			url += "." + compression
+		}
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, filepath.FromSlash(url)), file, 0o644))
+
+		ni := &narinfo.NarInfo{
+			StorePath:   "/nix/store/" + hashPart + "-net-tools-1.60_p20170221182432",
+			URL:         url,
+			Compression: compression,
+			FileHash:    fileHash,
+			FileSize:    uint64(len(file)),
+			NarHash:     sha256Hash(nar),
+			NarSize:     uint64(len(nar)),
+			References:  []string{"7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc-glibc-2.27"},
+		}
+
+		sig, err := sk.Sign(nil, ni.Fingerprint())
+		require.NoError(t, err)
+
+		ni.Signatures = []signature.Signature{sig}
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, hashPart+".narinfo"), []byte(ni.String()), 0o644))
+	}
+
+	return nar
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	sk, err := signature.LoadSecretKey(test1SecretKey)
+	require.NoError(t, err)
+
+	trusted := []signature.PublicKey{sk.ToPublicKey()}
+	nar := writeFixtureCache(t, dir, sk)
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	for _, cacheURL := range []string{srv.URL, srv.URL + "/", "file://" + dir} {
+		cacheURL := cacheURL
+
+		t.Run(cacheURL, func(t *testing.T) {
+			c, err := binarycache.NewClient(cacheURL, trusted)
+			require.NoError(t, err)
+
+			info, err := c.CacheInfo(ctx)
+			if assert.NoError(t, err) {
+				assert.Equal(t, &binarycache.CacheInfo{StoreDir: "/nix/store", WantMassQuery: true, Priority: 30}, info)
+			}
+
+			for compression, hashPart := range fixtureHashParts {
+				ni, err := c.GetNarInfo(ctx, hashPart)
+				require.NoError(t, err, compression)
+				assert.Equal(t, compression, ni.Compression)
+
+				r, err := c.GetNar(ctx, ni)
+				require.NoError(t, err, compression)
+
+				b, err := io.ReadAll(r)
+				if assert.NoError(t, err, compression) {
+					assert.Equal(t, nar, b, compression)
+				}
+
+				assert.NoError(t, r.Close())
+			}
+
+			_, err = c.GetNarInfo(ctx, "33bgd045z0d4icpbc2yyz4gx48ak44la")
+			assert.ErrorIs(t, err, binarycache.ErrNotFound)
+		})
+	}
+}
+
+func TestClientUntrusted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	sk, _, err := signature.GenerateKeypair("other", nil)
+	require.NoError(t, err)
+
+	writeFixtureCache(t, dir, sk)
+
+	test1, err := signature.LoadSecretKey(test1SecretKey)
+	require.NoError(t, err)
+
+	c, err := binarycache.NewClient("file://"+dir, []signature.PublicKey{test1.ToPublicKey()})
+	require.NoError(t, err)
+
+	hashPart := fixtureHashParts[binarycache.CompressionXz]
+
+	_, err = c.GetNarInfo(ctx, hashPart)
+	assert.ErrorIs(t, err, binarycache.ErrUntrusted)
+
+	// a policy trusting the key, but requiring two signatures
+	c.Policy = signature.NewPolicy([]signature.PublicKey{sk.ToPublicKey(), test1.ToPublicKey()})
+
+	_, err = c.GetNarInfo(ctx, hashPart)
+	assert.NoError(t, err)
+
+	c.Policy.Threshold = 2
+
+	_, err = c.GetNarInfo(ctx, hashPart)
+	assert.ErrorIs(t, err, binarycache.ErrUntrusted)
+
+	c.RequireSigs = false
+
+	_, err = c.GetNarInfo(ctx, hashPart)
+	assert.NoError(t, err)
+}
+
+func TestClientHashMismatch(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	sk, err := signature.LoadSecretKey(test1SecretKey)
+	require.NoError(t, err)
+
+	nar := writeFixtureCache(t, dir, sk)
+
+	c, err := binarycache.NewClient("file://"+dir, []signature.PublicKey{sk.ToPublicKey()})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name   string
+		modify func(ni *narinfo.NarInfo)
+	}{
+		{"nar hash", func(ni *narinfo.NarInfo) { ni.NarHash = sha256Hash([]byte("foo")) }},
+		{"nar size", func(ni *narinfo.NarInfo) { ni.NarSize++ }},
+		{"file hash", func(ni *narinfo.NarInfo) { ni.FileHash = sha256Hash(nar) }},
+		{"file size", func(ni *narinfo.NarInfo) { ni.FileSize-- }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ni, err := c.GetNarInfo(ctx, fixtureHashParts[binarycache.CompressionZstd])
+			require.NoError(t, err)
+
+			tc.modify(ni)
+
+			r, err := c.GetNar(ctx, ni)
+			require.NoError(t, err)
+			defer r.Close()
+
+			_, err = io.ReadAll(r)
+			assert.ErrorIs(t, err, binarycache.ErrHashMismatch)
+		})
+	}
+
+	t.Run("path mismatch", func(t *testing.T) {
+		require.NoError(t, os.Rename(
+			filepath.Join(dir, fixtureHashParts[binarycache.CompressionXz]+".narinfo"),
+			filepath.Join(dir, "33bgd045z0d4icpbc2yyz4gx48ak44la.narinfo"),
+		))
+
+		_, err := c.GetNarInfo(ctx, "33bgd045z0d4icpbc2yyz4gx48ak44la")
+		assert.Error(t, err)
+	})
+
+	t.Run("outside of cache", func(t *testing.T) {
+		_, err := c.GetNar(ctx, &narinfo.NarInfo{URL: "../nar/foo.nar"})
+		assert.Error(t, err)
+	})
+}
+
+func TestClientStoreDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	c, err := binarycache.NewClient("file://"+dir, nil)
+	require.NoError(t, err)
+
+	// a missing nix-cache-info is for the client's store
+	info, err := c.CacheInfo(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &binarycache.CacheInfo{StoreDir: "/nix/store"}, info)
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, binarycache.CacheInfoFile),
+		[]byte("StoreDir: /gnu/store\n"), 0o600))
+
+	_, err = c.CacheInfo(ctx)
+	assert.Error(t, err)
+
+	c.StoreDir = "/gnu/store"
+
+	info, err = c.CacheInfo(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/gnu/store", info.StoreDir)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	for _, u := range []string{"s3://bucket", "ssh://host", "://"} {
+		_, err := binarycache.NewClient(u, nil)
+		assert.Error(t, err, u)
+	}
+}
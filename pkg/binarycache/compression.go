@@ -0,0 +1,90 @@
+package binarycache
+
+import (
+	stdbzip2 "compress/bzip2"
+	"fmt"
+	"io"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// The compression methods NARs in binary caches can be compressed with,
+// as found in the Compression field of narinfos.
+const (
+	CompressionNone  = "none"
+	CompressionXz    = "xz"
+	CompressionZstd  = "zstd"
+	CompressionBzip2 = "bzip2"
+)
+
+// Decompress returns a reader decompressing r, compressed with the given
+// method. An empty method means no compression, like in old narinfos.
+func Decompress(compression string, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case "", CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress xz: %w", err)
+		}
+
+		return io.NopCloser(xr), nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress zstd: %w", err)
+		}
+
+		return zr.IOReadCloser(), nil
+	case CompressionBzip2:
+		return io.NopCloser(stdbzip2.NewReader(r)), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %v", compression)
+	}
+}
+
+// Compress returns a writer compressing to w with the given method.
+// It needs to be closed to flush the compressed stream, which doesn't
+// close w.
+func Compress(compression string, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case "", CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionXz:
+		return xz.NewWriter(w)
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionBzip2:
+		return bzip2.NewWriter(w, nil)
+	default:
+		return nil, fmt.Errorf("unsupported compression: %v", compression)
+	}
+}
+
+// CompressionExtension returns the extension of NAR files compressed with
+// the given method, like ".xz", as Nix names them.
+func CompressionExtension(compression string) (string, error) {
+	switch compression {
+	case "", CompressionNone:
+		return "", nil
+	case CompressionXz:
+		return ".xz", nil
+	case CompressionZstd:
+		return ".zst", nil
+	case CompressionBzip2:
+		return ".bz2", nil
+	default:
+		return "", fmt.Errorf("unsupported compression: %v", compression)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
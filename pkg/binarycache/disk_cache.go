@@ -0,0 +1,378 @@
+package binarycache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/realisation"
+	"github.com/nix-community/go-nix/pkg/sqlite"
+	"github.com/nix-community/go-nix/pkg/sqlite/binary_cache_v6"
+)
+
+// The defaults of the TTLs used by Nix.
+const (
+	DefaultTTLNegative   = time.Hour
+	DefaultTTLPositive   = 30 * 24 * time.Hour
+	DefaultTTLMeta       = 7 * 24 * time.Hour
+	DefaultPurgeInterval = 24 * time.Hour
+)
+
+// Outcome is the result of a lookup in the disk cache.
+type Outcome int
+
+const (
+	// OutcomeUnknown means the disk cache doesn't know about the path,
+	// or the entry is expired.
+	OutcomeUnknown Outcome = iota
+	// OutcomeInvalid means the binary cache is known not to have the path.
+	OutcomeInvalid
+	// OutcomeValid means the binary cache is known to have the path.
+	OutcomeValid
+)
+
+// DiskCache is the disk cache of narinfos, a binary_cache_v6 database.
+// Entries are kept per binary cache, which need to be registered first.
+type DiskCache struct {
+	db      *sql.DB
+	queries *binary_cache_v6.Queries
+
+	// TTLNegative is the time entries of missing paths are used for,
+	// like Nix's narinfo-cache-negative-ttl setting.
+	TTLNegative time.Duration
+	// TTLPositive is the time narinfos are used for,
+	// like Nix's narinfo-cache-positive-ttl setting.
+	TTLPositive time.Duration
+	// TTLMeta is the time the info of binary caches is used for.
+	TTLMeta time.Duration
+	// PurgeInterval is the interval at which Purge deletes expired entries.
+	PurgeInterval time.Duration
+}
+
+// NewDiskCache returns a DiskCache using an already opened binary_cache_v6
+// database, with the default TTLs.
+func NewDiskCache(db *sql.DB) *DiskCache {
+	return &DiskCache{
+		db:            db,
+		queries:       binary_cache_v6.New(db),
+		TTLNegative:   DefaultTTLNegative,
+		TTLPositive:   DefaultTTLPositive,
+		TTLMeta:       DefaultTTLMeta,
+		PurgeInterval: DefaultPurgeInterval,
+	}
+}
+
+// OpenDiskCache opens the disk cache at dsn, creating it if needed.
+// Like Nix, it purges expired entries if that hasn't happened for a while.
+func OpenDiskCache(ctx context.Context, dsn string) (*DiskCache, error) {
+	db, _, err := sqlite.InitBinaryCacheV6(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := NewDiskCache(db)
+
+	if _, err := dc.Purge(ctx); err != nil {
+		db.Close()
+
+		return nil, fmt.Errorf("unable to purge disk cache: %w", err)
+	}
+
+	return dc, nil
+}
+
+// Close closes the underlying database.
+func (dc *DiskCache) Close() error {
+	return dc.db.Close()
+}
+
+// RegisterCache records the info of the binary cache at url.
+func (dc *DiskCache) RegisterCache(ctx context.Context, url string, info *CacheInfo) error {
+	params := binary_cache_v6.InsertCacheParams{
+		Url:       url,
+		Timestamp: time.Now().Unix(),
+		Storedir:  info.StoreDir,
+		Priority:  int64(info.Priority),
+	}
+
+	if info.WantMassQuery {
+		params.Wantmassquery = 1
+	}
+
+	_, err := dc.queries.InsertCache(ctx, params)
+
+	return err
+}
+
+// CacheInfo returns the info of the binary cache at url, or ErrNotFound if
+// it's not registered or its info is expired.
+func (dc *DiskCache) CacheInfo(ctx context.Context, url string) (*CacheInfo, error) {
+	_, info, err := dc.queryCache(ctx, url)
+
+	return info, err
+}
+
+func (dc *DiskCache) queryCache(ctx context.Context, url string) (int64, *CacheInfo, error) {
+	rows, err := dc.queries.QueryCache(ctx, binary_cache_v6.QueryCacheParams{
+		Url:       url,
+		Timestamp: dc.since(dc.TTLMeta),
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(rows) == 0 {
+		return 0, nil, ErrNotFound
+	}
+
+	return rows[0].ID, &CacheInfo{
+		StoreDir:      rows[0].Storedir,
+		WantMassQuery: rows[0].Wantmassquery != 0,
+		Priority:      int(rows[0].Priority),
+	}, nil
+}
+
+// UpsertNarInfo records the narinfo of the path with the given hash part
+// in the binary cache at url. If ni is nil, the path is recorded as missing.
+// The binary cache needs to be registered.
+func (dc *DiskCache) UpsertNarInfo(ctx context.Context, url string, hashPart string, ni *narinfo.NarInfo) error {
+	id, _, err := dc.queryCache(ctx, url)
+	if err != nil {
+		return fmt.Errorf("unable to look up binary cache %v: %w", url, err)
+	}
+
+	now := time.Now().Unix()
+
+	if ni == nil {
+		return dc.queries.InsertMissingNAR(ctx, binary_cache_v6.InsertMissingNARParams{
+			Cache:     id,
+			Hashpart:  hashPart,
+			Timestamp: now,
+		})
+	}
+
+	if ni.NarHash == nil {
+		return fmt.Errorf("narinfo of %v has no NarHash", ni.StorePath)
+	}
+
+	name := strings.TrimPrefix(path.Base(ni.StorePath), hashPart+"-")
+	if name == path.Base(ni.StorePath) {
+		return fmt.Errorf("store path %v doesn't match hash part %v", ni.StorePath, hashPart)
+	}
+
+	sigs := make([]string, len(ni.Signatures))
+	for i, sig := range ni.Signatures {
+		sigs[i] = sig.String()
+	}
+
+	params := binary_cache_v6.InsertNarParams{
+		Cache:       id,
+		Hashpart:    hashPart,
+		Namepart:    sql.NullString{String: name, Valid: true},
+		Url:         sql.NullString{String: ni.URL, Valid: true},
+		Compression: sql.NullString{String: ni.Compression, Valid: true},
+		Filesize:    sql.NullInt64{Int64: int64(ni.FileSize), Valid: ni.FileSize != 0}, //nolint:gosec
+		Narhash:     sql.NullString{String: ni.NarHash.Format(nixhash.NixBase32, true), Valid: true},
+		Narsize:     sql.NullInt64{Int64: int64(ni.NarSize), Valid: true}, //nolint:gosec
+		Refs:        sql.NullString{String: strings.Join(ni.References, " "), Valid: true},
+		Deriver:     sql.NullString{String: ni.Deriver, Valid: ni.Deriver != ""},
+		Sigs:        sql.NullString{String: strings.Join(sigs, " "), Valid: true},
+		Ca:          sql.NullString{String: ni.CA, Valid: true},
+		Timestamp:   now,
+	}
+
+	if ni.FileHash != nil {
+		params.Filehash = sql.NullString{String: ni.FileHash.Format(nixhash.NixBase32, true), Valid: true}
+	}
+
+	return dc.queries.InsertNar(ctx, params)
+}
+
+// LookupNarInfo looks up the narinfo of the path with the given hash part
+// in the binary cache at url. The narinfo is only returned if the outcome
+// is OutcomeValid.
+func (dc *DiskCache) LookupNarInfo(ctx context.Context, url string, hashPart string) (Outcome, *narinfo.NarInfo, error) {
+	id, info, err := dc.queryCache(ctx, url)
+	if errors.Is(err, ErrNotFound) {
+		return OutcomeUnknown, nil, nil
+	} else if err != nil {
+		return OutcomeUnknown, nil, err
+	}
+
+	rows, err := dc.queries.QueryNar(ctx, binary_cache_v6.QueryNarParams{
+		Cache:       id,
+		Hashpart:    hashPart,
+		Timestamp:   dc.since(dc.TTLNegative),
+		Timestamp_2: dc.since(dc.TTLPositive),
+	})
+	if err != nil {
+		return OutcomeUnknown, nil, err
+	}
+
+	if len(rows) == 0 {
+		return OutcomeUnknown, nil, nil
+	}
+
+	if rows[0].Present == 0 {
+		return OutcomeInvalid, nil, nil
+	}
+
+	ni, err := narInfoFromRow(info.StoreDir, hashPart, &rows[0])
+	if err != nil {
+		return OutcomeUnknown, nil, fmt.Errorf("invalid entry for %v in %v: %w", hashPart, url, err)
+	}
+
+	return OutcomeValid, ni, nil
+}
+
+func narInfoFromRow(storeDir string, hashPart string, row *binary_cache_v6.QueryNarRow) (*narinfo.NarInfo, error) {
+	ni := &narinfo.NarInfo{
+		StorePath:   storeDir + "/" + hashPart + "-" + row.Namepart.String,
+		URL:         row.Url.String,
+		Compression: row.Compression.String,
+		FileSize:    uint64(row.Filesize.Int64), //nolint:gosec
+		NarSize:     uint64(row.Narsize.Int64),  //nolint:gosec
+		References:  strings.Fields(row.Refs.String),
+		Deriver:     row.Deriver.String,
+		CA:          row.Ca.String,
+	}
+
+	var err error
+
+	if ni.NarHash, err = nixhash.ParseAny(row.Narhash.String, nil); err != nil {
+		return nil, fmt.Errorf("invalid NarHash: %w", err)
+	}
+
+	if row.Filehash.Valid {
+		if ni.FileHash, err = nixhash.ParseAny(row.Filehash.String, nil); err != nil {
+			return nil, fmt.Errorf("invalid FileHash: %w", err)
+		}
+	}
+
+	for _, s := range strings.Fields(row.Sigs.String) {
+		sig, err := signature.ParseSignature(s)
+		if err != nil {
+			return nil, err
+		}
+
+		ni.Signatures = append(ni.Signatures, sig)
+	}
+
+	return ni, nil
+}
+
+// UpsertRealisation records the realisation of the derivation output id in
+// the binary cache at url. If r is nil, the realisation is recorded as
+// missing. The binary cache needs to be registered.
+func (dc *DiskCache) UpsertRealisation(
+	ctx context.Context,
+	url string,
+	id *realisation.DrvOutput,
+	r *realisation.Realisation,
+) error {
+	cacheID, _, err := dc.queryCache(ctx, url)
+	if err != nil {
+		return fmt.Errorf("unable to look up binary cache %v: %w", url, err)
+	}
+
+	now := time.Now().Unix()
+
+	if r == nil {
+		return dc.queries.InsertMissingRealisation(ctx, binary_cache_v6.InsertMissingRealisationParams{
+			Cache:     cacheID,
+			Outputid:  id.String(),
+			Timestamp: now,
+		})
+	}
+
+	content, err := r.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	return dc.queries.InsertRealisation(ctx, binary_cache_v6.InsertRealisationParams{
+		Cache:     cacheID,
+		Outputid:  id.String(),
+		Content:   content,
+		Timestamp: now,
+	})
+}
+
+// LookupRealisation looks up the realisation of the derivation output id in
+// the binary cache at url. The realisation is only returned if the outcome
+// is OutcomeValid.
+func (dc *DiskCache) LookupRealisation(
+	ctx context.Context,
+	url string,
+	id *realisation.DrvOutput,
+) (Outcome, *realisation.Realisation, error) {
+	cacheID, _, err := dc.queryCache(ctx, url)
+	if errors.Is(err, ErrNotFound) {
+		return OutcomeUnknown, nil, nil
+	} else if err != nil {
+		return OutcomeUnknown, nil, err
+	}
+
+	rows, err := dc.queries.QueryRealisation(ctx, binary_cache_v6.QueryRealisationParams{
+		Cache:       cacheID,
+		Outputid:    id.String(),
+		Timestamp:   dc.since(dc.TTLNegative),
+		Timestamp_2: dc.since(dc.TTLPositive),
+	})
+	if err != nil {
+		return OutcomeUnknown, nil, err
+	}
+
+	if len(rows) == 0 {
+		return OutcomeUnknown, nil, nil
+	}
+
+	if rows[0] == nil {
+		return OutcomeInvalid, nil, nil
+	}
+
+	r, err := realisation.Parse(rows[0])
+	if err != nil {
+		return OutcomeUnknown, nil, fmt.Errorf("invalid realisation of %v in %v: %w", id, url, err)
+	}
+
+	return OutcomeValid, r, nil
+}
+
+// Purge deletes the expired narinfos, if that hasn't happened within the
+// purge interval. It returns whether it did.
+func (dc *DiskCache) Purge(ctx context.Context) (bool, error) {
+	lastPurge, err := dc.queries.QueryLastPurge(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	if lastPurge.Valid && lastPurge.Int64 >= dc.since(dc.PurgeInterval) {
+		return false, nil
+	}
+
+	if err := dc.queries.PurgeNars(ctx, binary_cache_v6.PurgeNarsParams{
+		Timestamp:   dc.since(dc.TTLNegative),
+		Timestamp_2: dc.since(dc.TTLPositive),
+	}); err != nil {
+		return false, err
+	}
+
+	if err := dc.queries.UpdateLastPurge(ctx, sql.NullInt64{Int64: time.Now().Unix(), Valid: true}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// since returns the timestamp ttl ago.
+func (dc *DiskCache) since(ttl time.Duration) int64 {
+	return time.Now().Unix() - int64(ttl/time.Second)
+}
@@ -0,0 +1,298 @@
+package binarycache_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/binarycache"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/realisation"
+	"github.com/nix-community/go-nix/pkg/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	cacheURL = "https://cache.nixos.org"
+	hashPart = "00bgd045z0d4icpbc2yyz4gx48ak44la"
+
+	//nolint:lll
+	strNarinfoSample = `StorePath: /nix/store/00bgd045z0d4icpbc2yyz4gx48ak44la-net-tools-1.60_p20170221182432
+URL: nar/1094wph9z4nwlgvsd53abfz8i117ykiv5dwnq9nnhz846s7xqd7d.nar.xz
+Compression: xz
+FileHash: sha256:1094wph9z4nwlgvsd53abfz8i117ykiv5dwnq9nnhz846s7xqd7d
+FileSize: 114980
+NarHash: sha256:0lxjvvpr59c2mdram7ympy5ay741f180kv3349hvfc3f8nrmbqf6
+NarSize: 464152
+References: 7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc-glibc-2.27
+Deriver: 10dx1q4ivjb115y3h90mipaaz533nr0d-net-tools-1.60_p20170221182432.drv
+Sig: cache.nixos.org-1:sn5s/RrqEI+YG6/PjwdbPjcAC7rcta7sJU4mFOawGvJBLsWkyLtBrT2EuFt/LJjWkTZ+ZWOI9NTtjo/woMdvAg==
+Sig: hydra.other.net-1:JXQ3Z/PXf0EZSFkFioa4FbyYpbbTbHlFBtZf4VqU0tuMTWzhMD7p9Q7acJjLn3jofOtilAAwRILKIfVuyrbjAA==
+`
+)
+
+func mustParseNarInfo(s string) *narinfo.NarInfo {
+	ni, err := narinfo.Parse(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+
+	return ni
+}
+
+func newTestDiskCache(t *testing.T) *binarycache.DiskCache {
+	t.Helper()
+
+	dc, err := binarycache.OpenDiskCache(context.Background(), filepath.Join(t.TempDir(), "binary-cache-v6.sqlite"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { dc.Close() })
+
+	return dc
+}
+
+func TestDiskCache(t *testing.T) {
+	ctx := context.Background()
+	dc := newTestDiskCache(t)
+
+	ni := mustParseNarInfo(strNarinfoSample)
+
+	t.Run("unregistered cache", func(t *testing.T) {
+		outcome, _, err := dc.LookupNarInfo(ctx, cacheURL, hashPart)
+		if assert.NoError(t, err) {
+			assert.Equal(t, binarycache.OutcomeUnknown, outcome)
+		}
+
+		assert.Error(t, dc.UpsertNarInfo(ctx, cacheURL, hashPart, ni))
+
+		_, err = dc.CacheInfo(ctx, cacheURL)
+		assert.ErrorIs(t, err, binarycache.ErrNotFound)
+	})
+
+	info := &binarycache.CacheInfo{StoreDir: "/nix/store", WantMassQuery: true, Priority: 40}
+	require.NoError(t, dc.RegisterCache(ctx, cacheURL, info))
+
+	t.Run("cache info", func(t *testing.T) {
+		found, err := dc.CacheInfo(ctx, cacheURL)
+		if assert.NoError(t, err) {
+			assert.Equal(t, info, found)
+		}
+	})
+
+	t.Run("positive", func(t *testing.T) {
+		require.NoError(t, dc.UpsertNarInfo(ctx, cacheURL, hashPart, ni))
+
+		outcome, found, err := dc.LookupNarInfo(ctx, cacheURL, hashPart)
+		if assert.NoError(t, err) {
+			assert.Equal(t, binarycache.OutcomeValid, outcome)
+			assert.Equal(t, strNarinfoSample, found.String())
+		}
+
+		assert.Error(t, dc.UpsertNarInfo(ctx, cacheURL, "7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc", ni),
+			"the hash part should match the store path")
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		require.NoError(t, dc.UpsertNarInfo(ctx, cacheURL, "7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc", nil))
+
+		outcome, found, err := dc.LookupNarInfo(ctx, cacheURL, "7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc")
+		if assert.NoError(t, err) {
+			assert.Equal(t, binarycache.OutcomeInvalid, outcome)
+			assert.Nil(t, found)
+		}
+	})
+
+	t.Run("realisations", func(t *testing.T) {
+		fooID, err := realisation.ParseDrvOutput(
+			"sha256:ba17bbf4d63d0fd4f0ab29e65c5fa0a1b4eb0d2e3eee7e32e1f64ec4d4b1fb69!out")
+		require.NoError(t, err)
+
+		barID, err := realisation.ParseDrvOutput(
+			"sha256:08813cbee9903c62be4c5027726a418a300da4500b2d369d3af9286f4815ceba!out")
+		require.NoError(t, err)
+
+		outcome, _, err := dc.LookupRealisation(ctx, cacheURL, fooID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, binarycache.OutcomeUnknown, outcome)
+		}
+
+		r := &realisation.Realisation{ID: fooID, OutPath: ni.StorePath, Signatures: ni.Signatures}
+		require.NoError(t, dc.UpsertRealisation(ctx, cacheURL, fooID, r))
+
+		outcome, found, err := dc.LookupRealisation(ctx, cacheURL, fooID)
+		if assert.NoError(t, err) && assert.Equal(t, binarycache.OutcomeValid, outcome) {
+			assert.Equal(t, fooID.String(), found.ID.String())
+			assert.Equal(t, ni.StorePath, found.OutPath)
+			assert.Equal(t, ni.Signatures, found.Signatures)
+		}
+
+		require.NoError(t, dc.UpsertRealisation(ctx, cacheURL, barID, nil))
+
+		outcome, found, err = dc.LookupRealisation(ctx, cacheURL, barID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, binarycache.OutcomeInvalid, outcome)
+			assert.Nil(t, found)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		dc.TTLNegative = 0
+
+		outcome, _, err := dc.LookupNarInfo(ctx, cacheURL, "7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc")
+		if assert.NoError(t, err) {
+			assert.Equal(t, binarycache.OutcomeUnknown, outcome)
+		}
+
+		// the positive entry is still valid
+		outcome, _, err = dc.LookupNarInfo(ctx, cacheURL, hashPart)
+		if assert.NoError(t, err) {
+			assert.Equal(t, binarycache.OutcomeValid, outcome)
+		}
+
+		dc.TTLNegative = binarycache.DefaultTTLNegative
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		// OpenDiskCache purged already
+		purged, err := dc.Purge(ctx)
+		if assert.NoError(t, err) {
+			assert.False(t, purged)
+		}
+
+		// timestamps have a resolution of seconds, so move the cutoffs into
+		// the future to expire everything.
+		dc.PurgeInterval = -time.Minute
+		dc.TTLPositive = -time.Minute
+		dc.TTLNegative = -time.Minute
+
+		purged, err = dc.Purge(ctx)
+		if assert.NoError(t, err) {
+			assert.True(t, purged)
+		}
+
+		dc.TTLPositive = binarycache.DefaultTTLPositive
+		dc.TTLNegative = binarycache.DefaultTTLNegative
+
+		outcome, _, err := dc.LookupNarInfo(ctx, cacheURL, hashPart)
+		if assert.NoError(t, err) {
+			assert.Equal(t, binarycache.OutcomeUnknown, outcome, "the entry should be gone")
+		}
+	})
+}
+
+// TestDiskCacheNoCA checks narinfos without a CA store an empty string, and
+// not NULL, as Nix reads the column without checking for NULL.
+func TestDiskCacheNoCA(t *testing.T) {
+	ctx := context.Background()
+
+	db, _, err := sqlite.InitBinaryCacheV6(filepath.Join(t.TempDir(), "binary-cache-v6.sqlite"))
+	require.NoError(t, err)
+
+	defer db.Close()
+
+	dc := binarycache.NewDiskCache(db)
+
+	info := &binarycache.CacheInfo{StoreDir: "/nix/store", Priority: 40}
+	require.NoError(t, dc.RegisterCache(ctx, cacheURL, info))
+	require.NoError(t, dc.UpsertNarInfo(ctx, cacheURL, hashPart, mustParseNarInfo(strNarinfoSample)))
+
+	var isEmpty bool
+
+	require.NoError(t, db.QueryRowContext(ctx, "select ca = '' from NARs where hashPart = ?", hashPart).Scan(&isEmpty))
+	assert.True(t, isEmpty, "ca should be an empty string")
+
+	_, found, err := dc.LookupNarInfo(ctx, cacheURL, hashPart)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "", found.CA)
+	}
+}
+
+// fakeGetter serves narinfos from a map, and counts lookups.
+type fakeGetter struct {
+	url      string // cacheURL if empty
+	info     *binarycache.CacheInfo
+	narinfos map[string]*narinfo.NarInfo
+	lookups  int
+	err      error
+}
+
+func (g *fakeGetter) URL() string {
+	if g.url != "" {
+		return g.url
+	}
+
+	return cacheURL
+}
+
+func (g *fakeGetter) CacheInfo(_ context.Context) (*binarycache.CacheInfo, error) {
+	g.lookups++
+
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	if g.info == nil {
+		return &binarycache.CacheInfo{StoreDir: "/nix/store"}, nil
+	}
+
+	return g.info, nil
+}
+
+func (g *fakeGetter) GetNarInfo(_ context.Context, hashPart string) (*narinfo.NarInfo, error) {
+	g.lookups++
+
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	ni, ok := g.narinfos[hashPart]
+	if !ok {
+		return nil, binarycache.ErrNotFound
+	}
+
+	return ni, nil
+}
+
+func TestCachingGetter(t *testing.T) {
+	ctx := context.Background()
+	getter := &fakeGetter{
+		narinfos: map[string]*narinfo.NarInfo{hashPart: mustParseNarInfo(strNarinfoSample)},
+	}
+
+	cg := binarycache.NewCachingGetter(getter, newTestDiskCache(t))
+	assert.Equal(t, cacheURL, cg.URL())
+
+	info, err := cg.CacheInfo(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/nix/store", info.StoreDir)
+	}
+
+	getter.lookups = 0
+
+	for i := 0; i < 2; i++ {
+		ni, err := cg.GetNarInfo(ctx, hashPart)
+		if assert.NoError(t, err) {
+			assert.Equal(t, strNarinfoSample, ni.String())
+		}
+
+		_, err = cg.GetNarInfo(ctx, "7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc")
+		assert.ErrorIs(t, err, binarycache.ErrNotFound)
+	}
+
+	assert.Equal(t, 2, getter.lookups, "the second round should be served from the disk cache")
+
+	getter.err = errors.New("connection refused")
+
+	_, err = cg.GetNarInfo(ctx, "10dx1q4ivjb115y3h90mipaaz533nr0d")
+	assert.ErrorIs(t, err, getter.err)
+
+	getter.err = nil
+
+	_, err = cg.GetNarInfo(ctx, "10dx1q4ivjb115y3h90mipaaz533nr0d")
+	assert.ErrorIs(t, err, binarycache.ErrNotFound, "errors shouldn't be cached")
+	assert.Equal(t, 4, getter.lookups)
+}
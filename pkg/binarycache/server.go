@@ -0,0 +1,372 @@
+package binarycache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/nar/ls"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/nix-community/go-nix/pkg/nixdb"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+const (
+	// ContentTypeCacheInfo is the content type of nix-cache-info files.
+	ContentTypeCacheInfo = "text/x-nix-cache-info"
+	// ContentTypeNar is the content type of uncompressed NARs.
+	ContentTypeNar = "application/x-nix-nar"
+	// ContentTypeListing is the content type of .ls listings.
+	ContentTypeListing = "application/json"
+	// ContentTypeLog is the content type of build logs.
+	ContentTypeLog = "text/plain; charset=utf-8"
+
+	// DefaultLogDir is the directory Nix writes build logs to.
+	DefaultLogDir = "/nix/var/log/nix"
+)
+
+// hashPartLen is the length of the hash part of store paths.
+//
+//nolint:gochecknoglobals
+var hashPartLen = nixbase32.EncodedLen(storepath.PathHashSize)
+
+// Server serves a binary cache over HTTP straight from a local store, like
+// nix-serve does: narinfos are generated from the Nix database on the fly,
+// and NARs are dumped from the store directory uncompressed.
+//
+// It serves the following, for GET and HEAD requests:
+//
+//	nix-cache-info
+//	<hash>.narinfo
+//	<hash>.ls
+//	nar/<hash>-<narhash>.nar
+//	log/<drv basename>
+type Server struct {
+	db *nixdb.DB
+
+	// RealStoreDir is the directory store paths are read from. It defaults
+	// to storepath.StoreDir, and can point elsewhere for stores mounted in
+	// another location.
+	RealStoreDir string
+	// LogDir is the directory build logs are read from, in the layout Nix
+	// uses: drvs/<first 2 characters>/<rest of the drv basename>[.bz2].
+	LogDir string
+	// Signer signs the narinfos, if set.
+	Signer signature.Signer
+	// Priority is the priority advertised in nix-cache-info.
+	Priority int
+}
+
+// NewServer returns a Server serving the store paths registered in db.
+func NewServer(db *nixdb.DB) *Server {
+	return &Server{
+		db:           db,
+		RealStoreDir: storepath.StoreDir,
+		LogDir:       DefaultLogDir,
+		Priority:     30,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/")
+
+	var err error
+
+	switch {
+	case p == CacheInfoFile:
+		s.serveCacheInfo(w, r)
+	case strings.HasSuffix(p, ".narinfo") && !strings.Contains(p, "/"):
+		err = s.serveNarInfo(w, r, strings.TrimSuffix(p, ".narinfo"))
+	case strings.HasSuffix(p, ".ls") && !strings.Contains(p, "/"):
+		err = s.serveListing(w, r, strings.TrimSuffix(p, ".ls"))
+	case strings.HasPrefix(p, "nar/") && strings.HasSuffix(p, ".nar"):
+		err = s.serveNar(w, r, strings.TrimSuffix(strings.TrimPrefix(p, "nar/"), ".nar"))
+	case strings.HasPrefix(p, "log/"):
+		err = s.serveLog(w, r, strings.TrimPrefix(p, "log/"))
+	default:
+		err = ErrNotFound
+	}
+
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func (s *Server) serveCacheInfo(w http.ResponseWriter, r *http.Request) {
+	info := &CacheInfo{
+		StoreDir:      storepath.StoreDir,
+		WantMassQuery: true,
+		Priority:      s.Priority,
+	}
+
+	serveString(w, r, ContentTypeCacheInfo, info.String())
+}
+
+func (s *Server) serveNarInfo(w http.ResponseWriter, r *http.Request, hashPart string) error {
+	info, err := s.queryPathInfo(r.Context(), hashPart)
+	if err != nil {
+		return err
+	}
+
+	ni, err := s.narInfo(info)
+	if err != nil {
+		return err
+	}
+
+	ni.ServeHTTP(w, r)
+
+	return nil
+}
+
+func (s *Server) serveListing(w http.ResponseWriter, r *http.Request, hashPart string) error {
+	info, err := s.queryPathInfo(r.Context(), hashPart)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(nar.DumpPath(pw, s.realPath(info.Path)))
+	}()
+
+	listing, err := ls.List(pr)
+
+	// unblock the dumping goroutine, in case listing failed.
+	pr.Close()
+
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(listing)
+	if err != nil {
+		return err
+	}
+
+	serveString(w, r, ContentTypeListing, string(b))
+
+	return nil
+}
+
+// serveNar serves the NAR of the path with the hash part at the start of
+// name. If name also has the NAR hash, like the URLs in our narinfos, the
+// path needs to match it, so clients never get different contents than
+// the narinfo they fetched announced.
+func (s *Server) serveNar(w http.ResponseWriter, r *http.Request, name string) error {
+	hashPart, narHash, hasNarHash := strings.Cut(name, "-")
+
+	info, err := s.queryPathInfo(r.Context(), hashPart)
+	if err != nil {
+		return err
+	}
+
+	if hasNarHash && narHash != nixbase32.EncodeToString(info.NarHash.Digest()) {
+		return ErrNotFound
+	}
+
+	w.Header().Set("Content-Type", ContentTypeNar)
+
+	if info.NarSize != 0 {
+		w.Header().Set("Content-Length", strconv.FormatUint(info.NarSize, 10))
+	}
+
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	// the status is sent with the first write, so errors can't be reported
+	// past this point. Clients notice the NAR is truncated.
+	_ = nar.DumpPath(w, s.realPath(info.Path))
+
+	return nil
+}
+
+// serveLog serves the build log of the derivation with the given basename,
+// or of the derivation that built the store path with the given basename.
+func (s *Server) serveLog(w http.ResponseWriter, r *http.Request, name string) error {
+	sp, err := storepath.FromString(name)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	drvName := sp.String()
+
+	if !strings.HasSuffix(drvName, ".drv") {
+		info, err := s.db.QueryPathInfo(r.Context(), sp.Absolute())
+		if err != nil {
+			return err
+		}
+
+		if info == nil || info.Deriver == "" {
+			return ErrNotFound
+		}
+
+		drvName = path.Base(info.Deriver)
+	}
+
+	logPath := filepath.Join(s.LogDir, "drvs", drvName[:2], drvName[2:])
+	compression := CompressionNone
+
+	f, err := os.Open(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		compression = CompressionBzip2
+		f, err = os.Open(logPath + ".bz2")
+	}
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+
+		return err
+	}
+	defer f.Close()
+
+	rc, err := Decompress(compression, f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", ContentTypeLog)
+
+	if r.Method != http.MethodHead {
+		_, _ = io.Copy(w, rc)
+	}
+
+	return nil
+}
+
+// queryPathInfo returns the info of the valid path with the given hash
+// part, or ErrNotFound.
+func (s *Server) queryPathInfo(ctx context.Context, hashPart string) (*nixdb.PathInfo, error) {
+	if len(hashPart) != hashPartLen || nixbase32.ValidateString(hashPart) != nil {
+		return nil, ErrNotFound
+	}
+
+	p, err := s.db.QueryPathFromHashPart(ctx, hashPart)
+	if err != nil {
+		return nil, err
+	}
+
+	if p == "" {
+		return nil, ErrNotFound
+	}
+
+	info, err := s.db.QueryPathInfo(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if info == nil {
+		return nil, ErrNotFound
+	}
+
+	return info, nil
+}
+
+// narInfo returns the narinfo of the path described by info, pointing to
+// its uncompressed NAR, and signed with Signer if set.
+func (s *Server) narInfo(info *nixdb.PathInfo) (*narinfo.NarInfo, error) {
+	sp, err := storepath.FromAbsolutePath(info.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	narHash := nixbase32.EncodeToString(info.NarHash.Digest())
+
+	ni := &narinfo.NarInfo{
+		StorePath:   info.Path,
+		URL:         "nar/" + sp.String()[:hashPartLen] + "-" + narHash + ".nar",
+		Compression: CompressionNone,
+		NarHash:     nixhash.MustNewHashWithEncoding(info.NarHash.Algo(), info.NarHash.Digest(), nixhash.NixBase32, true),
+		NarSize:     info.NarSize,
+		Signatures:  append([]signature.Signature(nil), info.Signatures...),
+	}
+
+	// older databases don't have the size, dump the path to get it
+	if ni.NarSize == 0 {
+		cw := &countingWriter{}
+
+		if err := nar.DumpPath(cw, s.realPath(info.Path)); err != nil {
+			return nil, err
+		}
+
+		ni.NarSize = cw.n
+	}
+
+	for _, ref := range info.References {
+		ni.References = append(ni.References, path.Base(ref))
+	}
+
+	if info.Deriver != "" {
+		ni.Deriver = path.Base(info.Deriver)
+	}
+
+	if info.CA != nil {
+		ni.CA = info.CA.String()
+	}
+
+	if s.Signer != nil {
+		sig, err := s.Signer.Sign(nil, ni.Fingerprint())
+		if err != nil {
+			return nil, err
+		}
+
+		ni.Signatures = append(ni.Signatures, sig)
+	}
+
+	return ni, nil
+}
+
+// realPath returns the location of the store path p in RealStoreDir.
+func (s *Server) realPath(p string) string {
+	return filepath.Join(s.RealStoreDir, path.Base(p))
+}
+
+// serveString serves body with the given content type, without the body for
+// HEAD requests.
+func serveString(w http.ResponseWriter, r *http.Request, contentType string, body string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+
+	if r.Method != http.MethodHead {
+		// the client went away if this fails, there's no one to tell.
+		_, _ = io.WriteString(w, body)
+	}
+}
+
+// countingWriter counts what's written to it.
+type countingWriter struct {
+	n uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += uint64(len(p))
+
+	return len(p), nil
+}
@@ -0,0 +1,251 @@
+package binarycache_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/binarycache"
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/nar/ls"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixdb"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	serverHello    = "/nix/store/00bgd045z0d4icpbc2yyz4gx48ak44la-hello"
+	serverHelloDrv = "/nix/store/11bgd045z0d4icpbc2yyz4gx48ak44la-hello.drv"
+	serverDep      = "/nix/store/22bgd045z0d4icpbc2yyz4gx48ak44la-dep"
+)
+
+// newTestStore writes hello and dep to a temporary store directory, and
+// registers them in a new Nix database. It returns the store directory, the
+// database and the NAR of hello.
+func newTestStore(t *testing.T) (string, *nixdb.DB, []byte) {
+	t.Helper()
+
+	storeDir := t.TempDir()
+
+	hello := filepath.Join(storeDir, filepath.Base(serverHello))
+	require.NoError(t, os.MkdirAll(filepath.Join(hello, "bin"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(hello, "bin", "hello"), []byte("#!/bin/sh\necho hello\n"), 0o755))
+	require.NoError(t, os.Symlink(serverDep, filepath.Join(hello, "dep")))
+	require.NoError(t, os.WriteFile(filepath.Join(storeDir, filepath.Base(serverDep)), []byte("dep"), 0o644))
+
+	dumpNar := func(p string) []byte {
+		var buf bytes.Buffer
+
+		require.NoError(t, nar.DumpPath(&buf, filepath.Join(storeDir, filepath.Base(p))))
+
+		return buf.Bytes()
+	}
+
+	helloNar := dumpNar(serverHello)
+	depNar := dumpNar(serverDep)
+
+	narHash := func(b []byte) *nixhash.Hash {
+		digest := sha256.Sum256(b)
+
+		return nixhash.MustNewHash(nixhash.SHA256, digest[:])
+	}
+
+	sqlDB, _, err := sqlite.InitNixV10(t.TempDir())
+	require.NoError(t, err)
+
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db := nixdb.New(sqlDB, nil)
+
+	require.NoError(t, db.RegisterValidPaths(context.Background(), []*nixdb.PathInfo{
+		{
+			Path:       serverHello,
+			Deriver:    serverHelloDrv,
+			NarHash:    narHash(helloNar),
+			NarSize:    uint64(len(helloNar)),
+			References: []string{serverDep},
+		},
+		{
+			// unknown NarSize, like in old databases
+			Path:    serverDep,
+			NarHash: narHash(depNar),
+		},
+	}))
+
+	return storeDir, db, helloNar
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	storeDir, db, helloNar := newTestStore(t)
+	logDir := t.TempDir()
+
+	sk, err := signature.LoadSecretKey(test1SecretKey)
+	require.NoError(t, err)
+
+	s := binarycache.NewServer(db)
+	s.RealStoreDir = storeDir
+	s.LogDir = logDir
+	s.Signer = sk
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	// do sends a request, and returns the response with its body.
+	do := func(t *testing.T, method string, p string) (*http.Response, string) {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(ctx, method, srv.URL+"/"+p, nil)
+		require.NoError(t, err)
+
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err)
+
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp, string(b)
+	}
+
+	t.Run("client", func(t *testing.T) {
+		c, err := binarycache.NewClient(srv.URL, []signature.PublicKey{sk.ToPublicKey()})
+		require.NoError(t, err)
+
+		info, err := c.CacheInfo(ctx)
+		if assert.NoError(t, err) {
+			assert.Equal(t, &binarycache.CacheInfo{StoreDir: "/nix/store", WantMassQuery: true, Priority: 30}, info)
+		}
+
+		ni, err := c.GetNarInfo(ctx, "00bgd045z0d4icpbc2yyz4gx48ak44la")
+		require.NoError(t, err)
+
+		assert.Equal(t, serverHello, ni.StorePath)
+		assert.Equal(t, binarycache.CompressionNone, ni.Compression)
+		assert.Equal(t, []string{filepath.Base(serverDep)}, ni.References)
+		assert.Equal(t, filepath.Base(serverHelloDrv), ni.Deriver)
+		assert.Len(t, ni.Signatures, 1)
+
+		r, err := c.GetNar(ctx, ni)
+		require.NoError(t, err)
+
+		b, err := io.ReadAll(r)
+		if assert.NoError(t, err) {
+			assert.Equal(t, helloNar, b)
+		}
+
+		assert.NoError(t, r.Close())
+
+		// the size of dep is computed
+		ni, err = c.GetNarInfo(ctx, "22bgd045z0d4icpbc2yyz4gx48ak44la")
+		if assert.NoError(t, err) {
+			assert.Equal(t, uint64(120), ni.NarSize)
+		}
+
+		_, err = c.GetNarInfo(ctx, "33bgd045z0d4icpbc2yyz4gx48ak44la")
+		assert.ErrorIs(t, err, binarycache.ErrNotFound)
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		resp, body := do(t, http.MethodHead, "00bgd045z0d4icpbc2yyz4gx48ak44la.narinfo")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/x-nix-narinfo", resp.Header.Get("Content-Type"))
+		assert.NotZero(t, resp.ContentLength)
+		assert.Empty(t, body)
+
+		_, narinfoBody := do(t, http.MethodGet, "00bgd045z0d4icpbc2yyz4gx48ak44la.narinfo")
+		assert.Equal(t, int64(len(narinfoBody)), resp.ContentLength)
+
+		resp, body = do(t, http.MethodHead, "nar/00bgd045z0d4icpbc2yyz4gx48ak44la.nar")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, binarycache.ContentTypeNar, resp.Header.Get("Content-Type"))
+		assert.Equal(t, int64(len(helloNar)), resp.ContentLength)
+		assert.Empty(t, body)
+	})
+
+	t.Run("nix-cache-info", func(t *testing.T) {
+		resp, body := do(t, http.MethodGet, binarycache.CacheInfoFile)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, binarycache.ContentTypeCacheInfo, resp.Header.Get("Content-Type"))
+		assert.Equal(t, "StoreDir: /nix/store\nWantMassQuery: 1\nPriority: 30\n", body)
+	})
+
+	t.Run("listing", func(t *testing.T) {
+		resp, body := do(t, http.MethodGet, "00bgd045z0d4icpbc2yyz4gx48ak44la.ls")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, binarycache.ContentTypeListing, resp.Header.Get("Content-Type"))
+
+		root, err := ls.ParseLS(strings.NewReader(body))
+		if assert.NoError(t, err) {
+			assert.Contains(t, root.Root.Entries, "bin")
+			assert.Contains(t, root.Root.Entries, "dep")
+		}
+	})
+
+	t.Run("log", func(t *testing.T) {
+		drvName := filepath.Base(serverHelloDrv)
+		logPath := filepath.Join(logDir, "drvs", drvName[:2], drvName[2:])
+
+		resp, _ := do(t, http.MethodGet, "log/"+drvName)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		// logs are usually compressed, this is "building hello\n"
+		require.NoError(t, os.MkdirAll(filepath.Dir(logPath), 0o755))
+		require.NoError(t, os.WriteFile(logPath+".bz2", []byte{
+			0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x69, 0x30,
+			0x2b, 0xc9, 0x00, 0x00, 0x01, 0x51, 0x80, 0x00, 0x10, 0x40, 0x00, 0x16,
+			0xe5, 0x82, 0x00, 0x20, 0x00, 0x31, 0x03, 0x40, 0xd0, 0x20, 0x00, 0xc8,
+			0x2d, 0xfb, 0x45, 0x9e, 0x27, 0x1d, 0x8f, 0x8b, 0xb9, 0x22, 0x9c, 0x28,
+			0x48, 0x34, 0x98, 0x15, 0xe4, 0x80,
+		}, 0o644))
+
+		for _, name := range []string{drvName, filepath.Base(serverHello)} {
+			resp, body := do(t, http.MethodGet, "log/"+name)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, name)
+			assert.Equal(t, binarycache.ContentTypeLog, resp.Header.Get("Content-Type"), name)
+			assert.Equal(t, "building hello\n", body, name)
+		}
+
+		require.NoError(t, os.WriteFile(logPath, []byte("plain\n"), 0o644))
+
+		_, body := do(t, http.MethodGet, "log/"+drvName)
+		assert.Equal(t, "plain\n", body)
+
+		// dep has no deriver
+		resp, _ = do(t, http.MethodGet, "log/"+filepath.Base(serverDep))
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		for _, p := range []string{
+			"",
+			"33bgd045z0d4icpbc2yyz4gx48ak44la.narinfo",
+			"00bgd045z0d4icpbc2yyz4gx48ak44l.narinfo",
+			"00bgd045z0d4icpbc2yyz4gx48ak44le.narinfo",
+			"nar/00bgd045z0d4icpbc2yyz4gx48ak44la-0000000000000000000000000000000000000000000000000000.nar",
+			"nar/00bgd045z0d4icpbc2yyz4gx48ak44la.nar.xz",
+			"foo/00bgd045z0d4icpbc2yyz4gx48ak44la.narinfo",
+			"log/foo",
+		} {
+			resp, _ := do(t, http.MethodGet, p)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode, p)
+		}
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		resp, _ := do(t, http.MethodPut, "00bgd045z0d4icpbc2yyz4gx48ak44la.narinfo")
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+		assert.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))
+	})
+}
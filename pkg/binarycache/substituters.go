@@ -0,0 +1,115 @@
+package binarycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+)
+
+// Substituters looks up narinfos in several binary caches, honouring their
+// priority and WantMassQuery settings like Nix does with its substituters.
+type Substituters struct {
+	getters []Getter
+	infos   []*CacheInfo
+}
+
+// NewSubstituters returns Substituters for getters, which are ordered by the
+// priority in their cache info, lowest first. Getters with the same
+// priority keep their order.
+func NewSubstituters(ctx context.Context, getters ...Getter) (*Substituters, error) {
+	s := &Substituters{
+		getters: make([]Getter, len(getters)),
+		infos:   make([]*CacheInfo, len(getters)),
+	}
+
+	copy(s.getters, getters)
+
+	for i, g := range s.getters {
+		info, err := g.CacheInfo(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get cache info of %v: %w", g.URL(), err)
+		}
+
+		s.infos[i] = info
+	}
+
+	sort.Stable(s)
+
+	return s, nil
+}
+
+// Getters returns the binary caches, in the order they are queried.
+func (s *Substituters) Getters() []Getter {
+	return s.getters
+}
+
+// GetNarInfo returns the narinfo of the store path with the given hash part
+// from the first binary cache having it, along with that binary cache.
+// It returns ErrNotFound if none of them has it.
+func (s *Substituters) GetNarInfo(ctx context.Context, hashPart string) (Getter, *narinfo.NarInfo, error) {
+	for _, g := range s.getters {
+		ni, err := g.GetNarInfo(ctx, hashPart)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+
+			return nil, nil, fmt.Errorf("%v: %w", g.URL(), err)
+		}
+
+		return g, ni, nil
+	}
+
+	return nil, nil, ErrNotFound
+}
+
+// QuerySubstitutable returns the narinfos of the store paths with the
+// given hash parts that can be substituted, keyed by hash part.
+// Like Nix, only binary caches with WantMassQuery set are asked.
+func (s *Substituters) QuerySubstitutable(
+	ctx context.Context,
+	hashParts []string,
+) (map[string]*narinfo.NarInfo, error) {
+	found := make(map[string]*narinfo.NarInfo, len(hashParts))
+
+	for i, g := range s.getters {
+		if !s.infos[i].WantMassQuery {
+			continue
+		}
+
+		for _, hashPart := range hashParts {
+			if _, ok := found[hashPart]; ok {
+				continue
+			}
+
+			ni, err := g.GetNarInfo(ctx, hashPart)
+			if err != nil {
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+
+				return nil, fmt.Errorf("%v: %w", g.URL(), err)
+			}
+
+			found[hashPart] = ni
+		}
+	}
+
+	return found, nil
+}
+
+func (s *Substituters) Len() int {
+	return len(s.getters)
+}
+
+func (s *Substituters) Less(i, j int) bool {
+	return s.infos[i].Priority < s.infos[j].Priority
+}
+
+func (s *Substituters) Swap(i, j int) {
+	s.getters[i], s.getters[j] = s.getters[j], s.getters[i]
+	s.infos[i], s.infos[j] = s.infos[j], s.infos[i]
+}
@@ -0,0 +1,59 @@
+package binarycache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/binarycache"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubstituters(t *testing.T) {
+	ctx := context.Background()
+
+	const (
+		hashPart1 = "10dx1q4ivjb115y3h90mipaaz533nr0d"
+		hashPart2 = "20dx1q4ivjb115y3h90mipaaz533nr0d"
+	)
+
+	ni1 := &narinfo.NarInfo{StorePath: "/nix/store/" + hashPart1 + "-a"}
+	ni2 := &narinfo.NarInfo{StorePath: "/nix/store/" + hashPart2 + "-b"}
+
+	// low has the highest priority, but doesn't want mass queries
+	low := &fakeGetter{
+		url:      "https://low.example.org",
+		info:     &binarycache.CacheInfo{StoreDir: "/nix/store", Priority: 10},
+		narinfos: map[string]*narinfo.NarInfo{hashPart1: ni1},
+	}
+	high := &fakeGetter{
+		url:      "https://high.example.org",
+		info:     &binarycache.CacheInfo{StoreDir: "/nix/store", WantMassQuery: true, Priority: 50},
+		narinfos: map[string]*narinfo.NarInfo{hashPart2: ni2},
+	}
+
+	s, err := binarycache.NewSubstituters(ctx, high, low)
+	require.NoError(t, err)
+	assert.Equal(t, []binarycache.Getter{low, high}, s.Getters())
+
+	g, ni, err := s.GetNarInfo(ctx, hashPart1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, low, g)
+		assert.Equal(t, ni1, ni)
+	}
+
+	g, ni, err = s.GetNarInfo(ctx, hashPart2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, high, g)
+		assert.Equal(t, ni2, ni)
+	}
+
+	_, _, err = s.GetNarInfo(ctx, "30dx1q4ivjb115y3h90mipaaz533nr0d")
+	assert.ErrorIs(t, err, binarycache.ErrNotFound)
+
+	found, err := s.QuerySubstitutable(ctx, []string{hashPart1, hashPart2})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]*narinfo.NarInfo{hashPart2: ni2}, found)
+	}
+}
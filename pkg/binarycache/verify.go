@@ -0,0 +1,120 @@
+package binarycache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/nix-community/go-nix/pkg/nixhash"
+)
+
+// ErrHashMismatch is returned when the content downloaded from a binary
+// cache doesn't match the hash or size its narinfo claims.
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// hashingReader hashes and counts what's read from r.
+type hashingReader struct {
+	r    io.Reader
+	h    hash.Hash
+	size uint64
+}
+
+func newHashingReader(r io.Reader, expected *nixhash.Hash) *hashingReader {
+	hr := &hashingReader{r: r}
+	if expected != nil {
+		hr.h = expected.Algo().Func().New()
+	}
+
+	return hr
+}
+
+func (hr *hashingReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	if hr.h != nil {
+		hr.h.Write(p[:n])
+	}
+
+	hr.size += uint64(n)
+
+	return n, err
+}
+
+// check compares what has been read against the expected hash and size.
+// A nil hash or zero size isn't checked.
+func (hr *hashingReader) check(what string, expected *nixhash.Hash, expectedSize uint64) error {
+	if expectedSize != 0 && hr.size != expectedSize {
+		return fmt.Errorf("%w: %s has size %d, expected %d", ErrHashMismatch, what, hr.size, expectedSize)
+	}
+
+	if expected != nil && !bytes.Equal(hr.h.Sum(nil), expected.Digest()) {
+		got, _ := nixhash.NewHash(expected.Algo(), hr.h.Sum(nil))
+
+		return fmt.Errorf("%w: %s has hash %v, expected %v", ErrHashMismatch, what,
+			got.Format(nixhash.NixBase32, true), expected.Format(nixhash.NixBase32, true))
+	}
+
+	return nil
+}
+
+// narReader decompresses a NAR file downloaded from a binary cache, and
+// verifies its file and NAR hashes and sizes once it has been read entirely.
+// A mismatch is returned by Read instead of io.EOF.
+type narReader struct {
+	body io.ReadCloser
+	file *hashingReader
+	dec  io.ReadCloser
+	nar  *hashingReader
+
+	fileHash *nixhash.Hash
+	fileSize uint64
+	narHash  *nixhash.Hash
+	narSize  uint64
+
+	err error
+}
+
+func (nr *narReader) Read(p []byte) (int, error) {
+	if nr.err != nil {
+		return 0, nr.err
+	}
+
+	n, err := nr.nar.Read(p)
+	if errors.Is(err, io.EOF) {
+		err = nr.finish()
+	}
+
+	if err != nil {
+		nr.err = err
+	}
+
+	return n, err
+}
+
+// finish checks the hashes once the NAR has been read entirely.
+func (nr *narReader) finish() error {
+	// decompressors might not read the file until its end
+	if _, err := io.Copy(io.Discard, nr.file); err != nil {
+		return err
+	}
+
+	if err := nr.file.check("file", nr.fileHash, nr.fileSize); err != nil {
+		return err
+	}
+
+	if err := nr.nar.check("NAR", nr.narHash, nr.narSize); err != nil {
+		return err
+	}
+
+	return io.EOF
+}
+
+func (nr *narReader) Close() error {
+	err := nr.dec.Close()
+	if bodyErr := nr.body.Close(); err == nil {
+		err = bodyErr
+	}
+
+	return err
+}
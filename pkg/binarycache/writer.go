@@ -0,0 +1,365 @@
+package binarycache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/nar/ls"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+// Writer adds store paths to a binary cache in a local directory, in the
+// layout `nix copy --to file://…` produces:
+//
+//	nix-cache-info
+//	<hash>.narinfo
+//	<hash>.ls
+//	nar/<filehash>.nar[.xz,…]
+//
+// Files are written atomically, so readers never see partial contents, and
+// NARs are written before the narinfos referring to them.
+type Writer struct {
+	dir string
+
+	// Compression is the method NARs are compressed with.
+	Compression string
+	// Signer signs the narinfos, if set.
+	Signer signature.Signer
+	// WriteNARListing enables writing the .ls listing of NARs.
+	WriteNARListing bool
+}
+
+// NewWriter returns a Writer for the binary cache at rawURL, which is a
+// file:// URL or a directory. The directory and its nix-cache-info file are
+// created if they don't exist yet, an existing binary cache needs to be for
+// the same store directory. NARs are compressed with xz by default.
+func NewWriter(rawURL string) (*Writer, error) {
+	dir := rawURL
+
+	if strings.Contains(rawURL, "://") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid binary cache URL: %w", err)
+		}
+
+		if u.Scheme != "file" {
+			return nil, fmt.Errorf("unsupported binary cache %v, only file:// is supported", rawURL)
+		}
+
+		dir = u.Path
+	}
+
+	w := &Writer{
+		dir:         filepath.FromSlash(dir),
+		Compression: CompressionXz,
+	}
+
+	if err := os.MkdirAll(filepath.Join(w.dir, "nar"), 0o755); err != nil {
+		return nil, err
+	}
+
+	infoPath := filepath.Join(w.dir, CacheInfoFile)
+
+	f, err := os.Open(infoPath)
+	if err == nil {
+		defer f.Close()
+
+		info, err := ParseCacheInfo(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %v: %w", infoPath, err)
+		}
+
+		if err := info.Check(storepath.StoreDir); err != nil {
+			return nil, err
+		}
+
+		return w, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	info := &CacheInfo{StoreDir: storepath.StoreDir}
+
+	if err := writeFileAtomic(infoPath, func(f io.Writer) error {
+		_, err := io.WriteString(f, info.String())
+
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Has returns true if the binary cache has the narinfo of the store path
+// with the given hash part.
+func (w *Writer) Has(hashPart string) (bool, error) {
+	_, err := os.Stat(filepath.Join(w.dir, hashPart+".narinfo"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// AddNar adds the store path described by info, with its NAR read from nar.
+// StorePath, References, Deriver, System, CA and Signatures are taken from
+// info, the other fields are computed. If info has a NarHash, the NAR needs
+// to match it.
+// It returns the narinfo written, or the existing one if the binary cache
+// already has the store path, in which case nar isn't read.
+func (w *Writer) AddNar(ctx context.Context, info *narinfo.NarInfo, nar io.Reader) (*narinfo.NarInfo, error) {
+	sp, err := storepath.FromAbsolutePath(info.StorePath)
+	if err != nil {
+		return nil, err
+	}
+
+	hashPart := nixbase32.EncodeToString(sp.Digest)
+	narinfoPath := filepath.Join(w.dir, hashPart+".narinfo")
+
+	if existing, err := readNarInfo(narinfoPath); err == nil {
+		return existing, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ni := &narinfo.NarInfo{
+		StorePath:   info.StorePath,
+		Compression: w.Compression,
+		References:  info.References,
+		Deriver:     info.Deriver,
+		System:      info.System,
+		CA:          info.CA,
+		Signatures:  append([]signature.Signature(nil), info.Signatures...),
+	}
+
+	if ni.Compression == "" {
+		ni.Compression = CompressionNone
+	}
+
+	listing, err := w.writeNar(ctx, ni, nar, info.NarHash)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write NAR of %v: %w", info.StorePath, err)
+	}
+
+	if w.Signer != nil {
+		sig, err := w.Signer.Sign(nil, ni.Fingerprint())
+		if err != nil {
+			return nil, err
+		}
+
+		ni.Signatures = append(ni.Signatures, sig)
+	}
+
+	if listing != nil {
+		if err := writeFileAtomic(filepath.Join(w.dir, hashPart+".ls"), func(f io.Writer) error {
+			b, err := json.Marshal(listing)
+			if err != nil {
+				return err
+			}
+
+			_, err = f.Write(b)
+
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeFileAtomic(narinfoPath, func(f io.Writer) error {
+		_, err := io.WriteString(f, ni.String())
+
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return ni, nil
+}
+
+// writeNar compresses nar to nar/<filehash>.nar.<ext>, and fills in the
+// URL, hashes and sizes of ni. If narHash is set, the NAR needs to match it,
+// otherwise nothing is written. It returns the listing of the NAR, if
+// enabled.
+func (w *Writer) writeNar(
+	ctx context.Context,
+	ni *narinfo.NarInfo,
+	nar io.Reader,
+	narHash *nixhash.HashWithEncoding,
+) (*ls.Root, error) {
+	ext, err := CompressionExtension(ni.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	// the file name depends on the hash of the compressed NAR, so write it
+	// to a temporary file first.
+	f, err := os.CreateTemp(filepath.Join(w.dir, "nar"), ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	file := &hashingWriter{w: bw, h: sha256.New()}
+
+	cw, err := Compress(ni.Compression, file)
+	if err != nil {
+		return nil, err
+	}
+
+	narHasher := &hashingWriter{w: cw, h: sha256.New()}
+	r := io.TeeReader(&contextReader{ctx: ctx, r: nar}, narHasher)
+
+	var listing *ls.Root
+
+	if w.WriteNARListing {
+		if listing, err = ls.List(r); err != nil {
+			return nil, err
+		}
+	}
+
+	// read the rest, or everything if there's no listing
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	ni.FileHash = nixhash.MustNewHashWithEncoding(nixhash.SHA256, file.h.Sum(nil), nixhash.NixBase32, true)
+	ni.FileSize = file.size
+	ni.NarHash = nixhash.MustNewHashWithEncoding(nixhash.SHA256, narHasher.h.Sum(nil), nixhash.NixBase32, true)
+	ni.NarSize = narHasher.size
+	ni.URL = path.Join("nar", nixbase32.EncodeToString(ni.FileHash.Digest())+".nar"+ext)
+
+	if narHash != nil && !bytes.Equal(narHash.Digest(), ni.NarHash.Digest()) {
+		return nil, fmt.Errorf("%w: NAR of %v has hash %v, expected %v", ErrHashMismatch,
+			ni.StorePath, ni.NarHash, narHash)
+	}
+
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return nil, err
+	}
+
+	// the same NAR might already be there, replacing it is harmless
+	if err := os.Rename(f.Name(), filepath.Join(w.dir, filepath.FromSlash(ni.URL))); err != nil {
+		return nil, err
+	}
+
+	return listing, nil
+}
+
+// readNarInfo parses the narinfo at p.
+func readNarInfo(p string) (*narinfo.NarInfo, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ni, err := narinfo.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %v: %w", p, err)
+	}
+
+	return ni, nil
+}
+
+// writeFileAtomic writes a temporary file next to p with write, and renames
+// it to p, so readers never see partial contents.
+func writeFileAtomic(p string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+
+	if err := write(bw); err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), p)
+}
+
+// hashingWriter hashes and counts what's written to w.
+type hashingWriter struct {
+	w    io.Writer
+	h    hash.Hash
+	size uint64
+}
+
+func (hw *hashingWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.h.Write(p[:n])
+	hw.size += uint64(n)
+
+	return n, err
+}
+
+// contextReader stops reading from r once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return cr.r.Read(p)
+}
@@ -0,0 +1,165 @@
+package binarycache_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/binarycache"
+	"github.com/nix-community/go-nix/pkg/nar/ls"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	ctx := context.Background()
+
+	nar, err := os.ReadFile(narPath)
+	require.NoError(t, err)
+
+	sk, err := signature.LoadSecretKey(test1SecretKey)
+	require.NoError(t, err)
+
+	for _, compression := range []string{
+		binarycache.CompressionNone,
+		binarycache.CompressionXz,
+		binarycache.CompressionZstd,
+		binarycache.CompressionBzip2,
+	} {
+		compression := compression
+
+		t.Run(compression, func(t *testing.T) {
+			dir := t.TempDir()
+
+			w, err := binarycache.NewWriter("file://" + dir)
+			require.NoError(t, err)
+
+			w.Compression = compression
+			w.Signer = sk
+			w.WriteNARListing = true
+
+			info := &narinfo.NarInfo{
+				StorePath:  "/nix/store/" + hashPart + "-net-tools-1.60_p20170221182432",
+				References: []string{"7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc-glibc-2.27"},
+				Deriver:    "10dx1q4ivjb115y3h90mipaaz533nr0d-net-tools-1.60_p20170221182432.drv",
+			}
+
+			ni, err := w.AddNar(ctx, info, bytes.NewReader(nar))
+			require.NoError(t, err)
+
+			assert.Equal(t, compression, ni.Compression)
+			assert.Equal(t, uint64(len(nar)), ni.NarSize)
+			assert.Equal(t, "sha256:0lxjvvpr59c2mdram7ympy5ay741f180kv3349hvfc3f8nrmbqf6", ni.NarHash.String())
+			assert.NoError(t, ni.Check())
+
+			has, err := w.Has(hashPart)
+			if assert.NoError(t, err) {
+				assert.True(t, has)
+			}
+
+			// the client can read back what was written
+			c, err := binarycache.NewClient("file://"+dir, []signature.PublicKey{sk.ToPublicKey()})
+			require.NoError(t, err)
+
+			info2, err := c.CacheInfo(ctx)
+			if assert.NoError(t, err) {
+				assert.Equal(t, "/nix/store", info2.StoreDir)
+			}
+
+			ni2, err := c.GetNarInfo(ctx, hashPart)
+			require.NoError(t, err)
+			assert.Equal(t, ni, ni2)
+
+			r, err := c.GetNar(ctx, ni2)
+			require.NoError(t, err)
+
+			b, err := io.ReadAll(r)
+			if assert.NoError(t, err) {
+				assert.True(t, bytes.Equal(nar, b), "NAR differs")
+			}
+
+			r.Close()
+
+			f, err := os.Open(filepath.Join(dir, hashPart+".ls"))
+			require.NoError(t, err)
+			defer f.Close()
+
+			listing, err := ls.ParseLS(f)
+			if assert.NoError(t, err) {
+				assert.Contains(t, listing.Root.Entries, "bin")
+			}
+
+			// adding it again doesn't read the NAR, and keeps the narinfo
+			ni3, err := w.AddNar(ctx, info, bytes.NewReader(nil))
+			if assert.NoError(t, err) {
+				assert.Equal(t, ni.String(), ni3.String())
+			}
+
+			// no temporary files are left behind
+			for _, d := range []string{dir, filepath.Join(dir, "nar")} {
+				matches, err := filepath.Glob(filepath.Join(d, ".tmp-*"))
+				if assert.NoError(t, err) {
+					assert.Empty(t, matches)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterInvalid(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	_, err := binarycache.NewWriter("https://cache.nixos.org")
+	assert.Error(t, err)
+
+	otherStore := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(otherStore, binarycache.CacheInfoFile),
+		[]byte("StoreDir: /gnu/store\n"), 0o600))
+
+	_, err = binarycache.NewWriter(otherStore)
+	assert.Error(t, err)
+
+	w, err := binarycache.NewWriter(dir)
+	require.NoError(t, err)
+
+	w.Compression = "lzip"
+
+	_, err = w.AddNar(ctx, &narinfo.NarInfo{StorePath: "/nix/store/" + hashPart + "-foo"}, bytes.NewReader(nil))
+	assert.Error(t, err)
+
+	w.Compression = binarycache.CompressionNone
+	w.WriteNARListing = true
+
+	_, err = w.AddNar(ctx, &narinfo.NarInfo{StorePath: "/nix/store/" + hashPart + "-foo"},
+		bytes.NewReader([]byte("not a NAR")))
+	assert.Error(t, err)
+
+	nar, err := os.ReadFile(narPath)
+	require.NoError(t, err)
+
+	nars, err := filepath.Glob(filepath.Join(dir, "nar", "*"))
+	require.NoError(t, err)
+
+	_, err = w.AddNar(ctx, &narinfo.NarInfo{
+		StorePath: "/nix/store/" + hashPart + "-foo",
+		NarHash:   mustParseNarInfo(strNarinfoSample).FileHash,
+	}, bytes.NewReader(nar))
+	assert.ErrorIs(t, err, binarycache.ErrHashMismatch)
+
+	// the NAR isn't left behind
+	narsAfter, err := filepath.Glob(filepath.Join(dir, "nar", "*"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, nars, narsAfter)
+	}
+
+	has, err := w.Has(hashPart)
+	if assert.NoError(t, err) {
+		assert.False(t, has)
+	}
+}
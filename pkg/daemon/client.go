@@ -0,0 +1,547 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/derivedpath"
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/wire"
+)
+
+// Client is a client for the Nix daemon worker protocol.
+// It's safe for concurrent use, but operations are processed one at a time.
+// Open multiple connections to process them in parallel.
+type Client struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	w    *bufio.Writer
+
+	logger Logger
+
+	version       uint64
+	daemonVersion string
+	trusted       TrustedFlag
+
+	mu sync.Mutex
+	// err is set once the connection is in an unknown state, like after an
+	// I/O error. All further operations will fail.
+	err error
+}
+
+// Connect connects to the daemon listening on the Unix socket at socketPath,
+// and performs the handshake.
+// Messages sent by the daemon are passed to logger, which can be nil.
+func Connect(ctx context.Context, socketPath string, logger Logger) (*Client, error) {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewClient(conn, logger)
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// NewClient performs the handshake on conn, and returns a client using it.
+// Messages sent by the daemon are passed to logger, which can be nil.
+func NewClient(conn io.ReadWriteCloser, logger Logger) (*Client, error) {
+	if logger == nil {
+		logger = discardLogger{}
+	}
+
+	c := &Client{
+		conn:   conn,
+		r:      bufio.NewReader(conn),
+		w:      bufio.NewWriter(conn),
+		logger: logger,
+	}
+
+	if err := c.handshake(); err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *Client) handshake() error {
+	if err := wire.WriteUint64(c.w, WorkerMagic1); err != nil {
+		return err
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	magic, err := wire.ReadUint64(c.r)
+	if err != nil {
+		return err
+	}
+
+	if magic != WorkerMagic2 {
+		return fmt.Errorf("invalid magic %x, not a Nix daemon", magic)
+	}
+
+	daemonVersion, err := wire.ReadUint64(c.r)
+	if err != nil {
+		return err
+	}
+
+	if err := checkVersion(daemonVersion); err != nil {
+		return err
+	}
+
+	if err := wire.WriteUint64(c.w, ProtocolVersion); err != nil {
+		return err
+	}
+
+	c.version = ProtocolVersion
+	if daemonVersion < c.version {
+		c.version = daemonVersion
+	}
+
+	// obsolete CPU affinity
+	if err := wire.WriteUint64(c.w, 0); err != nil {
+		return err
+	}
+
+	// obsolete reserveSpace
+	if err := wire.WriteBool(c.w, false); err != nil {
+		return err
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	if ProtocolMinor(c.version) >= 33 {
+		if c.daemonVersion, err = wire.ReadString(c.r, maxStringSize); err != nil {
+			return err
+		}
+	}
+
+	if ProtocolMinor(c.version) >= 35 {
+		trusted, err := wire.ReadUint64(c.r)
+		if err != nil {
+			return err
+		}
+
+		c.trusted = TrustedFlag(trusted)
+	}
+
+	return c.processStderr()
+}
+
+// ProtocolVersion returns the negotiated protocol version.
+func (c *Client) ProtocolVersion() uint64 {
+	return c.version
+}
+
+// DaemonVersion returns the Nix version of the daemon, like "2.18.1".
+// It's empty if the daemon speaks a protocol version older than 1.33.
+func (c *Client) DaemonVersion() string {
+	return c.daemonVersion
+}
+
+// Trusted returns whether the daemon trusts us.
+func (c *Client) Trusted() TrustedFlag {
+	return c.trusted
+}
+
+// Close closes the underlying connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// processStderr processes the messages sent by the daemon while it's processing an operation,
+// until it's done. It returns an *Error if the daemon reported one.
+func (c *Client) processStderr() error {
+	for {
+		code, err := wire.ReadUint64(c.r)
+		if err != nil {
+			return err
+		}
+
+		switch code {
+		case stderrLast:
+			return nil
+
+		case stderrError:
+			e, err := readError(c.r, c.version)
+			if err != nil {
+				return err
+			}
+
+			return e
+
+		case stderrNext:
+			msg, err := wire.ReadString(c.r, maxStringSize)
+			if err != nil {
+				return err
+			}
+
+			c.logger.Log(msg)
+
+		case stderrStartActivity:
+			act, err := readActivity(c.r)
+			if err != nil {
+				return err
+			}
+
+			c.logger.StartActivity(act)
+
+		case stderrStopActivity:
+			id, err := wire.ReadUint64(c.r)
+			if err != nil {
+				return err
+			}
+
+			c.logger.StopActivity(id)
+
+		case stderrResult:
+			res, err := readActivityResult(c.r)
+			if err != nil {
+				return err
+			}
+
+			c.logger.Result(res)
+
+		case stderrRead, stderrWrite:
+			return fmt.Errorf("daemon requested unsupported data transfer (%x)", code)
+
+		default:
+			return fmt.Errorf("unknown message type %x from daemon", code)
+		}
+	}
+}
+
+// watchContext makes I/O on the connection respect the deadline and
+// cancellation of ctx, if the connection supports deadlines.
+// The returned function needs to be called once the operation is done.
+func (c *Client) watchContext(ctx context.Context) func() {
+	conn, ok := c.conn.(interface{ SetDeadline(time.Time) error })
+	if !ok || ctx.Done() == nil {
+		return func() {}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		select {
+		case <-ctx.Done():
+			// interrupt any pending I/O
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+
+		_ = conn.SetDeadline(time.Time{})
+	}
+}
+
+// do sends op, then calls fn to send the arguments and receive the result.
+// Errors other than the ones sent by the daemon leave the connection in
+// an unknown state, and break the client.
+func (c *Client) do(ctx context.Context, op Op, fn func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return fmt.Errorf("connection is broken: %w", c.err)
+	}
+
+	stop := c.watchContext(ctx)
+
+	err := wire.WriteUint64(c.w, uint64(op))
+	if err == nil {
+		err = fn()
+	}
+
+	stop()
+
+	var daemonErr *Error
+	if err != nil && !errors.As(err, &daemonErr) {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+
+		if c.err == nil {
+			c.err = err
+		}
+	}
+
+	return err
+}
+
+// flushAndProcessStderr flushes everything written so far, then waits for the daemon to finish.
+func (c *Client) flushAndProcessStderr() error {
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	return c.processStderr()
+}
+
+// SetOptions sends settings to the daemon, affecting all further operations.
+func (c *Client) SetOptions(ctx context.Context, opts *ClientOptions) error {
+	return c.do(ctx, OpSetOptions, func() error {
+		if err := writeClientOptions(c.w, opts); err != nil {
+			return err
+		}
+
+		return c.flushAndProcessStderr()
+	})
+}
+
+// IsValidPath returns whether the given store path is valid.
+func (c *Client) IsValidPath(ctx context.Context, path string) (bool, error) {
+	var valid bool
+
+	err := c.do(ctx, OpIsValidPath, func() error {
+		if err := wire.WriteString(c.w, path); err != nil {
+			return err
+		}
+
+		if err := c.flushAndProcessStderr(); err != nil {
+			return err
+		}
+
+		var err error
+		valid, err = wire.ReadBool(c.r)
+
+		return err
+	})
+
+	return valid, err
+}
+
+// QueryPathInfo returns information about a store path.
+// It returns nil if the path is not valid.
+func (c *Client) QueryPathInfo(ctx context.Context, path string) (*PathInfo, error) {
+	var info *PathInfo
+
+	err := c.do(ctx, OpQueryPathInfo, func() error {
+		if err := wire.WriteString(c.w, path); err != nil {
+			return err
+		}
+
+		if err := c.flushAndProcessStderr(); err != nil {
+			return err
+		}
+
+		valid, err := wire.ReadBool(c.r)
+		if err != nil || !valid {
+			return err
+		}
+
+		info, err = readPathInfo(c.r)
+		if err != nil {
+			return err
+		}
+
+		info.Path = path
+
+		return nil
+	})
+
+	return info, err
+}
+
+// QueryReferrers returns all valid store paths referring to the given path.
+func (c *Client) QueryReferrers(ctx context.Context, path string) ([]string, error) {
+	var referrers []string
+
+	err := c.do(ctx, OpQueryReferrers, func() error {
+		if err := wire.WriteString(c.w, path); err != nil {
+			return err
+		}
+
+		if err := c.flushAndProcessStderr(); err != nil {
+			return err
+		}
+
+		var err error
+		referrers, err = readStrings(c.r)
+
+		return err
+	})
+
+	return referrers, err
+}
+
+// AddToStoreNar adds a store path described by info to the store,
+// reading its NAR serialization from narReader.
+func (c *Client) AddToStoreNar(
+	ctx context.Context,
+	info *PathInfo,
+	narReader io.Reader,
+	repair bool,
+	dontCheckSigs bool,
+) error {
+	return c.do(ctx, OpAddToStoreNar, func() error {
+		if err := wire.WriteString(c.w, info.Path); err != nil {
+			return err
+		}
+
+		if err := writePathInfo(c.w, info); err != nil {
+			return err
+		}
+
+		if err := wire.WriteBool(c.w, repair); err != nil {
+			return err
+		}
+
+		if err := wire.WriteBool(c.w, dontCheckSigs); err != nil {
+			return err
+		}
+
+		// The NAR is sent in frames, while the daemon might already send
+		// log messages, or an error.
+		writeErrC := make(chan error, 1)
+
+		go func() {
+			err := c.writeFramed(narReader)
+			if err != nil {
+				// unblock processStderr, the daemon would wait for more data.
+				c.conn.Close()
+			}
+
+			writeErrC <- err
+		}()
+
+		err := c.processStderr()
+
+		// Daemons drain all frames even when failing, so unless there was an
+		// I/O error, the writer will finish.
+		var daemonErr *Error
+		if err != nil && !errors.As(err, &daemonErr) {
+			c.conn.Close()
+		}
+
+		writeErr := <-writeErrC
+
+		if writeErr != nil {
+			return fmt.Errorf("unable to send NAR: %w", writeErr)
+		}
+
+		return err
+	})
+}
+
+// writeFramed writes the contents of r as framed data.
+func (c *Client) writeFramed(r io.Reader) error {
+	fw := wire.NewFramedWriter(c.w, wire.DefaultFrameSize)
+
+	if _, err := io.Copy(fw, r); err != nil {
+		return err
+	}
+
+	if err := fw.Close(); err != nil {
+		return err
+	}
+
+	return c.w.Flush()
+}
+
+// NarFromPath writes the NAR serialization of the given store path to w.
+func (c *Client) NarFromPath(ctx context.Context, path string, w io.Writer) error {
+	return c.do(ctx, OpNarFromPath, func() error {
+		if err := wire.WriteString(c.w, path); err != nil {
+			return err
+		}
+
+		if err := c.flushAndProcessStderr(); err != nil {
+			return err
+		}
+
+		// The NAR is sent as-is, so we need to parse it to know where it ends.
+		_, err := nar.Copy(w, c.r)
+
+		return err
+	})
+}
+
+// writeDerivedPaths writes a list of derived paths, in the format used
+// by the negotiated protocol version.
+func (c *Client) writeDerivedPaths(paths []*derivedpath.DerivedPath) error {
+	strs := make([]string, len(paths))
+
+	for i, p := range paths {
+		if ProtocolMinor(c.version) >= 30 {
+			strs[i] = p.String()
+		} else {
+			strs[i] = p.LegacyString()
+		}
+	}
+
+	return writeStrings(c.w, strs)
+}
+
+// BuildPaths builds or substitutes the given paths.
+func (c *Client) BuildPaths(ctx context.Context, paths []*derivedpath.DerivedPath, mode BuildMode) error {
+	return c.do(ctx, OpBuildPaths, func() error {
+		if err := c.writeDerivedPaths(paths); err != nil {
+			return err
+		}
+
+		if err := wire.WriteUint64(c.w, uint64(mode)); err != nil {
+			return err
+		}
+
+		if err := c.flushAndProcessStderr(); err != nil {
+			return err
+		}
+
+		return expectUint64(c.r, 1)
+	})
+}
+
+// QueryMissing returns which of the given paths (and their dependencies)
+// would need to be built or substituted.
+func (c *Client) QueryMissing(ctx context.Context, paths []*derivedpath.DerivedPath) (*MissingInfo, error) {
+	var info MissingInfo
+
+	err := c.do(ctx, OpQueryMissing, func() error {
+		if err := c.writeDerivedPaths(paths); err != nil {
+			return err
+		}
+
+		if err := c.flushAndProcessStderr(); err != nil {
+			return err
+		}
+
+		return wire.NewDecoder(c.r, maxStringSize).Decode(&info)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
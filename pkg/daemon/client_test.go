@@ -0,0 +1,663 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/derivedpath"
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	pathHello = "/nix/store/knn6wc1a89c47yb70qwv56rmxylia6wx-hello-2.12"
+	pathGlibc = "/nix/store/7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc-glibc-2.27"
+	pathDrv   = "/nix/store/0hm2f1psjpcwg8fijsmr4wwxrx59s092-bar.drv"
+)
+
+// fakeDaemon is a minimal daemon, speaking the protocol version it's configured with.
+// It keeps the store contents in memory.
+type fakeDaemon struct {
+	version uint64
+
+	infos map[string]*PathInfo
+	nars  map[string][]byte
+
+	// the derived paths received by BuildPaths
+	built []string
+	// if set, the daemon never replies to operations.
+	hang bool
+}
+
+func newFakeDaemon(version uint64) *fakeDaemon {
+	return &fakeDaemon{
+		version: version,
+		infos:   make(map[string]*PathInfo),
+		nars:    make(map[string][]byte),
+	}
+}
+
+func (d *fakeDaemon) serve(conn io.ReadWriteCloser) error {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	if err := expectUint64(r, WorkerMagic1); err != nil {
+		return err
+	}
+
+	if err := wire.WriteUint64(w, WorkerMagic2); err != nil {
+		return err
+	}
+
+	if err := wire.WriteUint64(w, d.version); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	clientVersion, err := wire.ReadUint64(r)
+	if err != nil {
+		return err
+	}
+
+	version := d.version
+	if clientVersion < version {
+		version = clientVersion
+	}
+
+	// cpu affinity, reserveSpace
+	if err := expectUint64(r, 0); err != nil {
+		return err
+	}
+
+	if err := expectUint64(r, 0); err != nil {
+		return err
+	}
+
+	if ProtocolMinor(version) >= 33 {
+		if err := wire.WriteString(w, "2.18.1"); err != nil {
+			return err
+		}
+	}
+
+	if ProtocolMinor(version) >= 35 {
+		if err := wire.WriteUint64(w, uint64(Trusted)); err != nil {
+			return err
+		}
+	}
+
+	if err := wire.WriteUint64(w, stderrLast); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		op, err := wire.ReadUint64(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		if d.hang {
+			_, _ = io.Copy(io.Discard, r)
+
+			return nil
+		}
+
+		if err := d.handle(Op(op), version, r, w); err != nil {
+			return err
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// handle processes a single operation.
+func (d *fakeDaemon) handle(op Op, version uint64, r io.Reader, w io.Writer) error {
+	sendError := func(msg string) error {
+		if err := wire.WriteUint64(w, stderrError); err != nil {
+			return err
+		}
+
+		return writeError(w, version, &Error{Message: msg, Traces: []string{"while doing something"}})
+	}
+
+	switch op {
+	case OpIsValidPath:
+		path, err := wire.ReadString(r, maxStringSize)
+		if err != nil {
+			return err
+		}
+
+		// send some logs and activities along
+		for _, err := range []error{
+			wire.WriteUint64(w, stderrNext),
+			wire.WriteString(w, "checking "+path),
+			wire.WriteUint64(w, stderrStartActivity),
+			writeActivity(w, Activity{
+				ID:     42,
+				Level:  VerbosityInfo,
+				Type:   ActivityQueryPathInfo,
+				Text:   "querying info about " + path,
+				Fields: []Field{{Type: FieldTypeString, String: path}, {Type: FieldTypeInt, Int: 23}},
+			}),
+			wire.WriteUint64(w, stderrResult),
+			writeActivityResult(w, ActivityResult{ActivityID: 42, Type: ResultProgress, Fields: []Field{
+				{Type: FieldTypeInt, Int: 1},
+				{Type: FieldTypeInt, Int: 1},
+			}}),
+			wire.WriteUint64(w, stderrStopActivity),
+			wire.WriteUint64(w, 42),
+			wire.WriteUint64(w, stderrLast),
+		} {
+			if err != nil {
+				return err
+			}
+		}
+
+		_, ok := d.infos[path]
+
+		return wire.WriteBool(w, ok)
+
+	case OpQueryPathInfo:
+		path, err := wire.ReadString(r, maxStringSize)
+		if err != nil {
+			return err
+		}
+
+		if err := wire.WriteUint64(w, stderrLast); err != nil {
+			return err
+		}
+
+		info, ok := d.infos[path]
+		if err := wire.WriteBool(w, ok); err != nil || !ok {
+			return err
+		}
+
+		return writePathInfo(w, info)
+
+	case OpQueryReferrers:
+		path, err := wire.ReadString(r, maxStringSize)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := d.infos[path]; !ok {
+			return sendError(fmt.Sprintf("path '%s' is not valid", path))
+		}
+
+		var referrers []string
+
+		for p, info := range d.infos {
+			for _, ref := range info.References {
+				if ref == path {
+					referrers = append(referrers, p)
+				}
+			}
+		}
+
+		if err := wire.WriteUint64(w, stderrLast); err != nil {
+			return err
+		}
+
+		return writeStrings(w, referrers)
+
+	case OpAddToStoreNar:
+		path, err := wire.ReadString(r, maxStringSize)
+		if err != nil {
+			return err
+		}
+
+		info, err := readPathInfo(r)
+		if err != nil {
+			return err
+		}
+
+		info.Path = path
+
+		// repair, dontCheckSigs
+		if _, err := wire.ReadBool(r); err != nil {
+			return err
+		}
+
+		if _, err := wire.ReadBool(r); err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+
+		for {
+			n, err := wire.ReadUint64(r)
+			if err != nil {
+				return err
+			}
+
+			if n == 0 {
+				break
+			}
+
+			if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+				return err
+			}
+		}
+
+		if uint64(buf.Len()) != info.NarSize {
+			return sendError("nar size mismatch")
+		}
+
+		d.infos[path] = info
+		d.nars[path] = buf.Bytes()
+
+		return wire.WriteUint64(w, stderrLast)
+
+	case OpNarFromPath:
+		path, err := wire.ReadString(r, maxStringSize)
+		if err != nil {
+			return err
+		}
+
+		narContents, ok := d.nars[path]
+		if !ok {
+			return sendError(fmt.Sprintf("path '%s' is not valid", path))
+		}
+
+		if err := wire.WriteUint64(w, stderrLast); err != nil {
+			return err
+		}
+
+		_, err = w.Write(narContents)
+
+		return err
+
+	case OpBuildPaths:
+		paths, err := readStrings(r)
+		if err != nil {
+			return err
+		}
+
+		if _, err := wire.ReadUint64(r); err != nil {
+			return err
+		}
+
+		d.built = append(d.built, paths...)
+
+		if err := wire.WriteUint64(w, stderrLast); err != nil {
+			return err
+		}
+
+		return wire.WriteUint64(w, 1)
+
+	case OpQueryMissing:
+		paths, err := readStrings(r)
+		if err != nil {
+			return err
+		}
+
+		if err := wire.WriteUint64(w, stderrLast); err != nil {
+			return err
+		}
+
+		// pretend all of them need to be built
+		for _, err := range []error{
+			writeStrings(w, paths),
+			writeStrings(w, nil),
+			writeStrings(w, []string{pathGlibc}),
+			wire.WriteUint64(w, 1234),
+			wire.WriteUint64(w, 5678),
+		} {
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported operation %d", op)
+	}
+}
+
+// newTestClient starts a fake daemon on one end of a pipe, and returns a client
+// connected to the other end.
+func newTestClient(t *testing.T, d *fakeDaemon, logger Logger) *Client {
+	t.Helper()
+
+	clientConn, daemonConn := net.Pipe()
+
+	serveErr := make(chan error, 1)
+
+	go func() {
+		serveErr <- d.serve(daemonConn)
+	}()
+
+	c, err := NewClient(clientConn, logger)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		c.Close()
+		assert.NoError(t, <-serveErr, "fake daemon failed")
+	})
+
+	return c
+}
+
+// recordingLogger records everything it receives.
+type recordingLogger struct {
+	msgs       []string
+	activities []Activity
+	stopped    []uint64
+	results    []ActivityResult
+}
+
+func (l *recordingLogger) Log(msg string)             { l.msgs = append(l.msgs, msg) }
+func (l *recordingLogger) StartActivity(act Activity) { l.activities = append(l.activities, act) }
+func (l *recordingLogger) StopActivity(id uint64)     { l.stopped = append(l.stopped, id) }
+func (l *recordingLogger) Result(res ActivityResult)  { l.results = append(l.results, res) }
+
+func genNar(t *testing.T, contents string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	nw, err := nar.NewWriter(&buf)
+	require.NoError(t, err)
+
+	require.NoError(t, nw.WriteHeader(&nar.Header{
+		Path: "/",
+		Type: nar.TypeRegular,
+		Size: int64(len(contents)),
+	}))
+
+	_, err = nw.Write([]byte(contents))
+	require.NoError(t, err)
+
+	require.NoError(t, nw.Close())
+
+	return buf.Bytes()
+}
+
+func genPathInfo(path string, narContents []byte, refs ...string) *PathInfo {
+	narHash := nixhash.SHA256.Func().New()
+	narHash.Write(narContents)
+
+	return &PathInfo{
+		Path:             path,
+		Deriver:          pathDrv,
+		NarHash:          nixhash.MustNewHash(nixhash.SHA256, narHash.Sum(nil)),
+		References:       append([]string{}, refs...),
+		RegistrationTime: time.Unix(1700000000, 0),
+		NarSize:          uint64(len(narContents)),
+		Signatures: []signature.Signature{{
+			Name: "cache.nixos.org-1",
+			Data: bytes.Repeat([]byte{0x42}, 64),
+		}},
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	t.Run("current", func(t *testing.T) {
+		c := newTestClient(t, newFakeDaemon(ProtocolVersion), nil)
+
+		assert.Equal(t, uint64(ProtocolVersion), c.ProtocolVersion())
+		assert.Equal(t, "2.18.1", c.DaemonVersion())
+		assert.Equal(t, Trusted, c.Trusted())
+	})
+
+	t.Run("newer daemon", func(t *testing.T) {
+		c := newTestClient(t, newFakeDaemon(1<<8|40), nil)
+
+		assert.Equal(t, uint64(ProtocolVersion), c.ProtocolVersion())
+	})
+
+	t.Run("older daemon", func(t *testing.T) {
+		c := newTestClient(t, newFakeDaemon(1<<8|25), nil)
+
+		assert.Equal(t, uint64(1<<8|25), c.ProtocolVersion())
+		assert.Equal(t, "", c.DaemonVersion())
+		assert.Equal(t, TrustedUnknown, c.Trusted())
+	})
+
+	t.Run("too old daemon", func(t *testing.T) {
+		clientConn, daemonConn := net.Pipe()
+
+		go func() {
+			_ = newFakeDaemon(1<<8 | 21).serve(daemonConn)
+		}()
+
+		_, err := NewClient(clientConn, nil)
+		assert.ErrorContains(t, err, "unsupported protocol version 1.21")
+	})
+
+	t.Run("not a daemon", func(t *testing.T) {
+		clientConn, daemonConn := net.Pipe()
+
+		go func() {
+			_, _ = wire.ReadUint64(daemonConn)
+			_ = wire.WriteUint64(daemonConn, 0xdeadbeef)
+			daemonConn.Close()
+		}()
+
+		_, err := NewClient(clientConn, nil)
+		assert.ErrorContains(t, err, "not a Nix daemon")
+	})
+}
+
+func TestConnect(t *testing.T) {
+	// Unix socket paths are limited in length, so don't use t.TempDir().
+	dir, err := os.MkdirTemp("", "daemon")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "socket")
+
+	l, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			_ = newFakeDaemon(ProtocolVersion).serve(conn)
+		}
+	}()
+
+	c, err := Connect(context.Background(), socketPath, nil)
+	require.NoError(t, err)
+
+	defer c.Close()
+
+	valid, err := c.IsValidPath(context.Background(), pathHello)
+	require.NoError(t, err)
+	assert.False(t, valid)
+}
+
+func TestQueries(t *testing.T) {
+	ctx := context.Background()
+
+	d := newFakeDaemon(ProtocolVersion)
+	d.infos[pathGlibc] = genPathInfo(pathGlibc, genNar(t, "glibc"))
+	d.infos[pathHello] = genPathInfo(pathHello, genNar(t, "hello"), pathGlibc, pathHello)
+
+	logger := &recordingLogger{}
+	c := newTestClient(t, d, logger)
+
+	t.Run("IsValidPath", func(t *testing.T) {
+		valid, err := c.IsValidPath(ctx, pathHello)
+		require.NoError(t, err)
+		assert.True(t, valid)
+
+		valid, err = c.IsValidPath(ctx, pathDrv)
+		require.NoError(t, err)
+		assert.False(t, valid)
+
+		// check logs and activities were received
+		assert.Equal(t, []string{"checking " + pathHello, "checking " + pathDrv}, logger.msgs)
+		if assert.Len(t, logger.activities, 2) {
+			assert.Equal(t, Activity{
+				ID:     42,
+				Level:  VerbosityInfo,
+				Type:   ActivityQueryPathInfo,
+				Text:   "querying info about " + pathHello,
+				Fields: []Field{{Type: FieldTypeString, String: pathHello}, {Type: FieldTypeInt, Int: 23}},
+			}, logger.activities[0])
+		}
+		assert.Equal(t, []uint64{42, 42}, logger.stopped)
+		if assert.Len(t, logger.results, 2) {
+			assert.Equal(t, ResultProgress, logger.results[0].Type)
+		}
+	})
+
+	t.Run("QueryPathInfo", func(t *testing.T) {
+		info, err := c.QueryPathInfo(ctx, pathHello)
+		require.NoError(t, err)
+		assert.Equal(t, d.infos[pathHello], info)
+
+		info, err = c.QueryPathInfo(ctx, pathDrv)
+		require.NoError(t, err)
+		assert.Nil(t, info)
+	})
+
+	t.Run("QueryReferrers", func(t *testing.T) {
+		referrers, err := c.QueryReferrers(ctx, pathGlibc)
+		require.NoError(t, err)
+		assert.Equal(t, []string{pathHello}, referrers)
+	})
+
+	t.Run("daemon error", func(t *testing.T) {
+		_, err := c.QueryReferrers(ctx, pathDrv)
+
+		var daemonErr *Error
+		if assert.ErrorAs(t, err, &daemonErr) {
+			assert.Equal(t, "path '"+pathDrv+"' is not valid", daemonErr.Message)
+			assert.Equal(t, []string{"while doing something"}, daemonErr.Traces)
+		}
+
+		// the client is still usable afterwards
+		valid, err := c.IsValidPath(ctx, pathHello)
+		require.NoError(t, err)
+		assert.True(t, valid)
+	})
+}
+
+func TestLegacyError(t *testing.T) {
+	c := newTestClient(t, newFakeDaemon(1<<8|25), nil)
+
+	_, err := c.QueryReferrers(context.Background(), pathDrv)
+
+	var daemonErr *Error
+	if assert.ErrorAs(t, err, &daemonErr) {
+		assert.Equal(t, "path '"+pathDrv+"' is not valid", daemonErr.Message)
+		assert.Equal(t, uint64(1), daemonErr.ExitStatus)
+	}
+}
+
+func TestAddToStoreNar(t *testing.T) {
+	ctx := context.Background()
+
+	d := newFakeDaemon(ProtocolVersion)
+	c := newTestClient(t, d, nil)
+
+	// make it span multiple frames
+	narContents := genNar(t, string(bytes.Repeat([]byte("hello"), wire.DefaultFrameSize)))
+	info := genPathInfo(pathHello, narContents)
+
+	require.NoError(t, c.AddToStoreNar(ctx, info, bytes.NewReader(narContents), false, false))
+	assert.Equal(t, info, d.infos[pathHello])
+
+	var buf bytes.Buffer
+
+	require.NoError(t, c.NarFromPath(ctx, pathHello, &buf))
+	assert.Equal(t, narContents, buf.Bytes())
+
+	t.Run("rejected", func(t *testing.T) {
+		info := genPathInfo(pathGlibc, narContents)
+		info.NarSize++
+
+		err := c.AddToStoreNar(ctx, info, bytes.NewReader(narContents), false, false)
+		assert.ErrorContains(t, err, "nar size mismatch")
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		err := c.NarFromPath(ctx, pathDrv, io.Discard)
+		assert.ErrorContains(t, err, "is not valid")
+	})
+}
+
+func TestBuildPaths(t *testing.T) {
+	ctx := context.Background()
+
+	paths := []*derivedpath.DerivedPath{
+		{Path: pathHello},
+		{DrvPath: &derivedpath.SingleDerivedPath{Path: pathDrv}, Outputs: derivedpath.OutputsSpec{Names: []string{"out"}}},
+	}
+
+	t.Run("current", func(t *testing.T) {
+		d := newFakeDaemon(ProtocolVersion)
+		c := newTestClient(t, d, nil)
+
+		require.NoError(t, c.BuildPaths(ctx, paths, BuildModeNormal))
+		assert.Equal(t, []string{pathHello, pathDrv + "^out"}, d.built)
+
+		missing, err := c.QueryMissing(ctx, paths)
+		require.NoError(t, err)
+		assert.Equal(t, &MissingInfo{
+			WillBuild:      []string{pathHello, pathDrv + "^out"},
+			WillSubstitute: []string{},
+			Unknown:        []string{pathGlibc},
+			DownloadSize:   1234,
+			NarSize:        5678,
+		}, missing)
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		d := newFakeDaemon(1<<8 | 29)
+		c := newTestClient(t, d, nil)
+
+		require.NoError(t, c.BuildPaths(ctx, paths, BuildModeNormal))
+		assert.Equal(t, []string{pathHello, pathDrv + "!out"}, d.built)
+	})
+}
+
+func TestContext(t *testing.T) {
+	d := newFakeDaemon(ProtocolVersion)
+	c := newTestClient(t, d, nil)
+
+	d.hang = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.IsValidPath(ctx, pathHello)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+
+	// the connection is in an unknown state afterwards
+	_, err = c.IsValidPath(context.Background(), pathHello)
+	assert.ErrorContains(t, err, "connection is broken")
+}
@@ -0,0 +1,172 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/wire"
+)
+
+// Error is an error sent by the daemon while processing an operation.
+type Error struct {
+	Level   Verbosity
+	Message string
+	// Traces contains additional context, innermost first.
+	Traces []string
+	// ExitStatus is only sent by daemons speaking protocol versions older than 1.26.
+	ExitStatus uint64
+}
+
+func (e *Error) Error() string {
+	if len(e.Traces) == 0 {
+		return e.Message
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString(e.Message)
+
+	for _, trace := range e.Traces {
+		sb.WriteString("\n… ")
+		sb.WriteString(trace)
+	}
+
+	return sb.String()
+}
+
+// readError reads an error, in the format used by the given protocol version.
+func readError(r io.Reader, version uint64) (*Error, error) {
+	var (
+		e   Error
+		err error
+	)
+
+	if ProtocolMinor(version) < 26 {
+		if e.Message, err = wire.ReadString(r, maxStringSize); err != nil {
+			return nil, err
+		}
+
+		if e.ExitStatus, err = wire.ReadUint64(r); err != nil {
+			return nil, err
+		}
+
+		return &e, nil
+	}
+
+	typ, err := wire.ReadString(r, maxStringSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != "Error" {
+		return nil, fmt.Errorf("unexpected error type %q", typ)
+	}
+
+	level, err := wire.ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Level = Verbosity(level)
+
+	// the name field is unused, and always "Error".
+	if _, err = wire.ReadString(r, maxStringSize); err != nil {
+		return nil, err
+	}
+
+	if e.Message, err = wire.ReadString(r, maxStringSize); err != nil {
+		return nil, err
+	}
+
+	// errors never carry a position.
+	if err = expectUint64(r, 0); err != nil {
+		return nil, err
+	}
+
+	n, err := wire.ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		if err = expectUint64(r, 0); err != nil {
+			return nil, err
+		}
+
+		trace, err := wire.ReadString(r, maxStringSize)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Traces = append(e.Traces, trace)
+	}
+
+	return &e, nil
+}
+
+// writeError writes an error, in the format used by the given protocol version.
+func writeError(w io.Writer, version uint64, e *Error) error {
+	if ProtocolMinor(version) < 26 {
+		if err := wire.WriteString(w, e.Message); err != nil {
+			return err
+		}
+
+		status := e.ExitStatus
+		if status == 0 {
+			status = 1
+		}
+
+		return wire.WriteUint64(w, status)
+	}
+
+	if err := wire.WriteString(w, "Error"); err != nil {
+		return err
+	}
+
+	if err := wire.WriteUint64(w, uint64(e.Level)); err != nil {
+		return err
+	}
+
+	if err := wire.WriteString(w, "Error"); err != nil {
+		return err
+	}
+
+	if err := wire.WriteString(w, e.Message); err != nil {
+		return err
+	}
+
+	// no position
+	if err := wire.WriteUint64(w, 0); err != nil {
+		return err
+	}
+
+	if err := wire.WriteUint64(w, uint64(len(e.Traces))); err != nil {
+		return err
+	}
+
+	for _, trace := range e.Traces {
+		if err := wire.WriteUint64(w, 0); err != nil {
+			return err
+		}
+
+		if err := wire.WriteString(w, trace); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func expectUint64(r io.Reader, expected uint64) error {
+	n, err := wire.ReadUint64(r)
+	if err != nil {
+		return err
+	}
+
+	if n != expected {
+		return fmt.Errorf("expected %d, got %d", expected, n)
+	}
+
+	return nil
+}
@@ -0,0 +1,280 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/nix-community/go-nix/pkg/wire"
+)
+
+// Verbosity is the log level of messages and activities.
+type Verbosity uint64
+
+const (
+	VerbosityError Verbosity = iota
+	VerbosityWarn
+	VerbosityNotice
+	VerbosityInfo
+	VerbosityTalkative
+	VerbosityChatty
+	VerbosityDebug
+	VerbosityVomit
+)
+
+// ActivityType describes what an activity is about.
+type ActivityType uint64
+
+const (
+	ActivityUnknown       ActivityType = 0
+	ActivityCopyPath      ActivityType = 100
+	ActivityFileTransfer  ActivityType = 101
+	ActivityRealise       ActivityType = 102
+	ActivityCopyPaths     ActivityType = 103
+	ActivityBuilds        ActivityType = 104
+	ActivityBuild         ActivityType = 105
+	ActivityOptimiseStore ActivityType = 106
+	ActivityVerifyPaths   ActivityType = 107
+	ActivitySubstitute    ActivityType = 108
+	ActivityQueryPathInfo ActivityType = 109
+	ActivityPostBuildHook ActivityType = 110
+	ActivityBuildWaiting  ActivityType = 111
+	ActivityFetchTree     ActivityType = 112
+)
+
+// ResultType describes what an activity result is about.
+type ResultType uint64
+
+const (
+	ResultFileLinked       ResultType = 100
+	ResultBuildLogLine     ResultType = 101
+	ResultUntrustedPath    ResultType = 102
+	ResultCorruptedPath    ResultType = 103
+	ResultSetPhase         ResultType = 104
+	ResultProgress         ResultType = 105
+	ResultSetExpected      ResultType = 106
+	ResultPostBuildLogLine ResultType = 107
+	ResultFetchStatus      ResultType = 108
+)
+
+// FieldType is the type of a Field.
+type FieldType uint64
+
+const (
+	FieldTypeInt    FieldType = 0
+	FieldTypeString FieldType = 1
+)
+
+// Field is a single field of an activity or result.
+// Depending on Type, either Int or String is set.
+type Field struct {
+	Type   FieldType
+	Int    uint64
+	String string
+}
+
+// Activity is started by the daemon when it begins working on something,
+// like a build or a download.
+type Activity struct {
+	ID     uint64
+	Level  Verbosity
+	Type   ActivityType
+	Text   string
+	Fields []Field
+	Parent uint64
+}
+
+// ActivityResult is sent by the daemon to report progress of an activity,
+// like a build log line.
+type ActivityResult struct {
+	ActivityID uint64
+	Type       ResultType
+	Fields     []Field
+}
+
+// Logger receives the log messages and activities sent by the daemon
+// while it's processing an operation.
+type Logger interface {
+	Log(msg string)
+	StartActivity(act Activity)
+	StopActivity(id uint64)
+	Result(res ActivityResult)
+}
+
+// discardLogger is a Logger discarding everything.
+type discardLogger struct{}
+
+func (discardLogger) Log(string)             {}
+func (discardLogger) StartActivity(Activity) {}
+func (discardLogger) StopActivity(uint64)    {}
+func (discardLogger) Result(ActivityResult)  {}
+
+func readFields(r io.Reader) ([]Field, error) {
+	n, err := wire.ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]Field, 0, wire.CapHint(n))
+
+	for i := uint64(0); i < n; i++ {
+		t, err := wire.ReadUint64(r)
+		if err != nil {
+			return nil, err
+		}
+
+		f := Field{Type: FieldType(t)}
+
+		switch f.Type {
+		case FieldTypeInt:
+			f.Int, err = wire.ReadUint64(r)
+		case FieldTypeString:
+			f.String, err = wire.ReadString(r, maxStringSize)
+		default:
+			return nil, fmt.Errorf("unknown field type %d", t)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+func writeFields(w io.Writer, fields []Field) error {
+	if err := wire.WriteUint64(w, uint64(len(fields))); err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		if err := wire.WriteUint64(w, uint64(f.Type)); err != nil {
+			return err
+		}
+
+		var err error
+
+		switch f.Type {
+		case FieldTypeInt:
+			err = wire.WriteUint64(w, f.Int)
+		case FieldTypeString:
+			err = wire.WriteString(w, f.String)
+		default:
+			err = fmt.Errorf("unknown field type %d", f.Type)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readActivity(r io.Reader) (Activity, error) {
+	var (
+		act Activity
+		err error
+		n   uint64
+	)
+
+	if act.ID, err = wire.ReadUint64(r); err != nil {
+		return act, err
+	}
+
+	if n, err = wire.ReadUint64(r); err != nil {
+		return act, err
+	}
+
+	act.Level = Verbosity(n)
+
+	if n, err = wire.ReadUint64(r); err != nil {
+		return act, err
+	}
+
+	act.Type = ActivityType(n)
+
+	if act.Text, err = wire.ReadString(r, maxStringSize); err != nil {
+		return act, err
+	}
+
+	if act.Fields, err = readFields(r); err != nil {
+		return act, err
+	}
+
+	act.Parent, err = wire.ReadUint64(r)
+
+	return act, err
+}
+
+func writeActivity(w io.Writer, act Activity) error {
+	for _, n := range []uint64{act.ID, uint64(act.Level), uint64(act.Type)} {
+		if err := wire.WriteUint64(w, n); err != nil {
+			return err
+		}
+	}
+
+	if err := wire.WriteString(w, act.Text); err != nil {
+		return err
+	}
+
+	if err := writeFields(w, act.Fields); err != nil {
+		return err
+	}
+
+	return wire.WriteUint64(w, act.Parent)
+}
+
+func readActivityResult(r io.Reader) (ActivityResult, error) {
+	var (
+		res ActivityResult
+		err error
+		n   uint64
+	)
+
+	if res.ActivityID, err = wire.ReadUint64(r); err != nil {
+		return res, err
+	}
+
+	if n, err = wire.ReadUint64(r); err != nil {
+		return res, err
+	}
+
+	res.Type = ResultType(n)
+	res.Fields, err = readFields(r)
+
+	return res, err
+}
+
+func writeActivityResult(w io.Writer, res ActivityResult) error {
+	if err := wire.WriteUint64(w, res.ActivityID); err != nil {
+		return err
+	}
+
+	if err := wire.WriteUint64(w, uint64(res.Type)); err != nil {
+		return err
+	}
+
+	return writeFields(w, res.Fields)
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context carrying logger.
+// The server uses it to pass a Logger sending messages to the client to
+// the store, which can retrieve it with LoggerFromContext.
+func WithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the Logger carried by ctx,
+// or a Logger discarding everything if there's none.
+func LoggerFromContext(ctx context.Context) Logger { //nolint:ireturn
+	if logger, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return logger
+	}
+
+	return discardLogger{}
+}
@@ -0,0 +1,119 @@
+// Package daemon implements the Nix daemon worker protocol, which is spoken
+// between Nix clients and the nix-daemon over a Unix socket.
+package daemon
+
+import "fmt"
+
+const (
+	// DefaultSocketPath is the default location of the nix-daemon socket.
+	DefaultSocketPath = "/nix/var/nix/daemon-socket/socket"
+
+	// WorkerMagic1 is sent by the client to initiate the handshake.
+	WorkerMagic1 = 0x6e697863
+	// WorkerMagic2 is sent back by the daemon.
+	WorkerMagic2 = 0x6478696f
+
+	// ProtocolVersion is the (highest) protocol version we speak.
+	ProtocolVersion = 1<<8 | 37
+	// MinProtocolVersion is the lowest protocol version we accept.
+	// It's the first version sending NARs in AddToStoreNar framed.
+	MinProtocolVersion = 1<<8 | 23
+
+	// maxStringSize is the maximum size of strings (paths, log lines, …)
+	// we accept from the other side.
+	maxStringSize = 64 << 20
+)
+
+// ProtocolMajor returns the major part of a protocol version.
+func ProtocolMajor(version uint64) uint64 {
+	return version & 0xff00
+}
+
+// ProtocolMinor returns the minor part of a protocol version.
+func ProtocolMinor(version uint64) uint64 {
+	return version & 0x00ff
+}
+
+// checkVersion returns an error if the protocol version announced by the other side
+// isn't supported.
+func checkVersion(version uint64) error {
+	if ProtocolMajor(version) != ProtocolMajor(ProtocolVersion) || version < MinProtocolVersion {
+		return fmt.Errorf(
+			"unsupported protocol version %d.%d, need at least %d.%d",
+			ProtocolMajor(version)>>8, ProtocolMinor(version),
+			ProtocolMajor(MinProtocolVersion)>>8, ProtocolMinor(MinProtocolVersion),
+		)
+	}
+
+	return nil
+}
+
+// Op is the number of a worker operation.
+type Op uint64
+
+const (
+	OpIsValidPath                 Op = 1
+	OpHasSubstitutes              Op = 3
+	OpQueryReferrers              Op = 6
+	OpAddToStore                  Op = 7
+	OpBuildPaths                  Op = 9
+	OpEnsurePath                  Op = 10
+	OpAddTempRoot                 Op = 11
+	OpAddIndirectRoot             Op = 12
+	OpFindRoots                   Op = 14
+	OpSetOptions                  Op = 19
+	OpCollectGarbage              Op = 20
+	OpQueryAllValidPaths          Op = 23
+	OpQueryPathInfo               Op = 26
+	OpQueryPathFromHashPart       Op = 29
+	OpQuerySubstitutablePathInfos Op = 30
+	OpQueryValidPaths             Op = 31
+	OpQuerySubstitutablePaths     Op = 32
+	OpQueryValidDerivers          Op = 33
+	OpOptimiseStore               Op = 34
+	OpVerifyStore                 Op = 35
+	OpBuildDerivation             Op = 36
+	OpAddSignatures               Op = 37
+	OpNarFromPath                 Op = 38
+	OpAddToStoreNar               Op = 39
+	OpQueryMissing                Op = 40
+	OpQueryDerivationOutputMap    Op = 41
+	OpRegisterDrvOutput           Op = 42
+	OpQueryRealisation            Op = 43
+	OpAddMultipleToStore          Op = 44
+	OpAddBuildLog                 Op = 45
+	OpBuildPathsWithResults       Op = 46
+	OpAddPermRoot                 Op = 47
+)
+
+// Codes of the messages the daemon sends while processing an operation,
+// before sending the result.
+const (
+	stderrNext          = 0x6f6c6d67
+	stderrRead          = 0x64617461
+	stderrWrite         = 0x64617416
+	stderrLast          = 0x616c7473
+	stderrError         = 0x63787470
+	stderrStartActivity = 0x53545254
+	stderrStopActivity  = 0x53544f50
+	stderrResult        = 0x52534c54
+)
+
+// TrustedFlag describes whether the daemon trusts the client.
+// It's only sent by daemons speaking protocol version 1.35 or newer.
+type TrustedFlag uint64
+
+const (
+	TrustedUnknown TrustedFlag = 0
+	Trusted        TrustedFlag = 1
+	NotTrusted     TrustedFlag = 2
+)
+
+// BuildMode specifies how BuildPaths should build.
+type BuildMode uint64
+
+const (
+	BuildModeNormal BuildMode = 0
+	BuildModeRepair BuildMode = 1
+	BuildModeCheck  BuildMode = 2
+)
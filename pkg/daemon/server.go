@@ -0,0 +1,601 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+
+	"github.com/nix-community/go-nix/pkg/derivedpath"
+	"github.com/nix-community/go-nix/pkg/wire"
+)
+
+// Store is the interface a store needs to implement to be served by Server.
+// These are the operations every store needs to support.
+//
+// All other operations are optional, and only available if the store
+// implements the corresponding interface (like ReferrersQuerier, NarProvider, …).
+// Clients calling an operation that's not implemented get an error.
+//
+// Logs and activities can be sent to the client through the Logger returned
+// by LoggerFromContext.
+type Store interface {
+	IsValidPath(ctx context.Context, path string) (bool, error)
+	// QueryPathInfo returns nil if the path is not valid.
+	QueryPathInfo(ctx context.Context, path string) (*PathInfo, error)
+}
+
+// ReferrersQuerier is implemented by stores supporting OpQueryReferrers.
+type ReferrersQuerier interface {
+	QueryReferrers(ctx context.Context, path string) ([]string, error)
+}
+
+// NarProvider is implemented by stores supporting OpNarFromPath.
+type NarProvider interface {
+	// NarFromPath writes the NAR serialization of path to w.
+	// Errors returned before anything has been written are sent to the client,
+	// later ones close the connection.
+	NarFromPath(ctx context.Context, path string, w io.Writer) error
+}
+
+// NarAdder is implemented by stores supporting OpAddToStoreNar.
+type NarAdder interface {
+	AddToStoreNar(ctx context.Context, info *PathInfo, narReader io.Reader, repair bool, dontCheckSigs bool) error
+}
+
+// Builder is implemented by stores supporting OpBuildPaths.
+type Builder interface {
+	BuildPaths(ctx context.Context, paths []*derivedpath.DerivedPath, mode BuildMode) error
+}
+
+// MissingQuerier is implemented by stores supporting OpQueryMissing.
+type MissingQuerier interface {
+	QueryMissing(ctx context.Context, paths []*derivedpath.DerivedPath) (*MissingInfo, error)
+}
+
+// OptionsSetter is implemented by stores interested in the options sent by
+// clients with OpSetOptions. Other stores silently ignore them.
+type OptionsSetter interface {
+	SetOptions(ctx context.Context, opts *ClientOptions) error
+}
+
+// Server serves the daemon protocol, dispatching operations to a Store.
+type Server struct {
+	Store Store
+
+	// NixVersion is sent to clients speaking protocol version 1.33 or newer.
+	NixVersion string
+	// Trusted is sent to clients speaking protocol version 1.35 or newer.
+	Trusted TrustedFlag
+}
+
+// NewServer returns a Server serving store.
+func NewServer(store Store) *Server {
+	return &Server{
+		Store:      store,
+		NixVersion: "go-nix",
+		Trusted:    TrustedUnknown,
+	}
+}
+
+// Serve accepts connections on l, and serves each of them in a new goroutine.
+// It returns once accepting fails, like when l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			_ = s.ServeConn(context.Background(), conn)
+		}()
+	}
+}
+
+// ServeConn serves a single connection, until the client disconnects,
+// an error occurs, or ctx is cancelled. It closes conn when done.
+func (s *Server) ServeConn(ctx context.Context, conn io.ReadWriteCloser) error {
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// interrupt any pending I/O when ctx is cancelled.
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	sc := &serverConn{
+		s: s,
+		r: bufio.NewReader(conn),
+		w: bufio.NewWriter(conn),
+	}
+
+	if err := sc.handshake(); err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+
+	for {
+		op, err := wire.ReadUint64(sc.r)
+		if err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		if err := sc.handle(ctx, Op(op)); err != nil {
+			return fmt.Errorf("error handling operation %d: %w", op, err)
+		}
+
+		if err := sc.flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// serverConn is the state of a single connection.
+type serverConn struct {
+	s *Server
+	r *bufio.Reader
+
+	// mu protects w and working, as loggers might be called from multiple goroutines.
+	mu sync.Mutex
+	w  *bufio.Writer
+	// working is set while an operation is processed, and messages
+	// can be sent to the client.
+	working bool
+
+	version uint64
+}
+
+func (sc *serverConn) flush() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.w.Flush()
+}
+
+func (sc *serverConn) handshake() error {
+	if err := expectUint64(sc.r, WorkerMagic1); err != nil {
+		return fmt.Errorf("invalid magic: %w", err)
+	}
+
+	if err := wire.WriteUint64(sc.w, WorkerMagic2); err != nil {
+		return err
+	}
+
+	if err := wire.WriteUint64(sc.w, ProtocolVersion); err != nil {
+		return err
+	}
+
+	if err := sc.w.Flush(); err != nil {
+		return err
+	}
+
+	clientVersion, err := wire.ReadUint64(sc.r)
+	if err != nil {
+		return err
+	}
+
+	if err := checkVersion(clientVersion); err != nil {
+		return err
+	}
+
+	sc.version = ProtocolVersion
+	if clientVersion < sc.version {
+		sc.version = clientVersion
+	}
+
+	// obsolete CPU affinity
+	hasAffinity, err := wire.ReadBool(sc.r)
+	if err != nil {
+		return err
+	}
+
+	if hasAffinity {
+		if _, err := wire.ReadUint64(sc.r); err != nil {
+			return err
+		}
+	}
+
+	// obsolete reserveSpace
+	if _, err := wire.ReadBool(sc.r); err != nil {
+		return err
+	}
+
+	if ProtocolMinor(sc.version) >= 33 {
+		if err := wire.WriteString(sc.w, sc.s.NixVersion); err != nil {
+			return err
+		}
+	}
+
+	if ProtocolMinor(sc.version) >= 35 {
+		if err := wire.WriteUint64(sc.w, uint64(sc.s.Trusted)); err != nil {
+			return err
+		}
+	}
+
+	if err := wire.WriteUint64(sc.w, stderrLast); err != nil {
+		return err
+	}
+
+	return sc.w.Flush()
+}
+
+// send writes a message to the client, if an operation is being processed.
+// Messages sent at other times are dropped.
+func (sc *serverConn) send(code uint64, fn func(w io.Writer) error) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if !sc.working {
+		return nil
+	}
+
+	if err := wire.WriteUint64(sc.w, code); err != nil {
+		return err
+	}
+
+	if err := fn(sc.w); err != nil {
+		return err
+	}
+
+	// messages should reach the client immediately.
+	return sc.w.Flush()
+}
+
+// startWork allows sending messages to the client, until stopWork is called.
+func (sc *serverConn) startWork() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.working = true
+}
+
+// stopWork sends either stderrLast, or the error if it's not nil.
+// No more messages can be sent afterwards.
+func (sc *serverConn) stopWork(err error) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.working = false
+
+	if err == nil {
+		return wire.WriteUint64(sc.w, stderrLast)
+	}
+
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Level: VerbosityError, Message: err.Error()}
+	}
+
+	if err := wire.WriteUint64(sc.w, stderrError); err != nil {
+		return err
+	}
+
+	return writeError(sc.w, sc.version, e)
+}
+
+// work calls fn with a context carrying a logger sending to the client.
+// It returns whether fn succeeded. If it didn't, the error has been sent
+// to the client, and no result must be sent.
+// The returned error is only set on I/O errors.
+func (sc *serverConn) work(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	sc.startWork()
+
+	err := fn(WithLogger(ctx, &serverLogger{sc: sc}))
+
+	return err == nil, sc.stopWork(err)
+}
+
+// errNotSupported is sent to clients calling operations the store doesn't implement.
+func errNotSupported(op Op) error {
+	return fmt.Errorf("operation %d is not supported by this store", op)
+}
+
+func (sc *serverConn) readDerivedPaths() ([]*derivedpath.DerivedPath, error) {
+	strs, err := readStrings(sc.r)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]*derivedpath.DerivedPath, len(strs))
+
+	for i, s := range strs {
+		if ProtocolMinor(sc.version) >= 30 {
+			paths[i], err = derivedpath.Parse(s)
+		} else {
+			paths[i], err = derivedpath.ParseLegacy(s)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+// handle reads the arguments of op, dispatches it to the store, and writes the result.
+func (sc *serverConn) handle(ctx context.Context, op Op) error { //nolint:gocognit,gocyclo,cyclop
+	store := sc.s.Store
+
+	switch op {
+	case OpIsValidPath:
+		path, err := wire.ReadString(sc.r, maxStringSize)
+		if err != nil {
+			return err
+		}
+
+		var valid bool
+
+		ok, err := sc.work(ctx, func(ctx context.Context) (err error) {
+			valid, err = store.IsValidPath(ctx, path)
+
+			return err
+		})
+		if !ok || err != nil {
+			return err
+		}
+
+		return wire.WriteBool(sc.w, valid)
+
+	case OpQueryPathInfo:
+		path, err := wire.ReadString(sc.r, maxStringSize)
+		if err != nil {
+			return err
+		}
+
+		var info *PathInfo
+
+		ok, err := sc.work(ctx, func(ctx context.Context) (err error) {
+			info, err = store.QueryPathInfo(ctx, path)
+
+			return err
+		})
+		if !ok || err != nil {
+			return err
+		}
+
+		if err := wire.WriteBool(sc.w, info != nil); err != nil || info == nil {
+			return err
+		}
+
+		return writePathInfo(sc.w, info)
+
+	case OpQueryReferrers:
+		path, err := wire.ReadString(sc.r, maxStringSize)
+		if err != nil {
+			return err
+		}
+
+		var referrers []string
+
+		ok, err := sc.work(ctx, func(ctx context.Context) (err error) {
+			q, ok := store.(ReferrersQuerier)
+			if !ok {
+				return errNotSupported(op)
+			}
+
+			referrers, err = q.QueryReferrers(ctx, path)
+
+			return err
+		})
+		if !ok || err != nil {
+			return err
+		}
+
+		return writeStrings(sc.w, referrers)
+
+	case OpNarFromPath:
+		path, err := wire.ReadString(sc.r, maxStringSize)
+		if err != nil {
+			return err
+		}
+
+		return sc.narFromPath(ctx, path)
+
+	case OpAddToStoreNar:
+		return sc.addToStoreNar(ctx)
+
+	case OpBuildPaths:
+		paths, err := sc.readDerivedPaths()
+		if err != nil {
+			return err
+		}
+
+		mode, err := wire.ReadUint64(sc.r)
+		if err != nil {
+			return err
+		}
+
+		ok, err := sc.work(ctx, func(ctx context.Context) error {
+			b, ok := store.(Builder)
+			if !ok {
+				return errNotSupported(op)
+			}
+
+			return b.BuildPaths(ctx, paths, BuildMode(mode))
+		})
+		if !ok || err != nil {
+			return err
+		}
+
+		return wire.WriteUint64(sc.w, 1)
+
+	case OpQueryMissing:
+		paths, err := sc.readDerivedPaths()
+		if err != nil {
+			return err
+		}
+
+		var info *MissingInfo
+
+		ok, err := sc.work(ctx, func(ctx context.Context) (err error) {
+			q, ok := store.(MissingQuerier)
+			if !ok {
+				return errNotSupported(op)
+			}
+
+			info, err = q.QueryMissing(ctx, paths)
+
+			return err
+		})
+		if !ok || err != nil {
+			return err
+		}
+
+		return wire.NewEncoder(sc.w).Encode(info)
+
+	case OpSetOptions:
+		opts, err := readClientOptions(sc.r)
+		if err != nil {
+			return err
+		}
+
+		_, err = sc.work(ctx, func(ctx context.Context) error {
+			if setter, ok := store.(OptionsSetter); ok {
+				return setter.SetOptions(ctx, opts)
+			}
+
+			return nil
+		})
+
+		return err
+
+	default:
+		// we don't know how to read the arguments, so can't continue. Like
+		// Nix, tell the client why before closing the connection.
+		err := fmt.Errorf("invalid operation %d", op)
+
+		sc.startWork()
+
+		if werr := sc.stopWork(err); werr != nil {
+			return werr
+		}
+
+		if werr := sc.flush(); werr != nil {
+			return werr
+		}
+
+		return err
+	}
+}
+
+// narFromPath sends the NAR after stderrLast, so only errors returned by
+// the store before writing anything can be sent to the client.
+func (sc *serverConn) narFromPath(ctx context.Context, path string) error {
+	sc.startWork()
+
+	p, ok := sc.s.Store.(NarProvider)
+	if !ok {
+		return sc.stopWork(errNotSupported(OpNarFromPath))
+	}
+
+	lw := &lazyWriter{sc: sc}
+
+	err := p.NarFromPath(WithLogger(ctx, &serverLogger{sc: sc}), path, lw)
+
+	if !lw.started {
+		return sc.stopWork(err)
+	}
+
+	return err
+}
+
+// lazyWriter stops work before writing the first byte to the client.
+type lazyWriter struct {
+	sc      *serverConn
+	started bool
+}
+
+func (lw *lazyWriter) Write(p []byte) (int, error) {
+	if !lw.started {
+		lw.started = true
+
+		if err := lw.sc.stopWork(nil); err != nil {
+			return 0, err
+		}
+	}
+
+	return lw.sc.w.Write(p)
+}
+
+func (sc *serverConn) addToStoreNar(ctx context.Context) error {
+	path, err := wire.ReadString(sc.r, maxStringSize)
+	if err != nil {
+		return err
+	}
+
+	info, err := readPathInfo(sc.r)
+	if err != nil {
+		return err
+	}
+
+	info.Path = path
+
+	repair, err := wire.ReadBool(sc.r)
+	if err != nil {
+		return err
+	}
+
+	dontCheckSigs, err := wire.ReadBool(sc.r)
+	if err != nil {
+		return err
+	}
+
+	fr := wire.NewFramedReader(sc.r, math.MaxUint64)
+
+	sc.startWork()
+
+	if adder, ok := sc.s.Store.(NarAdder); ok {
+		err = adder.AddToStoreNar(WithLogger(ctx, &serverLogger{sc: sc}), info, fr, repair, dontCheckSigs)
+	} else {
+		err = errNotSupported(OpAddToStoreNar)
+	}
+
+	// The client sends all frames in any case, so consume the rest.
+	if readErr := fr.Close(); readErr != nil {
+		return readErr
+	}
+
+	return sc.stopWork(err)
+}
+
+// serverLogger sends everything to the client.
+// Errors are ignored, as they'll show up when sending the result.
+type serverLogger struct {
+	sc *serverConn
+}
+
+func (l *serverLogger) Log(msg string) {
+	_ = l.sc.send(stderrNext, func(w io.Writer) error {
+		return wire.WriteString(w, msg)
+	})
+}
+
+func (l *serverLogger) StartActivity(act Activity) {
+	_ = l.sc.send(stderrStartActivity, func(w io.Writer) error {
+		return writeActivity(w, act)
+	})
+}
+
+func (l *serverLogger) StopActivity(id uint64) {
+	_ = l.sc.send(stderrStopActivity, func(w io.Writer) error {
+		return wire.WriteUint64(w, id)
+	})
+}
+
+func (l *serverLogger) Result(res ActivityResult) {
+	_ = l.sc.send(stderrResult, func(w io.Writer) error {
+		return writeActivityResult(w, res)
+	})
+}
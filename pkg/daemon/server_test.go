@@ -0,0 +1,366 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/derivedpath"
+	"github.com/nix-community/go-nix/pkg/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readOnlyStore only implements the query operations.
+type readOnlyStore struct {
+	infos map[string]*PathInfo
+}
+
+func (s *readOnlyStore) IsValidPath(_ context.Context, path string) (bool, error) {
+	_, ok := s.infos[path]
+
+	return ok, nil
+}
+
+func (s *readOnlyStore) QueryPathInfo(_ context.Context, path string) (*PathInfo, error) {
+	return s.infos[path], nil
+}
+
+func (s *readOnlyStore) QueryReferrers(_ context.Context, path string) ([]string, error) {
+	if _, ok := s.infos[path]; !ok {
+		return nil, fmt.Errorf("path '%s' is not valid", path)
+	}
+
+	referrers := []string{}
+
+	for p, info := range s.infos {
+		for _, ref := range info.References {
+			if ref == path {
+				referrers = append(referrers, p)
+			}
+		}
+	}
+
+	return referrers, nil
+}
+
+// memStore is a store keeping everything in memory, implementing all operations.
+type memStore struct {
+	readOnlyStore
+
+	mu    sync.Mutex
+	nars  map[string][]byte
+	built []*derivedpath.DerivedPath
+	opts  *ClientOptions
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		readOnlyStore: readOnlyStore{infos: make(map[string]*PathInfo)},
+		nars:          make(map[string][]byte),
+	}
+}
+
+func (s *memStore) NarFromPath(_ context.Context, path string, w io.Writer) error {
+	narContents, ok := s.nars[path]
+	if !ok {
+		return fmt.Errorf("path '%s' is not valid", path)
+	}
+
+	_, err := w.Write(narContents)
+
+	return err
+}
+
+func (s *memStore) AddToStoreNar(
+	ctx context.Context,
+	info *PathInfo,
+	narReader io.Reader,
+	_ bool,
+	_ bool,
+) error {
+	LoggerFromContext(ctx).Log("adding " + info.Path)
+
+	narContents, err := io.ReadAll(narReader)
+	if err != nil {
+		return err
+	}
+
+	if uint64(len(narContents)) != info.NarSize {
+		return fmt.Errorf("nar size mismatch")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.infos[info.Path] = info
+	s.nars[info.Path] = narContents
+
+	return nil
+}
+
+func (s *memStore) BuildPaths(ctx context.Context, paths []*derivedpath.DerivedPath, _ BuildMode) error {
+	logger := LoggerFromContext(ctx)
+
+	for i, p := range paths {
+		act := Activity{ID: uint64(i + 1), Type: ActivityBuild, Text: "building " + p.String()}
+
+		logger.StartActivity(act)
+		logger.Result(ActivityResult{ActivityID: act.ID, Type: ResultBuildLogLine, Fields: []Field{
+			{Type: FieldTypeString, String: "Hello, world!"},
+		}})
+		logger.StopActivity(act.ID)
+	}
+
+	s.built = append(s.built, paths...)
+
+	return nil
+}
+
+func (s *memStore) QueryMissing(_ context.Context, paths []*derivedpath.DerivedPath) (*MissingInfo, error) {
+	info := &MissingInfo{WillBuild: []string{}, WillSubstitute: []string{}, Unknown: []string{}}
+
+	for _, p := range paths {
+		info.WillBuild = append(info.WillBuild, p.String())
+	}
+
+	return info, nil
+}
+
+func (s *memStore) SetOptions(_ context.Context, opts *ClientOptions) error {
+	s.opts = opts
+
+	return nil
+}
+
+// newServerTestClient serves store on one end of a pipe, and returns a client
+// connected to the other end.
+func newServerTestClient(t *testing.T, store Store, logger Logger) *Client {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+
+	serveErr := make(chan error, 1)
+
+	go func() {
+		serveErr <- NewServer(store).ServeConn(context.Background(), serverConn)
+	}()
+
+	c, err := NewClient(clientConn, logger)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		c.Close()
+		assert.NoError(t, <-serveErr, "server failed")
+	})
+
+	return c
+}
+
+func TestServerHandshake(t *testing.T) {
+	c := newServerTestClient(t, newMemStore(), nil)
+
+	assert.Equal(t, uint64(ProtocolVersion), c.ProtocolVersion())
+	assert.Equal(t, "go-nix", c.DaemonVersion())
+	assert.Equal(t, TrustedUnknown, c.Trusted())
+
+	t.Run("too old client", func(t *testing.T) {
+		clientConn, serverConn := net.Pipe()
+
+		serveErr := make(chan error, 1)
+
+		go func() {
+			serveErr <- NewServer(newMemStore()).ServeConn(context.Background(), serverConn)
+		}()
+
+		require.NoError(t, wire.WriteUint64(clientConn, WorkerMagic1))
+		require.NoError(t, expectUint64(clientConn, WorkerMagic2))
+		require.NoError(t, expectUint64(clientConn, ProtocolVersion))
+		require.NoError(t, wire.WriteUint64(clientConn, 1<<8|21))
+
+		assert.ErrorContains(t, <-serveErr, "unsupported protocol version 1.21")
+		clientConn.Close()
+	})
+}
+
+func TestServerQueries(t *testing.T) {
+	ctx := context.Background()
+
+	store := newMemStore()
+	store.infos[pathGlibc] = genPathInfo(pathGlibc, genNar(t, "glibc"))
+	store.infos[pathHello] = genPathInfo(pathHello, genNar(t, "hello"), pathGlibc)
+
+	c := newServerTestClient(t, store, nil)
+
+	valid, err := c.IsValidPath(ctx, pathHello)
+	require.NoError(t, err)
+	assert.True(t, valid)
+
+	valid, err = c.IsValidPath(ctx, pathDrv)
+	require.NoError(t, err)
+	assert.False(t, valid)
+
+	info, err := c.QueryPathInfo(ctx, pathHello)
+	require.NoError(t, err)
+	assert.Equal(t, store.infos[pathHello], info)
+
+	info, err = c.QueryPathInfo(ctx, pathDrv)
+	require.NoError(t, err)
+	assert.Nil(t, info)
+
+	referrers, err := c.QueryReferrers(ctx, pathGlibc)
+	require.NoError(t, err)
+	assert.Equal(t, []string{pathHello}, referrers)
+
+	_, err = c.QueryReferrers(ctx, pathDrv)
+
+	var daemonErr *Error
+	if assert.ErrorAs(t, err, &daemonErr) {
+		assert.Equal(t, "path '"+pathDrv+"' is not valid", daemonErr.Message)
+	}
+
+	require.NoError(t, c.SetOptions(ctx, &ClientOptions{
+		KeepGoing:      true,
+		Verbosity:      VerbosityTalkative,
+		MaxBuildJobs:   4,
+		UseSubstitutes: true,
+		Overrides:      map[string]string{"substituters": "https://cache.nixos.org"},
+	}))
+	assert.Equal(t, &ClientOptions{
+		KeepGoing:      true,
+		Verbosity:      VerbosityTalkative,
+		MaxBuildJobs:   4,
+		UseSubstitutes: true,
+		Overrides:      map[string]string{"substituters": "https://cache.nixos.org"},
+	}, store.opts)
+}
+
+func TestServerNars(t *testing.T) {
+	ctx := context.Background()
+
+	store := newMemStore()
+	logger := &recordingLogger{}
+	c := newServerTestClient(t, store, logger)
+
+	narContents := genNar(t, string(bytes.Repeat([]byte("hello"), wire.DefaultFrameSize)))
+	info := genPathInfo(pathHello, narContents)
+
+	require.NoError(t, c.AddToStoreNar(ctx, info, bytes.NewReader(narContents), false, false))
+	assert.Equal(t, info, store.infos[pathHello])
+	assert.Equal(t, []string{"adding " + pathHello}, logger.msgs)
+
+	var buf bytes.Buffer
+
+	require.NoError(t, c.NarFromPath(ctx, pathHello, &buf))
+	assert.Equal(t, narContents, buf.Bytes())
+
+	t.Run("rejected", func(t *testing.T) {
+		info := genPathInfo(pathGlibc, narContents)
+		info.NarSize++
+
+		err := c.AddToStoreNar(ctx, info, bytes.NewReader(narContents), false, false)
+		assert.ErrorContains(t, err, "nar size mismatch")
+
+		valid, err := c.IsValidPath(ctx, pathGlibc)
+		require.NoError(t, err)
+		assert.False(t, valid)
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		err := c.NarFromPath(ctx, pathDrv, io.Discard)
+		assert.ErrorContains(t, err, "is not valid")
+
+		// the connection is still usable
+		valid, err := c.IsValidPath(ctx, pathHello)
+		require.NoError(t, err)
+		assert.True(t, valid)
+	})
+}
+
+func TestServerBuild(t *testing.T) {
+	ctx := context.Background()
+
+	store := newMemStore()
+	logger := &recordingLogger{}
+	c := newServerTestClient(t, store, logger)
+
+	paths := []*derivedpath.DerivedPath{
+		{Path: pathHello},
+		{DrvPath: &derivedpath.SingleDerivedPath{Path: pathDrv}, Outputs: derivedpath.OutputsSpec{All: true}},
+	}
+
+	require.NoError(t, c.BuildPaths(ctx, paths, BuildModeNormal))
+	assert.Equal(t, paths, store.built)
+
+	assert.Equal(t, []Activity{
+		{ID: 1, Type: ActivityBuild, Text: "building " + pathHello, Fields: []Field{}},
+		{ID: 2, Type: ActivityBuild, Text: "building " + pathDrv + "^*", Fields: []Field{}},
+	}, logger.activities)
+	assert.Equal(t, []uint64{1, 2}, logger.stopped)
+	assert.Len(t, logger.results, 2)
+
+	missing, err := c.QueryMissing(ctx, paths)
+	require.NoError(t, err)
+	// sets are sent sorted, like Nix does
+	assert.Equal(t, []string{pathDrv + "^*", pathHello}, missing.WillBuild)
+}
+
+func TestServerReadOnly(t *testing.T) {
+	ctx := context.Background()
+
+	store := &readOnlyStore{infos: map[string]*PathInfo{}}
+	store.infos[pathHello] = genPathInfo(pathHello, genNar(t, "hello"))
+
+	c := newServerTestClient(t, store, nil)
+
+	narContents := genNar(t, string(bytes.Repeat([]byte("glibc"), wire.DefaultFrameSize)))
+
+	err := c.AddToStoreNar(ctx, genPathInfo(pathGlibc, narContents), bytes.NewReader(narContents), false, false)
+	assert.ErrorContains(t, err, "not supported")
+
+	err = c.NarFromPath(ctx, pathHello, io.Discard)
+	assert.ErrorContains(t, err, "not supported")
+
+	err = c.BuildPaths(ctx, []*derivedpath.DerivedPath{{Path: pathHello}}, BuildModeNormal)
+	assert.ErrorContains(t, err, "not supported")
+
+	_, err = c.QueryMissing(ctx, []*derivedpath.DerivedPath{{Path: pathHello}})
+	assert.ErrorContains(t, err, "not supported")
+
+	// options are ignored
+	require.NoError(t, c.SetOptions(ctx, &ClientOptions{}))
+
+	// query operations still work
+	valid, err := c.IsValidPath(ctx, pathHello)
+	require.NoError(t, err)
+	assert.True(t, valid)
+}
+
+func TestServerInvalidOperation(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+
+	serveErr := make(chan error, 1)
+
+	go func() {
+		serveErr <- NewServer(newMemStore()).ServeConn(context.Background(), serverConn)
+	}()
+
+	c, err := NewClient(clientConn, nil)
+	require.NoError(t, err)
+
+	defer c.Close()
+
+	// the error reaches the client before the connection is closed
+	err = c.do(context.Background(), Op(999), c.flushAndProcessStderr)
+
+	var daemonErr *Error
+	if assert.ErrorAs(t, err, &daemonErr) {
+		assert.Equal(t, "invalid operation 999", daemonErr.Message)
+	}
+
+	assert.ErrorContains(t, <-serveErr, "invalid operation 999")
+}
@@ -0,0 +1,327 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/wire"
+)
+
+// PathInfo describes a valid path in the store.
+type PathInfo struct {
+	Path    string
+	Deriver string // Empty if unknown
+	// NarHash is the sha256 hash of the NAR serialization of the path.
+	NarHash          *nixhash.Hash
+	References       []string
+	RegistrationTime time.Time
+	NarSize          uint64
+	// Ultimate is set if the path was built locally, and not substituted.
+	Ultimate   bool
+	Signatures []signature.Signature
+	CA         string // Empty if not content-addressed
+}
+
+func readStrings(r io.Reader) ([]string, error) {
+	n, err := wire.ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, 0, wire.CapHint(n))
+
+	for i := uint64(0); i < n; i++ {
+		s, err := wire.ReadString(r, maxStringSize)
+		if err != nil {
+			return nil, err
+		}
+
+		strs = append(strs, s)
+	}
+
+	return strs, nil
+}
+
+func writeStrings(w io.Writer, strs []string) error {
+	if err := wire.WriteUint64(w, uint64(len(strs))); err != nil {
+		return err
+	}
+
+	for _, s := range strs {
+		if err := wire.WriteString(w, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readPathInfo reads a path info, without the path itself,
+// which is only sent in some places.
+func readPathInfo(r io.Reader) (*PathInfo, error) {
+	var (
+		info PathInfo
+		err  error
+	)
+
+	if info.Deriver, err = wire.ReadString(r, maxStringSize); err != nil {
+		return nil, err
+	}
+
+	narHash, err := wire.ReadString(r, maxStringSize)
+	if err != nil {
+		return nil, err
+	}
+
+	algo := nixhash.SHA256
+
+	h, err := nixhash.ParseAny(narHash, &algo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nar hash: %w", err)
+	}
+
+	info.NarHash = &h.Hash
+
+	if info.References, err = readStrings(r); err != nil {
+		return nil, err
+	}
+
+	registrationTime, err := wire.ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if registrationTime != 0 {
+		info.RegistrationTime = time.Unix(int64(registrationTime), 0) //nolint:gosec
+	}
+
+	if info.NarSize, err = wire.ReadUint64(r); err != nil {
+		return nil, err
+	}
+
+	if info.Ultimate, err = wire.ReadBool(r); err != nil {
+		return nil, err
+	}
+
+	sigs, err := readStrings(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range sigs {
+		sig, err := signature.ParseSignature(s)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Signatures = append(info.Signatures, sig)
+	}
+
+	if info.CA, err = wire.ReadString(r, maxStringSize); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// writePathInfo writes a path info, without the path itself.
+func writePathInfo(w io.Writer, info *PathInfo) error {
+	if err := wire.WriteString(w, info.Deriver); err != nil {
+		return err
+	}
+
+	if info.NarHash == nil || info.NarHash.Algo() != nixhash.SHA256 {
+		return fmt.Errorf("nar hash needs to be sha256")
+	}
+
+	if err := wire.WriteString(w, info.NarHash.Format(nixhash.Base16, false)); err != nil {
+		return err
+	}
+
+	if err := writeStrings(w, info.References); err != nil {
+		return err
+	}
+
+	var registrationTime uint64
+	if !info.RegistrationTime.IsZero() {
+		registrationTime = uint64(info.RegistrationTime.Unix()) //nolint:gosec
+	}
+
+	if err := wire.WriteUint64(w, registrationTime); err != nil {
+		return err
+	}
+
+	if err := wire.WriteUint64(w, info.NarSize); err != nil {
+		return err
+	}
+
+	if err := wire.WriteBool(w, info.Ultimate); err != nil {
+		return err
+	}
+
+	sigs := make([]string, len(info.Signatures))
+	for i, sig := range info.Signatures {
+		sigs[i] = sig.String()
+	}
+
+	if err := writeStrings(w, sigs); err != nil {
+		return err
+	}
+
+	return wire.WriteString(w, info.CA)
+}
+
+// MissingInfo is returned by QueryMissing.
+type MissingInfo struct {
+	WillBuild      []string `wire:"set"`
+	WillSubstitute []string `wire:"set"`
+	Unknown        []string `wire:"set"`
+	DownloadSize   uint64
+	NarSize        uint64
+}
+
+// ClientOptions are the settings sent by a client with OpSetOptions.
+type ClientOptions struct {
+	KeepFailed     bool
+	KeepGoing      bool
+	TryFallback    bool
+	Verbosity      Verbosity
+	MaxBuildJobs   uint64
+	MaxSilentTime  uint64
+	BuildVerbosity Verbosity
+	BuildCores     uint64
+	UseSubstitutes bool
+	// Overrides contains all other settings, by name.
+	Overrides map[string]string
+}
+
+func readClientOptions(r io.Reader) (*ClientOptions, error) {
+	var (
+		opts ClientOptions
+		err  error
+		n    uint64
+	)
+
+	for _, b := range []*bool{&opts.KeepFailed, &opts.KeepGoing, &opts.TryFallback} {
+		if *b, err = wire.ReadBool(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if n, err = wire.ReadUint64(r); err != nil {
+		return nil, err
+	}
+
+	opts.Verbosity = Verbosity(n)
+
+	if opts.MaxBuildJobs, err = wire.ReadUint64(r); err != nil {
+		return nil, err
+	}
+
+	if opts.MaxSilentTime, err = wire.ReadUint64(r); err != nil {
+		return nil, err
+	}
+
+	// obsolete useBuildHook
+	if _, err = wire.ReadUint64(r); err != nil {
+		return nil, err
+	}
+
+	if n, err = wire.ReadUint64(r); err != nil {
+		return nil, err
+	}
+
+	opts.BuildVerbosity = Verbosity(n)
+
+	// obsolete logType and printBuildTrace
+	for i := 0; i < 2; i++ {
+		if _, err = wire.ReadUint64(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.BuildCores, err = wire.ReadUint64(r); err != nil {
+		return nil, err
+	}
+
+	if opts.UseSubstitutes, err = wire.ReadBool(r); err != nil {
+		return nil, err
+	}
+
+	if n, err = wire.ReadUint64(r); err != nil {
+		return nil, err
+	}
+
+	opts.Overrides = make(map[string]string, wire.CapHint(n))
+
+	for i := uint64(0); i < n; i++ {
+		k, err := wire.ReadString(r, maxStringSize)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := wire.ReadString(r, maxStringSize)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Overrides[k] = v
+	}
+
+	return &opts, nil
+}
+
+func writeClientOptions(w io.Writer, opts *ClientOptions) error {
+	for _, b := range []bool{opts.KeepFailed, opts.KeepGoing, opts.TryFallback} {
+		if err := wire.WriteBool(w, b); err != nil {
+			return err
+		}
+	}
+
+	for _, n := range []uint64{
+		uint64(opts.Verbosity),
+		opts.MaxBuildJobs,
+		opts.MaxSilentTime,
+		1, // obsolete useBuildHook
+		uint64(opts.BuildVerbosity),
+		0, // obsolete logType
+		0, // obsolete printBuildTrace
+		opts.BuildCores,
+	} {
+		if err := wire.WriteUint64(w, n); err != nil {
+			return err
+		}
+	}
+
+	if err := wire.WriteBool(w, opts.UseSubstitutes); err != nil {
+		return err
+	}
+
+	// write overrides sorted, to be deterministic
+	keys := make([]string, 0, len(opts.Overrides))
+	for k := range opts.Overrides {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	if err := wire.WriteUint64(w, uint64(len(keys))); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := wire.WriteString(w, k); err != nil {
+			return err
+		}
+
+		if err := wire.WriteString(w, opts.Overrides[k]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -13,7 +13,7 @@ import (
 //nolint:gochecknoglobals
 var colon = []byte{':'}
 
-// getMaskedATermHash returns the hex-representation of
+// getMaskedATermHash returns the sha256 hash of the masked ATerm representation.
 // In case the Derivation is not just a fixed-output derivation,
 // calculating the output hashes includes all inputs derivations.
 //
@@ -25,15 +25,15 @@ var colon = []byte{':'}
 // Input derivation are replaced with a hex-replacement string,
 // which is calculated by CalculateDrvReplacement,
 // but passed in as a map here (we don't want to always recurse, but precompute).
-func (d *Derivation) getMaskedATermHash(inputDrvReplacements map[string]string) (string, error) {
+func (d *Derivation) getMaskedATermHash(inputDrvReplacements map[string]string) (*nixhash.Hash, error) {
 	h := sha256.New()
 
 	err := d.writeDerivation(h, true, inputDrvReplacements)
 	if err != nil {
-		return "", fmt.Errorf("error writing masked ATerm: %w", err)
+		return nil, fmt.Errorf("error writing masked ATerm: %w", err)
 	}
 
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return nixhash.MustNewHash(nixhash.SHA256, h.Sum(nil)), nil
 }
 
 func hashStrings(h chash.Hash, strings ...string) []byte {
@@ -59,71 +59,41 @@ func (d *Derivation) CalculateOutputPaths(inputDrvReplacements map[string]string
 
 	outputPaths := make(map[string]string, len(d.Outputs))
 
-	h := sha256.New()
+	// the masked ATerm hash is the same for all outputs,
+	// so only calculate it once, if needed.
+	var maskedATermHash *nixhash.Hash
 
 	for outputName, o := range d.Outputs {
-		// calculate the part of an output path that comes after the hash
-		var outputPathName string
-		if outputName == "out" {
-			outputPathName = derivationName
-		} else {
-			outputPathName = derivationName + "-" + outputName
-		}
-
-		var storeHash []byte
+		var (
+			calculatedPath *storepath.StorePath
+			err            error
+		)
 
 		if o.HashAlgorithm != "" {
-			// This code is _weird_ but it is what Nix is doing. See:
-			// https://github.com/NixOS/nix/blob/1385b2007804c8a0370f2a6555045a00e34b07c7/src/libstore/store-api.cc#L178-L196
-			if o.HashAlgorithm == "r:sha256" {
-				storeHash = hashStrings(
-					h,
-					"source",
-					"sha256",
-					o.Hash,
-					storepath.StoreDir,
-					derivationName,
-				)
-			} else {
-				fixedHex := hex.EncodeToString(hashStrings(h, "fixed", "out", o.HashAlgorithm, o.Hash, ""))
-
-				h.Reset()
-
-				storeHash = hashStrings(
-					h,
-					"output",
-					"out",
-					"sha256",
-					fixedHex,
-					storepath.StoreDir,
-					derivationName,
-				)
-			}
-		} else {
-			maskedATermHash, err := d.getMaskedATermHash(inputDrvReplacements)
+			method, hash, err := o.fixedOutputHash()
 			if err != nil {
-				return nil, fmt.Errorf("failed to calculate masked ATerm hash: %w", err)
+				return nil, fmt.Errorf("invalid fixed output %v: %w", outputName, err)
 			}
 
-			storeHash = hashStrings(
-				h,
-				"output",
-				outputName,
-				"sha256",
-				maskedATermHash,
-				storepath.StoreDir,
-				outputPathName,
-			)
-		}
+			calculatedPath, err = storepath.MakeFixedOutputPath(method, hash, derivationName, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to calculate fixed output path: %w", err)
+			}
+		} else {
+			if maskedATermHash == nil {
+				maskedATermHash, err = d.getMaskedATermHash(inputDrvReplacements)
+				if err != nil {
+					return nil, fmt.Errorf("failed to calculate masked ATerm hash: %w", err)
+				}
+			}
 
-		calculatedPath := storepath.StorePath{
-			Name:   outputPathName,
-			Digest: nixhash.CompressHash(storeHash, 20),
+			calculatedPath, err = storepath.MakeOutputPath(outputName, maskedATermHash, derivationName)
+			if err != nil {
+				return nil, fmt.Errorf("failed to calculate output path: %w", err)
+			}
 		}
 
 		outputPaths[outputName] = calculatedPath.Absolute()
-
-		h.Reset()
 	}
 
 	return outputPaths, nil
@@ -1,6 +1,10 @@
 package derivation
 
 import (
+	"fmt"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/nixhash"
 	"github.com/nix-community/go-nix/pkg/storepath"
 )
 
@@ -13,3 +17,28 @@ type Output struct {
 func (o *Output) Validate() error {
 	return storepath.Validate(o.Path)
 }
+
+// fixedOutputHash parses HashAlgorithm and Hash of a fixed output.
+// HashAlgorithm is the name of a hash algorithm, prefixed with `r:`
+// if the output is hashed recursively. Hash is base16-encoded.
+func (o *Output) fixedOutputHash() (storepath.FileIngestionMethod, *nixhash.Hash, error) {
+	method := storepath.FileIngestionFlat
+	algoStr := o.HashAlgorithm
+
+	if strings.HasPrefix(algoStr, "r:") {
+		method = storepath.FileIngestionRecursive
+		algoStr = algoStr[2:]
+	}
+
+	algo, err := nixhash.ParseAlgorithm(algoStr)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	hash, err := nixhash.ParseAny(o.Hash, &algo)
+	if err != nil {
+		return 0, nil, fmt.Errorf("unable to parse hash %v: %w", o.Hash, err)
+	}
+
+	return method, &hash.Hash, nil
+}
@@ -0,0 +1,303 @@
+// Package derivedpath implements the "derived paths" Nix tools pass around,
+// like `/nix/store/…-foo.drv^out,dev`, `/nix/store/…-foo.drv^*` or plain
+// store paths.
+//
+// A derived path is either an opaque store path, or (some) outputs of a
+// derivation. With dynamic derivations, that derivation can itself be
+// the output of another derivation, which results in chains like
+// `/nix/store/…-foo.drv^out^bin`.
+package derivedpath
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+const (
+	// Separator separates a derivation from its outputs in the CLI syntax.
+	Separator = "^"
+	// LegacySeparator separates a derivation from its outputs in the
+	// syntax used by older versions of the worker protocol.
+	LegacySeparator = "!"
+)
+
+//nolint:gochecknoglobals
+var outputNameRe = regexp.MustCompile(`^` + storepath.NameRe.String() + `$`)
+
+// OutputsSpec describes which outputs of a derivation are referred to.
+// Either All is set, or Names contains a sorted set of output names.
+type OutputsSpec struct {
+	All   bool
+	Names []string
+}
+
+// String returns `*` for all outputs, or the comma-separated output names.
+func (o OutputsSpec) String() string {
+	if o.All {
+		return "*"
+	}
+
+	return strings.Join(o.Names, ",")
+}
+
+// Validate checks the output spec contains at least one valid output name,
+// and that Names is sorted and free of duplicates.
+func (o OutputsSpec) Validate() error {
+	if o.All {
+		if len(o.Names) != 0 {
+			return fmt.Errorf("all outputs requested, but names specified too")
+		}
+
+		return nil
+	}
+
+	if len(o.Names) == 0 {
+		return fmt.Errorf("no outputs specified")
+	}
+
+	for i, name := range o.Names {
+		if err := validateOutputName(name); err != nil {
+			return err
+		}
+
+		if i > 0 && name <= o.Names[i-1] {
+			return fmt.Errorf("invalid output names order: %s <= %s", name, o.Names[i-1])
+		}
+	}
+
+	return nil
+}
+
+// NewOutputsSpec returns an OutputsSpec for the given output names.
+// Names are validated, sorted and deduplicated.
+func NewOutputsSpec(names ...string) (OutputsSpec, error) {
+	if len(names) == 0 {
+		return OutputsSpec{}, fmt.Errorf("no outputs specified")
+	}
+
+	seen := make(map[string]struct{}, len(names))
+	sortedNames := make([]string, 0, len(names))
+
+	for _, name := range names {
+		if err := validateOutputName(name); err != nil {
+			return OutputsSpec{}, err
+		}
+
+		if _, ok := seen[name]; ok {
+			continue
+		}
+
+		seen[name] = struct{}{}
+
+		sortedNames = append(sortedNames, name)
+	}
+
+	sort.Strings(sortedNames)
+
+	return OutputsSpec{Names: sortedNames}, nil
+}
+
+// ParseOutputsSpec parses `*` or a comma-separated list of output names.
+func ParseOutputsSpec(s string) (OutputsSpec, error) {
+	if s == "*" {
+		return OutputsSpec{All: true}, nil
+	}
+
+	return NewOutputsSpec(strings.Split(s, ",")...)
+}
+
+func validateOutputName(name string) error {
+	if !outputNameRe.MatchString(name) {
+		return fmt.Errorf("invalid output name: '%s'", name)
+	}
+
+	return nil
+}
+
+// SingleDerivedPath refers to a single store path.
+// It's either an opaque store path (Path is set),
+// or the output Output of the derivation at DrvPath.
+type SingleDerivedPath struct {
+	Path string // The absolute store path, for opaque paths.
+
+	DrvPath *SingleDerivedPath // The derivation, for built paths.
+	Output  string             // The output name, for built paths.
+}
+
+// IsBuilt returns true if this path refers to the output of a derivation.
+func (p *SingleDerivedPath) IsBuilt() bool {
+	return p.DrvPath != nil
+}
+
+// String returns the path in CLI syntax, like `/nix/store/…-foo.drv^out`.
+func (p *SingleDerivedPath) String() string {
+	if !p.IsBuilt() {
+		return p.Path
+	}
+
+	return p.DrvPath.String() + Separator + p.Output
+}
+
+// Validate checks the path for syntactical validity.
+// The innermost path of a built path needs to be a .drv file.
+func (p *SingleDerivedPath) Validate() error {
+	if !p.IsBuilt() {
+		return storepath.Validate(p.Path)
+	}
+
+	if p.Path != "" {
+		return fmt.Errorf("built path may not set Path: %v", p.Path)
+	}
+
+	if err := validateOutputName(p.Output); err != nil {
+		return err
+	}
+
+	return validateDrvPath(p.DrvPath)
+}
+
+// DerivedPath refers to an opaque store path (Path is set),
+// or one or more outputs of the derivation at DrvPath.
+type DerivedPath struct {
+	Path string // The absolute store path, for opaque paths.
+
+	DrvPath *SingleDerivedPath // The derivation, for built paths.
+	Outputs OutputsSpec        // The requested outputs, for built paths.
+}
+
+// IsBuilt returns true if this path refers to outputs of a derivation.
+func (p *DerivedPath) IsBuilt() bool {
+	return p.DrvPath != nil
+}
+
+// String returns the path in CLI syntax, like `/nix/store/…-foo.drv^out,dev`.
+// This is also the syntax used by the worker protocol starting with
+// protocol version 1.30.
+func (p *DerivedPath) String() string {
+	return p.format(Separator)
+}
+
+// LegacyString returns the path in the syntax used by the worker protocol
+// before version 1.30, like `/nix/store/…-foo.drv!out,dev`.
+func (p *DerivedPath) LegacyString() string {
+	return p.format(LegacySeparator)
+}
+
+func (p *DerivedPath) format(separator string) string {
+	if !p.IsBuilt() {
+		return p.Path
+	}
+
+	return p.DrvPath.String() + separator + p.Outputs.String()
+}
+
+// Validate checks the path for syntactical validity.
+// The innermost path of a built path needs to be a .drv file.
+func (p *DerivedPath) Validate() error {
+	if !p.IsBuilt() {
+		return storepath.Validate(p.Path)
+	}
+
+	if p.Path != "" {
+		return fmt.Errorf("built path may not set Path: %v", p.Path)
+	}
+
+	if err := p.Outputs.Validate(); err != nil {
+		return err
+	}
+
+	return validateDrvPath(p.DrvPath)
+}
+
+// validateDrvPath validates a path is suitable to build outputs from.
+func validateDrvPath(p *SingleDerivedPath) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	if !p.IsBuilt() && !strings.HasSuffix(p.Path, ".drv") {
+		return fmt.Errorf("not a derivation: %v", p.Path)
+	}
+
+	return nil
+}
+
+// ParseSingle parses a single derived path in CLI syntax,
+// like `/nix/store/…-foo.drv^out` or `/nix/store/…-foo`.
+func ParseSingle(s string) (*SingleDerivedPath, error) {
+	i := strings.LastIndex(s, Separator)
+	if i == -1 {
+		p := &SingleDerivedPath{Path: s}
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+
+		return p, nil
+	}
+
+	drvPath, err := ParseSingle(s[:i])
+	if err != nil {
+		return nil, err
+	}
+
+	p := &SingleDerivedPath{
+		DrvPath: drvPath,
+		Output:  s[i+len(Separator):],
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Parse parses a derived path in CLI syntax,
+// like `/nix/store/…-foo.drv^out,dev`, `/nix/store/…-foo.drv^*`,
+// `/nix/store/…-foo.drv^out^bin` or `/nix/store/…-foo`.
+func Parse(s string) (*DerivedPath, error) {
+	return parse(s, Separator)
+}
+
+// ParseLegacy parses a derived path in the syntax used by the worker protocol
+// before version 1.30, like `/nix/store/…-foo.drv!out,dev`.
+func ParseLegacy(s string) (*DerivedPath, error) {
+	return parse(s, LegacySeparator)
+}
+
+func parse(s string, separator string) (*DerivedPath, error) {
+	i := strings.LastIndex(s, separator)
+	if i == -1 {
+		p := &DerivedPath{Path: s}
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+
+		return p, nil
+	}
+
+	drvPath, err := ParseSingle(s[:i])
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := ParseOutputsSpec(s[i+len(separator):])
+	if err != nil {
+		return nil, err
+	}
+
+	p := &DerivedPath{
+		DrvPath: drvPath,
+		Outputs: outputs,
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
@@ -0,0 +1,141 @@
+package derivedpath_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/derivedpath"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	drvPath    = "/nix/store/cl5fr6hlr6hdqza2vgb9qqy5s26wls8i-jq-1.6.drv"
+	opaquePath = "/nix/store/gz5wackiq656d26w298hkqf2494c21kr-jq-1.6"
+)
+
+//nolint:gochecknoglobals
+var cases = []struct {
+	Title       string
+	Str         string
+	LegacyStr   string
+	JSON        string
+	DerivedPath *derivedpath.DerivedPath
+}{
+	{
+		Title:       "opaque",
+		Str:         opaquePath,
+		LegacyStr:   opaquePath,
+		JSON:        `"` + opaquePath + `"`,
+		DerivedPath: &derivedpath.DerivedPath{Path: opaquePath},
+	},
+	{
+		Title:       "opaque drv",
+		Str:         drvPath,
+		LegacyStr:   drvPath,
+		JSON:        `"` + drvPath + `"`,
+		DerivedPath: &derivedpath.DerivedPath{Path: drvPath},
+	},
+	{
+		Title:     "all outputs",
+		Str:       drvPath + "^*",
+		LegacyStr: drvPath + "!*",
+		JSON:      `{"drvPath":"` + drvPath + `","outputs":["*"]}`,
+		DerivedPath: &derivedpath.DerivedPath{
+			DrvPath: &derivedpath.SingleDerivedPath{Path: drvPath},
+			Outputs: derivedpath.OutputsSpec{All: true},
+		},
+	},
+	{
+		Title:     "some outputs",
+		Str:       drvPath + "^bin,dev",
+		LegacyStr: drvPath + "!bin,dev",
+		JSON:      `{"drvPath":"` + drvPath + `","outputs":["bin","dev"]}`,
+		DerivedPath: &derivedpath.DerivedPath{
+			DrvPath: &derivedpath.SingleDerivedPath{Path: drvPath},
+			Outputs: derivedpath.OutputsSpec{Names: []string{"bin", "dev"}},
+		},
+	},
+	{
+		Title:     "dynamic",
+		Str:       drvPath + "^out^bin",
+		LegacyStr: drvPath + "^out!bin",
+		JSON:      `{"drvPath":{"drvPath":"` + drvPath + `","output":"out"},"outputs":["bin"]}`,
+		DerivedPath: &derivedpath.DerivedPath{
+			DrvPath: &derivedpath.SingleDerivedPath{
+				DrvPath: &derivedpath.SingleDerivedPath{Path: drvPath},
+				Output:  "out",
+			},
+			Outputs: derivedpath.OutputsSpec{Names: []string{"bin"}},
+		},
+	},
+}
+
+func TestDerivedPath(t *testing.T) {
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			t.Run("Parse", func(t *testing.T) {
+				p, err := derivedpath.Parse(c.Str)
+				if assert.NoError(t, err) {
+					assert.Equal(t, c.DerivedPath, p)
+				}
+			})
+
+			t.Run("ParseLegacy", func(t *testing.T) {
+				p, err := derivedpath.ParseLegacy(c.LegacyStr)
+				if assert.NoError(t, err) {
+					assert.Equal(t, c.DerivedPath, p)
+				}
+			})
+
+			t.Run("String", func(t *testing.T) {
+				assert.Equal(t, c.Str, c.DerivedPath.String())
+				assert.Equal(t, c.LegacyStr, c.DerivedPath.LegacyString())
+			})
+
+			t.Run("JSON", func(t *testing.T) {
+				b, err := json.Marshal(c.DerivedPath)
+				if assert.NoError(t, err) {
+					assert.Equal(t, c.JSON, string(b))
+				}
+
+				var p derivedpath.DerivedPath
+
+				if assert.NoError(t, json.Unmarshal([]byte(c.JSON), &p)) {
+					assert.Equal(t, c.DerivedPath, &p)
+				}
+			})
+		})
+	}
+}
+
+func TestParseOutputsSpec(t *testing.T) {
+	spec, err := derivedpath.ParseOutputsSpec("out,dev,out")
+	if assert.NoError(t, err) {
+		assert.Equal(t, derivedpath.OutputsSpec{Names: []string{"dev", "out"}}, spec,
+			"output names should be sorted and deduplicated")
+		assert.Equal(t, "dev,out", spec.String())
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"foo",
+		opaquePath + "^out",            // not a derivation
+		drvPath + "^",                  // no outputs
+		drvPath + "^out,",              // empty output name
+		drvPath + "^out,*",             // mixing all and names
+		drvPath + "^^out",              // empty output in the middle
+		"/nix/store/foo.drv^out",       // invalid store path
+		drvPath + "^out/../../etc",     // invalid output name
+		"/nix/store/" + drvPath + "^*", // garbage prefix
+	} {
+		_, err := derivedpath.Parse(s)
+		assert.Error(t, err, "parsing '%v' should fail", s)
+	}
+
+	var p derivedpath.DerivedPath
+
+	assert.Error(t, json.Unmarshal([]byte(`{"outputs":["out"]}`), &p), "missing drvPath should fail")
+	assert.Error(t, json.Unmarshal([]byte(`{"drvPath":"`+drvPath+`","outputs":[]}`), &p), "no outputs should fail")
+}
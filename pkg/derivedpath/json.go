@@ -0,0 +1,143 @@
+package derivedpath
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// The JSON representation matches the one used by Nix:
+// Opaque paths are serialized as a plain string,
+// built paths as an object with the derivation (recursively) in "drvPath",
+// and "output", or "outputs" respectively.
+// "All outputs" is serialized as ["*"].
+
+type singleBuiltJSON struct {
+	DrvPath *SingleDerivedPath `json:"drvPath"`
+	Output  string             `json:"output"`
+}
+
+type builtJSON struct {
+	DrvPath *SingleDerivedPath `json:"drvPath"`
+	Outputs OutputsSpec        `json:"outputs"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o OutputsSpec) MarshalJSON() ([]byte, error) {
+	if o.All {
+		return json.Marshal([]string{"*"})
+	}
+
+	return json.Marshal(o.Names)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (o *OutputsSpec) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+
+	if len(names) == 1 && names[0] == "*" {
+		*o = OutputsSpec{All: true}
+
+		return nil
+	}
+
+	spec, err := NewOutputsSpec(names...)
+	if err != nil {
+		return err
+	}
+
+	*o = spec
+
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p *SingleDerivedPath) MarshalJSON() ([]byte, error) {
+	if !p.IsBuilt() {
+		return json.Marshal(p.Path)
+	}
+
+	return json.Marshal(&singleBuiltJSON{
+		DrvPath: p.DrvPath,
+		Output:  p.Output,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *SingleDerivedPath) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+
+	if len(data) > 0 && data[0] == '"' {
+		var path string
+		if err := json.Unmarshal(data, &path); err != nil {
+			return err
+		}
+
+		*p = SingleDerivedPath{Path: path}
+
+		return p.Validate()
+	}
+
+	var b singleBuiltJSON
+	if err := json.Unmarshal(data, &b); err != nil {
+		return err
+	}
+
+	if b.DrvPath == nil {
+		return fmt.Errorf("missing drvPath")
+	}
+
+	*p = SingleDerivedPath{
+		DrvPath: b.DrvPath,
+		Output:  b.Output,
+	}
+
+	return p.Validate()
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p *DerivedPath) MarshalJSON() ([]byte, error) {
+	if !p.IsBuilt() {
+		return json.Marshal(p.Path)
+	}
+
+	return json.Marshal(&builtJSON{
+		DrvPath: p.DrvPath,
+		Outputs: p.Outputs,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *DerivedPath) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+
+	if len(data) > 0 && data[0] == '"' {
+		var path string
+		if err := json.Unmarshal(data, &path); err != nil {
+			return err
+		}
+
+		*p = DerivedPath{Path: path}
+
+		return p.Validate()
+	}
+
+	var b builtJSON
+	if err := json.Unmarshal(data, &b); err != nil {
+		return err
+	}
+
+	if b.DrvPath == nil {
+		return fmt.Errorf("missing drvPath")
+	}
+
+	*p = DerivedPath{
+		DrvPath: b.DrvPath,
+		Outputs: b.Outputs,
+	}
+
+	return p.Validate()
+}
@@ -0,0 +1,84 @@
+package derivedpath
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/nix-community/go-nix/pkg/derivation"
+)
+
+// resolveOutputs looks up the derivation at drvPath in the store,
+// and returns the paths of the requested outputs, sorted by output name.
+func resolveOutputs(
+	ctx context.Context,
+	store derivation.Store,
+	drvPath *SingleDerivedPath,
+	outputs OutputsSpec,
+) ([]string, error) {
+	drvStorePath, err := drvPath.Resolve(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	drv, err := store.Get(ctx, drvStorePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get derivation %v: %w", drvStorePath, err)
+	}
+
+	names := outputs.Names
+	if outputs.All {
+		names = make([]string, 0, len(drv.Outputs))
+		for name := range drv.Outputs {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+	}
+
+	paths := make([]string, len(names))
+
+	for i, name := range names {
+		o, ok := drv.Outputs[name]
+		if !ok {
+			return nil, fmt.Errorf("derivation %v has no output '%v'", drvStorePath, name)
+		}
+
+		// content-addressed derivations don't know their output paths upfront.
+		if o.Path == "" {
+			return nil, fmt.Errorf("path of output '%v' of %v is not known statically", name, drvStorePath)
+		}
+
+		paths[i] = o.Path
+	}
+
+	return paths, nil
+}
+
+// Resolve returns the store path this path refers to.
+// For built paths, the derivation (and all derivations it's built from)
+// are looked up in store.
+func (p *SingleDerivedPath) Resolve(ctx context.Context, store derivation.Store) (string, error) {
+	if !p.IsBuilt() {
+		return p.Path, nil
+	}
+
+	paths, err := resolveOutputs(ctx, store, p.DrvPath, OutputsSpec{Names: []string{p.Output}})
+	if err != nil {
+		return "", err
+	}
+
+	return paths[0], nil
+}
+
+// Resolve returns the store paths this path refers to.
+// For built paths, these are the paths of the requested outputs,
+// sorted by output name. The derivation (and all derivations it's built from)
+// are looked up in store.
+func (p *DerivedPath) Resolve(ctx context.Context, store derivation.Store) ([]string, error) {
+	if !p.IsBuilt() {
+		return []string{p.Path}, nil
+	}
+
+	return resolveOutputs(ctx, store, p.DrvPath, p.Outputs)
+}
@@ -0,0 +1,109 @@
+package derivedpath_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/derivation/store"
+	"github.com/nix-community/go-nix/pkg/derivedpath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	drvStore, err := store.NewFSStore("../../test/testdata/")
+	if err != nil {
+		panic(err)
+	}
+
+	cases := []struct {
+		Title    string
+		Str      string
+		Expected []string
+	}{
+		{
+			Title:    "opaque",
+			Str:      opaquePath,
+			Expected: []string{opaquePath},
+		},
+		{
+			Title: "some outputs",
+			Str:   drvPath + "^out,bin",
+			Expected: []string{
+				"/nix/store/amh6f24qs9809zg9xzckfi90ysfi8r2a-jq-1.6-bin",
+				"/nix/store/gz5wackiq656d26w298hkqf2494c21kr-jq-1.6",
+			},
+		},
+		{
+			Title: "all outputs",
+			Str:   drvPath + "^*",
+			Expected: []string{
+				"/nix/store/amh6f24qs9809zg9xzckfi90ysfi8r2a-jq-1.6-bin",
+				"/nix/store/0jmbidsi4asvlqlgnsqrcfyddx7icq2h-jq-1.6-dev",
+				"/nix/store/q5pywa8m8zz0d5v4b3f17pafqwia81yd-jq-1.6-doc",
+				"/nix/store/95mivp8m5gsv88ar0apd0xb0jvlzzd83-jq-1.6-lib",
+				"/nix/store/dhk7c8fbzzlhcpb2c7fdrwqsz761msrl-jq-1.6-man",
+				"/nix/store/gz5wackiq656d26w298hkqf2494c21kr-jq-1.6",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			p, err := derivedpath.Parse(c.Str)
+			if err != nil {
+				panic(err)
+			}
+
+			paths, err := p.Resolve(context.Background(), drvStore)
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.Expected, paths)
+			}
+		})
+	}
+
+	t.Run("missing output", func(t *testing.T) {
+		p, err := derivedpath.Parse(drvPath + "^foo")
+		if err != nil {
+			panic(err)
+		}
+
+		_, err = p.Resolve(context.Background(), drvStore)
+		assert.Error(t, err)
+	})
+
+	t.Run("dynamic", func(t *testing.T) {
+		// Pretend the output of foo.drv is the jq .drv.
+		tmpDir := t.TempDir()
+
+		for src, dst := range map[string]string{
+			"4wvvbi4jwn0prsdxb7vs673qa5h9gr7x-foo.drv":    "4wvvbi4jwn0prsdxb7vs673qa5h9gr7x-foo.drv",
+			"cl5fr6hlr6hdqza2vgb9qqy5s26wls8i-jq-1.6.drv": "5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo",
+		} {
+			contents, err := os.ReadFile(filepath.Join("../../test/testdata", src))
+			if err != nil {
+				panic(err)
+			}
+
+			if err := os.WriteFile(filepath.Join(tmpDir, dst), contents, 0o644); err != nil { //nolint:gosec
+				panic(err)
+			}
+		}
+
+		dynStore, err := store.NewFSStore(tmpDir)
+		if err != nil {
+			panic(err)
+		}
+
+		p, err := derivedpath.Parse("/nix/store/4wvvbi4jwn0prsdxb7vs673qa5h9gr7x-foo.drv^out^dev")
+		if err != nil {
+			panic(err)
+		}
+
+		paths, err := p.Resolve(context.Background(), dynStore)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"/nix/store/0jmbidsi4asvlqlgnsqrcfyddx7icq2h-jq-1.6-dev"}, paths)
+		}
+	})
+}
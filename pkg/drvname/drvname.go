@@ -0,0 +1,129 @@
+// Package drvname splits package names into name and version, and compares
+// versions, matching the semantics of builtins.parseDrvName and
+// builtins.compareVersions in Nix.
+package drvname
+
+import (
+	"strconv"
+)
+
+// DrvName is a package name split into its name and version part.
+type DrvName struct {
+	Name    string // The name, like "hello" (aka pname)
+	Version string // The version, like "2.12.1". Empty if there's no version.
+}
+
+// String returns the full name, which is the name and version
+// joined by a dash, or just the name if there's no version.
+func (n DrvName) String() string {
+	if n.Version == "" {
+		return n.Name
+	}
+
+	return n.Name + "-" + n.Version
+}
+
+// Parse splits a name like "hello-2.12.1" into its name and version part.
+// The version starts after the first dash that's not followed by a letter.
+// This can be used on StorePath.Name and Derivation.Name() alike.
+func Parse(s string) DrvName {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '-' && i+1 < len(s) && !isAlpha(s[i+1]) {
+			return DrvName{
+				Name:    s[:i],
+				Version: s[i+1:],
+			}
+		}
+	}
+
+	return DrvName{Name: s}
+}
+
+// CompareVersions compares two version strings the same way
+// `nix-env -u` does.
+// It returns -1 if v1 is older than v2, 0 if they're equal,
+// and 1 if v1 is newer than v2.
+//
+// Versions are split into components, separated by dots and dashes,
+// and on transitions from digits to non-digits.
+// Components are compared pairwise, numerically if both are numbers,
+// lexicographically otherwise. A number is considered newer than
+// a non-number, except for "pre", which is always older than anything else.
+// A missing component is considered older than a number.
+func CompareVersions(v1, v2 string) int {
+	for p1, p2 := 0, 0; p1 < len(v1) || p2 < len(v2); {
+		var c1, c2 string
+
+		c1, p1 = nextComponent(v1, p1)
+		c2, p2 = nextComponent(v2, p2)
+
+		if componentsLT(c1, c2) {
+			return -1
+		} else if componentsLT(c2, c1) {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// nextComponent returns the next version component of s, starting at offset p,
+// and the offset after it.
+func nextComponent(s string, p int) (string, int) {
+	// skip any dots and dashes (component separators)
+	for p < len(s) && (s[p] == '.' || s[p] == '-') {
+		p++
+	}
+
+	start := p
+
+	// If the first character is a digit, consume the longest sequence of
+	// digits. Otherwise, consume the longest sequence of non-digit,
+	// non-separator characters.
+	if p < len(s) && isDigit(s[p]) {
+		for p < len(s) && isDigit(s[p]) {
+			p++
+		}
+	} else {
+		for p < len(s) && !isDigit(s[p]) && s[p] != '.' && s[p] != '-' {
+			p++
+		}
+	}
+
+	return s[start:p], p
+}
+
+// componentsLT returns true if the version component c1 is older than c2.
+func componentsLT(c1, c2 string) bool {
+	n1, err1 := strconv.ParseInt(c1, 10, 32)
+	n2, err2 := strconv.ParseInt(c2, 10, 32)
+
+	isNum1 := err1 == nil
+	isNum2 := err2 == nil
+
+	switch {
+	case isNum1 && isNum2:
+		return n1 < n2
+	case c1 == "" && isNum2:
+		return true
+	case c1 == "pre" && c2 != "pre":
+		return true
+	case c2 == "pre":
+		return false
+	// Assume that `2.3a' < `2.3.1'.
+	case isNum2:
+		return true
+	case isNum1:
+		return false
+	default:
+		return c1 < c2
+	}
+}
+
+func isAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,78 @@
+package drvname_test
+
+import (
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/drvname"
+	"github.com/stretchr/testify/assert"
+)
+
+// The test cases are ported from Nix' tests/functional/lang/eval-okay-versions.nix.
+func TestParse(t *testing.T) {
+	cases := []struct {
+		FullName string
+		Name     string
+		Version  string
+	}{
+		{"hello-1.0.2", "hello", "1.0.2"},
+		{"hello", "hello", ""},
+		{"915resolution-0.5.2", "915resolution", "0.5.2"},
+		{"xf86-video-i810-1.7.4", "xf86-video-i810", "1.7.4"},
+		{"name-that-ends-with-dash--1.0", "name-that-ends-with-dash", "-1.0"},
+		{"net-tools-1.60_p20170221182432", "net-tools", "1.60_p20170221182432"},
+		{"jq-1.6-bin", "jq", "1.6-bin"},
+		{"hello-", "hello-", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.FullName, func(t *testing.T) {
+			n := drvname.Parse(c.FullName)
+			assert.Equal(t, c.Name, n.Name)
+			assert.Equal(t, c.Version, n.Version)
+
+			if c.Version != "" {
+				assert.Equal(t, c.FullName, n.String())
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		V1       string
+		V2       string
+		Expected int
+	}{
+		{"1.0", "2.3", -1},
+		{"2.1", "2.3", -1},
+		{"2.3", "2.3", 0},
+		{"2.5", "2.3", 1},
+		{"3.1", "2.3", 1},
+		{"2.3.1", "2.3", 1},
+		{"2.3.1", "2.3a", 1},
+		{"2.3pre1", "2.3", -1},
+		{"2.3pre3", "2.3pre12", -1},
+		{"2.3a", "2.3c", -1},
+		{"2.3pre1", "2.3c", -1},
+		{"2.3pre1", "2.3q", -1},
+		{"1.0-rc1", "1.0.1", -1},
+		{"1.10", "1.9", 1},
+		{"", "", 0},
+		{"", "1", -1},
+		// numbers not fitting into an int are compared as strings
+		{"99999999999", "100000000000", 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.V1+" "+c.V2, func(t *testing.T) {
+			assert.Equal(t, c.Expected, drvname.CompareVersions(c.V1, c.V2))
+			assert.Equal(t, -c.Expected, drvname.CompareVersions(c.V2, c.V1))
+		})
+	}
+}
+
+func BenchmarkCompareVersions(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		drvname.CompareVersions("1.60_p20170221182432", "1.60_p20170221182433")
+	}
+}
@@ -0,0 +1,134 @@
+package evalcache
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AttrType is the type of a cached attribute, as stored in the type column.
+type AttrType int64
+
+const (
+	// AttrPlaceholder is an attribute known to exist, that hasn't been
+	// evaluated yet.
+	AttrPlaceholder AttrType = 0
+	// AttrFullAttrs is an attribute set, its attributes are the children.
+	AttrFullAttrs AttrType = 1
+	// AttrString is a string, possibly with context.
+	AttrString AttrType = 2
+	// AttrMissing is an attribute that doesn't exist.
+	AttrMissing AttrType = 3
+	// AttrMisc is a value of a type that isn't cached, like a function.
+	AttrMisc AttrType = 4
+	// AttrFailed is an attribute whose evaluation failed.
+	AttrFailed AttrType = 5
+	AttrBool   AttrType = 6
+	// AttrListOfStrings is a list of strings without context.
+	AttrListOfStrings AttrType = 7
+	AttrInt           AttrType = 8
+)
+
+//nolint:gochecknoglobals
+var attrTypeNames = []string{
+	"placeholder",
+	"attrs",
+	"string",
+	"missing",
+	"misc",
+	"failed",
+	"bool",
+	"list of strings",
+	"int",
+}
+
+func (t AttrType) String() string {
+	if t >= 0 && int(t) < len(attrTypeNames) {
+		return attrTypeNames[t]
+	}
+
+	return fmt.Sprintf("AttrType(%d)", int64(t))
+}
+
+// Attr is a cached attribute.
+// Which of the value fields is set depends on Type.
+type Attr struct {
+	// ID is the rowid of the attribute, which its children refer to.
+	ID int64
+	// Parent is the ID of the parent attribute, 0 for the root.
+	Parent int64
+	// Name is the name of the attribute, empty for the root.
+	Name string
+	Type AttrType
+
+	Bool   bool
+	Int    int64
+	String string
+	// Context is the context of a string, like `!out!/nix/store/…drv` for
+	// an output of a derivation, or a plain store path.
+	Context []string
+	// Strings are the elements of a list of strings.
+	Strings []string
+}
+
+// decodeValue sets the value of a from the value and context columns.
+func (a *Attr) decodeValue(value sql.NullString, context sql.NullString) error {
+	var err error
+
+	switch a.Type {
+	case AttrPlaceholder, AttrFullAttrs, AttrMissing, AttrMisc, AttrFailed:
+		// no value
+	case AttrString:
+		a.String = value.String
+
+		// the context is stored space-separated
+		if context.String != "" {
+			a.Context = strings.Split(context.String, " ")
+		}
+	case AttrBool:
+		var n int64
+
+		n, err = strconv.ParseInt(value.String, 10, 64)
+		a.Bool = n != 0
+	case AttrInt:
+		a.Int, err = strconv.ParseInt(value.String, 10, 64)
+	case AttrListOfStrings:
+		// the elements are stored tab-separated
+		if value.String != "" {
+			a.Strings = strings.Split(value.String, "\t")
+		}
+	default:
+		return fmt.Errorf("unexpected attribute type %d", int64(a.Type))
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %v value %q: %w", a.Type, value.String, err)
+	}
+
+	return nil
+}
+
+// FormatValue renders the value of a, in a Nix-like syntax for the types
+// with a value.
+func (a *Attr) FormatValue() string {
+	switch a.Type { //nolint:exhaustive
+	case AttrString:
+		return strconv.Quote(a.String)
+	case AttrBool:
+		return strconv.FormatBool(a.Bool)
+	case AttrInt:
+		return strconv.FormatInt(a.Int, 10)
+	case AttrListOfStrings:
+		elems := make([]string, 0, len(a.Strings)+2)
+		elems = append(elems, "[")
+
+		for _, s := range a.Strings {
+			elems = append(elems, strconv.Quote(s))
+		}
+
+		return strings.Join(append(elems, "]"), " ")
+	default:
+		return "<" + a.Type.String() + ">"
+	}
+}
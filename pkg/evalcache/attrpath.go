@@ -0,0 +1,55 @@
+package evalcache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseAttrPath splits an attribute path like `packages.x86_64-linux.hello`
+// into its names. Names containing dots can be quoted, like `a."b.c"`.
+// The empty string is the empty path.
+func ParseAttrPath(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var (
+		names   []string
+		cur     strings.Builder
+		inQuote bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			inQuote = !inQuote
+		case c == '.' && !inQuote:
+			names = append(names, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+
+	if inQuote {
+		return nil, fmt.Errorf("missing closing quote in attribute path %v", s)
+	}
+
+	return append(names, cur.String()), nil
+}
+
+// FormatAttrPath joins names to an attribute path, quoting names where needed.
+func FormatAttrPath(names []string) string {
+	quoted := make([]string, len(names))
+
+	for i, name := range names {
+		if name == "" || strings.ContainsAny(name, `."`) {
+			quoted[i] = strconv.Quote(name)
+		} else {
+			quoted[i] = name
+		}
+	}
+
+	return strings.Join(quoted, ".")
+}
@@ -0,0 +1,201 @@
+// Package evalcache reads the evaluation caches Nix keeps for flakes,
+// in ~/.cache/nix/eval-cache-v5/<fingerprint>.sqlite.
+//
+// Each cached attribute is a row pointing to the rowid of its parent,
+// the root attribute has parent 0 and an empty name.
+// The row types and values are decoded into Attr.
+package evalcache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/nix-community/go-nix/pkg/sqlite"
+)
+
+// ErrNotFound is returned when looking up an attribute that isn't cached.
+var ErrNotFound = errors.New("attribute not found in the eval cache")
+
+// The queries below use the rowid, which sqlc can't deal with,
+// so they're not in pkg/sqlite/eval_cache_v5.
+const (
+	queryAttr = `select rowid, type, value, context from Attributes where parent = ? and name = ?`
+
+	queryAttrByID = `select parent, name, type, value, context from Attributes where rowid = ?`
+
+	queryChildren = `select rowid, name, type, value, context from Attributes where parent = ? order by name`
+)
+
+// Cache is an eval cache database.
+type Cache struct {
+	db *sql.DB
+}
+
+// New returns a Cache using an already opened eval_cache_v5 database.
+func New(db *sql.DB) *Cache {
+	return &Cache{db: db}
+}
+
+// Open opens the eval cache database at dsn.
+func Open(dsn string) (*Cache, error) {
+	db, _, err := sqlite.EvalCacheV5Strict(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(db), nil
+}
+
+// Close closes the underlying database.
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Root returns the root attribute, usually the attribute set of the
+// flake outputs.
+func (c *Cache) Root(ctx context.Context) (*Attr, error) {
+	return c.child(ctx, 0, "")
+}
+
+// Get looks up the attribute at path, like
+// []string{"packages", "x86_64-linux", "hello", "drvPath"}.
+// It returns ErrNotFound if the attribute, or one of its parents, isn't cached.
+func (c *Cache) Get(ctx context.Context, path []string) (*Attr, error) {
+	attr, err := c.Root(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, name := range path {
+		attr, err = c.child(ctx, attr.ID, name)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, fmt.Errorf("%w: %v", err, FormatAttrPath(path[:i+1]))
+			}
+
+			return nil, err
+		}
+	}
+
+	return attr, nil
+}
+
+// Children returns the cached children of attr, sorted by name.
+func (c *Cache) Children(ctx context.Context, attr *Attr) ([]*Attr, error) {
+	rows, err := c.db.QueryContext(ctx, queryChildren, attr.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var children []*Attr
+
+	for rows.Next() {
+		child := &Attr{Parent: attr.ID}
+
+		var value, context sql.NullString
+		if err := rows.Scan(&child.ID, &child.Name, &child.Type, &value, &context); err != nil {
+			return nil, err
+		}
+
+		if err := child.decodeValue(value, context); err != nil {
+			return nil, fmt.Errorf("attribute %v: %w", child.Name, err)
+		}
+
+		children = append(children, child)
+	}
+
+	return children, rows.Err()
+}
+
+// AttrPath rebuilds the path of attr by following the parent links.
+// The path of the root is empty.
+func (c *Cache) AttrPath(ctx context.Context, attr *Attr) ([]string, error) {
+	var path []string
+
+	seen := make(map[int64]struct{})
+
+	for cur := *attr; cur.Parent != 0; {
+		if _, ok := seen[cur.ID]; ok {
+			return nil, fmt.Errorf("cycle in the parents of attribute %d", attr.ID)
+		}
+
+		seen[cur.ID] = struct{}{}
+		path = append(path, cur.Name)
+
+		var value, context sql.NullString
+
+		id := cur.Parent
+		cur = Attr{ID: id}
+
+		err := c.db.QueryRowContext(ctx, queryAttrByID, id).Scan(&cur.Parent, &cur.Name, &cur.Type, &value, &context)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("parent %d of attribute %d is missing", id, attr.ID)
+			}
+
+			return nil, err
+		}
+	}
+
+	// reverse, as we collected the names from the leaf up
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	return path, nil
+}
+
+// Walk calls fn for attr and all its cached descendants, depth-first,
+// with their paths relative to attr.
+func (c *Cache) Walk(ctx context.Context, attr *Attr, fn func(path []string, attr *Attr) error) error {
+	return c.walk(ctx, nil, attr, fn)
+}
+
+func (c *Cache) walk(ctx context.Context, path []string, attr *Attr, fn func(path []string, attr *Attr) error) error {
+	if err := fn(path, attr); err != nil {
+		return err
+	}
+
+	if attr.Type != AttrFullAttrs {
+		return nil
+	}
+
+	children, err := c.Children(ctx, attr)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		childPath := append(path[:len(path):len(path)], child.Name)
+
+		if err := c.walk(ctx, childPath, child, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Cache) child(ctx context.Context, parent int64, name string) (*Attr, error) {
+	attr := &Attr{Parent: parent, Name: name}
+
+	var value, context sql.NullString
+
+	err := c.db.QueryRowContext(ctx, queryAttr, parent, name).Scan(&attr.ID, &attr.Type, &value, &context)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	if err := attr.decodeValue(value, context); err != nil {
+		return nil, fmt.Errorf("attribute %v: %w", name, err)
+	}
+
+	return attr, nil
+}
@@ -0,0 +1,180 @@
+package evalcache_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/evalcache"
+	"github.com/nix-community/go-nix/pkg/sqlite"
+	"github.com/nix-community/go-nix/pkg/sqlite/eval_cache_v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	helloDrv = "/nix/store/qnavcbp5ydyd12asgz7rpr7is7hlswaz-hello-2.12.1.drv"
+	helloOut = "/nix/store/kz5clxh7s1n0fnx6d37c1wc2cs9qm53q-hello-2.12.1"
+)
+
+// newTestCache returns a cache with rows laid out like Nix does,
+// the rowids are the indices in rows plus one.
+func newTestCache(t *testing.T) *evalcache.Cache {
+	t.Helper()
+
+	db, queries, err := sqlite.InitEvalCacheV5(filepath.Join(t.TempDir(), "eval-cache.sqlite"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { db.Close() })
+
+	str := func(s string) sql.NullString {
+		return sql.NullString{String: s, Valid: true}
+	}
+
+	rows := []eval_cache_v5.InsertAttributeWithContextParams{
+		{Parent: 0, Name: str(""), Type: int64(evalcache.AttrFullAttrs)},
+		{Parent: 1, Name: str("packages"), Type: int64(evalcache.AttrFullAttrs)},
+		{Parent: 2, Name: str("x86_64-linux"), Type: int64(evalcache.AttrFullAttrs)},
+		{Parent: 3, Name: str("hello"), Type: int64(evalcache.AttrFullAttrs)},
+		{Parent: 4, Name: str("drvPath"), Type: int64(evalcache.AttrString), Value: str(helloDrv), Context: str("=" + helloDrv)},
+		{Parent: 4, Name: str("outPath"), Type: int64(evalcache.AttrString), Value: str(helloOut), Context: str("!out!" + helloDrv)},
+		{Parent: 4, Name: str("meta"), Type: int64(evalcache.AttrPlaceholder)},
+		{Parent: 4, Name: str("outputs"), Type: int64(evalcache.AttrListOfStrings), Value: str("out\tman")},
+		{Parent: 4, Name: str("version"), Type: int64(evalcache.AttrInt), Value: str("12")},
+		{Parent: 4, Name: str("allowSubstitutes"), Type: int64(evalcache.AttrBool), Value: str("1")},
+		{Parent: 4, Name: str("override"), Type: int64(evalcache.AttrMisc)},
+		{Parent: 3, Name: str("broken"), Type: int64(evalcache.AttrFailed)},
+		{Parent: 1, Name: str("checks"), Type: int64(evalcache.AttrMissing)},
+		{Parent: 1, Name: str("a.b"), Type: int64(evalcache.AttrString), Value: str("dotted")},
+	}
+
+	for _, row := range rows {
+		require.NoError(t, queries.InsertAttributeWithContext(context.Background(), row))
+	}
+
+	return evalcache.New(db)
+}
+
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCache(t)
+
+	get := func(t *testing.T, path string) *evalcache.Attr {
+		t.Helper()
+
+		names, err := evalcache.ParseAttrPath(path)
+		require.NoError(t, err)
+
+		attr, err := c.Get(ctx, names)
+		require.NoError(t, err)
+
+		return attr
+	}
+
+	t.Run("string with context", func(t *testing.T) {
+		attr := get(t, "packages.x86_64-linux.hello.outPath")
+		assert.Equal(t, evalcache.AttrString, attr.Type)
+		assert.Equal(t, helloOut, attr.String)
+		assert.Equal(t, []string{"!out!" + helloDrv}, attr.Context)
+		assert.Equal(t, `"`+helloOut+`"`, attr.FormatValue())
+	})
+
+	t.Run("values", func(t *testing.T) {
+		assert.Equal(t, []string{"out", "man"}, get(t, "packages.x86_64-linux.hello.outputs").Strings)
+		assert.Equal(t, int64(12), get(t, "packages.x86_64-linux.hello.version").Int)
+		assert.True(t, get(t, "packages.x86_64-linux.hello.allowSubstitutes").Bool)
+		assert.Equal(t, "dotted", get(t, `"a.b"`).String)
+
+		assert.Equal(t, `[ "out" "man" ]`, get(t, "packages.x86_64-linux.hello.outputs").FormatValue())
+		assert.Equal(t, "<failed>", get(t, "packages.x86_64-linux.broken").FormatValue())
+	})
+
+	t.Run("types", func(t *testing.T) {
+		for path, typ := range map[string]evalcache.AttrType{
+			"":                                     evalcache.AttrFullAttrs,
+			"packages.x86_64-linux.hello.meta":     evalcache.AttrPlaceholder,
+			"packages.x86_64-linux.hello.override": evalcache.AttrMisc,
+			"packages.x86_64-linux.broken":         evalcache.AttrFailed,
+			"checks":                               evalcache.AttrMissing,
+		} {
+			assert.Equal(t, typ, get(t, path).Type, path)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := c.Get(ctx, []string{"packages", "aarch64-linux", "hello"})
+		if assert.ErrorIs(t, err, evalcache.ErrNotFound) {
+			assert.Contains(t, err.Error(), "packages.aarch64-linux")
+		}
+	})
+}
+
+func TestAttrPath(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCache(t)
+
+	attr, err := c.Get(ctx, []string{"packages", "x86_64-linux", "hello", "drvPath"})
+	require.NoError(t, err)
+
+	path, err := c.AttrPath(ctx, attr)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"packages", "x86_64-linux", "hello", "drvPath"}, path)
+	}
+
+	root, err := c.Root(ctx)
+	require.NoError(t, err)
+
+	path, err = c.AttrPath(ctx, root)
+	if assert.NoError(t, err) {
+		assert.Empty(t, path)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCache(t)
+
+	attr, err := c.Get(ctx, []string{"packages"})
+	require.NoError(t, err)
+
+	var paths []string
+
+	require.NoError(t, c.Walk(ctx, attr, func(path []string, _ *evalcache.Attr) error {
+		paths = append(paths, evalcache.FormatAttrPath(path))
+
+		return nil
+	}))
+
+	assert.Equal(t, []string{
+		"",
+		"x86_64-linux",
+		"x86_64-linux.broken",
+		"x86_64-linux.hello",
+		"x86_64-linux.hello.allowSubstitutes",
+		"x86_64-linux.hello.drvPath",
+		"x86_64-linux.hello.meta",
+		"x86_64-linux.hello.outPath",
+		"x86_64-linux.hello.outputs",
+		"x86_64-linux.hello.override",
+		"x86_64-linux.hello.version",
+	}, paths)
+}
+
+func TestParseAttrPath(t *testing.T) {
+	for s, expected := range map[string][]string{
+		"":                      nil,
+		"packages.x86_64-linux": {"packages", "x86_64-linux"},
+		`a."b.c".d`:             {"a", "b.c", "d"},
+		`a.""`:                  {"a", ""},
+	} {
+		names, err := evalcache.ParseAttrPath(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, expected, names, s)
+			assert.Equal(t, s, evalcache.FormatAttrPath(names), "should round-trip")
+		}
+	}
+
+	_, err := evalcache.ParseAttrPath(`a."b`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+// Package export reads and writes the format produced by `nix-store --export`,
+// and consumed by `nix-store --import`.
+//
+// An export is a sequence of entries, each prefixed by an uint64 field 1,
+// and terminated by an uint64 field 0. Each entry consists of:
+//
+//   - the NAR serialization of the store path
+//   - the magic ExportMagic
+//   - the store path
+//   - its references, as a list of store paths
+//   - its deriver, or an empty string
+//   - an optional legacy signature: 0 if there's none, or 1 followed by it
+package export
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+// ExportMagic is written after the NAR of each entry.
+const ExportMagic = 0x4558494e
+
+// maxStringSize is the maximum size of store paths and signatures.
+const maxStringSize = 64 << 10
+
+// Entry describes a store path contained in an export, except for its NAR.
+type Entry struct {
+	Path       string
+	References []string
+	Deriver    string // Empty if unknown
+
+	// Signature is a legacy signature, as written by old Nix versions.
+	// It's ignored by Nix on import. Empty if there's none.
+	Signature string
+
+	// NarHash is the sha256 hash of the NAR, and NarSize its size.
+	// They are set by the Reader, and ignored by the Writer.
+	NarHash *nixhash.Hash
+	NarSize uint64
+}
+
+// trailer is the part of an entry following the NAR and ExportMagic.
+type trailer struct {
+	Path       string
+	References []string
+	Deriver    string
+	Signature  *string
+}
+
+// Validate checks the entry contains valid store paths.
+func (e *Entry) Validate() error {
+	if err := storepath.Validate(e.Path); err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+
+	for _, ref := range e.References {
+		if err := storepath.Validate(ref); err != nil {
+			return fmt.Errorf("invalid reference: %w", err)
+		}
+	}
+
+	if e.Deriver != "" {
+		if err := storepath.Validate(e.Deriver); err != nil {
+			return fmt.Errorf("invalid deriver: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// NarInfo returns a NarInfo describing the entry, with its uncompressed NAR
+// located at nar/<narhash>.nar, as Nix does in binary caches.
+// It needs NarHash to be set.
+func (e *Entry) NarInfo() (*narinfo.NarInfo, error) {
+	if e.NarHash == nil || e.NarHash.Algo() != nixhash.SHA256 {
+		return nil, fmt.Errorf("nar hash needs to be sha256")
+	}
+
+	narHash, err := nixhash.NewHashWithEncoding(nixhash.SHA256, e.NarHash.Digest(), nixhash.NixBase32, true)
+	if err != nil {
+		return nil, err
+	}
+
+	references := make([]string, len(e.References))
+	for i, ref := range e.References {
+		references[i] = path.Base(ref)
+	}
+
+	ni := &narinfo.NarInfo{
+		StorePath:   e.Path,
+		URL:         "nar/" + nixbase32.EncodeToString(e.NarHash.Digest()) + ".nar",
+		Compression: "none",
+		FileHash:    narHash,
+		FileSize:    e.NarSize,
+		NarHash:     narHash,
+		NarSize:     e.NarSize,
+		References:  references,
+	}
+
+	if e.Deriver != "" {
+		ni.Deriver = path.Base(e.Deriver)
+	}
+
+	return ni, nil
+}
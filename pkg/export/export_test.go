@@ -0,0 +1,207 @@
+package export_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/export"
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	pathHello = "/nix/store/knn6wc1a89c47yb70qwv56rmxylia6wx-hello-2.12"
+	pathGlibc = "/nix/store/7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc-glibc-2.27"
+	pathDrv   = "/nix/store/0hm2f1psjpcwg8fijsmr4wwxrx59s092-bar.drv"
+)
+
+// genNar returns a NAR of a regular file with the given contents.
+func genNar(t *testing.T, contents string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	nw, err := nar.NewWriter(&buf)
+	require.NoError(t, err)
+
+	require.NoError(t, nw.WriteHeader(&nar.Header{
+		Path: "/",
+		Type: nar.TypeRegular,
+		Size: int64(len(contents)),
+	}))
+
+	_, err = nw.Write([]byte(contents))
+	require.NoError(t, err)
+
+	require.NoError(t, nw.Close())
+
+	return buf.Bytes()
+}
+
+// wireString returns s in Nix wire format, padded to 8 bytes.
+func wireString(s string) []byte {
+	b := append([]byte{byte(len(s)), 0, 0, 0, 0, 0, 0, 0}, s...)
+
+	return append(b, make([]byte, (8-len(s)%8)%8)...)
+}
+
+//nolint:gochecknoglobals
+var (
+	wireOne   = []byte{1, 0, 0, 0, 0, 0, 0, 0}
+	wireTwo   = []byte{2, 0, 0, 0, 0, 0, 0, 0}
+	wireZero  = []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	wireMagic = []byte{'N', 'I', 'X', 'E', 0, 0, 0, 0}
+)
+
+func concat(bs ...[]byte) []byte {
+	return bytes.Join(bs, nil)
+}
+
+func TestExport(t *testing.T) {
+	narGlibc := genNar(t, "glibc")
+	narHello := genNar(t, "Hello, world!")
+
+	entries := []*export.Entry{{
+		Path:       pathGlibc,
+		References: []string{},
+	}, {
+		Path:       pathHello,
+		References: []string{pathGlibc, pathHello},
+		Deriver:    pathDrv,
+		Signature:  "legacy",
+	}}
+
+	// This is how `nix-store --export` would write it.
+	expected := concat(
+		wireOne, narGlibc, wireMagic,
+		wireString(pathGlibc),
+		wireZero,       // no references
+		wireString(""), // no deriver
+		wireZero,       // no signature
+		wireOne, narHello, wireMagic,
+		wireString(pathHello),
+		wireTwo, wireString(pathGlibc), wireString(pathHello),
+		wireString(pathDrv),
+		wireOne, wireString("legacy"),
+		wireZero, // end marker
+	)
+
+	var buf bytes.Buffer
+
+	ew := export.NewWriter(&buf)
+	require.NoError(t, ew.WriteEntry(entries[0], bytes.NewReader(narGlibc)))
+	require.NoError(t, ew.WriteEntry(entries[1], bytes.NewReader(narHello)))
+	require.NoError(t, ew.Close())
+
+	assert.Equal(t, expected, buf.Bytes())
+
+	t.Run("read", func(t *testing.T) {
+		// trailing data after the end marker is left alone.
+		r := bytes.NewReader(append(append([]byte{}, expected...), "trailing"...))
+		er := export.NewReader(r)
+
+		for i, narContents := range [][]byte{narGlibc, narHello} {
+			var narBuf bytes.Buffer
+
+			e, err := er.Next(&narBuf)
+			require.NoError(t, err)
+
+			assert.Equal(t, narContents, narBuf.Bytes())
+
+			narHash := sha256.Sum256(narContents)
+			assert.Equal(t, nixhash.MustNewHash(nixhash.SHA256, narHash[:]), e.NarHash)
+			assert.Equal(t, uint64(len(narContents)), e.NarSize)
+
+			e.NarHash = nil
+			e.NarSize = 0
+			assert.Equal(t, entries[i], e)
+		}
+
+		_, err := er.Next(io.Discard)
+		assert.Equal(t, io.EOF, err)
+
+		rest, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("trailing"), rest)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		cases := []struct {
+			Title  string
+			Data   []byte
+			ErrMsg string
+		}{
+			{"empty", []byte{}, "unexpected EOF"},
+			{"garbage", wireTwo, "doesn't look like something created by 'nix-store --export'"},
+			{"invalid nar", concat(wireOne, wireString("nix-archive-2")), "unable to read NAR"},
+			{"invalid magic", concat(wireOne, narGlibc, wireZero), "invalid magic"},
+			{"invalid path", concat(
+				wireOne, narGlibc, wireMagic, wireString("/tmp/foo"), wireZero, wireString(""), wireZero,
+			), "invalid path"},
+			{"truncated", expected[:len(expected)-16], "unexpected EOF"},
+			{"missing end marker", expected[:len(expected)-8], "unexpected EOF"},
+		}
+
+		for _, c := range cases {
+			t.Run(c.Title, func(t *testing.T) {
+				er := export.NewReader(bytes.NewReader(c.Data))
+
+				var err error
+				for err == nil {
+					_, err = er.Next(io.Discard)
+				}
+
+				assert.ErrorContains(t, err, c.ErrMsg)
+
+				// errors are sticky
+				_, err2 := er.Next(io.Discard)
+				assert.Equal(t, err, err2)
+			})
+		}
+	})
+
+	t.Run("invalid entries", func(t *testing.T) {
+		ew := export.NewWriter(io.Discard)
+
+		err := ew.WriteEntry(&export.Entry{Path: pathHello, References: []string{"foo"}}, bytes.NewReader(narHello))
+		assert.ErrorContains(t, err, "invalid reference")
+
+		err = ew.WriteEntry(&export.Entry{Path: pathHello}, bytes.NewReader(narHello[:20]))
+		assert.ErrorContains(t, err, "unable to write NAR")
+
+		// errors are sticky
+		assert.ErrorContains(t, ew.Close(), "unable to write NAR")
+	})
+}
+
+func TestEntryNarInfo(t *testing.T) {
+	e := &export.Entry{
+		Path:       pathHello,
+		References: []string{pathGlibc, pathHello},
+		Deriver:    pathDrv,
+		NarHash:    nixhash.MustParseNixBase32("sha256:1m5r6dlfa7djvsp2fvj4m1hc2qn0sqlnbvnhkn2szzmpfwqb1knr"),
+		NarSize:    42,
+	}
+
+	ni, err := e.NarInfo()
+	require.NoError(t, err)
+
+	assert.Equal(t, `StorePath: /nix/store/knn6wc1a89c47yb70qwv56rmxylia6wx-hello-2.12
+URL: nar/1m5r6dlfa7djvsp2fvj4m1hc2qn0sqlnbvnhkn2szzmpfwqb1knr.nar
+Compression: none
+FileHash: sha256:1m5r6dlfa7djvsp2fvj4m1hc2qn0sqlnbvnhkn2szzmpfwqb1knr
+FileSize: 42
+NarHash: sha256:1m5r6dlfa7djvsp2fvj4m1hc2qn0sqlnbvnhkn2szzmpfwqb1knr
+NarSize: 42
+References: 7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc-glibc-2.27 knn6wc1a89c47yb70qwv56rmxylia6wx-hello-2.12
+Deriver: 0hm2f1psjpcwg8fijsmr4wwxrx59s092-bar.drv
+`, ni.String())
+	assert.NoError(t, ni.Check())
+
+	_, err = (&export.Entry{Path: pathHello}).NarInfo()
+	assert.Error(t, err)
+}
@@ -0,0 +1,107 @@
+package export
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/wire"
+)
+
+// Reader reads the entries of an export.
+type Reader struct {
+	r   io.Reader
+	err error // sticky error, io.EOF once the end marker has been read
+}
+
+// NewReader returns a reader for the export read from r.
+// It reads exactly up to the end marker.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Next reads the next entry, writing its NAR to w.
+// It returns io.EOF once the end of the export has been reached.
+func (er *Reader) Next(w io.Writer) (*Entry, error) {
+	if er.err != nil {
+		return nil, er.err
+	}
+
+	e, err := er.next(w)
+	if err != nil {
+		// the export ending before the end marker is unexpected.
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		er.err = err
+
+		return nil, err
+	}
+
+	if e == nil {
+		er.err = io.EOF
+
+		return nil, io.EOF
+	}
+
+	return e, nil
+}
+
+// next reads the next entry, or returns a nil entry on the end marker.
+func (er *Reader) next(w io.Writer) (*Entry, error) {
+	n, err := wire.ReadUint64(er.r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("input doesn't look like something created by 'nix-store --export'")
+	}
+
+	h := sha256.New()
+
+	narSize, err := nar.Copy(io.MultiWriter(w, h), er.r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read NAR: %w", err)
+	}
+
+	magic, err := wire.ReadUint64(er.r)
+	if err != nil {
+		return nil, err
+	}
+
+	if magic != ExportMagic {
+		return nil, fmt.Errorf("invalid magic %x, expected %x", magic, ExportMagic)
+	}
+
+	var t trailer
+	if err := wire.NewDecoder(er.r, maxStringSize).Decode(&t); err != nil {
+		return nil, err
+	}
+
+	e := &Entry{
+		Path:       t.Path,
+		References: t.References,
+		Deriver:    t.Deriver,
+		NarHash:    nixhash.MustNewHash(nixhash.SHA256, h.Sum(nil)),
+		NarSize:    uint64(narSize),
+	}
+
+	if t.Signature != nil {
+		e.Signature = *t.Signature
+	}
+
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
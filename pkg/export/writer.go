@@ -0,0 +1,84 @@
+package export
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/wire"
+)
+
+// Writer writes an export.
+type Writer struct {
+	w      io.Writer
+	err    error // sticky error
+	closed bool
+}
+
+// NewWriter returns a writer for an export written to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteEntry writes an entry, with the NAR read from narReader.
+// The NAR is parsed while copying, to make sure it is valid.
+func (ew *Writer) WriteEntry(e *Entry, narReader io.Reader) error {
+	if ew.err != nil {
+		return ew.err
+	}
+
+	if err := e.Validate(); err != nil {
+		return err
+	}
+
+	ew.err = ew.writeEntry(e, narReader)
+
+	return ew.err
+}
+
+func (ew *Writer) writeEntry(e *Entry, narReader io.Reader) error {
+	if err := wire.WriteUint64(ew.w, 1); err != nil {
+		return err
+	}
+
+	if _, err := nar.Copy(ew.w, narReader); err != nil {
+		return fmt.Errorf("unable to write NAR: %w", err)
+	}
+
+	if err := wire.WriteUint64(ew.w, ExportMagic); err != nil {
+		return err
+	}
+
+	t := trailer{
+		Path:       e.Path,
+		References: e.References,
+		Deriver:    e.Deriver,
+	}
+
+	if e.Signature != "" {
+		t.Signature = &e.Signature
+	}
+
+	return wire.NewEncoder(ew.w).Encode(&t)
+}
+
+// Close writes the end marker. It doesn't close the underlying writer.
+func (ew *Writer) Close() error {
+	// if we already closed once, don't close again
+	if ew.closed {
+		return nil
+	}
+
+	if ew.err != nil {
+		return ew.err
+	}
+
+	if ew.err = wire.WriteUint64(ew.w, 0); ew.err != nil {
+		return ew.err
+	}
+
+	ew.closed = true
+	ew.err = fmt.Errorf("export writer already closed")
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package fetchercache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Attrs are the keys and values of cache entries, stored as JSON objects.
+// Like in Nix, the values are strings, uint64s or bools.
+type Attrs map[string]interface{}
+
+// encode renders the attrs like Nix does: compact, with sorted keys.
+// Nix looks up entries by this string, so it needs to match exactly, which
+// is why it isn't left to encoding/json.
+func (a Attrs) encode() (string, error) {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+
+	// like the std::map of nlohmann::json objects
+	sort.Strings(names)
+
+	var sb strings.Builder
+
+	sb.WriteByte('{')
+
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+
+		if err := writeString(&sb, name); err != nil {
+			return "", err
+		}
+
+		sb.WriteByte(':')
+
+		switch v := a[name].(type) {
+		case string:
+			if err := writeString(&sb, v); err != nil {
+				return "", fmt.Errorf("attribute %v: %w", name, err)
+			}
+		case uint64:
+			sb.WriteString(strconv.FormatUint(v, 10))
+		case bool:
+			sb.WriteString(strconv.FormatBool(v))
+		default:
+			return "", fmt.Errorf("unsupported type %T of attribute %v", v, name)
+		}
+	}
+
+	sb.WriteByte('}')
+
+	return sb.String(), nil
+}
+
+// writeString writes s as a JSON string, escaped like nlohmann::json's dump()
+// does: only quotes, backslashes and control characters are escaped, anything
+// else, including U+2028 and U+2029, is written as is.
+// Like dump(), it fails on invalid UTF-8, which encoding/json would replace.
+func writeString(sb *strings.Builder, s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("%q is not valid UTF-8", s)
+	}
+
+	sb.WriteByte('"')
+
+	// all bytes of multi-byte characters are >= 0x80, so they're copied as is
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				fmt.Fprintf(sb, `\u%04x`, c)
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+
+	sb.WriteByte('"')
+
+	return nil
+}
+
+func decodeAttrs(s string) (Attrs, error) {
+	var raw map[string]interface{}
+
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseNumber()
+
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid attrs: %w", err)
+	}
+
+	a := make(Attrs, len(raw))
+
+	for name, v := range raw {
+		switch v := v.(type) {
+		case string, bool:
+			a[name] = v
+		case json.Number:
+			n, err := strconv.ParseUint(v.String(), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid attrs: attribute %v: %w", name, err)
+			}
+
+			a[name] = n
+		default:
+			return nil, fmt.Errorf("invalid attrs: unsupported type %T of attribute %v", v, name)
+		}
+	}
+
+	return a, nil
+}
+
+// String returns the string attribute name, and whether it's set.
+// It's an error if the attribute has another type.
+func (a Attrs) String(name string) (string, bool, error) {
+	v, ok := a[name]
+	if !ok {
+		return "", false, nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return "", false, fmt.Errorf("attribute %v should be a string, not %T", name, v)
+	}
+
+	return s, true, nil
+}
+
+// Uint returns the integer attribute name, and whether it's set.
+// It's an error if the attribute has another type.
+func (a Attrs) Uint(name string) (uint64, bool, error) {
+	v, ok := a[name]
+	if !ok {
+		return 0, false, nil
+	}
+
+	n, ok := v.(uint64)
+	if !ok {
+		return 0, false, fmt.Errorf("attribute %v should be an integer, not %T", name, v)
+	}
+
+	return n, true, nil
+}
+
+// requireString is like String, but fails if the attribute isn't set.
+func (a Attrs) requireString(name string) (string, error) {
+	s, ok, err := a.String(name)
+	if err == nil && !ok {
+		err = fmt.Errorf("attribute %v is missing", name)
+	}
+
+	return s, err
+}
+
+// requireUint is like Uint, but fails if the attribute isn't set.
+func (a Attrs) requireUint(name string) (uint64, error) {
+	n, ok, err := a.Uint(name)
+	if err == nil && !ok {
+		err = fmt.Errorf("attribute %v is missing", name)
+	}
+
+	return n, err
+}
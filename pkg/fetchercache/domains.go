@@ -0,0 +1,281 @@
+package fetchercache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+// The domains of the entries written by Nix's fetchers.
+const (
+	// DomainFile is used by downloads of single files, like fetchurl and
+	// the flake registry.
+	DomainFile = "file"
+	// DomainTarball is used by downloads of tarballs, which are unpacked
+	// into Nix's tarball cache.
+	DomainTarball = "tarball"
+
+	DomainGitLastModified      = "gitLastModified"
+	DomainGitRevCount          = "gitRevCount"
+	DomainGitRevToTreeHash     = "gitRevToTreeHash"
+	DomainGitRevToLastModified = "gitRevToLastModified"
+)
+
+// RegistryFileName is the name the flake registry is downloaded with.
+const RegistryFileName = "flake-registry.json"
+
+// File is a file downloaded by Nix, and added to the store.
+type File struct {
+	ETag string
+	// EffectiveURL is the URL the file was downloaded from, after redirects.
+	EffectiveURL string
+	// ImmutableURL is the URL advertised by the server with a Link header
+	// for locking, if any.
+	ImmutableURL string
+	// StorePath is the absolute store path the file was added as.
+	// Callers should check it's still valid before using it.
+	StorePath string
+}
+
+func fileKey(url, name string) Key {
+	return Key{
+		Domain: DomainFile,
+		Attrs:  Attrs{"url": url, "name": name},
+	}
+}
+
+// LookupFile returns the file downloaded from url with the given name, and
+// whether the entry is expired.
+func (c *Cache) LookupFile(ctx context.Context, url, name string) (*File, bool, error) {
+	res, err := c.Lookup(ctx, fileKey(url, name))
+	if err != nil {
+		return nil, false, err
+	}
+
+	f, err := fileFromAttrs(res.Value)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid entry for %v: %w", url, err)
+	}
+
+	return f, res.Expired, nil
+}
+
+// UpsertFile records the file downloaded from url with the given name.
+// Like Nix, callers should record it for every URL in the redirect chain.
+func (c *Cache) UpsertFile(ctx context.Context, url, name string, f *File) error {
+	sp, err := storepath.FromAbsolutePath(f.StorePath)
+	if err != nil {
+		return err
+	}
+
+	value := Attrs{
+		"etag":      f.ETag,
+		"url":       f.EffectiveURL,
+		"storePath": sp.String(),
+	}
+
+	if f.ImmutableURL != "" {
+		value["immutableUrl"] = f.ImmutableURL
+	}
+
+	return c.Upsert(ctx, fileKey(url, name), value)
+}
+
+// LookupRegistry returns the flake registry downloaded from url, and whether
+// the entry is expired.
+func (c *Cache) LookupRegistry(ctx context.Context, url string) (*File, bool, error) {
+	return c.LookupFile(ctx, url, RegistryFileName)
+}
+
+func fileFromAttrs(a Attrs) (*File, error) {
+	var (
+		f   File
+		err error
+	)
+
+	if f.ETag, err = a.requireString("etag"); err != nil {
+		return nil, err
+	}
+
+	if f.EffectiveURL, err = a.requireString("url"); err != nil {
+		return nil, err
+	}
+
+	if f.ImmutableURL, _, err = a.String("immutableUrl"); err != nil {
+		return nil, err
+	}
+
+	// Nix stores the store path without the store directory
+	s, err := a.requireString("storePath")
+	if err != nil {
+		return nil, err
+	}
+
+	sp, err := storepath.FromString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	f.StorePath = sp.Absolute()
+
+	return &f, nil
+}
+
+// Tarball is a tarball downloaded by Nix, and unpacked into its tarball cache.
+type Tarball struct {
+	ETag string
+	// TreeHash is the git tree hash of the unpacked tarball, in hex.
+	TreeHash     string
+	LastModified time.Time
+	// ImmutableURL is the URL advertised by the server with a Link header
+	// for locking, if any.
+	ImmutableURL string
+}
+
+func tarballKey(url string) Key {
+	return Key{
+		Domain: DomainTarball,
+		Attrs:  Attrs{"url": url},
+	}
+}
+
+// LookupTarball returns the tarball downloaded from url, and whether the
+// entry is expired.
+func (c *Cache) LookupTarball(ctx context.Context, url string) (*Tarball, bool, error) {
+	res, err := c.Lookup(ctx, tarballKey(url))
+	if err != nil {
+		return nil, false, err
+	}
+
+	t, err := tarballFromAttrs(res.Value)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid entry for %v: %w", url, err)
+	}
+
+	return t, res.Expired, nil
+}
+
+// UpsertTarball records the tarball downloaded from url.
+// Like Nix, callers should record it for every URL in the redirect chain.
+func (c *Cache) UpsertTarball(ctx context.Context, url string, t *Tarball) error {
+	value := Attrs{
+		"etag":         t.ETag,
+		"treeHash":     t.TreeHash,
+		"lastModified": uint64(t.LastModified.Unix()), //nolint:gosec
+	}
+
+	if t.ImmutableURL != "" {
+		value["immutableUrl"] = t.ImmutableURL
+	}
+
+	return c.Upsert(ctx, tarballKey(url), value)
+}
+
+func tarballFromAttrs(a Attrs) (*Tarball, error) {
+	var (
+		t   Tarball
+		err error
+	)
+
+	if t.ETag, err = a.requireString("etag"); err != nil {
+		return nil, err
+	}
+
+	if t.TreeHash, err = a.requireString("treeHash"); err != nil {
+		return nil, err
+	}
+
+	lastModified, err := a.requireUint("lastModified")
+	if err != nil {
+		return nil, err
+	}
+
+	t.LastModified = time.Unix(int64(lastModified), 0) //nolint:gosec
+
+	if t.ImmutableURL, _, err = a.String("immutableUrl"); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// The git entries below are keyed by revisions (commit hashes in hex), and
+// never expire, as they describe immutable objects.
+
+func revKey(domain, rev string) Key {
+	return Key{
+		Domain: domain,
+		Attrs:  Attrs{"rev": rev},
+	}
+}
+
+// LookupGitLastModified returns the commit time of the git revision rev.
+func (c *Cache) LookupGitLastModified(ctx context.Context, rev string) (time.Time, error) {
+	return c.lookupRevTime(ctx, DomainGitLastModified, rev)
+}
+
+// UpsertGitLastModified records the commit time of the git revision rev.
+func (c *Cache) UpsertGitLastModified(ctx context.Context, rev string, lastModified time.Time) error {
+	return c.Upsert(ctx, revKey(DomainGitLastModified, rev), Attrs{
+		"lastModified": uint64(lastModified.Unix()), //nolint:gosec
+	})
+}
+
+// LookupGitRevCount returns the number of ancestors of the git revision rev.
+func (c *Cache) LookupGitRevCount(ctx context.Context, rev string) (uint64, error) {
+	return c.lookupRevUint(ctx, DomainGitRevCount, rev, "revCount")
+}
+
+// UpsertGitRevCount records the number of ancestors of the git revision rev.
+func (c *Cache) UpsertGitRevCount(ctx context.Context, rev string, revCount uint64) error {
+	return c.Upsert(ctx, revKey(DomainGitRevCount, rev), Attrs{"revCount": revCount})
+}
+
+// LookupGitRevToTreeHash returns the tree hash of the git revision rev,
+// as recorded by the GitHub-like fetchers.
+func (c *Cache) LookupGitRevToTreeHash(ctx context.Context, rev string) (string, error) {
+	res, err := c.Lookup(ctx, revKey(DomainGitRevToTreeHash, rev))
+	if err != nil {
+		return "", err
+	}
+
+	return res.Value.requireString("treeHash")
+}
+
+// UpsertGitRevToTreeHash records the tree hash of the git revision rev.
+func (c *Cache) UpsertGitRevToTreeHash(ctx context.Context, rev string, treeHash string) error {
+	return c.Upsert(ctx, revKey(DomainGitRevToTreeHash, rev), Attrs{"treeHash": treeHash})
+}
+
+// LookupGitRevToLastModified returns the commit time of the git revision rev,
+// as recorded by the GitHub-like fetchers.
+func (c *Cache) LookupGitRevToLastModified(ctx context.Context, rev string) (time.Time, error) {
+	return c.lookupRevTime(ctx, DomainGitRevToLastModified, rev)
+}
+
+// UpsertGitRevToLastModified records the commit time of the git revision rev.
+func (c *Cache) UpsertGitRevToLastModified(ctx context.Context, rev string, lastModified time.Time) error {
+	return c.Upsert(ctx, revKey(DomainGitRevToLastModified, rev), Attrs{
+		"lastModified": uint64(lastModified.Unix()), //nolint:gosec
+	})
+}
+
+func (c *Cache) lookupRevUint(ctx context.Context, domain, rev, name string) (uint64, error) {
+	res, err := c.Lookup(ctx, revKey(domain, rev))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.Value.requireUint(name)
+}
+
+func (c *Cache) lookupRevTime(ctx context.Context, domain, rev string) (time.Time, error) {
+	n, err := c.lookupRevUint(ctx, domain, rev, "lastModified")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(int64(n), 0), nil //nolint:gosec
+}
@@ -0,0 +1,193 @@
+// Package fetchercache provides typed access to the cache Nix keeps for its
+// fetchers, in ~/.cache/nix/fetcher-cache-v2.sqlite.
+//
+// Entries are keyed by a domain (like "file" or "tarball") and a set of
+// attributes, their values are attributes as well. Both are stored as JSON.
+// Entries older than the TTL (Nix's tarball-ttl setting) are expired: Nix
+// still uses them, but revalidates them first, like with an etag.
+package fetchercache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/sqlite"
+	"github.com/nix-community/go-nix/pkg/sqlite/fetcher_cache_v2"
+)
+
+// DefaultTTL is the default value of Nix's tarball-ttl setting.
+const DefaultTTL = time.Hour
+
+// ErrNotFound is returned when looking up an entry that isn't cached,
+// or is expired where that matters.
+var ErrNotFound = errors.New("entry not found in the fetcher cache")
+
+// Key identifies a cache entry.
+type Key struct {
+	Domain string
+	Attrs  Attrs
+}
+
+// Result is a cache entry found by a lookup.
+type Result struct {
+	Value     Attrs
+	Timestamp time.Time
+	// Expired is set if the entry is older than the TTL.
+	Expired bool
+}
+
+// Entry is a raw cache entry, as returned by Entries.
+type Entry struct {
+	Domain    string
+	Key       string // JSON
+	Value     string // JSON
+	Timestamp time.Time
+}
+
+// Cache is a fetcher cache database.
+type Cache struct {
+	db      *sql.DB
+	queries *fetcher_cache_v2.Queries
+
+	// TTL is the time after which entries expire, like Nix's tarball-ttl
+	// setting. If it's zero, all entries are expired.
+	TTL time.Duration
+}
+
+// New returns a Cache using an already opened fetcher_cache_v2 database,
+// with the default TTL.
+func New(db *sql.DB) *Cache {
+	return &Cache{
+		db:      db,
+		queries: fetcher_cache_v2.New(db),
+		TTL:     DefaultTTL,
+	}
+}
+
+// Open opens the fetcher cache database at dsn.
+func Open(dsn string) (*Cache, error) {
+	db, _, err := sqlite.FetcherCacheV2Strict(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(db), nil
+}
+
+// Close closes the underlying database.
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Upsert inserts or replaces the entry for key, with the current time.
+func (c *Cache) Upsert(ctx context.Context, key Key, value Attrs) error {
+	k, err := key.Attrs.encode()
+	if err != nil {
+		return fmt.Errorf("invalid key: %w", err)
+	}
+
+	v, err := value.encode()
+	if err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+
+	return c.queries.UpsertCache(ctx, fetcher_cache_v2.UpsertCacheParams{
+		Domain:    key.Domain,
+		Key:       k,
+		Value:     v,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// Lookup returns the entry for key, expired or not.
+// It returns ErrNotFound if there's no entry.
+func (c *Cache) Lookup(ctx context.Context, key Key) (*Result, error) {
+	k, err := key.Attrs.encode()
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+
+	rows, err := c.queries.QueryCache(ctx, fetcher_cache_v2.QueryCacheParams{
+		Domain: key.Domain,
+		Key:    k,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+
+	value, err := decodeAttrs(rows[0].Value)
+	if err != nil {
+		return nil, fmt.Errorf("entry %v %v: %w", key.Domain, k, err)
+	}
+
+	return &Result{
+		Value:     value,
+		Timestamp: time.Unix(rows[0].Timestamp, 0),
+		Expired:   c.expired(rows[0].Timestamp),
+	}, nil
+}
+
+// LookupWithTTL is like Lookup, but returns ErrNotFound for expired entries.
+func (c *Cache) LookupWithTTL(ctx context.Context, key Key) (Attrs, error) {
+	res, err := c.Lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Expired {
+		return nil, ErrNotFound
+	}
+
+	return res.Value, nil
+}
+
+// Entries returns all entries, sorted by domain and key.
+func (c *Cache) Entries(ctx context.Context) ([]*Entry, error) {
+	rows, err := c.queries.QueryEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, len(rows))
+	for i, row := range rows {
+		entries[i] = &Entry{
+			Domain:    row.Domain,
+			Key:       row.Key,
+			Value:     row.Value,
+			Timestamp: time.Unix(row.Timestamp, 0),
+		}
+	}
+
+	return entries, nil
+}
+
+// Expired returns true if an entry with timestamp t is expired.
+func (c *Cache) Expired(t time.Time) bool {
+	return c.expired(t.Unix())
+}
+
+// Prune deletes the entries older than t, and returns how many were deleted.
+func (c *Cache) Prune(ctx context.Context, t time.Time) (int64, error) {
+	return c.queries.DeleteOlderThan(ctx, t.Unix())
+}
+
+// PruneExpired deletes the expired entries, and returns how many were deleted.
+func (c *Cache) PruneExpired(ctx context.Context) (int64, error) {
+	if c.TTL == 0 {
+		return c.queries.DeleteOlderThan(ctx, math.MaxInt64)
+	}
+
+	return c.queries.DeleteOlderThan(ctx, time.Now().Unix()-int64(c.TTL/time.Second))
+}
+
+func (c *Cache) expired(timestamp int64) bool {
+	return c.TTL == 0 || timestamp+int64(c.TTL/time.Second) < time.Now().Unix()
+}
@@ -0,0 +1,255 @@
+package fetchercache_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/fetchercache"
+	"github.com/nix-community/go-nix/pkg/sqlite"
+	"github.com/nix-community/go-nix/pkg/sqlite/fetcher_cache_v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	registryURL = "https://channels.nixos.org/flake-registry.json"
+	tarballURL  = "https://github.com/NixOS/nixpkgs/archive/nixos-unstable.tar.gz"
+	rev         = "b3a285628a6928f62cdf4d09f4e656f7ecbbcafb"
+)
+
+func newTestCache(t *testing.T) (*fetchercache.Cache, *fetcher_cache_v2.Queries) {
+	t.Helper()
+
+	db, queries, err := sqlite.InitFetcherCacheV2(filepath.Join(t.TempDir(), "fetcher-cache-v2.sqlite"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { db.Close() })
+
+	return fetchercache.New(db), queries
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	c, queries := newTestCache(t)
+
+	f := &fetchercache.File{
+		ETag:         `"5a3f0e8c"`,
+		EffectiveURL: "https://raw.githubusercontent.com/NixOS/flake-registry/master/flake-registry.json",
+		StorePath:    "/nix/store/9vcrqr1s0nh0bmmnh0zc5f2cxvwcvmkx-flake-registry.json",
+	}
+
+	require.NoError(t, c.UpsertFile(ctx, registryURL, fetchercache.RegistryFileName, f))
+
+	t.Run("layout", func(t *testing.T) {
+		entries, err := c.Entries(ctx)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+
+		// this is what Nix looks up and expects
+		assert.Equal(t, "file", entries[0].Domain)
+		assert.Equal(t, `{"name":"flake-registry.json","url":"`+registryURL+`"}`, entries[0].Key)
+		assert.Equal(t,
+			`{"etag":"\"5a3f0e8c\"","storePath":"9vcrqr1s0nh0bmmnh0zc5f2cxvwcvmkx-flake-registry.json",`+
+				`"url":"https://raw.githubusercontent.com/NixOS/flake-registry/master/flake-registry.json"}`,
+			entries[0].Value)
+	})
+
+	t.Run("lookup", func(t *testing.T) {
+		found, expired, err := c.LookupRegistry(ctx, registryURL)
+		if assert.NoError(t, err) {
+			assert.Equal(t, f, found)
+			assert.False(t, expired)
+		}
+
+		_, _, err = c.LookupFile(ctx, registryURL, "other-name")
+		assert.ErrorIs(t, err, fetchercache.ErrNotFound)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		c.TTL = 0
+		defer func() { c.TTL = fetchercache.DefaultTTL }()
+
+		_, expired, err := c.LookupFile(ctx, registryURL, fetchercache.RegistryFileName)
+		if assert.NoError(t, err) {
+			assert.True(t, expired, "a TTL of 0 expires everything")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		require.NoError(t, queries.UpsertCache(ctx, fetcher_cache_v2.UpsertCacheParams{
+			Domain: "file",
+			Key:    `{"name":"invalid","url":"https://example.com"}`,
+			Value:  `{"etag":"","url":"https://example.com"}`,
+		}))
+
+		_, _, err := c.LookupFile(ctx, "https://example.com", "invalid")
+		assert.ErrorContains(t, err, "storePath is missing")
+
+		assert.Error(t, c.UpsertFile(ctx, registryURL, "foo", &fetchercache.File{StorePath: "/tmp/foo"}))
+	})
+}
+
+func TestTarball(t *testing.T) {
+	ctx := context.Background()
+	c, queries := newTestCache(t)
+
+	// an entry written by Nix, a day ago
+	require.NoError(t, queries.UpsertCache(ctx, fetcher_cache_v2.UpsertCacheParams{
+		Domain: "tarball",
+		Key:    `{"url":"` + tarballURL + `"}`,
+		Value: `{"etag":"W/\"c4b0ae1b\"","immutableUrl":"https://github.com/NixOS/nixpkgs/archive/` + rev + `.tar.gz",` +
+			`"lastModified":1718000000,"treeHash":"1b8d5b6e9a3b1f0a2c7e8d9f0a1b2c3d4e5f6a7b"}`,
+		Timestamp: time.Now().Add(-24 * time.Hour).Unix(),
+	}))
+
+	expected := &fetchercache.Tarball{
+		ETag:         `W/"c4b0ae1b"`,
+		TreeHash:     "1b8d5b6e9a3b1f0a2c7e8d9f0a1b2c3d4e5f6a7b",
+		LastModified: time.Unix(1718000000, 0),
+		ImmutableURL: "https://github.com/NixOS/nixpkgs/archive/" + rev + ".tar.gz",
+	}
+
+	tarball, expired, err := c.LookupTarball(ctx, tarballURL)
+	if assert.NoError(t, err) {
+		assert.Equal(t, expected, tarball)
+		assert.True(t, expired)
+	}
+
+	_, err = c.LookupWithTTL(ctx, fetchercache.Key{
+		Domain: fetchercache.DomainTarball,
+		Attrs:  fetchercache.Attrs{"url": tarballURL},
+	})
+	assert.ErrorIs(t, err, fetchercache.ErrNotFound, "expired entries should be ignored")
+
+	// revalidating refreshes the timestamp
+	require.NoError(t, c.UpsertTarball(ctx, tarballURL, tarball))
+
+	tarball, expired, err = c.LookupTarball(ctx, tarballURL)
+	if assert.NoError(t, err) {
+		assert.Equal(t, expected, tarball)
+		assert.False(t, expired)
+	}
+}
+
+func TestGit(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestCache(t)
+
+	lastModified := time.Unix(1718000000, 0)
+
+	require.NoError(t, c.UpsertGitLastModified(ctx, rev, lastModified))
+	require.NoError(t, c.UpsertGitRevCount(ctx, rev, 650000))
+	require.NoError(t, c.UpsertGitRevToTreeHash(ctx, rev, "1b8d5b6e9a3b1f0a2c7e8d9f0a1b2c3d4e5f6a7b"))
+	require.NoError(t, c.UpsertGitRevToLastModified(ctx, rev, lastModified))
+
+	// git entries describe immutable objects, and are used even if expired
+	c.TTL = 0
+
+	tm, err := c.LookupGitLastModified(ctx, rev)
+	if assert.NoError(t, err) {
+		assert.Equal(t, lastModified, tm)
+	}
+
+	n, err := c.LookupGitRevCount(ctx, rev)
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint64(650000), n)
+	}
+
+	treeHash, err := c.LookupGitRevToTreeHash(ctx, rev)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1b8d5b6e9a3b1f0a2c7e8d9f0a1b2c3d4e5f6a7b", treeHash)
+	}
+
+	tm, err = c.LookupGitRevToLastModified(ctx, rev)
+	if assert.NoError(t, err) {
+		assert.Equal(t, lastModified, tm)
+	}
+
+	_, err = c.LookupGitRevCount(ctx, "0000000000000000000000000000000000000000")
+	assert.ErrorIs(t, err, fetchercache.ErrNotFound)
+
+	entries, err := c.Entries(ctx)
+	if assert.NoError(t, err) && assert.Len(t, entries, 4) {
+		assert.Equal(t, `{"rev":"`+rev+`"}`, entries[0].Key)
+		assert.Equal(t, `{"lastModified":1718000000}`, entries[0].Value)
+	}
+}
+
+func TestAttrs(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestCache(t)
+
+	key := fetchercache.Key{Domain: "custom", Attrs: fetchercache.Attrs{"url": "https://example.com/?a=1&b=<2>"}}
+
+	require.NoError(t, c.Upsert(ctx, key, fetchercache.Attrs{"n": uint64(1), "b": true, "s": "x"}))
+
+	value, err := c.LookupWithTTL(ctx, key)
+	if assert.NoError(t, err) {
+		assert.Equal(t, fetchercache.Attrs{"n": uint64(1), "b": true, "s": "x"}, value)
+	}
+
+	entries, err := c.Entries(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{"url":"https://example.com/?a=1&b=<2>"}`, entries[0].Key, "should not be HTML-escaped")
+	}
+
+	assert.Error(t, c.Upsert(ctx, key, fetchercache.Attrs{"n": 1}), "only uint64 integers are allowed")
+
+	t.Run("escaping", func(t *testing.T) {
+		c, _ := newTestCache(t)
+
+		// nlohmann::json writes U+2028 as is, and escapes control characters
+		// with lowercase hex, but \b and \f with their short forms
+		url := "https://example.com/\u2028\"\\\b\f\x01\x7f"
+		key := fetchercache.Key{Domain: "custom", Attrs: fetchercache.Attrs{"url": url}}
+
+		require.NoError(t, c.Upsert(ctx, key, fetchercache.Attrs{"s": "x"}))
+
+		entries, err := c.Entries(ctx)
+		if assert.NoError(t, err) {
+			assert.Equal(t, `{"url":"https://example.com/`+"\u2028"+`\"\\\b\f\u0001`+"\x7f"+`"}`, entries[0].Key)
+		}
+
+		value, err := c.LookupWithTTL(ctx, key)
+		if assert.NoError(t, err) {
+			assert.Equal(t, fetchercache.Attrs{"s": "x"}, value)
+		}
+
+		invalid := fetchercache.Key{Domain: "custom", Attrs: fetchercache.Attrs{"url": "https://example.com/\xff"}}
+
+		assert.Error(t, c.Upsert(ctx, invalid, fetchercache.Attrs{"s": "x"}), "invalid UTF-8 can't be encoded")
+	})
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	c, queries := newTestCache(t)
+
+	for i, age := range []time.Duration{0, 30 * time.Minute, 2 * time.Hour, 48 * time.Hour} {
+		require.NoError(t, queries.UpsertCache(ctx, fetcher_cache_v2.UpsertCacheParams{
+			Domain:    "test",
+			Key:       string(rune('a' + i)),
+			Value:     "{}",
+			Timestamp: time.Now().Add(-age).Unix(),
+		}))
+	}
+
+	n, err := c.PruneExpired(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(2), n)
+	}
+
+	n, err = c.Prune(ctx, time.Now().Add(-10*time.Minute))
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), n)
+	}
+
+	c.TTL = 0
+
+	n, err = c.PruneExpired(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), n)
+	}
+}
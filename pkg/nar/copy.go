@@ -0,0 +1,46 @@
+package nar
+
+import (
+	"io"
+)
+
+// Copy reads a single NAR from r, and writes it to w, returning the number of
+// bytes copied. As NARs aren't length-prefixed, it's parsed to know where it
+// ends, which also validates it. r is read exactly up to the end of the NAR,
+// so it can be used on streams containing other data after it.
+func Copy(w io.Writer, r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+
+	nr, err := NewReader(io.TeeReader(cr, w))
+	if err != nil {
+		return cr.n, err
+	}
+	defer nr.Close()
+
+	for {
+		if _, err := nr.Next(); err != nil {
+			if err == io.EOF {
+				return cr.n, nil
+			}
+
+			return cr.n, err
+		}
+
+		if _, err := io.Copy(io.Discard, nr); err != nil {
+			return cr.n, err
+		}
+	}
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,38 @@
+package nar_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopy(t *testing.T) {
+	narContents := genSymlinkNar()
+
+	// data after the NAR is left in the reader.
+	r := bytes.NewReader(append(append([]byte{}, narContents...), "trailing"...))
+
+	var buf bytes.Buffer
+
+	n, err := nar.Copy(&buf, r)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(narContents)), n)
+	assert.Equal(t, narContents, buf.Bytes())
+
+	rest, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("trailing"), rest)
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := nar.Copy(io.Discard, bytes.NewReader(narContents[:len(narContents)-16]))
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := nar.Copy(io.Discard, bytes.NewReader(genInvalidOrderNAR()))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,129 @@
+package ls
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/nar"
+)
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+
+	return n, err
+}
+
+// List reads the NAR from r, and returns its listing.
+// The NAR is read until its end, but r isn't read any further.
+func List(r io.Reader) (*Root, error) {
+	cr := &countingReader{r: r}
+
+	nr, err := nar.NewReader(cr)
+	if err != nil {
+		return nil, err
+	}
+	defer nr.Close()
+
+	root := &Root{Version: 1}
+
+	for {
+		hdr, err := nr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, err
+		}
+
+		node := &Node{
+			Type:       hdr.Type,
+			Size:       hdr.Size,
+			LinkTarget: hdr.LinkTarget,
+			Executable: hdr.Executable,
+		}
+
+		if hdr.Type == nar.TypeRegular {
+			// the reader is at the start of the contents when the header is returned
+			node.NAROffset = cr.n
+		}
+
+		if hdr.Type == nar.TypeDirectory {
+			node.Entries = make(map[string]*Node)
+		}
+
+		if hdr.Path == "/" {
+			root.Root = *node
+
+			continue
+		}
+
+		parent := &root.Root
+
+		for _, name := range strings.Split(strings.TrimPrefix(path.Dir(hdr.Path), "/"), "/") {
+			if name == "" {
+				continue
+			}
+
+			parent = parent.Entries[name]
+			if parent == nil {
+				return nil, fmt.Errorf("missing parent directory of %v", hdr.Path)
+			}
+		}
+
+		parent.Entries[path.Base(hdr.Path)] = node
+	}
+
+	return root, nil
+}
+
+// MarshalJSON renders the listing like Nix does.
+func (r *Root) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"version": r.Version,
+		"root":    &r.Root,
+	})
+}
+
+// MarshalJSON renders the node like Nix does, only with the fields
+// relevant to its type.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	obj := map[string]interface{}{
+		"type": n.Type,
+	}
+
+	switch n.Type {
+	case nar.TypeRegular:
+		obj["size"] = n.Size
+
+		if n.Executable {
+			obj["executable"] = true
+		}
+
+		if n.NAROffset != 0 {
+			obj["narOffset"] = n.NAROffset
+		}
+	case nar.TypeSymlink:
+		obj["target"] = n.LinkTarget
+	case nar.TypeDirectory:
+		entries := n.Entries
+		if entries == nil {
+			entries = map[string]*Node{}
+		}
+
+		obj["entries"] = entries
+	}
+
+	return json.Marshal(obj)
+}
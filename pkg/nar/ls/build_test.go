@@ -0,0 +1,82 @@
+package ls_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/nar/ls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	var buf bytes.Buffer
+
+	nw, err := nar.NewWriter(&buf)
+	require.NoError(t, err)
+
+	for _, f := range []struct {
+		hdr      nar.Header
+		contents string
+	}{
+		{hdr: nar.Header{Path: "/", Type: nar.TypeDirectory}},
+		{hdr: nar.Header{Path: "/bin", Type: nar.TypeDirectory}},
+		{hdr: nar.Header{Path: "/bin/hello", Type: nar.TypeRegular, Size: 5, Executable: true}, contents: "hello"},
+		{hdr: nar.Header{Path: "/empty", Type: nar.TypeDirectory}},
+		{hdr: nar.Header{Path: "/link", Type: nar.TypeSymlink, LinkTarget: "bin/hello"}},
+		{hdr: nar.Header{Path: "/readme", Type: nar.TypeRegular, Size: 0}},
+	} {
+		hdr := f.hdr
+		require.NoError(t, nw.WriteHeader(&hdr))
+
+		_, err := nw.Write([]byte(f.contents))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, nw.Close())
+
+	narBytes := buf.Bytes()
+
+	root, err := ls.List(bytes.NewReader(narBytes))
+	require.NoError(t, err)
+
+	hello := root.Root.Entries["bin"].Entries["hello"]
+	require.NotNil(t, hello)
+	assert.Equal(t, "hello", string(narBytes[hello.NAROffset:hello.NAROffset+hello.Size]))
+
+	b, err := json.Marshal(root)
+	require.NoError(t, err)
+
+	//nolint:lll
+	assert.Equal(t, `{"root":{"entries":{"bin":{"entries":{"hello":{"executable":true,"narOffset":`+
+		`400,"size":5,"type":"regular"}},"type":"directory"},"empty":{"entries":{},"type":"directory"},"link":{"target":"bin/hello","type":"symlink"},"readme":{"narOffset":`+
+		`992,"size":0,"type":"regular"}},"type":"directory"},"version":1}`, string(b))
+
+	parsed, err := ls.ParseLS(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.Equal(t, root, parsed)
+}
+
+func TestListNar(t *testing.T) {
+	f, err := os.Open("../../../test/testdata/nar_1094wph9z4nwlgvsd53abfz8i117ykiv5dwnq9nnhz846s7xqd7d.nar")
+	require.NoError(t, err)
+	defer f.Close()
+
+	root, err := ls.List(f)
+	require.NoError(t, err)
+	assert.Equal(t, nar.TypeDirectory, root.Root.Type)
+
+	// the listing can be parsed back
+	b, err := json.Marshal(root)
+	require.NoError(t, err)
+
+	parsed, err := ls.ParseLS(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.Equal(t, root, parsed)
+
+	_, err = ls.List(bytes.NewReader([]byte("not a nar")))
+	assert.Error(t, err)
+}
@@ -85,6 +85,11 @@ func NewReader(r io.Reader) (*Reader, error) {
 
 		err := narReader.parseNode("/")
 		if err != nil {
+			// the root node wasn't read completely, so any EOF is unexpected.
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
 			narReader.errors <- err
 		} else {
 			narReader.errors <- io.EOF
@@ -106,6 +106,24 @@ func TestReaderSymlink(t *testing.T) {
 
 // TODO: various early close cases
 
+func TestReaderTruncated(t *testing.T) {
+	narContents := genSymlinkNar()
+
+	// cut off the closing token of the root node
+	nr, err := nar.NewReader(bytes.NewBuffer(narContents[:len(narContents)-16]))
+	assert.NoError(t, err)
+
+	_, err = nr.Next()
+	assert.NoError(t, err)
+
+	_, err = nr.Next()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "Next() should return io.ErrUnexpectedEOF")
+
+	assert.NotPanics(t, func() {
+		nr.Close()
+	}, "closing the reader shouldn't panic")
+}
+
 func TestReaderInvalidOrder(t *testing.T) {
 	nr, err := nar.NewReader(bytes.NewBuffer(genInvalidOrderNAR()))
 	assert.NoError(t, err)
@@ -3,6 +3,7 @@ package narinfo
 import (
 	"strconv"
 
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
 	"github.com/nix-community/go-nix/pkg/nixhash"
 	"github.com/nix-community/go-nix/pkg/storepath"
 )
@@ -25,3 +26,8 @@ func (n NarInfo) Fingerprint() string {
 
 	return f[:len(f)-1]
 }
+
+// CheckSignatures evaluates the signatures of the narinfo against policy.
+func (n NarInfo) CheckSignatures(policy *signature.Policy) *signature.Report {
+	return policy.Evaluate(n.Fingerprint(), n.Signatures)
+}
@@ -0,0 +1,20 @@
+package narinfo
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// ServeHTTP writes the narinfo as the response, with its content type.
+// The body is left out for HEAD requests.
+func (n *NarInfo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := n.String()
+
+	w.Header().Set("Content-Type", n.ContentType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(s)))
+
+	if r.Method != http.MethodHead {
+		_, _ = io.WriteString(w, s)
+	}
+}
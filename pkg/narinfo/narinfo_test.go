@@ -3,7 +3,10 @@ package narinfo_test
 import (
 	"bytes"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -249,15 +252,19 @@ func TestNarInfoWithUnknownDeriver(t *testing.T) {
 	ni, err := narinfo.Parse(strings.NewReader(strNarinfoSampleWithUnknownDeriver))
 	assert.NoError(t, err)
 
-	// Test the parsing happy path
-	assert.Equal(t, narinfoSampleWithUnknownDeriver, ni)
+	// Test the parsing happy path, an unknown deriver is the same as none
+	assert.Empty(t, ni.Deriver)
+	assert.Equal(t, []narinfo.Field{{"Deriver", "unknown-deriver"}}, ni.Extra)
 	assert.NoError(t, ni.Check())
 
-	// Test to string
+	// Test to string, the line is kept
+	assert.Equal(t, strNarinfoSampleWithUnknownDeriver, "\n"+ni.String())
+
+	// it's left out of narinfos without it
 	assert.Equal(
 		t,
 		strings.Replace(strNarinfoSampleWithUnknownDeriver, "Deriver: unknown-deriver\n", "", -1),
-		"\n"+ni.String(),
+		"\n"+narinfoSampleWithUnknownDeriver.String(),
 	)
 }
 
@@ -326,8 +333,128 @@ func TestBigNarinfo(t *testing.T) {
 	}
 	defer f.Close()
 
-	_, err = narinfo.Parse(f)
+	b, err := io.ReadAll(f)
+	if err != nil {
+		panic(err)
+	}
+
+	ni, err := narinfo.Parse(bytes.NewReader(b))
 	assert.NoError(t, err, "Parsing big .narinfo files shouldn't fail")
+
+	assert.Equal(t, string(b), ni.String(), "big .narinfo files should round-trip")
+
+	ni, err = narinfo.ParseStrict(bytes.NewReader(b))
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(b), ni.String())
+	}
+}
+
+func TestNarInfoUnknownFields(t *testing.T) {
+	// fields in another order than Nix's, with some it doesn't know about
+	//nolint:lll
+	str := `StorePath: /nix/store/00bgd045z0d4icpbc2yyz4gx48ak44la-net-tools-1.60_p20170221182432
+URL: nar/1094wph9z4nwlgvsd53abfz8i117ykiv5dwnq9nnhz846s7xqd7d.nar.xz
+Compression: xz
+NarHash: sha256:0lxjvvpr59c2mdram7ympy5ay741f180kv3349hvfc3f8nrmbqf6
+NarSize: 464152
+FileHash: sha256:1094wph9z4nwlgvsd53abfz8i117ykiv5dwnq9nnhz846s7xqd7d
+FileSize: 114980
+X-Cache: attic
+References: 7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc-glibc-2.27
+Sig: cache.nixos.org-1:sn5s/RrqEI+YG6/PjwdbPjcAC7rcta7sJU4mFOawGvJBLsWkyLtBrT2EuFt/LJjWkTZ+ZWOI9NTtjo/woMdvAg==
+Deriver: 10dx1q4ivjb115y3h90mipaaz533nr0d-net-tools-1.60_p20170221182432.drv
+X-Uploaded: 2023-01-01
+`
+
+	ni, err := narinfo.Parse(strings.NewReader(str))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []narinfo.Field{{"X-Cache", "attic"}, {"X-Uploaded", "2023-01-01"}}, ni.Extra)
+	assert.Equal(t, str, ni.String())
+
+	t.Run("modified", func(t *testing.T) {
+		ni, err := narinfo.Parse(strings.NewReader(str))
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		// added fields go after the last one with the same key, or at the end
+		ni.Signatures = append(ni.Signatures, _SignaturesNarinfoSample[1])
+		ni.System = "x86_64-linux"
+		ni.Extra = ni.Extra[:1]
+
+		assert.Equal(t, strings.Replace(
+			strings.Replace(str, "X-Uploaded: 2023-01-01\n", "System: x86_64-linux\n", 1),
+			"Deriver:",
+			"Sig: "+_SignaturesNarinfoSample[1].String()+"\nDeriver:",
+			1,
+		), ni.String())
+	})
+
+	t.Run("new", func(t *testing.T) {
+		ni := *narinfoSample
+		ni.Extra = []narinfo.Field{{"X-Cache", "attic"}}
+
+		assert.Equal(t, strNarinfoSample[1:]+"X-Cache: attic\n", ni.String())
+	})
+
+	t.Run("missing compression", func(t *testing.T) {
+		s := strings.Replace(str, "Compression: xz\n", "", 1)
+
+		ni, err := narinfo.Parse(strings.NewReader(s))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "bzip2", ni.Compression)
+			assert.Equal(t, s, ni.String())
+		}
+	})
+}
+
+func TestParseStrict(t *testing.T) {
+	ni, err := narinfo.ParseStrict(strings.NewReader(strNarinfoSample))
+	if assert.NoError(t, err) {
+		assert.Equal(t, narinfoSample, ni)
+	}
+
+	for _, s := range []string{
+		strNarinfoSample + "X-Cache: attic\n",
+		strNarinfoSample + "NarSize: 464152\n",
+		strNarinfoSample + "StorePath: /nix/store/00bgd045z0d4icpbc2yyz4gx48ak44la-foo\n",
+	} {
+		_, err := narinfo.ParseStrict(strings.NewReader(s))
+		assert.Error(t, err)
+
+		// the lenient parser keeps going, and writes back everything
+		ni, err := narinfo.Parse(strings.NewReader(s))
+		if assert.NoError(t, err) {
+			assert.Equal(t, s[1:], ni.String())
+		}
+	}
+
+	// the first value of repeated keys is used
+	ni, err = narinfo.Parse(strings.NewReader(strNarinfoSample +
+		"References: 00bgd045z0d4icpbc2yyz4gx48ak44la-foo\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, narinfoSample.References, ni.References)
+	}
+}
+
+func TestNarInfoServeHTTP(t *testing.T) {
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		rec := httptest.NewRecorder()
+		narinfoSample.ServeHTTP(rec, httptest.NewRequest(method, "/00bgd045z0d4icpbc2yyz4gx48ak44la.narinfo", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/x-nix-narinfo", rec.Header().Get("Content-Type"))
+		assert.Equal(t, strconv.Itoa(len(strNarinfoSample)-1), rec.Header().Get("Content-Length"))
+
+		if method == http.MethodGet {
+			assert.Equal(t, strNarinfoSample[1:], rec.Body.String())
+		} else {
+			assert.Empty(t, rec.Body.String())
+		}
+	}
 }
 
 func BenchmarkNarInfo(b *testing.B) {
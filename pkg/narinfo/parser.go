@@ -13,10 +13,31 @@ import (
 
 // Parse reads a .narinfo file content
 // and returns a NarInfo struct with the parsed data.
+//
+// Unknown fields, like the ones some binary caches add, are kept in Extra,
+// and the order of the fields is remembered, so String returns the same
+// content as long as the NarInfo isn't modified. Extra also keeps the
+// repeated values of keys other than Sig, whose first value is used, and
+// "Deriver: unknown-deriver", which leaves Deriver empty.
 func Parse(r io.Reader) (*NarInfo, error) {
+	return parse(r, false)
+}
+
+// ParseStrict is like Parse, but fails on unknown keys, and on keys other
+// than Sig appearing more than once.
+func ParseStrict(r io.Reader) (*NarInfo, error) {
+	return parse(r, true)
+}
+
+func parse(r io.Reader, strict bool) (*NarInfo, error) {
 	narInfo := &NarInfo{}
 	scanner := bufio.NewScanner(r)
 
+	// the keys in the order they appear in, to write them back in that order
+	var keys []string
+
+	seen := make(map[string]struct{})
+
 	// Increase the buffer size.
 	// Some .narinfo files have a lot of entries in References,
 	// and bufio.Scanner will error bufio.ErrTooLong otherwise.
@@ -38,6 +59,22 @@ func Parse(r io.Reader) (*NarInfo, error) {
 			return nil, err
 		}
 
+		keys = append(keys, k)
+
+		if _, ok := seen[k]; ok && k != "Sig" {
+			if strict {
+				return nil, fmt.Errorf("duplicate key %v", k)
+			}
+
+			// keep the first value, and the others as they are, so they're
+			// written back
+			narInfo.Extra = append(narInfo.Extra, Field{Key: k, Value: v})
+
+			continue
+		}
+
+		seen[k] = struct{}{}
+
 		switch k {
 		case "StorePath":
 			narInfo.StorePath = v
@@ -72,7 +109,10 @@ func Parse(r io.Reader) (*NarInfo, error) {
 
 			narInfo.References = append(narInfo.References, strings.Split(v, " ")...)
 		case "Deriver":
-			if v != "unknown-deriver" {
+			// an unknown deriver is the same as none, but is written back
+			if v == "unknown-deriver" {
+				narInfo.Extra = append(narInfo.Extra, Field{Key: k, Value: v})
+			} else {
 				narInfo.Deriver = v
 			}
 		case "System":
@@ -80,14 +120,18 @@ func Parse(r io.Reader) (*NarInfo, error) {
 		case "Sig":
 			signature, e := signature.ParseSignature(v)
 			if e != nil {
-				return nil, fmt.Errorf("unable to parse signature line %v: %v", v, err)
+				return nil, fmt.Errorf("unable to parse signature line %v: %w", v, e)
 			}
 
 			narInfo.Signatures = append(narInfo.Signatures, signature)
 		case "CA":
 			narInfo.CA = v
 		default:
-			return nil, fmt.Errorf("unknown key %v", k)
+			if strict {
+				return nil, fmt.Errorf("unknown key %v", k)
+			}
+
+			narInfo.Extra = append(narInfo.Extra, Field{Key: k, Value: v})
 		}
 
 		if err != nil {
@@ -104,9 +148,28 @@ func Parse(r io.Reader) (*NarInfo, error) {
 		narInfo.Compression = "bzip2"
 	}
 
+	// only remember the order if String wouldn't write the same one anyway
+	if !equalKeys(keys, narInfo.fields()) {
+		narInfo.layout = keys
+	}
+
 	return narInfo, nil
 }
 
+func equalKeys(keys []string, fields []Field) bool {
+	if len(keys) != len(fields) {
+		return false
+	}
+
+	for i, f := range fields {
+		if keys[i] != f.Key {
+			return false
+		}
+	}
+
+	return true
+}
+
 // splitOnce - Split a string and make sure it's only splittable once.
 func splitOnce(s string, sep string) (string, string, error) {
 	idx := strings.Index(s, sep)
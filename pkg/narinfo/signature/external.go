@@ -0,0 +1,115 @@
+package signature
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// socketTimeout bounds how long signing over a Unix socket can take.
+const socketTimeout = 30 * time.Second
+
+// ExternalSigner is a crypto.Signer for an ed25519 key held by another
+// process, like a signing service, or an agent in front of a hardware token.
+// Only the public key is known locally.
+//
+// Wrap it with NewCryptoSigner to sign narinfos and realisations, which also
+// verifies the signatures returned.
+type ExternalSigner struct {
+	pub  PublicKey
+	sign func(message []byte) (string, error)
+}
+
+var _ crypto.Signer = &ExternalSigner{}
+
+// NewExternalSigner returns an ExternalSigner for pub, calling sign with the
+// message to sign. sign returns the signature in the usual
+// <keyname>:<base64-signature-data> format, with an optional trailing
+// newline.
+func NewExternalSigner(pub PublicKey, sign func(message []byte) (string, error)) *ExternalSigner {
+	return &ExternalSigner{pub: pub, sign: sign}
+}
+
+// NewCommandSigner returns an ExternalSigner for pub, running the command
+// name with args for each signature. The command reads the message on stdin,
+// and prints the signature on stdout.
+func NewCommandSigner(pub PublicKey, name string, args ...string) *ExternalSigner {
+	return NewExternalSigner(pub, func(message []byte) (string, error) {
+		var stdout, stderr bytes.Buffer
+
+		cmd := exec.Command(name, args...) //nolint:gosec
+		cmd.Stdin = bytes.NewReader(message)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("%v failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+		}
+
+		return stdout.String(), nil
+	})
+}
+
+// NewSocketSigner returns an ExternalSigner for pub, connecting to the Unix
+// socket at path for each signature. The message is sent followed by a
+// newline, and the signature is read back up to a newline or the end of the
+// connection.
+func NewSocketSigner(pub PublicKey, path string) *ExternalSigner {
+	return NewExternalSigner(pub, func(message []byte) (string, error) {
+		conn, err := net.DialTimeout("unix", path, socketTimeout)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+
+		if err := conn.SetDeadline(time.Now().Add(socketTimeout)); err != nil {
+			return "", err
+		}
+
+		if _, err := conn.Write(append(message, '\n')); err != nil {
+			return "", err
+		}
+
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil && (err != io.EOF || reply == "") {
+			return "", fmt.Errorf("unable to read signature from %v: %w", path, err)
+		}
+
+		return reply, nil
+	})
+}
+
+// Public implements crypto.Signer.
+func (s *ExternalSigner) Public() crypto.PublicKey {
+	return s.pub.Data
+}
+
+// Sign implements crypto.Signer. Like ed25519, it signs the message itself,
+// opts needs to be crypto.Hash(0).
+func (s *ExternalSigner) Sign(_ io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.Hash(0) {
+		return nil, fmt.Errorf("ed25519 signers can't sign pre-hashed messages")
+	}
+
+	reply, err := s.sign(message)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := ParseSignature(strings.TrimSpace(reply))
+	if err != nil {
+		return nil, err
+	}
+
+	if sig.Name != s.pub.Name {
+		return nil, fmt.Errorf("got a signature by %v, expected %v", sig.Name, s.pub.Name)
+	}
+
+	return sig.Data, nil
+}
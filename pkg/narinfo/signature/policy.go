@@ -0,0 +1,336 @@
+package signature
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status is the outcome of checking a signature against a Policy.
+type Status int
+
+const (
+	// StatusValid is for signatures by a trusted key, in its validity window.
+	StatusValid Status = iota
+	// StatusInvalid is for signatures that don't verify with the trusted keys
+	// of the same name.
+	StatusInvalid
+	// StatusUntrusted is for signatures by keys the policy doesn't know.
+	StatusUntrusted
+	// StatusExpired is for signatures by a trusted key, outside of its
+	// validity window.
+	StatusExpired
+	// StatusRevoked is for signatures by a revoked key.
+	StatusRevoked
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusValid:
+		return "valid"
+	case StatusInvalid:
+		return "invalid"
+	case StatusUntrusted:
+		return "untrusted"
+	case StatusExpired:
+		return "expired"
+	case StatusRevoked:
+		return "revoked"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// TrustedKey is a public key trusted by a Policy, possibly for a limited
+// time only, to rotate keys.
+type TrustedKey struct {
+	PublicKey
+	// NotBefore is when the key becomes valid. Zero means always.
+	NotBefore time.Time
+	// NotAfter is when the key stops being valid. Zero means never.
+	NotAfter time.Time
+}
+
+// validAt returns true if t is in the validity window of the key.
+func (k *TrustedKey) validAt(t time.Time) bool {
+	return (k.NotBefore.IsZero() || !t.Before(k.NotBefore)) &&
+		(k.NotAfter.IsZero() || t.Before(k.NotAfter))
+}
+
+// Policy decides whether signed objects, like narinfos and realisations,
+// are trusted. Unlike VerifyFirst, it can require several signatures, limit
+// keys to a validity window, and reject revoked keys.
+type Policy struct {
+	// Keys are the trusted keys. Several keys can have the same name.
+	Keys []TrustedKey
+	// Revoked are keys whose signatures are never accepted, even if they
+	// are also in Keys.
+	Revoked []PublicKey
+	// Threshold is the number of distinct trusted keys that need to have
+	// signed. Values below 1 are treated as 1.
+	Threshold int
+}
+
+// NewPolicy returns a Policy trusting pubKeys, requiring a single signature,
+// like Nix does with trusted-public-keys.
+func NewPolicy(pubKeys []PublicKey) *Policy {
+	p := &Policy{Threshold: 1}
+
+	for _, pk := range pubKeys {
+		p.Keys = append(p.Keys, TrustedKey{PublicKey: pk})
+	}
+
+	return p
+}
+
+// Result is the outcome of checking one signature.
+type Result struct {
+	Signature Signature
+	Status    Status
+}
+
+// Report describes which signatures of an object were accepted by a Policy.
+type Report struct {
+	// Results has the outcome for each signature, in order.
+	Results []Result
+	// ValidKeys is the number of distinct trusted keys with a valid signature.
+	ValidKeys int
+	// Threshold is the number of keys the policy required.
+	Threshold int
+}
+
+// Trusted returns true if enough trusted keys signed.
+func (r *Report) Trusted() bool {
+	return r.ValidKeys >= r.Threshold
+}
+
+// Signatures returns the signatures with the given status.
+func (r *Report) Signatures(status Status) []Signature {
+	var sigs []Signature
+
+	for _, res := range r.Results {
+		if res.Status == status {
+			sigs = append(sigs, res.Signature)
+		}
+	}
+
+	return sigs
+}
+
+// Err returns nil if the object is trusted, and an error describing why
+// not otherwise.
+func (r *Report) Err() error {
+	if r.Trusted() {
+		return nil
+	}
+
+	return fmt.Errorf("signed by %d of the %d required trusted keys", r.ValidKeys, r.Threshold)
+}
+
+// Evaluate checks the signatures of the object with the given fingerprint,
+// at the current time.
+func (p *Policy) Evaluate(fingerprint string, sigs []Signature) *Report {
+	return p.EvaluateAt(fingerprint, sigs, time.Now())
+}
+
+// EvaluateAt checks the signatures of the object with the given
+// fingerprint, with the validity windows of the keys checked at t.
+func (p *Policy) EvaluateAt(fingerprint string, sigs []Signature, t time.Time) *Report {
+	r := &Report{
+		Results:   make([]Result, len(sigs)),
+		Threshold: p.Threshold,
+	}
+
+	if r.Threshold < 1 {
+		r.Threshold = 1
+	}
+
+	validKeys := make(map[string]struct{})
+
+	for i, sig := range sigs {
+		status, key := p.check(fingerprint, sig, t)
+		r.Results[i] = Result{Signature: sig, Status: status}
+
+		if status == StatusValid {
+			validKeys[key.String()] = struct{}{}
+		}
+	}
+
+	r.ValidKeys = len(validKeys)
+
+	return r
+}
+
+// check returns the status of sig, and the key that verified it.
+func (p *Policy) check(fingerprint string, sig Signature, t time.Time) (Status, *TrustedKey) {
+	for _, pk := range p.Revoked {
+		if pk.Verify(fingerprint, sig) {
+			return StatusRevoked, nil
+		}
+	}
+
+	status := StatusUntrusted
+
+	for i := range p.Keys {
+		key := &p.Keys[i]
+
+		if key.Name != sig.Name {
+			continue
+		}
+
+		if !key.Verify(fingerprint, sig) {
+			// keep looking, another key with the same name might verify it
+			if status == StatusUntrusted {
+				status = StatusInvalid
+			}
+
+			continue
+		}
+
+		if key.validAt(t) {
+			return StatusValid, key
+		}
+
+		status = StatusExpired
+	}
+
+	return status, nil
+}
+
+// ParseTrustedPublicKeys parses a whitespace-separated list of public keys,
+// like the trusted-public-keys setting of Nix.
+func ParseTrustedPublicKeys(s string) ([]PublicKey, error) {
+	fields := strings.Fields(s)
+	pubKeys := make([]PublicKey, 0, len(fields))
+
+	for _, f := range fields {
+		pk, err := ParsePublicKey(f)
+		if err != nil {
+			return nil, err
+		}
+
+		pubKeys = append(pubKeys, pk)
+	}
+
+	return pubKeys, nil
+}
+
+// ParsePolicy reads a policy from a file in the format of nix.conf:
+//
+//	# keys are trusted in addition to each other
+//	trusted-public-keys = cache.example.org-1:… cache.example.org-2:…
+//	revoked-public-keys = cache.example.org-0:…
+//	# the number of distinct keys that need to have signed, 1 by default
+//	required-signatures = 2
+//	# the validity window of the keys with a name, in RFC 3339 or as a
+//	# date, with - for no bound
+//	key-validity = cache.example.org-1 - 2024-06-01
+//	key-validity = cache.example.org-2 2024-05-01T00:00:00Z -
+//
+// Settings can be repeated, lists are appended to each other.
+func ParsePolicy(r io.Reader) (*Policy, error) {
+	p := &Policy{Threshold: 1}
+	scanner := bufio.NewScanner(r)
+
+	type window struct{ notBefore, notAfter time.Time }
+
+	windows := make(map[string]window)
+
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid line in policy: %v", line)
+		}
+
+		k = strings.TrimSpace(k)
+		v = strings.TrimSpace(v)
+
+		switch k {
+		case "trusted-public-keys":
+			pubKeys, err := ParseTrustedPublicKeys(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %v: %w", k, err)
+			}
+
+			p.Keys = append(p.Keys, NewPolicy(pubKeys).Keys...)
+		case "revoked-public-keys":
+			pubKeys, err := ParseTrustedPublicKeys(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %v: %w", k, err)
+			}
+
+			p.Revoked = append(p.Revoked, pubKeys...)
+		case "required-signatures":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid %v: %v", k, v)
+			}
+
+			p.Threshold = n
+		case "key-validity":
+			fields := strings.Fields(v)
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("invalid %v, expected a key name and two times: %v", k, v)
+			}
+
+			notBefore, err := parsePolicyTime(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %v: %w", k, err)
+			}
+
+			notAfter, err := parsePolicyTime(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %v: %w", k, err)
+			}
+
+			windows[fields[0]] = window{notBefore, notAfter}
+		default:
+			return nil, fmt.Errorf("unknown setting %v in policy", k)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for name, w := range windows {
+		found := false
+
+		for i := range p.Keys {
+			if p.Keys[i].Name == name {
+				p.Keys[i].NotBefore = w.notBefore
+				p.Keys[i].NotAfter = w.notAfter
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("key-validity for %v, which isn't a trusted key", name)
+		}
+	}
+
+	return p, nil
+}
+
+// parsePolicyTime parses a time in RFC 3339 or as a date, or "-" for none.
+func parsePolicyTime(s string) (time.Time, error) {
+	if s == "-" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse("2006-01-02", s)
+}
@@ -0,0 +1,183 @@
+package signature_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newKeypair returns a keypair generated from a fixed seed.
+func newKeypair(t *testing.T, name string, seed byte) (signature.SecretKey, signature.PublicKey) {
+	t.Helper()
+
+	sk, pk, err := signature.GenerateKeypair(name, strings.NewReader(strings.Repeat(string(rune('a'+seed)), 32)))
+	require.NoError(t, err)
+
+	return sk, pk
+}
+
+func sign(t *testing.T, sk signature.SecretKey, fingerprint string) signature.Signature {
+	t.Helper()
+
+	sig, err := sk.Sign(nil, fingerprint)
+	require.NoError(t, err)
+
+	return sig
+}
+
+func TestPolicy(t *testing.T) {
+	//nolint:lll
+	const fingerprint = "1;/nix/store/syd87l2rxw8cbsxmxl853h0r6pdwhwjr-curl-7.82.0-bin;sha256:1b4sb93wp679q4zx9k1ignby1yna3z7c4c2ri3wphylbc2dwsys0;196040;"
+
+	sk1, pk1 := newKeypair(t, "cache-1", 1)
+	sk2, pk2 := newKeypair(t, "cache-2", 2)
+	skOld, pkOld := newKeypair(t, "cache-0", 0)
+	skOther, _ := newKeypair(t, "other-1", 3)
+	skFake, _ := newKeypair(t, "cache-1", 4)
+
+	sig1 := sign(t, sk1, fingerprint)
+	sig2 := sign(t, sk2, fingerprint)
+	sigOld := sign(t, skOld, fingerprint)
+	sigOther := sign(t, skOther, fingerprint)
+	sigFake := sign(t, skFake, fingerprint)
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("single key", func(t *testing.T) {
+		p := signature.NewPolicy([]signature.PublicKey{pk1})
+
+		r := p.EvaluateAt(fingerprint, []signature.Signature{sigOther, sigFake, sig1}, now)
+		assert.True(t, r.Trusted())
+		assert.NoError(t, r.Err())
+		assert.Equal(t, []signature.Result{
+			{Signature: sigOther, Status: signature.StatusUntrusted},
+			{Signature: sigFake, Status: signature.StatusInvalid},
+			{Signature: sig1, Status: signature.StatusValid},
+		}, r.Results)
+		assert.Equal(t, []signature.Signature{sig1}, r.Signatures(signature.StatusValid))
+
+		r = p.EvaluateAt(fingerprint, []signature.Signature{sigFake}, now)
+		assert.False(t, r.Trusted())
+		assert.EqualError(t, r.Err(), "signed by 0 of the 1 required trusted keys")
+
+		r = p.EvaluateAt(fingerprint, nil, now)
+		assert.False(t, r.Trusted())
+	})
+
+	t.Run("threshold", func(t *testing.T) {
+		p := signature.NewPolicy([]signature.PublicKey{pk1, pk2})
+		p.Threshold = 2
+
+		r := p.EvaluateAt(fingerprint, []signature.Signature{sig1, sig1}, now)
+		assert.False(t, r.Trusted(), "the same key only counts once")
+		assert.Equal(t, 1, r.ValidKeys)
+
+		r = p.EvaluateAt(fingerprint, []signature.Signature{sig2, sigOther, sig1}, now)
+		assert.True(t, r.Trusted())
+		assert.Equal(t, 2, r.ValidKeys)
+	})
+
+	t.Run("validity", func(t *testing.T) {
+		p := &signature.Policy{Keys: []signature.TrustedKey{
+			{PublicKey: pkOld, NotAfter: now},
+			{PublicKey: pk1, NotBefore: now.Add(-time.Hour)},
+		}}
+
+		sigs := []signature.Signature{sigOld, sig1}
+
+		r := p.EvaluateAt(fingerprint, sigs, now.Add(-2*time.Hour))
+		assert.Equal(t, []signature.Signature{sigOld}, r.Signatures(signature.StatusValid))
+		assert.Equal(t, []signature.Signature{sig1}, r.Signatures(signature.StatusExpired))
+
+		r = p.EvaluateAt(fingerprint, sigs, now)
+		assert.Equal(t, []signature.Signature{sig1}, r.Signatures(signature.StatusValid))
+		assert.Equal(t, []signature.Signature{sigOld}, r.Signatures(signature.StatusExpired))
+		assert.True(t, r.Trusted())
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		p := signature.NewPolicy([]signature.PublicKey{pk1, pkOld})
+		p.Revoked = []signature.PublicKey{pkOld}
+
+		r := p.EvaluateAt(fingerprint, []signature.Signature{sigOld}, now)
+		assert.Equal(t, signature.StatusRevoked, r.Results[0].Status)
+		assert.False(t, r.Trusted())
+	})
+
+	t.Run("narinfo", func(t *testing.T) {
+		//nolint:lll
+		ni, err := narinfo.Parse(strings.NewReader(`StorePath: /nix/store/syd87l2rxw8cbsxmxl853h0r6pdwhwjr-curl-7.82.0-bin
+URL: nar/05ra3y72i3qjri7xskf9qj8kb29r6naqy1sqpbs3azi3xcigmj56.nar.xz
+Compression: xz
+NarHash: sha256:1b4sb93wp679q4zx9k1ignby1yna3z7c4c2ri3wphylbc2dwsys0
+NarSize: 196040
+References: 0jqd0rlxzra1rs38rdxl43yh6rxchgc6-curl-7.82.0 6w8g7njm4mck5dmjxws0z1xnrxvl81xa-glibc-2.34-115 j5jxw3iy7bbz4a57fh9g2xm2gxmyal8h-zlib-1.2.12 yxvjs9drzsphm9pcf42a4byzj1kb9m7k-openssl-1.1.1n
+Sig: cache.nixos.org-1:TsTTb3WGTZKphvYdBHXwo6weVILmTytUjLB+vcX89fOjjRicCHmKA4RCPMVLkj6TMJ4GMX3HPVWRdD1hkeKZBQ==
+Sig: test1:519iiVLx/c4Rdt5DNt6Y2Jm6hcWE9+XY69ygiWSZCNGVcmOcyL64uVAJ3cV8vaTusIZdbTnYo9Y7vDNeTmmMBQ==
+`))
+		require.NoError(t, err)
+
+		pk, err := signature.ParsePublicKey(nixosPublicKey)
+		require.NoError(t, err)
+
+		r := ni.CheckSignatures(signature.NewPolicy([]signature.PublicKey{pk}))
+		assert.True(t, r.Trusted())
+		assert.Equal(t, signature.StatusValid, r.Results[0].Status)
+		assert.Equal(t, signature.StatusUntrusted, r.Results[1].Status)
+	})
+}
+
+func TestParsePolicy(t *testing.T) {
+	_, pk1 := newKeypair(t, "cache-1", 1)
+	_, pk2 := newKeypair(t, "cache-2", 2)
+	_, pkOld := newKeypair(t, "cache-0", 0)
+
+	p, err := signature.ParsePolicy(strings.NewReader(`
+# current keys
+trusted-public-keys = ` + pk1.String() + `
+trusted-public-keys =  ` + pk2.String() + `   # added later
+revoked-public-keys = ` + pkOld.String() + `
+required-signatures = 2
+key-validity = cache-1 - 2024-06-01
+key-validity = cache-2 2024-05-01T12:00:00Z -
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, &signature.Policy{
+		Keys: []signature.TrustedKey{
+			{PublicKey: pk1, NotAfter: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+			{PublicKey: pk2, NotBefore: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		},
+		Revoked:   []signature.PublicKey{pkOld},
+		Threshold: 2,
+	}, p)
+
+	for _, s := range []string{
+		"trusted-public-keys",
+		"trusted-public-keys = foo",
+		"required-signatures = 0",
+		"key-validity = cache-1 - ",
+		"key-validity = cache-1 - yesterday",
+		"trusted-public-keys = " + pk1.String() + "\nkey-validity = cache-2 - -",
+		"substituters = https://cache.nixos.org",
+	} {
+		_, err := signature.ParsePolicy(strings.NewReader(s))
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseTrustedPublicKeys(t *testing.T) {
+	pubKeys, err := signature.ParseTrustedPublicKeys(" " + nixosPublicKey + "\t" + test1PublicKey + "\n")
+	if assert.NoError(t, err) {
+		assert.Len(t, pubKeys, 2)
+		assert.Equal(t, test1PublicKey, pubKeys[1].String())
+	}
+
+	_, err = signature.ParseTrustedPublicKeys(nixosPublicKey + " foo")
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+)
+
+// Signer signs the fingerprints of narinfos and realisations.
+//
+// SecretKey implements it with a private key in memory. CryptoSigner
+// implements it on top of any crypto.Signer holding an ed25519 key, like an
+// ExternalSigner, so the private key can live elsewhere.
+//
+// Signer isn't a crypto.Signer itself: SecretKey.Sign already takes the
+// fingerprint as a string and returns a named Signature, and a type can't
+// have both methods. Keys behind a crypto.Signer are wrapped with
+// NewCryptoSigner instead.
+type Signer interface {
+	// Sign returns the signature of fingerprint.
+	// If rand is nil, the signer picks its source of randomness.
+	Sign(rand io.Reader, fingerprint string) (Signature, error)
+	// ToPublicKey returns the public key verifying the signatures.
+	ToPublicKey() PublicKey
+}
+
+var (
+	_ Signer = SecretKey{}
+	_ Signer = &CryptoSigner{}
+)
+
+// CryptoSigner is a Signer using a crypto.Signer holding an ed25519 key.
+type CryptoSigner struct {
+	name   string
+	signer crypto.Signer
+	pub    ed25519.PublicKey
+}
+
+// NewCryptoSigner returns a Signer producing signatures named name with
+// signer, whose public key needs to be an ed25519 one.
+func NewCryptoSigner(name string, signer crypto.Signer) (*CryptoSigner, error) {
+	if name == "" {
+		return nil, fmt.Errorf("key name is missing")
+	}
+
+	pub, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %T, only ed25519 keys are supported", signer.Public())
+	}
+
+	return &CryptoSigner{name: name, signer: signer, pub: pub}, nil
+}
+
+// Sign implements Signer. The signature is checked against the public key,
+// so a misbehaving crypto.Signer, like an external one, can't produce invalid
+// signatures.
+func (s *CryptoSigner) Sign(rand io.Reader, fingerprint string) (Signature, error) {
+	// passing crypto.Hash(0) as ed25519 doesn't support pre-hashed messages
+	data, err := s.signer.Sign(rand, []byte(fingerprint), crypto.Hash(0))
+	if err != nil {
+		return Signature{}, fmt.Errorf("unable to sign with %v: %w", s.name, err)
+	}
+
+	sig := Signature{s.name, data}
+
+	if len(data) != ed25519.SignatureSize || !s.ToPublicKey().Verify(fingerprint, sig) {
+		return Signature{}, fmt.Errorf("signer for %v returned an invalid signature", s.name)
+	}
+
+	return sig, nil
+}
+
+// ToPublicKey implements Signer.
+func (s *CryptoSigner) ToPublicKey() PublicKey {
+	return PublicKey{s.name, s.pub}
+}
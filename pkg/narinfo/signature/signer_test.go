@@ -0,0 +1,234 @@
+package signature_test
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:lll
+const (
+	curlFingerprint = "1;/nix/store/syd87l2rxw8cbsxmxl853h0r6pdwhwjr-curl-7.82.0-bin;sha256:1b4sb93wp679q4zx9k1ignby1yna3z7c4c2ri3wphylbc2dwsys0;196040;/nix/store/0jqd0rlxzra1rs38rdxl43yh6rxchgc6-curl-7.82.0,/nix/store/6w8g7njm4mck5dmjxws0z1xnrxvl81xa-glibc-2.34-115,/nix/store/j5jxw3iy7bbz4a57fh9g2xm2gxmyal8h-zlib-1.2.12,/nix/store/yxvjs9drzsphm9pcf42a4byzj1kb9m7k-openssl-1.1.1n"
+	curlSignature   = "test1:519iiVLx/c4Rdt5DNt6Y2Jm6hcWE9+XY69ygiWSZCNGVcmOcyL64uVAJ3cV8vaTusIZdbTnYo9Y7vDNeTmmMBQ=="
+)
+
+// TestHelperProcess isn't a real test, it's used as the signing command by
+// TestCommandSigner. It signs stdin with test1, or another key if asked to.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	key := test1SecretKey
+	if os.Getenv("HELPER_WRONG_KEY") == "1" {
+		sk, _ := newKeypair(t, "test1", 1)
+		key = sk.String()
+	}
+
+	if err := signStream(key, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+// signStream reads a message from r, and writes its signature with key to w.
+func signStream(key string, r io.Reader, w io.Writer) error {
+	sk, err := signature.LoadSecretKey(key)
+	if err != nil {
+		return err
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	sig, err := sk.Sign(nil, string(b))
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, sig.String())
+
+	return err
+}
+
+func TestSecretKeySigner(t *testing.T) {
+	sk, err := signature.LoadSecretKey(test1SecretKey)
+	require.NoError(t, err)
+
+	var signer signature.Signer = sk
+
+	sig, err := signer.Sign(nil, curlFingerprint)
+	if assert.NoError(t, err) {
+		assert.Equal(t, curlSignature, sig.String())
+	}
+
+	assert.Equal(t, test1PublicKey, signer.ToPublicKey().String())
+}
+
+func TestCryptoSigner(t *testing.T) {
+	pub, err := signature.ParsePublicKey(test1PublicKey)
+	require.NoError(t, err)
+
+	t.Run("external", func(t *testing.T) {
+		var messages []string
+
+		signer, err := signature.NewCryptoSigner("test1", signature.NewExternalSigner(pub, func(m []byte) (string, error) {
+			messages = append(messages, string(m))
+
+			return curlSignature + "\n", nil
+		}))
+		require.NoError(t, err)
+
+		assert.Equal(t, test1PublicKey, signer.ToPublicKey().String())
+
+		sig, err := signer.Sign(nil, curlFingerprint)
+		if assert.NoError(t, err) {
+			assert.Equal(t, curlSignature, sig.String())
+		}
+
+		assert.Equal(t, []string{curlFingerprint}, messages)
+
+		// the signature doesn't match the fingerprint
+		_, err = signer.Sign(nil, curlFingerprint+"foo")
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong name", func(t *testing.T) {
+		signer, err := signature.NewCryptoSigner("test1", signature.NewExternalSigner(pub, func([]byte) (string, error) {
+			return "test2" + curlSignature[len("test1"):], nil
+		}))
+		require.NoError(t, err)
+
+		_, err = signer.Sign(nil, curlFingerprint)
+		assert.Error(t, err)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		e := signature.NewExternalSigner(pub, func([]byte) (string, error) {
+			return "", fmt.Errorf("token unplugged")
+		})
+
+		signer, err := signature.NewCryptoSigner("test1", e)
+		require.NoError(t, err)
+
+		_, err = signer.Sign(nil, curlFingerprint)
+		assert.ErrorContains(t, err, "token unplugged")
+
+		_, err = e.Sign(nil, []byte(curlFingerprint), crypto.SHA256)
+		assert.Error(t, err, "pre-hashed messages aren't supported")
+
+		_, err = signature.NewCryptoSigner("", e)
+		assert.Error(t, err)
+	})
+
+	t.Run("non-ed25519", func(t *testing.T) {
+		_, err := signature.NewCryptoSigner("test1", fakeCryptoSigner{})
+		assert.Error(t, err)
+	})
+}
+
+// fakeCryptoSigner is a crypto.Signer with an unsupported public key type.
+type fakeCryptoSigner struct{}
+
+func (fakeCryptoSigner) Public() crypto.PublicKey {
+	return sha256.New()
+}
+
+func (fakeCryptoSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, nil
+}
+
+func TestCommandSigner(t *testing.T) {
+	pub, err := signature.ParsePublicKey(test1PublicKey)
+	require.NoError(t, err)
+
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+
+	signer, err := signature.NewCryptoSigner("test1",
+		signature.NewCommandSigner(pub, os.Args[0], "-test.run=TestHelperProcess"))
+	require.NoError(t, err)
+
+	sig, err := signer.Sign(nil, curlFingerprint)
+	if assert.NoError(t, err) {
+		assert.Equal(t, curlSignature, sig.String())
+	}
+
+	t.Run("wrong key", func(t *testing.T) {
+		t.Setenv("HELPER_WRONG_KEY", "1")
+
+		_, err := signer.Sign(nil, curlFingerprint)
+		assert.Error(t, err)
+	})
+
+	t.Run("failing command", func(t *testing.T) {
+		signer, err := signature.NewCryptoSigner("test1",
+			signature.NewCommandSigner(pub, filepath.Join(t.TempDir(), "missing")))
+		require.NoError(t, err)
+
+		_, err = signer.Sign(nil, curlFingerprint)
+		assert.Error(t, err)
+	})
+}
+
+func TestSocketSigner(t *testing.T) {
+	pub, err := signature.ParsePublicKey(test1PublicKey)
+	require.NoError(t, err)
+
+	socketPath := filepath.Join(t.TempDir(), "signer.sock")
+
+	l, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	defer l.Close()
+
+	// serve signs one line per connection
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err == nil {
+				_ = signStream(test1SecretKey, strings.NewReader(line[:len(line)-1]), conn)
+			}
+
+			conn.Close()
+		}
+	}()
+
+	signer, err := signature.NewCryptoSigner("test1", signature.NewSocketSigner(pub, socketPath))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		sig, err := signer.Sign(nil, curlFingerprint)
+		if assert.NoError(t, err) {
+			assert.Equal(t, curlSignature, sig.String())
+		}
+	}
+
+	t.Run("no server", func(t *testing.T) {
+		signer, err := signature.NewCryptoSigner("test1",
+			signature.NewSocketSigner(pub, filepath.Join(t.TempDir(), "missing.sock")))
+		require.NoError(t, err)
+
+		_, err = signer.Sign(nil, curlFingerprint)
+		assert.Error(t, err)
+	})
+}
@@ -2,7 +2,8 @@ package narinfo
 
 import (
 	"bytes"
-	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/nix-community/go-nix/pkg/narinfo/signature"
 	"github.com/nix-community/go-nix/pkg/nixhash"
@@ -39,56 +40,146 @@ type NarInfo struct {
 
 	// TODO: Figure out the meaning of this
 	CA string
+
+	// Extra holds the fields Parse doesn't store elsewhere, like unknown
+	// keys, so String can write them back.
+	Extra []Field
+
+	// layout is the order of the keys of the parsed file, if String
+	// wouldn't write them in the same order.
+	layout []string
 }
 
-func (n *NarInfo) String() string {
-	var buf bytes.Buffer
+// Field is a key and value pair of a .narinfo file.
+type Field struct {
+	Key   string
+	Value string
+}
 
-	fmt.Fprintf(&buf, "StorePath: %v\n", n.StorePath)
-	fmt.Fprintf(&buf, "URL: %v\n", n.URL)
-	fmt.Fprintf(&buf, "Compression: %v\n", n.Compression)
+// fields returns the fields of the narinfo, in the order Nix writes them,
+// followed by Extra.
+func (n *NarInfo) fields() []Field {
+	fields := make([]Field, 0, 12+len(n.Signatures)+len(n.Extra))
 
-	if n.FileHash != nil && n.FileSize != 0 {
-		fmt.Fprintf(&buf, "FileHash: %s\n", n.FileHash.String())
-		fmt.Fprintf(&buf, "FileSize: %d\n", n.FileSize)
+	add := func(k string, v string) {
+		fields = append(fields, Field{Key: k, Value: v})
 	}
 
-	fmt.Fprintf(&buf, "NarHash: %s\n", n.NarHash.String())
-
-	fmt.Fprintf(&buf, "NarSize: %d\n", n.NarSize)
+	add("StorePath", n.StorePath)
+	add("URL", n.URL)
+	add("Compression", n.Compression)
 
-	buf.WriteString("References:")
+	if n.FileHash != nil && n.FileSize != 0 {
+		add("FileHash", n.FileHash.String())
+		add("FileSize", strconv.FormatUint(n.FileSize, 10))
+	}
 
-	if len(n.References) == 0 {
-		buf.WriteByte(' ')
-	} else {
-		for _, r := range n.References {
-			buf.WriteByte(' ')
-			buf.WriteString(r)
-		}
+	if n.NarHash != nil {
+		add("NarHash", n.NarHash.String())
 	}
 
-	buf.WriteByte('\n')
+	add("NarSize", strconv.FormatUint(n.NarSize, 10))
+	add("References", strings.Join(n.References, " "))
 
 	if n.Deriver != "" {
-		fmt.Fprintf(&buf, "Deriver: %v\n", n.Deriver)
+		add("Deriver", n.Deriver)
 	}
 
 	if n.System != "" {
-		fmt.Fprintf(&buf, "System: %v\n", n.System)
+		add("System", n.System)
 	}
 
 	for _, s := range n.Signatures {
-		fmt.Fprintf(&buf, "Sig: %v\n", s)
+		add("Sig", s.String())
 	}
 
 	if n.CA != "" {
-		fmt.Fprintf(&buf, "CA: %v\n", n.CA)
+		add("CA", n.CA)
+	}
+
+	return append(fields, n.Extra...)
+}
+
+// String returns the .narinfo file content. Parsed narinfos keep the order
+// of their fields, other fields are written in the order Nix uses, with
+// Extra at the end.
+func (n *NarInfo) String() string {
+	fields := n.fields()
+
+	if n.layout != nil {
+		fields = n.applyLayout(fields)
+	}
+
+	var buf bytes.Buffer
+
+	for _, f := range fields {
+		buf.WriteString(f.Key)
+		buf.WriteString(": ")
+		buf.WriteString(f.Value)
+		buf.WriteByte('\n')
 	}
 
 	return buf.String()
 }
 
+// applyLayout orders fields like the keys in layout. Fields not in layout,
+// like signatures added after parsing, go after the last field with the
+// same key, or at the end.
+func (n *NarInfo) applyLayout(fields []Field) []Field {
+	byKey := make(map[string][]Field)
+	keys := make([]string, 0, len(fields))
+
+	for _, f := range fields {
+		if _, ok := byKey[f.Key]; !ok {
+			keys = append(keys, f.Key)
+		}
+
+		byKey[f.Key] = append(byKey[f.Key], f)
+	}
+
+	ordered := make([]Field, 0, len(fields))
+
+	// the number of fields written, and where the last one went, by key
+	written := make(map[string]int)
+	last := make(map[string]int)
+
+	for _, k := range n.layout {
+		if written[k] == len(byKey[k]) {
+			continue
+		}
+
+		ordered = append(ordered, byKey[k][written[k]])
+		written[k]++
+		last[k] = len(ordered) - 1
+	}
+
+	for _, k := range keys {
+		for _, f := range byKey[k][written[k]:] {
+			// Parse defaults a missing Compression to bzip2
+			if k == "Compression" && f.Value == "bzip2" && written[k] == 0 {
+				continue
+			}
+
+			i, ok := last[k]
+			if !ok {
+				i = len(ordered) - 1
+			}
+
+			ordered = append(ordered[:i+1], append([]Field{f}, ordered[i+1:]...)...)
+
+			for k2, j := range last {
+				if j > i {
+					last[k2] = j + 1
+				}
+			}
+
+			last[k] = i + 1
+		}
+	}
+
+	return ordered
+}
+
 // ContentType returns the mime content type of the object.
 func (n NarInfo) ContentType() string {
 	return "text/x-nix-narinfo"
@@ -0,0 +1,90 @@
+// Package nixdb provides a high-level API over the database of a local Nix
+// store (the nix_v10 schema, usually at /nix/var/nix/db/db.sqlite).
+//
+// It deals with typed path infos instead of raw rows, and keeps the database
+// consistent the same way Nix does when registering paths.
+package nixdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/nix-community/go-nix/pkg/derivation"
+	"github.com/nix-community/go-nix/pkg/derivation/store"
+	"github.com/nix-community/go-nix/pkg/sqlite"
+	"github.com/nix-community/go-nix/pkg/sqlite/nix_v10"
+)
+
+// DB is a Nix database.
+type DB struct {
+	db      *sql.DB
+	queries *nix_v10.Queries
+
+	// drvStore is used to read derivations being registered,
+	// to record their outputs.
+	drvStore derivation.Store
+}
+
+// New returns a DB using an already opened nix_v10 database.
+// drvStore is used to read the .drv files of derivations being registered.
+func New(db *sql.DB, drvStore derivation.Store) *DB {
+	return &DB{
+		db:       db,
+		queries:  nix_v10.New(db),
+		drvStore: drvStore,
+	}
+}
+
+// Open opens the nix_v10 database at dsn.
+// If drvStore is nil, derivations are read from storepath.StoreDir.
+func Open(dsn string, drvStore derivation.Store) (*DB, error) {
+	if drvStore == nil {
+		var err error
+
+		drvStore, err = store.NewFSStore("")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db, _, err := sqlite.NixV10Strict(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(db, drvStore), nil
+}
+
+// Close closes the underlying database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// queryPathID returns the id of a valid path.
+func queryPathID(ctx context.Context, q *nix_v10.Queries, path string) (int64, error) {
+	row, err := q.QueryPathInfo(ctx, path)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("path '%s' is not valid", path)
+		}
+
+		return 0, err
+	}
+
+	return row.ID, nil
+}
+
+func isValidPath(ctx context.Context, q *nix_v10.Queries, path string) (bool, error) {
+	_, err := q.QueryPathInfo(ctx, path)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,295 @@
+package nixdb_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/derivation/store"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixdb"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/sqlite"
+	"github.com/nix-community/go-nix/pkg/sqlite/nix_v10"
+	"github.com/nix-community/go-nix/pkg/storepath"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	pathBar    = "/nix/store/4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar"
+	pathBarDrv = "/nix/store/0hm2f1psjpcwg8fijsmr4wwxrx59s092-bar.drv"
+	pathFoo    = "/nix/store/5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo"
+	pathFooDrv = "/nix/store/4wvvbi4jwn0prsdxb7vs673qa5h9gr7x-foo.drv"
+
+	testdataDir = "../../test/testdata"
+)
+
+func mustParseHash(s string) *nixhash.Hash {
+	h, err := nixhash.ParseAny(s, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	return &h.Hash
+}
+
+func mustParseCA(s string) *storepath.ContentAddress {
+	ca, err := storepath.ParseContentAddress(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return ca
+}
+
+// newTestDB returns a DB with a new nix_v10 database in a temporary
+// directory, reading derivations from drvDir.
+func newTestDB(t *testing.T, drvDir string) *nixdb.DB {
+	t.Helper()
+
+	return newTestDBWith(t, drvDir, sqlite.InitNixV10)
+}
+
+// newTestCADB is like newTestDB, but the database also has the tables for
+// content-addressed derivations.
+func newTestCADB(t *testing.T, drvDir string) *nixdb.DB {
+	t.Helper()
+
+	return newTestDBWith(t, drvDir, sqlite.InitNixV10CA)
+}
+
+func newTestDBWith(
+	t *testing.T,
+	drvDir string,
+	initDB func(dir string) (*sql.DB, *nix_v10.Queries, error),
+) *nixdb.DB {
+	t.Helper()
+
+	db, _, err := initDB(t.TempDir())
+	require.NoError(t, err)
+
+	t.Cleanup(func() { db.Close() })
+
+	drvStore, err := store.NewFSStore(drvDir)
+	require.NoError(t, err)
+
+	return nixdb.New(db, drvStore)
+}
+
+// testInfos returns the infos of foo, its derivation, and their references,
+// in an order where referrers come first.
+func testInfos() []*nixdb.PathInfo {
+	sig, err := signature.ParseSignature(
+		"cache.nixos.org-1:sn5s/RrqEI+YG6/PjwdbPjcAC7rcta7sJU4mFOawGvJBLsWkyLtBrT2EuFt/LJjWkTZ+ZWOI9NTtjo/woMdvAg==",
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	narHash := mustParseHash("sha256:1b8m03r63zqhnjf7l5wnldhh7c134ap5vpj0850ymkq1iyzicy5s")
+
+	return []*nixdb.PathInfo{
+		{
+			Path:             pathFoo,
+			Deriver:          pathFooDrv,
+			NarHash:          narHash,
+			References:       []string{pathBar},
+			RegistrationTime: time.Unix(1700000000, 0),
+			NarSize:          120,
+			Ultimate:         true,
+		},
+		{
+			Path:       pathFooDrv,
+			NarHash:    narHash,
+			References: []string{pathBarDrv},
+			NarSize:    480,
+			CA:         mustParseCA("text:sha256:7e3e384481309af458ed2200ae9d6d83823fefc1eab0f5d8e24bec8e26af46ba"),
+		},
+		{
+			Path:       pathBarDrv,
+			NarHash:    narHash,
+			NarSize:    400,
+			CA:         mustParseCA("text:sha256:d772458796a3a457f931fceee5ca22c3a3596d065c59ab5f622bd163eb712056"),
+			Signatures: []signature.Signature{sig},
+		},
+		{
+			Path:       pathBar,
+			Deriver:    pathBarDrv,
+			NarHash:    narHash,
+			NarSize:    120,
+			CA:         mustParseCA("fixed:r:sha256:08813cbee9903c62be4c5027726a418a300da4500b2d369d3af9286f4815ceba"),
+			Signatures: []signature.Signature{sig},
+		},
+	}
+}
+
+func TestRegisterValidPaths(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, testdataDir)
+	infos := testInfos()
+
+	before := time.Now().Truncate(time.Second)
+
+	require.NoError(t, db.RegisterValidPaths(ctx, infos))
+
+	t.Run("QueryPathInfo", func(t *testing.T) {
+		info, err := db.QueryPathInfo(ctx, pathFoo)
+		if assert.NoError(t, err) {
+			assert.Equal(t, infos[0], info)
+		}
+
+		info, err = db.QueryPathInfo(ctx, pathBar)
+		if assert.NoError(t, err) {
+			assert.Equal(t, infos[3].CA, info.CA)
+			assert.Equal(t, infos[3].Signatures, info.Signatures)
+			assert.Empty(t, info.References)
+			assert.False(t, info.Ultimate)
+			assert.False(t, info.RegistrationTime.Before(before), "should be registered now")
+		}
+
+		info, err = db.QueryPathInfo(ctx, "/nix/store/00000000000000000000000000000000-missing")
+		if assert.NoError(t, err) {
+			assert.Nil(t, info)
+		}
+	})
+
+	t.Run("IsValidPath", func(t *testing.T) {
+		valid, err := db.IsValidPath(ctx, pathFooDrv)
+		if assert.NoError(t, err) {
+			assert.True(t, valid)
+		}
+
+		valid, err = db.IsValidPath(ctx, "/nix/store/00000000000000000000000000000000-missing")
+		if assert.NoError(t, err) {
+			assert.False(t, valid)
+		}
+	})
+
+	t.Run("QueryPathFromHashPart", func(t *testing.T) {
+		path, err := db.QueryPathFromHashPart(ctx, "4q0pg5zpfmznxscq3avycvf9xdvx50n3")
+		if assert.NoError(t, err) {
+			assert.Equal(t, pathBar, path)
+		}
+
+		for _, hashPart := range []string{"4q0pg5zpfmznxscq3avycvf9xdvx50n", "00000000000000000000000000000000", "zzzz"} {
+			path, err = db.QueryPathFromHashPart(ctx, hashPart)
+			if assert.NoError(t, err) {
+				assert.Empty(t, path, hashPart)
+			}
+		}
+	})
+
+	t.Run("QueryClosure", func(t *testing.T) {
+		closure, err := db.QueryClosure(ctx, []string{pathFoo})
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{pathBar, pathFoo}, closure)
+		}
+
+		closure, err = db.QueryClosure(ctx, []string{pathFooDrv, pathFoo})
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{pathBarDrv, pathBar, pathFooDrv, pathFoo}, closure)
+		}
+
+		_, err = db.QueryClosure(ctx, []string{"/nix/store/00000000000000000000000000000000-missing"})
+		assert.Error(t, err)
+	})
+
+	t.Run("QueryReferrersClosure", func(t *testing.T) {
+		closure, err := db.QueryReferrersClosure(ctx, []string{pathBarDrv})
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{pathBarDrv, pathFooDrv}, closure)
+		}
+
+		_, err = db.QueryReferrersClosure(ctx, []string{"/nix/store/00000000000000000000000000000000-missing"})
+		assert.Error(t, err)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		info := *infos[0]
+		info.Deriver = ""
+		info.Signatures = infos[3].Signatures
+		info.RegistrationTime = time.Time{}
+
+		require.NoError(t, db.RegisterValidPaths(ctx, []*nixdb.PathInfo{&info}))
+
+		updated, err := db.QueryPathInfo(ctx, pathFoo)
+		if assert.NoError(t, err) {
+			assert.Equal(t, infos[3].Signatures, updated.Signatures)
+			assert.Equal(t, pathFooDrv, updated.Deriver, "deriver should be kept")
+			assert.Equal(t, infos[0].RegistrationTime, updated.RegistrationTime, "registration time should be kept")
+		}
+	})
+}
+
+func TestRegisterValidPathsInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	check := func(t *testing.T, db *nixdb.DB, infos []*nixdb.PathInfo, msg string) {
+		t.Helper()
+
+		err := db.RegisterValidPaths(ctx, infos)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), msg)
+		}
+
+		// nothing should have been registered
+		for _, info := range infos {
+			valid, err := db.IsValidPath(ctx, info.Path)
+			if assert.NoError(t, err) {
+				assert.False(t, valid, info.Path)
+			}
+		}
+	}
+
+	t.Run("missing reference", func(t *testing.T) {
+		check(t, newTestDB(t, testdataDir), testInfos()[:3], "path '"+pathBar+"' is not valid")
+	})
+
+	t.Run("not content-addressed", func(t *testing.T) {
+		infos := testInfos()
+		infos[2].CA = infos[1].CA
+
+		check(t, newTestDB(t, testdataDir), infos, "claims to be content-addressed but isn't")
+	})
+
+	t.Run("missing hash", func(t *testing.T) {
+		infos := testInfos()
+		infos[0].NarHash = nil
+
+		check(t, newTestDB(t, testdataDir), infos, "has no sha256 NAR hash")
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		infos := testInfos()
+		infos[3].References = []string{pathFoo, pathBar}
+		infos[3].CA = nil
+
+		check(t, newTestDB(t, testdataDir), infos, "cycle detected in the references of")
+	})
+
+	t.Run("incorrect output path", func(t *testing.T) {
+		drvDir := t.TempDir()
+
+		for _, name := range []string{pathBarDrv, pathFooDrv} {
+			contents, err := os.ReadFile(filepath.Join(testdataDir, filepath.Base(name)))
+			require.NoError(t, err)
+
+			// pretend foo depends on a different bar
+			contents = []byte(strings.ReplaceAll(string(contents), "08813cbee9903c62", "18813cbee9903c62"))
+
+			require.NoError(t, os.WriteFile(filepath.Join(drvDir, filepath.Base(name)), contents, 0o644))
+		}
+
+		infos := testInfos()
+		for _, info := range infos {
+			info.CA = nil
+		}
+
+		check(t, newTestDB(t, drvDir), infos, "has incorrect output paths")
+	})
+}
@@ -0,0 +1,94 @@
+package nixdb
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/sqlite/nix_v10"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+// PathInfo describes a valid path in the Nix store.
+type PathInfo struct {
+	Path    string
+	Deriver string // Empty if unknown
+	// NarHash is the hash of the NAR serialisation of the path.
+	// It's always a sha256 hash.
+	NarHash *nixhash.Hash
+	// References are the absolute store paths the path refers to,
+	// including itself if it refers to itself.
+	References       []string
+	RegistrationTime time.Time
+	NarSize          uint64 // Zero if unknown
+	// Ultimate is set if the path was built locally.
+	Ultimate   bool
+	Signatures []signature.Signature
+	// CA is set if the path is content-addressed.
+	CA *storepath.ContentAddress
+}
+
+func newPathInfo(path string, row *nix_v10.QueryPathInfoRow, references []string) (*PathInfo, error) {
+	h, err := nixhash.ParseAny(row.Hash, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hash: %w", err)
+	}
+
+	info := &PathInfo{
+		Path:             path,
+		Deriver:          row.Deriver.String,
+		NarHash:          &h.Hash,
+		References:       references,
+		RegistrationTime: time.Unix(row.Registrationtime, 0),
+		Ultimate:         row.Ultimate.Valid && row.Ultimate.Int64 != 0,
+	}
+
+	if row.Narsize.Valid {
+		info.NarSize = uint64(row.Narsize.Int64) //nolint:gosec
+	}
+
+	for _, s := range strings.Fields(row.Sigs.String) {
+		sig, err := signature.ParseSignature(s)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Signatures = append(info.Signatures, sig)
+	}
+
+	if row.Ca.Valid && row.Ca.String != "" {
+		info.CA, err = storepath.ParseContentAddress(row.Ca.String)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// row returns the columns the path info is stored in, the same way Nix does.
+func (info *PathInfo) row() nix_v10.RegisterValidPathParams {
+	sigs := make([]string, len(info.Signatures))
+	for i, sig := range info.Signatures {
+		sigs[i] = sig.String()
+	}
+
+	row := nix_v10.RegisterValidPathParams{
+		Path:             info.Path,
+		Hash:             info.NarHash.Format(nixhash.Base16, true),
+		Registrationtime: info.RegistrationTime.Unix(),
+		Deriver:          sql.NullString{String: info.Deriver, Valid: info.Deriver != ""},
+		Narsize:          sql.NullInt64{Int64: int64(info.NarSize), Valid: info.NarSize != 0}, //nolint:gosec
+		Ultimate:         sql.NullInt64{Int64: 1, Valid: info.Ultimate},
+		Sigs:             sql.NullString{String: strings.Join(sigs, " "), Valid: true},
+	}
+
+	if info.CA != nil {
+		row.Ca = sql.NullString{String: info.CA.String(), Valid: true}
+	}
+
+	return row
+}
@@ -0,0 +1,125 @@
+package nixdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+// IsValidPath returns true if path is valid in the store.
+func (d *DB) IsValidPath(ctx context.Context, path string) (bool, error) {
+	return isValidPath(ctx, d.queries, path)
+}
+
+// QueryPathInfo returns information about path, or nil if it isn't valid.
+func (d *DB) QueryPathInfo(ctx context.Context, path string) (*PathInfo, error) {
+	row, err := d.queries.QueryPathInfo(ctx, path)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil //nolint:nilnil
+		}
+
+		return nil, err
+	}
+
+	references, err := d.queries.QueryReferences(ctx, row.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(references)
+
+	info, err := newPathInfo(path, &row, references)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path info of %v: %w", path, err)
+	}
+
+	return info, nil
+}
+
+// QueryPathFromHashPart returns the valid path with the given hash part, or
+// an empty string if there is none.
+func (d *DB) QueryPathFromHashPart(ctx context.Context, hashPart string) (string, error) {
+	prefix := storepath.StoreDir + "/" + hashPart
+
+	path, err := d.queries.QueryPathFromHashPart(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	// the query returns the first path sorting after prefix
+	if !strings.HasPrefix(path, prefix+"-") {
+		return "", nil
+	}
+
+	return path, nil
+}
+
+// QueryClosure returns the closure of paths: the paths themselves, and all
+// paths they refer to, recursively. The result is sorted.
+// All paths need to be valid.
+func (d *DB) QueryClosure(ctx context.Context, paths []string) ([]string, error) {
+	return closure(paths, func(path string) ([]string, error) {
+		id, err := queryPathID(ctx, d.queries, path)
+		if err != nil {
+			return nil, err
+		}
+
+		return d.queries.QueryReferences(ctx, id)
+	})
+}
+
+// QueryReferrersClosure returns the paths referring to any of paths,
+// recursively, including paths themselves. The result is sorted.
+// This is the set of paths that would become invalid when deleting paths.
+// All paths need to be valid.
+func (d *DB) QueryReferrersClosure(ctx context.Context, paths []string) ([]string, error) {
+	return closure(paths, func(path string) ([]string, error) {
+		if _, err := queryPathID(ctx, d.queries, path); err != nil {
+			return nil, err
+		}
+
+		return d.queries.QueryReferrers(ctx, path)
+	})
+}
+
+// closure returns the sorted closure of paths, following the edges returned
+// by next.
+func closure(paths []string, next func(path string) ([]string, error)) ([]string, error) {
+	seen := make(map[string]struct{}, len(paths))
+	queue := make([]string, 0, len(paths))
+
+	for _, p := range paths {
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			queue = append(queue, p)
+		}
+	}
+
+	for i := 0; i < len(queue); i++ {
+		edges, err := next(queue[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range edges {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				queue = append(queue, p)
+			}
+		}
+	}
+
+	sort.Strings(queue)
+
+	return queue, nil
+}
@@ -0,0 +1,215 @@
+package nixdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/realisation"
+	"github.com/nix-community/go-nix/pkg/sqlite/nix_v10"
+)
+
+// The functions in this file need the tables for content-addressed
+// derivations, see sqlite.InitNixV10CA.
+
+// RegisterRealisation records the realisation r, in one transaction.
+// Its output path needs to be valid, and its dependent realisations need to
+// be registered already.
+// If the derivation output is already realised to the same path, the
+// signatures of r are added to the existing ones.
+func (d *DB) RegisterRealisation(ctx context.Context, r *realisation.Realisation) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := registerRealisation(ctx, d.queries.WithTx(tx), r); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+
+		return fmt.Errorf("unable to register realisation of %v: %w", r.ID, err)
+	}
+
+	return tx.Commit()
+}
+
+func registerRealisation(ctx context.Context, q *nix_v10.Queries, r *realisation.Realisation) error {
+	if r.ID == nil {
+		return fmt.Errorf("realisation has no id")
+	}
+
+	if _, err := queryPathID(ctx, q, r.OutPath); err != nil {
+		return err
+	}
+
+	existing, err := queryRealisation(ctx, q, r.ID)
+	if err != nil {
+		return err
+	}
+
+	sigs := r.Signatures
+
+	if existing != nil {
+		if existing.OutPath != r.OutPath {
+			return fmt.Errorf("it is already realised to %v, not %v", existing.OutPath, r.OutPath)
+		}
+
+		sigs = append(existing.Signatures, sigs...)
+	}
+
+	if existing == nil {
+		err = q.RegisterRealisedOutput(ctx, nix_v10.RegisterRealisedOutputParams{
+			Drvpath:    r.ID.HashString(),
+			Outputname: r.ID.OutputName,
+			Path:       r.OutPath,
+			Signatures: formatSignatures(sigs),
+		})
+	} else {
+		err = q.UpdateRealisedOutput(ctx, nix_v10.UpdateRealisedOutputParams{
+			Signatures: formatSignatures(sigs),
+			Drvpath:    r.ID.HashString(),
+			Outputname: r.ID.OutputName,
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for depID, depPath := range r.DependentRealisations {
+		dep, err := realisation.ParseDrvOutput(depID)
+		if err != nil {
+			return err
+		}
+
+		depRealisation, err := queryRealisation(ctx, q, dep)
+		if err != nil {
+			return err
+		}
+
+		if depRealisation == nil {
+			return fmt.Errorf("dependent realisation %v is not registered", depID)
+		}
+
+		if depRealisation.OutPath != depPath {
+			return fmt.Errorf("dependent realisation %v is realised to %v, not %v", depID, depRealisation.OutPath, depPath)
+		}
+
+		if err := q.AddRealisationReference(ctx, nix_v10.AddRealisationReferenceParams{
+			Drvpath:      r.ID.HashString(),
+			Outputname:   r.ID.OutputName,
+			Drvpath_2:    dep.HashString(),
+			Outputname_2: dep.OutputName,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// QueryRealisation returns the realisation of the derivation output id,
+// or nil if it isn't realised.
+func (d *DB) QueryRealisation(ctx context.Context, id *realisation.DrvOutput) (*realisation.Realisation, error) {
+	r, err := queryRealisation(ctx, d.queries, id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query realisation of %v: %w", id, err)
+	}
+
+	return r, nil
+}
+
+// QueryRealisedOutputs returns the realised outputs of the derivation with
+// the given hash (modulo its inputs), as a map from output name to path.
+func (d *DB) QueryRealisedOutputs(ctx context.Context, drvHash *nixhash.Hash) (map[string]string, error) {
+	rows, err := d.queries.QueryAllRealisedOutputs(ctx, drvHash.Format(nixhash.Base16, true))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make(map[string]string, len(rows))
+	for _, row := range rows {
+		outputs[row.Outputname] = row.Path
+	}
+
+	return outputs, nil
+}
+
+func queryRealisation(
+	ctx context.Context,
+	q *nix_v10.Queries,
+	id *realisation.DrvOutput,
+) (*realisation.Realisation, error) {
+	row, err := q.QueryRealisedOutput(ctx, nix_v10.QueryRealisedOutputParams{
+		Drvpath:    id.HashString(),
+		Outputname: id.OutputName,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil //nolint:nilnil
+		}
+
+		return nil, err
+	}
+
+	r := &realisation.Realisation{
+		ID:      id,
+		OutPath: row.Path,
+	}
+
+	for _, s := range strings.Fields(row.Signatures.String) {
+		sig, err := signature.ParseSignature(s)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Signatures = append(r.Signatures, sig)
+	}
+
+	refs, err := q.QueryRealisationReferences(ctx, row.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		depRow, err := q.QueryRealisedOutput(ctx, nix_v10.QueryRealisedOutputParams{
+			Drvpath:    ref.Drvpath,
+			Outputname: ref.Outputname,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if r.DependentRealisations == nil {
+			r.DependentRealisations = make(map[string]string, len(refs))
+		}
+
+		r.DependentRealisations[ref.Drvpath+"!"+ref.Outputname] = depRow.Path
+	}
+
+	return r, nil
+}
+
+// formatSignatures renders sigs space-separated, sorted and without
+// duplicates, like Nix stores them.
+func formatSignatures(sigs []signature.Signature) sql.NullString {
+	set := make(map[string]struct{}, len(sigs))
+	for _, sig := range sigs {
+		set[sig.String()] = struct{}{}
+	}
+
+	strs := make([]string, 0, len(set))
+	for s := range set {
+		strs = append(strs, s)
+	}
+
+	sort.Strings(strs)
+
+	return sql.NullString{String: strings.Join(strs, " "), Valid: true}
+}
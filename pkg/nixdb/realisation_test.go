@@ -0,0 +1,108 @@
+package nixdb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/realisation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseDrvOutput(s string) *realisation.DrvOutput {
+	id, err := realisation.ParseDrvOutput(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return id
+}
+
+func TestRealisations(t *testing.T) {
+	ctx := context.Background()
+	db := newTestCADB(t, testdataDir)
+
+	require.NoError(t, db.RegisterValidPaths(ctx, testInfos()))
+
+	barID := mustParseDrvOutput("sha256:08813cbee9903c62be4c5027726a418a300da4500b2d369d3af9286f4815ceba!out")
+	fooID := mustParseDrvOutput("sha256:ba17bbf4d63d0fd4f0ab29e65c5fa0a1b4eb0d2e3eee7e32e1f64ec4d4b1fb69!out")
+
+	sk, err := signature.LoadSecretKey(
+		"test1:jbX9NxZp8WB/coK8k7yLf0gNYmBbIbCrOFwgJgI7OV+0sASf4R5oFQlioSlN3bJ56uvshzr7S9Z75pcv+9hSkQ==",
+	)
+	require.NoError(t, err)
+
+	bar := &realisation.Realisation{ID: barID, OutPath: pathBar}
+	foo := &realisation.Realisation{
+		ID:                    fooID,
+		OutPath:               pathFoo,
+		DependentRealisations: map[string]string{barID.String(): pathBar},
+	}
+	require.NoError(t, foo.Sign(sk))
+
+	t.Run("missing dependency", func(t *testing.T) {
+		assert.Error(t, db.RegisterRealisation(ctx, foo))
+	})
+
+	require.NoError(t, db.RegisterRealisation(ctx, bar))
+	require.NoError(t, db.RegisterRealisation(ctx, foo))
+
+	t.Run("QueryRealisation", func(t *testing.T) {
+		r, err := db.QueryRealisation(ctx, fooID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, foo, r)
+			assert.True(t, r.Verify([]signature.PublicKey{sk.ToPublicKey()}))
+		}
+
+		r, err = db.QueryRealisation(ctx, barID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, bar, r)
+		}
+
+		r, err = db.QueryRealisation(ctx, mustParseDrvOutput(fooID.HashString()+"!dev"))
+		if assert.NoError(t, err) {
+			assert.Nil(t, r)
+		}
+	})
+
+	t.Run("QueryRealisedOutputs", func(t *testing.T) {
+		outputs, err := db.QueryRealisedOutputs(ctx, fooID.DrvHash)
+		if assert.NoError(t, err) {
+			assert.Equal(t, map[string]string{"out": pathFoo}, outputs)
+		}
+	})
+
+	t.Run("add signatures", func(t *testing.T) {
+		sk2, _, err := signature.GenerateKeypair("test2", nil)
+		require.NoError(t, err)
+
+		bar2 := &realisation.Realisation{ID: barID, OutPath: pathBar}
+		require.NoError(t, bar2.Sign(sk2))
+		require.NoError(t, db.RegisterRealisation(ctx, bar2))
+		// registering the same signature again doesn't duplicate it
+		require.NoError(t, db.RegisterRealisation(ctx, bar2))
+
+		r, err := db.QueryRealisation(ctx, barID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, bar2.Signatures, r.Signatures)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		err := db.RegisterRealisation(ctx, &realisation.Realisation{ID: barID, OutPath: pathFoo})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "already realised")
+		}
+	})
+
+	t.Run("invalid output path", func(t *testing.T) {
+		err := db.RegisterRealisation(ctx, &realisation.Realisation{
+			ID:      mustParseDrvOutput(fooID.HashString() + "!dev"),
+			OutPath: "/nix/store/00000000000000000000000000000000-foo-dev",
+		})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "is not valid")
+		}
+	})
+}
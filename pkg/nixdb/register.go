@@ -0,0 +1,292 @@
+package nixdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/derivation"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/sqlite/nix_v10"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+// RegisterValidPaths registers the given paths as valid, in one transaction.
+// Paths that are already valid get their info updated, their deriver and
+// registration time are kept.
+//
+// Like Nix, it checks that:
+//   - paths claiming to be content-addressed really are,
+//   - all references are valid, or are being registered,
+//   - the output paths of derivations being registered are correct,
+//   - the references among the registered paths don't form cycles.
+//
+// The outputs of derivations are recorded, their .drv files are read from
+// the derivation store.
+// A zero RegistrationTime is replaced with the current time.
+func (d *DB) RegisterValidPaths(ctx context.Context, infos []*PathInfo) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := d.registerValidPaths(ctx, d.queries.WithTx(tx), infos); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *DB) registerValidPaths(ctx context.Context, q *nix_v10.Queries, infos []*PathInfo) error {
+	now := time.Now()
+	drvs := make(map[string]*derivation.Derivation)
+
+	for _, info := range infos {
+		if err := checkPathInfo(info); err != nil {
+			return err
+		}
+
+		row := info.row()
+		if info.RegistrationTime.IsZero() {
+			row.Registrationtime = now.Unix()
+		}
+
+		if err := addOrUpdatePath(ctx, q, &row); err != nil {
+			return fmt.Errorf("unable to register %v: %w", info.Path, err)
+		}
+
+		if strings.HasSuffix(info.Path, ".drv") {
+			drv, err := d.drvStore.Get(ctx, info.Path)
+			if err != nil {
+				return fmt.Errorf("unable to read derivation %v: %w", info.Path, err)
+			}
+
+			if err := addDerivationOutputs(ctx, q, info.Path, drv); err != nil {
+				return fmt.Errorf("unable to register outputs of %v: %w", info.Path, err)
+			}
+
+			drvs[info.Path] = drv
+		}
+	}
+
+	for _, info := range infos {
+		if err := addReferences(ctx, q, info); err != nil {
+			return fmt.Errorf("unable to register references of %v: %w", info.Path, err)
+		}
+	}
+
+	// check the output paths only now, as the input derivations might have been
+	// registered along with the derivations referring to them.
+	replacements := make(map[string]string)
+
+	for drvPath, drv := range drvs {
+		if err := d.checkOutputPaths(ctx, drv, replacements); err != nil {
+			return fmt.Errorf("derivation %v has incorrect output paths: %w", drvPath, err)
+		}
+	}
+
+	return checkCycles(infos)
+}
+
+// checkPathInfo checks the info of a path about to be registered.
+func checkPathInfo(info *PathInfo) error {
+	if _, err := storepath.FromAbsolutePath(info.Path); err != nil {
+		return err
+	}
+
+	if info.NarHash == nil || info.NarHash.Algo() != nixhash.SHA256 {
+		return fmt.Errorf("path '%s' has no sha256 NAR hash", info.Path)
+	}
+
+	if info.CA != nil {
+		if err := info.CA.Check(info.Path, info.References); err != nil {
+			return fmt.Errorf(
+				"cannot add path '%s' to the Nix store because it claims to be content-addressed but isn't: %w",
+				info.Path, err,
+			)
+		}
+	}
+
+	return nil
+}
+
+func addOrUpdatePath(ctx context.Context, q *nix_v10.Queries, row *nix_v10.RegisterValidPathParams) error {
+	valid, err := isValidPath(ctx, q, row.Path)
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		return q.RegisterValidPath(ctx, *row)
+	}
+
+	return q.UpdatePathInfo(ctx, nix_v10.UpdatePathInfoParams{
+		Narsize:  row.Narsize,
+		Hash:     row.Hash,
+		Ultimate: row.Ultimate,
+		Sigs:     row.Sigs,
+		Ca:       row.Ca,
+		Path:     row.Path,
+	})
+}
+
+func addDerivationOutputs(ctx context.Context, q *nix_v10.Queries, drvPath string, drv *derivation.Derivation) error {
+	id, err := queryPathID(ctx, q, drvPath)
+	if err != nil {
+		return err
+	}
+
+	for name, o := range drv.Outputs {
+		// floating content-addressed outputs have no path yet
+		if o.Path == "" {
+			continue
+		}
+
+		if err := q.AddDerivationOutput(ctx, nix_v10.AddDerivationOutputParams{
+			Drv:  id,
+			ID:   name,
+			Path: o.Path,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addReferences(ctx context.Context, q *nix_v10.Queries, info *PathInfo) error {
+	referrer, err := queryPathID(ctx, q, info.Path)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range info.References {
+		reference, err := queryPathID(ctx, q, ref)
+		if err != nil {
+			return err
+		}
+
+		if err := q.AddReference(ctx, nix_v10.AddReferenceParams{
+			Referrer:  referrer,
+			Reference: reference,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkOutputPaths re-calculates the output paths of drv, and returns an
+// error if they don't match. The replacements of input derivations are
+// calculated as needed, and memoized in replacements.
+func (d *DB) checkOutputPaths(ctx context.Context, drv *derivation.Derivation, replacements map[string]string) error {
+	if err := drv.Validate(); err != nil {
+		return err
+	}
+
+	inputReplacements, err := d.inputReplacements(ctx, drv, replacements)
+	if err != nil {
+		return err
+	}
+
+	outputPaths, err := drv.CalculateOutputPaths(inputReplacements)
+	if err != nil {
+		return err
+	}
+
+	for name, o := range drv.Outputs {
+		if o.Path != "" && o.Path != outputPaths[name] {
+			return fmt.Errorf("output %v should be %v, not %v", name, outputPaths[name], o.Path)
+		}
+	}
+
+	return nil
+}
+
+func (d *DB) inputReplacements(
+	ctx context.Context,
+	drv *derivation.Derivation,
+	replacements map[string]string,
+) (map[string]string, error) {
+	inputReplacements := make(map[string]string, len(drv.InputDerivations))
+
+	for drvPath := range drv.InputDerivations {
+		replacement, ok := replacements[drvPath]
+		if !ok {
+			inputDrv, err := d.drvStore.Get(ctx, drvPath)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read input derivation %v: %w", drvPath, err)
+			}
+
+			r, err := d.inputReplacements(ctx, inputDrv, replacements)
+			if err != nil {
+				return nil, err
+			}
+
+			replacement, err = inputDrv.CalculateDrvReplacement(r)
+			if err != nil {
+				return nil, err
+			}
+
+			replacements[drvPath] = replacement
+		}
+
+		inputReplacements[drvPath] = replacement
+	}
+
+	return inputReplacements, nil
+}
+
+// checkCycles returns an error if the references among infos form a cycle.
+// Self-references are fine.
+func checkCycles(infos []*PathInfo) error {
+	byPath := make(map[string]*PathInfo, len(infos))
+	for _, info := range infos {
+		byPath[info.Path] = info
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+
+	state := make(map[string]int, len(infos))
+
+	var visit func(path, parent string) error
+	visit = func(path, parent string) error {
+		switch state[path] {
+		case visiting:
+			return fmt.Errorf("cycle detected in the references of '%s' from '%s'", path, parent)
+		case done:
+			return nil
+		}
+
+		state[path] = visiting
+
+		for _, ref := range byPath[path].References {
+			if _, ok := byPath[ref]; ok && ref != path {
+				if err := visit(ref, path); err != nil {
+					return err
+				}
+			}
+		}
+
+		state[path] = done
+
+		return nil
+	}
+
+	for _, info := range infos {
+		if err := visit(info.Path, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package realisation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/nixhash"
+)
+
+// DrvOutput identifies an output of a content-addressed derivation,
+// by the hash of the derivation modulo its inputs, and the output name.
+// It's rendered like `sha256:<base16>!out`.
+type DrvOutput struct {
+	DrvHash    *nixhash.Hash
+	OutputName string
+}
+
+// ParseDrvOutput parses the id of a derivation output.
+func ParseDrvOutput(s string) (*DrvOutput, error) {
+	hash, outputName, ok := strings.Cut(s, "!")
+	if !ok {
+		return nil, fmt.Errorf("invalid derivation output id %v: missing '!'", s)
+	}
+
+	if outputName == "" {
+		return nil, fmt.Errorf("invalid derivation output id %v: empty output name", s)
+	}
+
+	h, err := nixhash.ParseAny(hash, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid derivation output id %v: %w", s, err)
+	}
+
+	return &DrvOutput{
+		DrvHash:    &h.Hash,
+		OutputName: outputName,
+	}, nil
+}
+
+// HashString returns the hash part of the id, like `sha256:<base16>`.
+// It's stored in the drvPath column of the Realisations table.
+func (o *DrvOutput) HashString() string {
+	return o.DrvHash.Format(nixhash.Base16, true)
+}
+
+// String renders the id like `sha256:<base16>!out`.
+func (o *DrvOutput) String() string {
+	return o.HashString() + "!" + o.OutputName
+}
@@ -0,0 +1,210 @@
+// Package realisation implements the realisations of content-addressed
+// derivations: the records mapping a derivation output to the store path it
+// was built to, which are signed and shared through binary caches.
+package realisation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+// Realisation records the store path a derivation output was built to.
+type Realisation struct {
+	ID *DrvOutput
+	// OutPath is the absolute store path of the output.
+	OutPath    string
+	Signatures []signature.Signature
+	// DependentRealisations maps the ids of the realisations this one
+	// depends on (like `sha256:…!out`) to their absolute output paths.
+	DependentRealisations map[string]string
+}
+
+// realisationJSON is the JSON layout used by Nix. The fields are in the
+// order nlohmann::json sorts them, so the output matches Nix byte for byte.
+type realisationJSON struct {
+	DependentRealisations map[string]string `json:"dependentRealisations"`
+	ID                    string            `json:"id"`
+	OutPath               string            `json:"outPath"`
+	Signatures            []string          `json:"signatures"`
+}
+
+// fingerprintJSON is realisationJSON without the signatures.
+type fingerprintJSON struct {
+	DependentRealisations map[string]string `json:"dependentRealisations"`
+	ID                    string            `json:"id"`
+	OutPath               string            `json:"outPath"`
+}
+
+// Parse parses a realisation in JSON, as stored in binary caches
+// (realisations/<id>.doi).
+func Parse(b []byte) (*Realisation, error) {
+	var r Realisation
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (r *Realisation) UnmarshalJSON(b []byte) error {
+	var rj realisationJSON
+	if err := json.Unmarshal(b, &rj); err != nil {
+		return fmt.Errorf("invalid realisation: %w", err)
+	}
+
+	id, err := ParseDrvOutput(rj.ID)
+	if err != nil {
+		return fmt.Errorf("invalid realisation: %w", err)
+	}
+
+	outPath, err := storepath.FromString(rj.OutPath)
+	if err != nil {
+		return fmt.Errorf("invalid realisation: outPath: %w", err)
+	}
+
+	*r = Realisation{
+		ID:      id,
+		OutPath: outPath.Absolute(),
+	}
+
+	for _, s := range rj.Signatures {
+		sig, err := signature.ParseSignature(s)
+		if err != nil {
+			return fmt.Errorf("invalid realisation: %w", err)
+		}
+
+		r.Signatures = append(r.Signatures, sig)
+	}
+
+	if len(rj.DependentRealisations) != 0 {
+		r.DependentRealisations = make(map[string]string, len(rj.DependentRealisations))
+
+		for depID, depPath := range rj.DependentRealisations {
+			if _, err := ParseDrvOutput(depID); err != nil {
+				return fmt.Errorf("invalid realisation: %w", err)
+			}
+
+			sp, err := storepath.FromString(depPath)
+			if err != nil {
+				return fmt.Errorf("invalid realisation: dependent realisation %v: %w", depID, err)
+			}
+
+			r.DependentRealisations[depID] = sp.Absolute()
+		}
+	}
+
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, rendering the realisation like Nix.
+func (r *Realisation) MarshalJSON() ([]byte, error) {
+	fj, err := r.fingerprintJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	// like Nix, render signatures sorted
+	sigs := make([]string, len(r.Signatures))
+	for i, sig := range r.Signatures {
+		sigs[i] = sig.String()
+	}
+
+	sort.Strings(sigs)
+
+	return marshal(&realisationJSON{
+		DependentRealisations: fj.DependentRealisations,
+		ID:                    fj.ID,
+		OutPath:               fj.OutPath,
+		Signatures:            sigs,
+	})
+}
+
+// Fingerprint returns the string signatures are made over: the JSON
+// rendering without signatures.
+func (r *Realisation) Fingerprint() (string, error) {
+	fj, err := r.fingerprintJSON()
+	if err != nil {
+		return "", err
+	}
+
+	b, err := marshal(fj)
+
+	return string(b), err
+}
+
+// Sign adds a signature made with signer.
+func (r *Realisation) Sign(signer signature.Signer) error {
+	fingerprint, err := r.Fingerprint()
+	if err != nil {
+		return err
+	}
+
+	sig, err := signer.Sign(nil, fingerprint)
+	if err != nil {
+		return err
+	}
+
+	r.Signatures = append(r.Signatures, sig)
+
+	return nil
+}
+
+// Verify returns true if the realisation has a valid signature by one of
+// pubKeys, as decided by signature.VerifyFirst.
+func (r *Realisation) Verify(pubKeys []signature.PublicKey) bool {
+	fingerprint, err := r.Fingerprint()
+	if err != nil {
+		return false
+	}
+
+	return signature.VerifyFirst(fingerprint, r.Signatures, pubKeys)
+}
+
+func (r *Realisation) fingerprintJSON() (*fingerprintJSON, error) {
+	if r.ID == nil {
+		return nil, fmt.Errorf("realisation has no id")
+	}
+
+	outPath, err := storepath.FromAbsolutePath(r.OutPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid outPath: %w", err)
+	}
+
+	fj := &fingerprintJSON{
+		DependentRealisations: make(map[string]string, len(r.DependentRealisations)),
+		ID:                    r.ID.String(),
+		OutPath:               outPath.String(),
+	}
+
+	for depID, depPath := range r.DependentRealisations {
+		sp, err := storepath.FromAbsolutePath(depPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dependent realisation %v: %w", depID, err)
+		}
+
+		fj.DependentRealisations[depID] = sp.String()
+	}
+
+	return fj, nil
+}
+
+// marshal renders v compactly, without escaping HTML characters,
+// like nlohmann::json does.
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
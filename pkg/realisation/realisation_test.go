@@ -0,0 +1,138 @@
+package realisation_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/realisation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	fooID   = "sha256:ba17bbf4d63d0fd4f0ab29e65c5fa0a1b4eb0d2e3eee7e32e1f64ec4d4b1fb69!out"
+	barID   = "sha256:08813cbee9903c62be4c5027726a418a300da4500b2d369d3af9286f4815ceba!dev"
+	fooPath = "/nix/store/5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo"
+	barPath = "/nix/store/4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar"
+
+	sigTest1   = "test1:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQ=="
+	sigExample = "cache.example.org-1:AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAg=="
+
+	//nolint:gosec
+	test1SecretKey = "test1:jbX9NxZp8WB/coK8k7yLf0gNYmBbIbCrOFwgJgI7OV+0sASf4R5oFQlioSlN3bJ56uvshzr7S9Z75pcv+9hSkQ=="
+)
+
+func TestParseDrvOutput(t *testing.T) {
+	id, err := realisation.ParseDrvOutput(fooID)
+	require.NoError(t, err)
+	assert.Equal(t, "out", id.OutputName)
+	assert.Equal(t, fooID, id.String())
+
+	// other encodings are accepted, but rendered in base16
+	id2, err := realisation.ParseDrvOutput(
+		"sha256:1mr6lp0rv1plzcyxqhpp0vw6zkzrv1iwcrk6hq0xdx8ip2fhgvax!out")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:"+id2.DrvHash.Format(nixhash.Base16, false)+"!out", id2.String())
+
+	for _, s := range []string{
+		"sha256:ba17bbf4d63d0fd4f0ab29e65c5fa0a1b4eb0d2e3eee7e32e1f64ec4d4b1fb69",
+		"sha256:ba17bbf4d63d0fd4f0ab29e65c5fa0a1b4eb0d2e3eee7e32e1f64ec4d4b1fb69!",
+		"ba17bbf4!out",
+	} {
+		_, err := realisation.ParseDrvOutput(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	//nolint:lll
+	s := `{"dependentRealisations":{"` + barID + `":"4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar"},"id":"` + fooID + `","outPath":"5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo","signatures":["` + sigTest1 + `","` + sigExample + `"]}`
+
+	r, err := realisation.Parse([]byte(s))
+	require.NoError(t, err)
+
+	assert.Equal(t, fooID, r.ID.String())
+	assert.Equal(t, fooPath, r.OutPath)
+	assert.Equal(t, map[string]string{barID: barPath}, r.DependentRealisations)
+	assert.Len(t, r.Signatures, 2)
+
+	t.Run("format", func(t *testing.T) {
+		b, err := json.Marshal(r)
+		require.NoError(t, err)
+
+		// signatures are sorted
+		//nolint:lll
+		assert.Equal(t, `{"dependentRealisations":{"`+barID+`":"4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar"},"id":"`+fooID+`","outPath":"5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo","signatures":["`+sigExample+`","`+sigTest1+`"]}`, string(b))
+	})
+
+	t.Run("fingerprint", func(t *testing.T) {
+		fp, err := r.Fingerprint()
+		require.NoError(t, err)
+		//nolint:lll
+		assert.Equal(t, `{"dependentRealisations":{"`+barID+`":"4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar"},"id":"`+fooID+`","outPath":"5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo"}`, fp)
+	})
+
+	t.Run("minimal", func(t *testing.T) {
+		r, err := realisation.Parse([]byte(`{"id":"` + fooID + `","outPath":"5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo"}`))
+		require.NoError(t, err)
+
+		b, err := json.Marshal(r)
+		require.NoError(t, err)
+		assert.Equal(t,
+			`{"dependentRealisations":{},"id":"`+fooID+`","outPath":"5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo","signatures":[]}`,
+			string(b))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{
+			`{"outPath":"5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo"}`,
+			`{"id":"` + fooID + `","outPath":"/nix/store/5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo"}`,
+			`{"id":"` + fooID + `","outPath":"5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo","signatures":["nocolon"]}`,
+			`{"dependentRealisations":{"bar":"4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar"},` +
+				`"id":"` + fooID + `","outPath":"5vyvcwah9l9kf07d52rcgdk70g2f4y13-foo"}`,
+			`[]`,
+		} {
+			_, err := realisation.Parse([]byte(s))
+			assert.Error(t, err, s)
+		}
+	})
+}
+
+func TestSignVerify(t *testing.T) {
+	id, err := realisation.ParseDrvOutput(fooID)
+	require.NoError(t, err)
+
+	r := &realisation.Realisation{
+		ID:                    id,
+		OutPath:               fooPath,
+		DependentRealisations: map[string]string{barID: barPath},
+	}
+
+	sk, err := signature.LoadSecretKey(test1SecretKey)
+	require.NoError(t, err)
+
+	pk := sk.ToPublicKey()
+
+	assert.False(t, r.Verify([]signature.PublicKey{pk}))
+
+	require.NoError(t, r.Sign(sk))
+	assert.True(t, r.Verify([]signature.PublicKey{pk}))
+
+	// signatures survive a round trip
+	b, err := json.Marshal(r)
+	require.NoError(t, err)
+
+	r2, err := realisation.Parse(b)
+	require.NoError(t, err)
+	assert.True(t, r2.Verify([]signature.PublicKey{pk}))
+
+	// and are bound to the output path
+	r2.OutPath = barPath
+	assert.False(t, r2.Verify([]signature.PublicKey{pk}))
+
+	_, otherPK, err := signature.GenerateKeypair("other", nil)
+	require.NoError(t, err)
+	assert.False(t, r.Verify([]signature.PublicKey{otherPK}))
+}
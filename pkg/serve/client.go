@@ -0,0 +1,489 @@
+package serve
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/derivation"
+	"github.com/nix-community/go-nix/pkg/export"
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/wire"
+)
+
+// maxStringSize is the maximum size of strings sent by the remote side.
+const maxStringSize = 64 << 20
+
+// Client is a client for the `nix-store --serve` protocol.
+// It's safe for concurrent use, but commands are processed one at a time.
+type Client struct {
+	r *bufio.Reader
+	w *bufio.Writer
+
+	// closer is called by Close, if set.
+	closer func() error
+
+	version uint64
+
+	mu sync.Mutex
+	// err is set once a command failed, as the connection is in an unknown
+	// state after that. All further commands will fail.
+	err error
+}
+
+// NewClient performs the handshake on rw, and returns a client using it.
+func NewClient(rw io.ReadWriter) (*Client, error) {
+	c := &Client{
+		r: bufio.NewReader(rw),
+		w: bufio.NewWriter(rw),
+	}
+
+	if closer, ok := rw.(io.Closer); ok {
+		c.closer = closer.Close
+	}
+
+	if err := c.handshake(); err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+
+	return c, nil
+}
+
+// SSHCommand returns the command Nix runs to talk to host with this protocol.
+// If write is false, the remote side refuses commands modifying its store.
+func SSHCommand(host string, write bool) *exec.Cmd {
+	args := []string{"-x", "-a", host, "--", "nix-store", "--serve"}
+	if write {
+		args = append(args, "--write")
+	}
+
+	return exec.Command("ssh", args...)
+}
+
+// StartCommand starts cmd, and returns a client talking to it over its stdin
+// and stdout. Closing the client closes the stdin of cmd, and waits for it
+// to exit.
+func StartCommand(cmd *exec.Cmd) (*Client, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c, err := NewClient(struct {
+		io.Reader
+		io.Writer
+	}{stdout, stdin})
+	if err != nil {
+		stdin.Close()
+
+		return nil, errors.Join(err, cmd.Wait())
+	}
+
+	c.closer = func() error {
+		stdin.Close()
+
+		return cmd.Wait()
+	}
+
+	return c, nil
+}
+
+func (c *Client) handshake() error {
+	if err := wire.WriteUint64(c.w, ServeMagic1); err != nil {
+		return err
+	}
+
+	if err := wire.WriteUint64(c.w, ProtocolVersion); err != nil {
+		return err
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	magic, err := wire.ReadUint64(c.r)
+	if err != nil {
+		return err
+	}
+
+	if magic != ServeMagic2 {
+		return fmt.Errorf("protocol mismatch, got magic %x", magic)
+	}
+
+	remoteVersion, err := wire.ReadUint64(c.r)
+	if err != nil {
+		return err
+	}
+
+	if ProtocolMajor(remoteVersion) != ProtocolMajor(ProtocolVersion) {
+		return fmt.Errorf("unsupported protocol version %d.%d",
+			ProtocolMajor(remoteVersion)>>8, ProtocolMinor(remoteVersion))
+	}
+
+	c.version = remoteVersion
+	if c.version > ProtocolVersion {
+		c.version = ProtocolVersion
+	}
+
+	return nil
+}
+
+// ProtocolVersion returns the negotiated protocol version.
+func (c *Client) ProtocolVersion() uint64 {
+	return c.version
+}
+
+// Close closes the underlying connection, if it can be closed.
+func (c *Client) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+
+	return c.closer()
+}
+
+// do sends cmd, and runs fn to send the arguments and receive the response.
+// Any error leaves the connection in an unknown state, so it is marked as broken.
+func (c *Client) do(cmd Command, fn func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	err := wire.WriteUint64(c.w, uint64(cmd))
+	if err == nil {
+		err = fn()
+	}
+
+	if err != nil {
+		c.err = fmt.Errorf("connection is broken: %w", err)
+	}
+
+	return err
+}
+
+// QueryValidPaths returns the paths that are valid on the remote side.
+// If lock is set, the paths are locked against garbage collection (by older
+// Nix versions). If substitute is set, the remote side tries to substitute
+// paths that aren't valid yet.
+func (c *Client) QueryValidPaths(paths []string, lock bool, substitute bool) ([]string, error) {
+	var valid []string
+
+	err := c.do(CmdQueryValidPaths, func() error {
+		enc := wire.NewEncoder(c.w)
+
+		for _, v := range []interface{}{lock, substitute} {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+
+		if err := enc.Encode(&pathSet{paths}); err != nil {
+			return err
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+
+		return wire.NewDecoder(c.r, maxStringSize).Decode(&valid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return valid, nil
+}
+
+// QueryPathInfos returns information about the valid ones of the given paths.
+func (c *Client) QueryPathInfos(paths []string) ([]*PathInfo, error) {
+	var infos []*PathInfo
+
+	err := c.do(CmdQueryPathInfos, func() error {
+		if err := wire.NewEncoder(c.w).Encode(&pathSet{paths}); err != nil {
+			return err
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+
+		dec := wire.NewDecoder(c.r, maxStringSize)
+
+		for {
+			var path string
+			if err := dec.Decode(&path); err != nil {
+				return err
+			}
+
+			// the list is terminated by an empty path
+			if path == "" {
+				return nil
+			}
+
+			info, err := c.readPathInfo(dec)
+			if err != nil {
+				return fmt.Errorf("unable to read path info of %v: %w", path, err)
+			}
+
+			info.Path = path
+			infos = append(infos, info)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+func (c *Client) readPathInfo(dec *wire.Decoder) (*PathInfo, error) {
+	var v0 pathInfoV0
+	if err := dec.Decode(&v0); err != nil {
+		return nil, err
+	}
+
+	info := &PathInfo{
+		Deriver:      v0.Deriver,
+		References:   v0.References,
+		DownloadSize: v0.DownloadSize,
+		NarSize:      v0.NarSize,
+	}
+
+	if ProtocolMinor(c.version) < 4 {
+		return info, nil
+	}
+
+	var v4 pathInfoV4
+	if err := dec.Decode(&v4); err != nil {
+		return nil, err
+	}
+
+	if v4.NarHash != "" {
+		h, err := nixhash.ParseAny(v4.NarHash, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nar hash: %w", err)
+		}
+
+		info.NarHash = &h.Hash
+	}
+
+	info.CA = v4.CA
+
+	for _, s := range v4.Signatures {
+		sig, err := signature.ParseSignature(s)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Signatures = append(info.Signatures, sig)
+	}
+
+	return info, nil
+}
+
+// DumpStorePath writes the NAR serialization of path to w.
+func (c *Client) DumpStorePath(path string, w io.Writer) error {
+	return c.do(CmdDumpStorePath, func() error {
+		if err := wire.WriteString(c.w, path); err != nil {
+			return err
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+
+		_, err := nar.Copy(w, c.r)
+
+		return err
+	})
+}
+
+// ImportPaths adds paths to the remote store. fn is called to write the
+// entries to import, the end marker is written afterwards.
+// This needs the remote side to be started with --write.
+func (c *Client) ImportPaths(fn func(ew *export.Writer) error) error {
+	return c.do(CmdImportPaths, func() error {
+		ew := export.NewWriter(c.w)
+
+		if err := fn(ew); err != nil {
+			return err
+		}
+
+		if err := ew.Close(); err != nil {
+			return err
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+
+		n, err := wire.ReadUint64(c.r)
+		if err != nil {
+			return err
+		}
+
+		if n != 1 {
+			return fmt.Errorf("remote side failed to import paths")
+		}
+
+		return nil
+	})
+}
+
+// ExportPaths requests the given paths in export format.
+// fn is called with a reader for the export, any entries not read by it are
+// skipped afterwards.
+func (c *Client) ExportPaths(paths []string, fn func(er *export.Reader) error) error {
+	return c.do(CmdExportPaths, func() error {
+		// obsolete signing flag
+		if err := wire.WriteUint64(c.w, 0); err != nil {
+			return err
+		}
+
+		if err := wire.NewEncoder(c.w).Encode(&pathSet{paths}); err != nil {
+			return err
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+
+		er := export.NewReader(c.r)
+
+		if err := fn(er); err != nil {
+			return err
+		}
+
+		for {
+			if _, err := er.Next(io.Discard); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+
+				return err
+			}
+		}
+	})
+}
+
+// BuildDerivation builds the outputs of drv on the remote side.
+// The inputs of drv need to be valid there already.
+// A failed build is reported in the result, not as an error.
+func (c *Client) BuildDerivation(drvPath string, drv *derivation.Derivation, opts *BuildOptions) (*BuildResult, error) {
+	var result BuildResult
+
+	err := c.do(CmdBuildDerivation, func() error {
+		enc := wire.NewEncoder(c.w)
+
+		if err := enc.Encode(drvPath); err != nil {
+			return err
+		}
+
+		if err := enc.Encode(newBasicDerivation(drv)); err != nil {
+			return err
+		}
+
+		if err := c.writeBuildOptions(enc, opts); err != nil {
+			return err
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+
+		return c.readBuildResult(&result)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *Client) writeBuildOptions(enc *wire.Encoder, opts *BuildOptions) error {
+	if opts == nil {
+		opts = &BuildOptions{}
+	}
+
+	fields := []interface{}{
+		uint64(opts.MaxSilentTime / time.Second),
+		uint64(opts.BuildTimeout / time.Second),
+	}
+
+	if ProtocolMinor(c.version) >= 2 {
+		fields = append(fields, opts.MaxLogSize)
+	}
+
+	if ProtocolMinor(c.version) >= 3 {
+		// obsolete nrRepeats and enforceDeterminism
+		fields = append(fields, uint64(0), false)
+	}
+
+	if ProtocolMinor(c.version) >= 7 {
+		fields = append(fields, opts.KeepFailed)
+	}
+
+	for _, f := range fields {
+		if err := enc.Encode(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) readBuildResult(result *BuildResult) error {
+	dec := wire.NewDecoder(c.r, maxStringSize)
+
+	if err := dec.Decode(&result.Status); err != nil {
+		return err
+	}
+
+	if err := dec.Decode(&result.ErrorMsg); err != nil {
+		return err
+	}
+
+	if ProtocolMinor(c.version) >= 3 {
+		var v3 buildResultV3
+		if err := dec.Decode(&v3); err != nil {
+			return err
+		}
+
+		result.TimesBuilt = v3.TimesBuilt
+		result.IsNonDeterministic = v3.IsNonDeterministic
+
+		if v3.StartTime != 0 {
+			result.StartTime = time.Unix(int64(v3.StartTime), 0) //nolint:gosec
+		}
+
+		if v3.StopTime != 0 {
+			result.StopTime = time.Unix(int64(v3.StopTime), 0) //nolint:gosec
+		}
+	}
+
+	if ProtocolMinor(c.version) >= 6 {
+		if err := dec.Decode(&result.BuiltOutputs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
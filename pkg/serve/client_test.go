@@ -0,0 +1,608 @@
+package serve
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/derivation"
+	"github.com/nix-community/go-nix/pkg/export"
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	pathHello = "/nix/store/knn6wc1a89c47yb70qwv56rmxylia6wx-hello-2.12"
+	pathGlibc = "/nix/store/7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc-glibc-2.27"
+	pathDrv   = "/nix/store/0hm2f1psjpcwg8fijsmr4wwxrx59s092-bar.drv"
+	pathBar   = "/nix/store/4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar"
+
+	sigHello = "cache.nixos.org-1:TsTTb3WGTZKphvYdBHXwo6weVILmTytUjLB+vcX89fOjjRicCHmKA4RCPMVLkj6TMJ4GMX3HPVWRdD1hkeKZBQ=="
+)
+
+// fakeServer implements the remote side of the protocol, for a store kept
+// in memory.
+type fakeServer struct {
+	version  uint64
+	nars     map[string][]byte
+	imported []*export.Entry
+
+	// the arguments of the last commands.
+	lock, substitute bool
+	drvPath          string
+	drv              *basicDerivation
+	opts             []uint64
+}
+
+func newFakeServer(t *testing.T, version uint64) *fakeServer {
+	t.Helper()
+
+	return &fakeServer{
+		version: version,
+		nars: map[string][]byte{
+			pathGlibc: genNar(t, "glibc"),
+			pathHello: genNar(t, "Hello, world!"),
+		},
+	}
+}
+
+// info returns the path info for path.
+func (s *fakeServer) info(path string) *PathInfo {
+	narContents := s.nars[path]
+	narHash := sha256.Sum256(narContents)
+
+	info := &PathInfo{
+		Path:         path,
+		References:   []string{},
+		DownloadSize: uint64(len(narContents)),
+		NarSize:      uint64(len(narContents)),
+	}
+
+	if path == pathHello {
+		info.Deriver = pathDrv
+		info.References = []string{pathGlibc, pathHello}
+	}
+
+	if ProtocolMinor(s.version) >= 4 {
+		info.NarHash = nixhash.MustNewHash(nixhash.SHA256, narHash[:])
+
+		if path == pathHello {
+			sig, err := signature.ParseSignature(sigHello)
+			if err != nil {
+				panic(err)
+			}
+
+			info.Signatures = []signature.Signature{sig}
+		}
+	}
+
+	return info
+}
+
+func (s *fakeServer) serve(rw io.ReadWriter) error {
+	r := bufio.NewReader(rw)
+	w := bufio.NewWriter(rw)
+
+	magic, err := wire.ReadUint64(r)
+	if err != nil {
+		return err
+	}
+
+	if magic != ServeMagic1 {
+		return fmt.Errorf("invalid magic %x", magic)
+	}
+
+	for _, n := range []uint64{ServeMagic2, s.version} {
+		if err := wire.WriteUint64(w, n); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := wire.ReadUint64(r); err != nil {
+		return err
+	}
+
+	for {
+		cmd, err := wire.ReadUint64(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		if err := s.handle(Command(cmd), r, w); err != nil {
+			return err
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+//nolint:cyclop
+func (s *fakeServer) handle(cmd Command, r io.Reader, w io.Writer) error {
+	dec := wire.NewDecoder(r, maxStringSize)
+	enc := wire.NewEncoder(w)
+
+	var paths []string
+
+	switch cmd {
+	case CmdQueryValidPaths:
+		if err := dec.Decode(&s.lock); err != nil {
+			return err
+		}
+
+		if err := dec.Decode(&s.substitute); err != nil {
+			return err
+		}
+
+		if err := dec.Decode(&paths); err != nil {
+			return err
+		}
+
+		valid := []string{}
+
+		for _, p := range paths {
+			if _, ok := s.nars[p]; ok {
+				valid = append(valid, p)
+			}
+		}
+
+		return enc.Encode(&pathSet{valid})
+
+	case CmdQueryPathInfos:
+		if err := dec.Decode(&paths); err != nil {
+			return err
+		}
+
+		for _, p := range paths {
+			if _, ok := s.nars[p]; !ok {
+				continue
+			}
+
+			info := s.info(p)
+
+			if err := enc.Encode(p); err != nil {
+				return err
+			}
+
+			if err := enc.Encode(&pathInfoV0{info.Deriver, info.References, info.DownloadSize, info.NarSize}); err != nil {
+				return err
+			}
+
+			if ProtocolMinor(s.version) >= 4 {
+				v4 := pathInfoV4{
+					NarHash:    info.NarHash.Format(nixhash.NixBase32, true),
+					Signatures: []string{},
+				}
+
+				for _, sig := range info.Signatures {
+					v4.Signatures = append(v4.Signatures, sig.String())
+				}
+
+				if err := enc.Encode(&v4); err != nil {
+					return err
+				}
+			}
+		}
+
+		return enc.Encode("")
+
+	case CmdDumpStorePath:
+		var path string
+		if err := dec.Decode(&path); err != nil {
+			return err
+		}
+
+		narContents, ok := s.nars[path]
+		if !ok {
+			// like Nix, just give up.
+			return fmt.Errorf("path '%s' is not valid", path)
+		}
+
+		_, err := w.Write(narContents)
+
+		return err
+
+	case CmdImportPaths:
+		er := export.NewReader(r)
+
+		for {
+			var buf bytes.Buffer
+
+			e, err := er.Next(&buf)
+			if err != nil {
+				if err == io.EOF {
+					return enc.Encode(uint64(1))
+				}
+
+				return err
+			}
+
+			s.imported = append(s.imported, e)
+			s.nars[e.Path] = buf.Bytes()
+		}
+
+	case CmdExportPaths:
+		var signing uint64
+		if err := dec.Decode(&signing); err != nil {
+			return err
+		}
+
+		if err := dec.Decode(&paths); err != nil {
+			return err
+		}
+
+		ew := export.NewWriter(w)
+
+		for _, p := range paths {
+			info := s.info(p)
+
+			if err := ew.WriteEntry(&export.Entry{
+				Path:       p,
+				References: info.References,
+				Deriver:    info.Deriver,
+			}, bytes.NewReader(s.nars[p])); err != nil {
+				return err
+			}
+		}
+
+		return ew.Close()
+
+	case CmdBuildDerivation:
+		if err := dec.Decode(&s.drvPath); err != nil {
+			return err
+		}
+
+		s.drv = &basicDerivation{}
+		if err := dec.Decode(s.drv); err != nil {
+			return err
+		}
+
+		numOpts := 2
+
+		switch minor := ProtocolMinor(s.version); {
+		case minor >= 7:
+			numOpts = 6
+		case minor >= 3:
+			numOpts = 5
+		case minor >= 2:
+			numOpts = 3
+		}
+
+		s.opts = make([]uint64, numOpts)
+		for i := range s.opts {
+			if err := dec.Decode(&s.opts[i]); err != nil {
+				return err
+			}
+		}
+
+		if err := enc.Encode(BuildStatusBuilt); err != nil {
+			return err
+		}
+
+		if err := enc.Encode(""); err != nil {
+			return err
+		}
+
+		if ProtocolMinor(s.version) >= 3 {
+			if err := enc.Encode(&buildResultV3{1, false, 1700000000, 1700000042}); err != nil {
+				return err
+			}
+		}
+
+		if ProtocolMinor(s.version) >= 6 {
+			return enc.Encode(map[string]string{"sha256:1234!out": `{"outPath":"` + pathBar[11:] + `"}`})
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unknown command %d", cmd)
+	}
+}
+
+// newTestClient serves s on one end of a pipe, and returns a client
+// connected to the other end.
+func newTestClient(t *testing.T, s *fakeServer) *Client {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+
+	go func() {
+		// errors are seen by the client as the connection being closed.
+		_ = s.serve(serverConn)
+		serverConn.Close()
+	}()
+
+	c, err := NewClient(clientConn)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		c.Close()
+	})
+
+	return c
+}
+
+func genNar(t *testing.T, contents string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	nw, err := nar.NewWriter(&buf)
+	require.NoError(t, err)
+
+	require.NoError(t, nw.WriteHeader(&nar.Header{
+		Path: "/",
+		Type: nar.TypeRegular,
+		Size: int64(len(contents)),
+	}))
+
+	_, err = nw.Write([]byte(contents))
+	require.NoError(t, err)
+
+	require.NoError(t, nw.Close())
+
+	return buf.Bytes()
+}
+
+func TestHandshake(t *testing.T) {
+	c := newTestClient(t, newFakeServer(t, ProtocolVersion))
+	assert.Equal(t, uint64(ProtocolVersion), c.ProtocolVersion())
+
+	// the lower version is used
+	c = newTestClient(t, newFakeServer(t, 2<<8|5))
+	assert.Equal(t, uint64(2<<8|5), c.ProtocolVersion())
+
+	c = newTestClient(t, newFakeServer(t, 2<<8|9))
+	assert.Equal(t, uint64(ProtocolVersion), c.ProtocolVersion())
+
+	t.Run("unsupported version", func(t *testing.T) {
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+
+		go func() {
+			_ = newFakeServer(t, 3<<8|0).serve(serverConn)
+			serverConn.Close()
+		}()
+
+		_, err := NewClient(clientConn)
+		assert.ErrorContains(t, err, "unsupported protocol version 3.0")
+	})
+
+	t.Run("not the serve protocol", func(t *testing.T) {
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+
+		go func() {
+			// read the magic and version sent by the client, then print a greeting.
+			_, _ = io.CopyN(io.Discard, serverConn, 16)
+			_, _ = io.Copy(serverConn, bytes.NewReader([]byte("Welcome to the server!\n")))
+			serverConn.Close()
+		}()
+
+		_, err := NewClient(clientConn)
+		assert.ErrorContains(t, err, "protocol mismatch")
+	})
+}
+
+func TestQueries(t *testing.T) {
+	for _, version := range []uint64{ProtocolVersion, 2<<8 | 3} {
+		t.Run(fmt.Sprintf("2.%d", ProtocolMinor(version)), func(t *testing.T) {
+			s := newFakeServer(t, version)
+			c := newTestClient(t, s)
+
+			valid, err := c.QueryValidPaths([]string{pathHello, pathBar, pathGlibc}, true, false)
+			require.NoError(t, err)
+			assert.Equal(t, []string{pathGlibc, pathHello}, valid)
+			assert.True(t, s.lock)
+			assert.False(t, s.substitute)
+
+			infos, err := c.QueryPathInfos([]string{pathHello, pathBar, pathGlibc})
+			require.NoError(t, err)
+			assert.Equal(t, []*PathInfo{s.info(pathGlibc), s.info(pathHello)}, infos)
+
+			if ProtocolMinor(version) < 4 {
+				assert.Nil(t, infos[1].NarHash)
+			}
+		})
+	}
+}
+
+func TestPaths(t *testing.T) {
+	s := newFakeServer(t, ProtocolVersion)
+	c := newTestClient(t, s)
+
+	var buf bytes.Buffer
+
+	require.NoError(t, c.DumpStorePath(pathHello, &buf))
+	assert.Equal(t, s.nars[pathHello], buf.Bytes())
+
+	barNar := genNar(t, "bar")
+
+	require.NoError(t, c.ImportPaths(func(ew *export.Writer) error {
+		return ew.WriteEntry(&export.Entry{
+			Path:       pathBar,
+			References: []string{pathGlibc},
+			Deriver:    pathDrv,
+		}, bytes.NewReader(barNar))
+	}))
+
+	require.Len(t, s.imported, 1)
+	assert.Equal(t, pathBar, s.imported[0].Path)
+	assert.Equal(t, []string{pathGlibc}, s.imported[0].References)
+	assert.Equal(t, barNar, s.nars[pathBar])
+
+	// only read the first entry, the rest is skipped
+	var entries []*export.Entry
+
+	require.NoError(t, c.ExportPaths([]string{pathHello, pathGlibc}, func(er *export.Reader) error {
+		buf.Reset()
+
+		e, err := er.Next(&buf)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, e)
+
+		return nil
+	}))
+
+	require.Len(t, entries, 1)
+	assert.Equal(t, pathGlibc, entries[0].Path)
+	assert.Equal(t, s.nars[pathGlibc], buf.Bytes())
+
+	t.Run("broken connection", func(t *testing.T) {
+		// the remote side gives up on invalid paths
+		err := c.DumpStorePath(pathDrv, io.Discard)
+		assert.Error(t, err)
+
+		_, err = c.QueryValidPaths([]string{pathHello}, false, false)
+		assert.ErrorContains(t, err, "connection is broken")
+	})
+}
+
+func genDerivation() *derivation.Derivation {
+	return &derivation.Derivation{
+		Outputs: map[string]*derivation.Output{
+			"out": {Path: pathBar},
+			"dev": {Path: pathHello},
+		},
+		InputSources: []string{pathHello, pathGlibc},
+		Platform:     "x86_64-linux",
+		Builder:      "/bin/sh",
+		Arguments:    []string{"-c", "echo bar > $out"},
+		Env:          map[string]string{"out": pathBar, "dev": pathHello},
+	}
+}
+
+func TestBuildDerivation(t *testing.T) {
+	opts := &BuildOptions{
+		MaxSilentTime: time.Hour,
+		BuildTimeout:  2 * time.Hour,
+		MaxLogSize:    1 << 20,
+		KeepFailed:    true,
+	}
+
+	cases := []struct {
+		Version uint64
+		Opts    []uint64
+		Result  *BuildResult
+	}{{
+		Version: ProtocolVersion,
+		Opts:    []uint64{3600, 7200, 1 << 20, 0, 0, 1},
+		Result: &BuildResult{
+			Status:       BuildStatusBuilt,
+			TimesBuilt:   1,
+			StartTime:    time.Unix(1700000000, 0),
+			StopTime:     time.Unix(1700000042, 0),
+			BuiltOutputs: map[string]string{"sha256:1234!out": `{"outPath":"4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar"}`},
+		},
+	}, {
+		Version: 2<<8 | 3,
+		Opts:    []uint64{3600, 7200, 1 << 20, 0, 0},
+		Result: &BuildResult{
+			Status:     BuildStatusBuilt,
+			TimesBuilt: 1,
+			StartTime:  time.Unix(1700000000, 0),
+			StopTime:   time.Unix(1700000042, 0),
+		},
+	}, {
+		Version: 2<<8 | 0,
+		Opts:    []uint64{3600, 7200},
+		Result:  &BuildResult{Status: BuildStatusBuilt},
+	}}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("2.%d", ProtocolMinor(tc.Version)), func(t *testing.T) {
+			s := newFakeServer(t, tc.Version)
+			c := newTestClient(t, s)
+
+			result, err := c.BuildDerivation(pathDrv, genDerivation(), opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.Result, result)
+			assert.True(t, result.Status.Success())
+
+			assert.Equal(t, pathDrv, s.drvPath)
+			assert.Equal(t, tc.Opts, s.opts)
+
+			// outputs and input sources are sorted
+			assert.Equal(t, &basicDerivation{
+				Outputs: []basicDerivationOutput{
+					{Name: "dev", Path: pathHello},
+					{Name: "out", Path: pathBar},
+				},
+				InputSources: []string{pathGlibc, pathHello},
+				Platform:     "x86_64-linux",
+				Builder:      "/bin/sh",
+				Arguments:    []string{"-c", "echo bar > $out"},
+				Env:          map[string]string{"out": pathBar, "dev": pathHello},
+			}, s.drv)
+		})
+	}
+}
+
+func TestBuildStatus(t *testing.T) {
+	assert.Equal(t, "TimedOut", BuildStatusTimedOut.String())
+	assert.Equal(t, "BuildStatus(42)", BuildStatus(42).String())
+	assert.False(t, BuildStatusTimedOut.Success())
+	assert.True(t, BuildStatusResolvesToAlreadyValid.Success())
+}
+
+// TestHelperProcess isn't a real test, it's used as the remote side by TestStartCommand.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	err := newFakeServer(t, ProtocolVersion).serve(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+func TestStartCommand(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+	cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1")
+
+	c, err := StartCommand(cmd)
+	require.NoError(t, err)
+
+	valid, err := c.QueryValidPaths([]string{pathHello}, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{pathHello}, valid)
+
+	// the remote side exits cleanly once stdin is closed
+	assert.NoError(t, c.Close())
+
+	t.Run("ssh", func(t *testing.T) {
+		cmd := SSHCommand("builder", true)
+		assert.Equal(t, []string{"ssh", "-x", "-a", "builder", "--", "nix-store", "--serve", "--write"}, cmd.Args)
+	})
+}
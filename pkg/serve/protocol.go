@@ -0,0 +1,102 @@
+// Package serve implements a client for the protocol spoken by
+// `nix-store --serve`, which Nix uses to talk to ssh:// stores and remote
+// builders.
+//
+// The protocol runs over any io.ReadWriter, usually the stdin and stdout of
+// an ssh process. Unlike the worker protocol, the remote side doesn't send
+// error messages: it prints them to stderr and exits, so any error leaves the
+// client unusable.
+package serve
+
+import "fmt"
+
+const (
+	ServeMagic1 = 0x390c9deb
+	ServeMagic2 = 0x5452eecb
+
+	// ProtocolVersion is the protocol version implemented by the client.
+	ProtocolVersion = 2<<8 | 7
+)
+
+// ProtocolMajor returns the major part of a protocol version.
+func ProtocolMajor(version uint64) uint64 {
+	return version & 0xff00
+}
+
+// ProtocolMinor returns the minor part of a protocol version.
+func ProtocolMinor(version uint64) uint64 {
+	return version & 0x00ff
+}
+
+// Command is a command sent by the client.
+type Command uint64
+
+const (
+	CmdQueryValidPaths Command = 1
+	CmdQueryPathInfos  Command = 2
+	CmdDumpStorePath   Command = 3
+	CmdImportPaths     Command = 4
+	CmdExportPaths     Command = 5
+	CmdBuildPaths      Command = 6
+	CmdQueryClosure    Command = 7
+	CmdBuildDerivation Command = 8
+	CmdAddToStoreNar   Command = 9
+)
+
+// BuildStatus is the outcome of a build.
+type BuildStatus uint64
+
+const (
+	BuildStatusBuilt                  BuildStatus = 0
+	BuildStatusSubstituted            BuildStatus = 1
+	BuildStatusAlreadyValid           BuildStatus = 2
+	BuildStatusPermanentFailure       BuildStatus = 3
+	BuildStatusInputRejected          BuildStatus = 4
+	BuildStatusOutputRejected         BuildStatus = 5
+	BuildStatusTransientFailure       BuildStatus = 6
+	BuildStatusCachedFailure          BuildStatus = 7
+	BuildStatusTimedOut               BuildStatus = 8
+	BuildStatusMiscFailure            BuildStatus = 9
+	BuildStatusDependencyFailed       BuildStatus = 10
+	BuildStatusLogLimitExceeded       BuildStatus = 11
+	BuildStatusNotDeterministic       BuildStatus = 12
+	BuildStatusResolvesToAlreadyValid BuildStatus = 13
+	BuildStatusNoSubstituters         BuildStatus = 14
+)
+
+//nolint:gochecknoglobals
+var buildStatusNames = []string{
+	"Built",
+	"Substituted",
+	"AlreadyValid",
+	"PermanentFailure",
+	"InputRejected",
+	"OutputRejected",
+	"TransientFailure",
+	"CachedFailure",
+	"TimedOut",
+	"MiscFailure",
+	"DependencyFailed",
+	"LogLimitExceeded",
+	"NotDeterministic",
+	"ResolvesToAlreadyValid",
+	"NoSubstituters",
+}
+
+func (s BuildStatus) String() string {
+	if int(s) < len(buildStatusNames) {
+		return buildStatusNames[s]
+	}
+
+	return fmt.Sprintf("BuildStatus(%d)", uint64(s))
+}
+
+// Success returns true if the build outputs are valid.
+func (s BuildStatus) Success() bool {
+	switch s { //nolint:exhaustive
+	case BuildStatusBuilt, BuildStatusSubstituted, BuildStatusAlreadyValid, BuildStatusResolvesToAlreadyValid:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,134 @@
+package serve
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/derivation"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+)
+
+// pathSet is a set of store paths, sent in sorted order.
+type pathSet struct {
+	Paths []string `wire:"set"`
+}
+
+// PathInfo describes a valid path on the remote side.
+type PathInfo struct {
+	Path       string
+	Deriver    string // Empty if unknown
+	References []string
+	// DownloadSize is the size of the path when substituting it,
+	// which is NarSize for the stores serving this protocol.
+	DownloadSize uint64
+	NarSize      uint64
+
+	// The following fields are only sent by remotes speaking protocol
+	// version 2.4 or newer.
+
+	NarHash    *nixhash.Hash
+	CA         string // Empty if not content-addressed
+	Signatures []signature.Signature
+}
+
+// pathInfoV0 is the part of a path info sent in all protocol versions,
+// following the path.
+type pathInfoV0 struct {
+	Deriver      string
+	References   []string `wire:"set"`
+	DownloadSize uint64
+	NarSize      uint64
+}
+
+// pathInfoV4 is the part of a path info sent in protocol version 2.4 or newer.
+type pathInfoV4 struct {
+	NarHash    string
+	CA         string
+	Signatures []string `wire:"set"`
+}
+
+// BuildOptions are sent along with a build request.
+type BuildOptions struct {
+	// MaxSilentTime is the maximum time the builder may not produce output.
+	// Zero means no limit.
+	MaxSilentTime time.Duration
+	// BuildTimeout is the maximum time a build may take. Zero means no limit.
+	BuildTimeout time.Duration
+	// MaxLogSize is the maximum size of the build log. Zero means no limit.
+	MaxLogSize uint64
+	// KeepFailed keeps the build directory of failed builds.
+	KeepFailed bool
+}
+
+// BuildResult describes the outcome of a build.
+type BuildResult struct {
+	Status   BuildStatus
+	ErrorMsg string
+
+	// The following fields are only sent by remotes speaking protocol
+	// version 2.3 or newer.
+
+	TimesBuilt         uint64
+	IsNonDeterministic bool
+	StartTime          time.Time
+	StopTime           time.Time
+
+	// BuiltOutputs maps the ids of the outputs built (like `sha256:…!out`)
+	// to their realisations, in JSON.
+	// It's only sent by remotes speaking protocol version 2.6 or newer.
+	BuiltOutputs map[string]string
+}
+
+// buildResultV3 is the part of a build result sent in protocol version 2.3
+// or newer.
+type buildResultV3 struct {
+	TimesBuilt         uint64
+	IsNonDeterministic bool
+	StartTime          uint64
+	StopTime           uint64
+}
+
+// basicDerivation is a derivation without its input derivations,
+// in the layout sent to the remote side.
+type basicDerivation struct {
+	Outputs      []basicDerivationOutput
+	InputSources []string `wire:"set"`
+	Platform     string
+	Builder      string
+	Arguments    []string
+	Env          map[string]string
+}
+
+type basicDerivationOutput struct {
+	Name          string
+	Path          string
+	HashAlgorithm string
+	Hash          string
+}
+
+func newBasicDerivation(drv *derivation.Derivation) *basicDerivation {
+	bd := &basicDerivation{
+		Outputs:      make([]basicDerivationOutput, 0, len(drv.Outputs)),
+		InputSources: drv.InputSources,
+		Platform:     drv.Platform,
+		Builder:      drv.Builder,
+		Arguments:    drv.Arguments,
+		Env:          drv.Env,
+	}
+
+	for name, o := range drv.Outputs {
+		bd.Outputs = append(bd.Outputs, basicDerivationOutput{
+			Name:          name,
+			Path:          o.Path,
+			HashAlgorithm: o.HashAlgorithm,
+			Hash:          o.Hash,
+		})
+	}
+
+	sort.Slice(bd.Outputs, func(i, j int) bool {
+		return bd.Outputs[i].Name < bd.Outputs[j].Name
+	})
+
+	return bd
+}
@@ -2,4 +2,10 @@
 insert or replace into Cache(domain, key, value, timestamp) values (?, ?, ?, ?);
 
 -- name: QueryCache :many
-select value, timestamp from Cache where domain = ? and key = ?;
\ No newline at end of file
+select value, timestamp from Cache where domain = ? and key = ?;
+
+-- name: QueryEntries :many
+select domain, key, value, timestamp from Cache order by domain, key;
+
+-- name: DeleteOlderThan :execrows
+delete from Cache where timestamp < ?;
\ No newline at end of file
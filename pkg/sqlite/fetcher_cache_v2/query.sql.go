@@ -9,6 +9,18 @@ import (
 	"context"
 )
 
+const deleteOlderThan = `-- name: DeleteOlderThan :execrows
+delete from Cache where timestamp < ?
+`
+
+func (q *Queries) DeleteOlderThan(ctx context.Context, timestamp int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteOlderThan, timestamp)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const queryCache = `-- name: QueryCache :many
 select value, timestamp from Cache where domain = ? and key = ?
 `
@@ -46,6 +58,38 @@ func (q *Queries) QueryCache(ctx context.Context, arg QueryCacheParams) ([]Query
 	return items, nil
 }
 
+const queryEntries = `-- name: QueryEntries :many
+select domain, key, value, timestamp from Cache order by domain, key
+`
+
+func (q *Queries) QueryEntries(ctx context.Context) ([]Cache, error) {
+	rows, err := q.db.QueryContext(ctx, queryEntries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Cache
+	for rows.Next() {
+		var i Cache
+		if err := rows.Scan(
+			&i.Domain,
+			&i.Key,
+			&i.Value,
+			&i.Timestamp,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const upsertCache = `-- name: UpsertCache :exec
 insert or replace into Cache(domain, key, value, timestamp) values (?, ?, ?, ?)
 `
@@ -0,0 +1,242 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/sqlite"
+	"github.com/nix-community/go-nix/pkg/sqlite/binary_cache_v6"
+	"github.com/nix-community/go-nix/pkg/sqlite/nix_v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitNixV10(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "db")
+
+	db, queries, err := sqlite.InitNixV10(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, queries.RegisterValidPath(ctx, nix_v10.RegisterValidPathParams{
+		Path: "/nix/store/4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar",
+		Hash: "sha256:08813cbee9903c62be4c5027726a418a300da4500b2d369d3af9286f4815ceba",
+	}))
+	require.NoError(t, db.Close())
+
+	schema, err := os.ReadFile(filepath.Join(dir, "schema"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "10", string(schema))
+	}
+
+	t.Run("reopen", func(t *testing.T) {
+		db, queries, err := sqlite.NixV10Strict(filepath.Join(dir, "db.sqlite"))
+		require.NoError(t, err)
+		defer db.Close()
+
+		paths, err := queries.QueryValidPaths(ctx)
+		if assert.NoError(t, err) {
+			assert.Len(t, paths, 1)
+		}
+	})
+
+	t.Run("init again", func(t *testing.T) {
+		db, _, err := sqlite.InitNixV10(dir)
+		if assert.NoError(t, err) {
+			db.Close()
+		}
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "schema"), []byte("11"), 0o644))
+
+		_, _, err := sqlite.NixV10Strict(filepath.Join(dir, "db.sqlite"))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "schema is version 11")
+		}
+
+		_, _, err = sqlite.InitNixV10(dir)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing schema file", func(t *testing.T) {
+		require.NoError(t, os.Remove(filepath.Join(dir, "schema")))
+
+		_, _, err := sqlite.NixV10Strict(filepath.Join(dir, "db.sqlite"))
+		assert.Error(t, err)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, _, err := sqlite.NixV10Strict(filepath.Join(t.TempDir(), "db.sqlite"))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "is it initialised")
+		}
+	})
+}
+
+func TestInitNixV10CA(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "db")
+	outPath := "/nix/store/4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar"
+	drvHash := "sha256:ba17bbf4d63d0fd4f0ab29e65c5fa0a1b4eb0d2e3eee7e32e1f64ec4d4b1fb69"
+
+	// a store database without CA support can be extended
+	db, _, err := sqlite.InitNixV10(dir)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, _, err = sqlite.NixV10CA(filepath.Join(dir, "db.sqlite"))
+	assert.Error(t, err)
+
+	db, queries, err := sqlite.InitNixV10CA(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, queries.RegisterValidPath(ctx, nix_v10.RegisterValidPathParams{
+		Path: outPath,
+		Hash: "sha256:08813cbee9903c62be4c5027726a418a300da4500b2d369d3af9286f4815ceba",
+	}))
+	require.NoError(t, queries.RegisterRealisedOutput(ctx, nix_v10.RegisterRealisedOutputParams{
+		Drvpath:    drvHash,
+		Outputname: "out",
+		Path:       outPath,
+	}))
+	require.NoError(t, db.Close())
+
+	schema, err := os.ReadFile(filepath.Join(dir, "ca-schema"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "4", string(schema))
+	}
+
+	t.Run("reopen", func(t *testing.T) {
+		db, queries, err := sqlite.NixV10CA(filepath.Join(dir, "db.sqlite"))
+		require.NoError(t, err)
+		defer db.Close()
+
+		row, err := queries.QueryRealisedOutput(ctx, nix_v10.QueryRealisedOutputParams{
+			Drvpath:    drvHash,
+			Outputname: "out",
+		})
+		if assert.NoError(t, err) {
+			assert.Equal(t, outPath, row.Path)
+		}
+	})
+
+	t.Run("init again", func(t *testing.T) {
+		db, _, err := sqlite.InitNixV10CA(dir)
+		if assert.NoError(t, err) {
+			db.Close()
+		}
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "ca-schema"), []byte("5"), 0o644))
+
+		_, _, err := sqlite.NixV10CA(filepath.Join(dir, "db.sqlite"))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "CA schema is version 5")
+		}
+
+		_, _, err = sqlite.InitNixV10CA(dir)
+		assert.Error(t, err)
+	})
+}
+
+func TestInitBinaryCacheV6(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "binary-cache-v6.sqlite")
+
+	db, queries, err := sqlite.InitBinaryCacheV6(path)
+	require.NoError(t, err)
+
+	_, err = queries.InsertCache(ctx, binary_cache_v6.InsertCacheParams{
+		Url:      "https://cache.nixos.org",
+		Storedir: "/nix/store",
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, _, err = sqlite.BinaryCacheV6Strict(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	t.Run("unsupported version", func(t *testing.T) {
+		db, err := sql.Open("sqlite3", path)
+		require.NoError(t, err)
+
+		_, err = db.Exec("PRAGMA user_version = 7")
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+
+		_, _, err = sqlite.BinaryCacheV6Strict(path)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "schema version 7")
+		}
+	})
+
+	t.Run("created by Nix", func(t *testing.T) {
+		db, err := sql.Open("sqlite3", path)
+		require.NoError(t, err)
+
+		_, err = db.Exec("PRAGMA user_version = 0")
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+
+		db, _, err = sqlite.BinaryCacheV6Strict(path)
+		if assert.NoError(t, err) {
+			db.Close()
+		}
+	})
+}
+
+func TestInitCaches(t *testing.T) {
+	dir := t.TempDir()
+
+	db, _, err := sqlite.InitEvalCacheV5(filepath.Join(dir, "eval-cache.sqlite"))
+	if assert.NoError(t, err) {
+		db.Close()
+	}
+
+	db, _, err = sqlite.InitFetcherCacheV2(filepath.Join(dir, "fetcher-cache-v2.sqlite"))
+	if assert.NoError(t, err) {
+		db.Close()
+	}
+
+	// a fetcher cache isn't an eval cache
+	_, _, err = sqlite.EvalCacheV5Strict(filepath.Join(dir, "fetcher-cache-v2.sqlite"))
+	assert.Error(t, err)
+}
+
+func TestOpenLenient(t *testing.T) {
+	// the openers without checks work on empty databases
+	for name, open := range map[string]func(string) (*sql.DB, error){
+		"BinaryCacheV6": func(dsn string) (*sql.DB, error) {
+			db, _, err := sqlite.BinaryCacheV6(dsn)
+
+			return db, err
+		},
+		"EvalCacheV5": func(dsn string) (*sql.DB, error) {
+			db, _, err := sqlite.EvalCacheV5(dsn)
+
+			return db, err
+		},
+		"FetcherCacheV2": func(dsn string) (*sql.DB, error) {
+			db, _, err := sqlite.FetcherCacheV2(dsn)
+
+			return db, err
+		},
+		"NixV10": func(dsn string) (*sql.DB, error) {
+			db, _, err := sqlite.NixV10(dsn)
+
+			return db, err
+		},
+	} {
+		db, err := open(":memory:")
+		if assert.NoError(t, err, name) {
+			assert.NoError(t, db.Ping(), name)
+			db.Close()
+		}
+	}
+}
@@ -0,0 +1,24 @@
+CREATE TABLE Realisations (
+    id integer primary key autoincrement not null,
+    drvPath text not null,
+    outputName text not null, -- symbolic output id, usually "out"
+    outputPath integer not null,
+    signatures text, -- space-separated list
+    foreign key (outputPath) references ValidPaths(id) on delete cascade
+);
+CREATE INDEX IndexRealisations on Realisations(drvPath, outputName);
+CREATE TRIGGER DeleteSelfRefsViaRealisations before delete on ValidPaths
+  begin
+    delete from RealisationsRefs where realisationReference in (
+      select id from Realisations where outputPath = old.id
+    );
+  end;
+CREATE TABLE RealisationsRefs (
+    referrer integer not null,
+    realisationReference integer,
+    foreign key (referrer) references Realisations(id) on delete cascade,
+    foreign key (realisationReference) references Realisations(id) on delete restrict
+);
+CREATE INDEX IndexRealisationsRefsRealisationReference on RealisationsRefs(realisationReference);
+CREATE INDEX IndexRealisationsRefs on RealisationsRefs(referrer);
+CREATE INDEX IndexRealisationsRefsOnOutputPath on Realisations(outputPath);
@@ -14,6 +14,19 @@ type DerivationOutput struct {
 	Path string
 }
 
+type Realisation struct {
+	ID         int64
+	Drvpath    string
+	Outputname string
+	Outputpath int64
+	Signatures sql.NullString
+}
+
+type RealisationsRef struct {
+	Referrer             int64
+	Realisationreference sql.NullInt64
+}
+
 type Ref struct {
 	Referrer  int64
 	Reference int64
@@ -30,4 +30,35 @@ select v.id, v.path from DerivationOutputs d join ValidPaths v on d.drv = v.id w
 select id, path from DerivationOutputs where drv = ?;
 
 -- name: QueryPathFromHashPart :one
-select path from ValidPaths where path >= ? limit 1;
\ No newline at end of file
+select path from ValidPaths where path >= ? limit 1;
+
+-- name: QueryValidPaths :many
+select path from ValidPaths;
+
+-- name: RegisterRealisedOutput :exec
+insert into Realisations (drvPath, outputName, outputPath, signatures)
+values (?, ?, (select id from ValidPaths where path = ?), ?);
+
+-- name: UpdateRealisedOutput :exec
+update Realisations set signatures = ? where drvPath = ? and outputName = ?;
+
+-- name: QueryRealisedOutput :one
+select Realisations.id, Output.path, Realisations.signatures from Realisations
+inner join ValidPaths as Output on Output.id = Realisations.outputPath
+where drvPath = ? and outputName = ?;
+
+-- name: QueryAllRealisedOutputs :many
+select outputName, Output.path from Realisations
+inner join ValidPaths as Output on Output.id = Realisations.outputPath
+where drvPath = ?;
+
+-- name: QueryRealisationReferences :many
+select drvPath, outputName from Realisations
+join RealisationsRefs on realisationReference = Realisations.id
+where referrer = ?;
+
+-- name: AddRealisationReference :exec
+insert or replace into RealisationsRefs (referrer, realisationReference) values (
+    (select id from Realisations where drvPath = ? and outputName = ?),
+    (select id from Realisations where drvPath = ? and outputName = ?)
+);
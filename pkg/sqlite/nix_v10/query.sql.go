@@ -25,6 +25,30 @@ func (q *Queries) AddDerivationOutput(ctx context.Context, arg AddDerivationOutp
 	return err
 }
 
+const addRealisationReference = `-- name: AddRealisationReference :exec
+insert or replace into RealisationsRefs (referrer, realisationReference) values (
+    (select id from Realisations where drvPath = ? and outputName = ?),
+    (select id from Realisations where drvPath = ? and outputName = ?)
+)
+`
+
+type AddRealisationReferenceParams struct {
+	Drvpath      string
+	Outputname   string
+	Drvpath_2    string
+	Outputname_2 string
+}
+
+func (q *Queries) AddRealisationReference(ctx context.Context, arg AddRealisationReferenceParams) error {
+	_, err := q.db.ExecContext(ctx, addRealisationReference,
+		arg.Drvpath,
+		arg.Outputname,
+		arg.Drvpath_2,
+		arg.Outputname_2,
+	)
+	return err
+}
+
 const addReference = `-- name: AddReference :exec
 insert or replace into Refs (referrer, reference) values (?, ?)
 `
@@ -48,6 +72,40 @@ func (q *Queries) InvalidatePath(ctx context.Context, path string) error {
 	return err
 }
 
+const queryAllRealisedOutputs = `-- name: QueryAllRealisedOutputs :many
+select outputName, Output.path from Realisations
+inner join ValidPaths as Output on Output.id = Realisations.outputPath
+where drvPath = ?
+`
+
+type QueryAllRealisedOutputsRow struct {
+	Outputname string
+	Path       string
+}
+
+func (q *Queries) QueryAllRealisedOutputs(ctx context.Context, drvpath string) ([]QueryAllRealisedOutputsRow, error) {
+	rows, err := q.db.QueryContext(ctx, queryAllRealisedOutputs, drvpath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []QueryAllRealisedOutputsRow
+	for rows.Next() {
+		var i QueryAllRealisedOutputsRow
+		if err := rows.Scan(&i.Outputname, &i.Path); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const queryDerivationOutputs = `-- name: QueryDerivationOutputs :many
 select id, path from DerivationOutputs where drv = ?
 `
@@ -121,6 +179,64 @@ func (q *Queries) QueryPathInfo(ctx context.Context, path string) (QueryPathInfo
 	return i, err
 }
 
+const queryRealisationReferences = `-- name: QueryRealisationReferences :many
+select drvPath, outputName from Realisations
+join RealisationsRefs on realisationReference = Realisations.id
+where referrer = ?
+`
+
+type QueryRealisationReferencesRow struct {
+	Drvpath    string
+	Outputname string
+}
+
+func (q *Queries) QueryRealisationReferences(ctx context.Context, referrer int64) ([]QueryRealisationReferencesRow, error) {
+	rows, err := q.db.QueryContext(ctx, queryRealisationReferences, referrer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []QueryRealisationReferencesRow
+	for rows.Next() {
+		var i QueryRealisationReferencesRow
+		if err := rows.Scan(&i.Drvpath, &i.Outputname); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const queryRealisedOutput = `-- name: QueryRealisedOutput :one
+select Realisations.id, Output.path, Realisations.signatures from Realisations
+inner join ValidPaths as Output on Output.id = Realisations.outputPath
+where drvPath = ? and outputName = ?
+`
+
+type QueryRealisedOutputParams struct {
+	Drvpath    string
+	Outputname string
+}
+
+type QueryRealisedOutputRow struct {
+	ID         int64
+	Path       string
+	Signatures sql.NullString
+}
+
+func (q *Queries) QueryRealisedOutput(ctx context.Context, arg QueryRealisedOutputParams) (QueryRealisedOutputRow, error) {
+	row := q.db.QueryRowContext(ctx, queryRealisedOutput, arg.Drvpath, arg.Outputname)
+	var i QueryRealisedOutputRow
+	err := row.Scan(&i.ID, &i.Path, &i.Signatures)
+	return i, err
+}
+
 const queryReferences = `-- name: QueryReferences :many
 select path from Refs join ValidPaths on reference = id where referrer = ?
 `
@@ -207,6 +323,55 @@ func (q *Queries) QueryValidDerivers(ctx context.Context, path string) ([]QueryV
 	return items, nil
 }
 
+const queryValidPaths = `-- name: QueryValidPaths :many
+select path from ValidPaths
+`
+
+func (q *Queries) QueryValidPaths(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, queryValidPaths)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		items = append(items, path)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const registerRealisedOutput = `-- name: RegisterRealisedOutput :exec
+insert into Realisations (drvPath, outputName, outputPath, signatures)
+values (?, ?, (select id from ValidPaths where path = ?), ?)
+`
+
+type RegisterRealisedOutputParams struct {
+	Drvpath    string
+	Outputname string
+	Path       string
+	Signatures sql.NullString
+}
+
+func (q *Queries) RegisterRealisedOutput(ctx context.Context, arg RegisterRealisedOutputParams) error {
+	_, err := q.db.ExecContext(ctx, registerRealisedOutput,
+		arg.Drvpath,
+		arg.Outputname,
+		arg.Path,
+		arg.Signatures,
+	)
+	return err
+}
+
 const registerValidPath = `-- name: RegisterValidPath :exec
 insert into ValidPaths (path, hash, registrationTime, deriver, narSize, ultimate, sigs, ca)
 values (?, ?, ?, ?, ?, ?, ?, ?)
@@ -261,3 +426,18 @@ func (q *Queries) UpdatePathInfo(ctx context.Context, arg UpdatePathInfoParams)
 	)
 	return err
 }
+
+const updateRealisedOutput = `-- name: UpdateRealisedOutput :exec
+update Realisations set signatures = ? where drvPath = ? and outputName = ?
+`
+
+type UpdateRealisedOutputParams struct {
+	Signatures sql.NullString
+	Drvpath    string
+	Outputname string
+}
+
+func (q *Queries) UpdateRealisedOutput(ctx context.Context, arg UpdateRealisedOutputParams) error {
+	_, err := q.db.ExecContext(ctx, updateRealisedOutput, arg.Signatures, arg.Drvpath, arg.Outputname)
+	return err
+}
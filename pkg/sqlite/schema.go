@@ -0,0 +1,266 @@
+package sqlite
+
+import (
+	"database/sql"
+	// used to embed the schemas.
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//go:embed binary_cache_v6/schema.sql
+var binaryCacheV6SQL string
+
+//go:embed eval_cache_v5/schema.sql
+var evalCacheV5SQL string
+
+//go:embed fetcher_cache_v2/schema.sql
+var fetcherCacheV2SQL string
+
+//go:embed nix_v10/schema.sql
+var nixV10SQL string
+
+//go:embed nix_v10/ca_schema.sql
+var nixCASQL string
+
+//nolint:gochecknoglobals
+var (
+	binaryCacheV6Schema = &schema{
+		name:        "binary cache",
+		version:     6,
+		sql:         binaryCacheV6SQL,
+		tables:      []string{"BinaryCaches", "NARs", "Realisations", "LastPurge"},
+		userVersion: true,
+	}
+
+	evalCacheV5Schema = &schema{
+		name:        "eval cache",
+		version:     5,
+		sql:         evalCacheV5SQL,
+		tables:      []string{"Attributes"},
+		userVersion: true,
+	}
+
+	fetcherCacheV2Schema = &schema{
+		name:        "fetcher cache",
+		version:     2,
+		sql:         fetcherCacheV2SQL,
+		tables:      []string{"Cache"},
+		userVersion: true,
+	}
+
+	// the version of the Nix store database is kept in the schema file
+	// next to it, not in user_version.
+	nixV10Schema = &schema{
+		name:       "Nix store",
+		version:    10,
+		sql:        nixV10SQL,
+		tables:     []string{"ValidPaths", "Refs", "DerivationOutputs"},
+		schemaFile: "schema",
+	}
+
+	// the tables for content-addressed derivations are an extension of the
+	// Nix store database, with their own version kept in ca-schema.
+	nixCASchema = &schema{
+		name:       "Nix store CA",
+		version:    4,
+		sql:        nixCASQL,
+		tables:     []string{"Realisations", "RealisationsRefs"},
+		schemaFile: "ca-schema",
+	}
+)
+
+// schema describes the schema of one of the Nix databases.
+type schema struct {
+	name    string // used in errors
+	version int
+	sql     string
+	// tables are the tables the schema creates, used to tell whether
+	// a database has been initialised.
+	tables []string
+	// userVersion is set if the version is recorded in the user_version pragma.
+	userVersion bool
+	// schemaFile is the name of the file next to the database the version
+	// is recorded in, if any.
+	schemaFile string
+}
+
+// create creates the schema if db doesn't contain any of its tables yet.
+func (s *schema) create(db *sql.DB) error {
+	existing, err := s.existingTables(db)
+	if err != nil {
+		return err
+	}
+
+	if len(existing) != 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(s.createSQL()); err != nil {
+		_ = tx.Rollback()
+
+		return fmt.Errorf("unable to create %s database: %w", s.name, err)
+	}
+
+	if s.userVersion {
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", s.version)); err != nil {
+			_ = tx.Rollback()
+
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// createSQL returns the statements creating the schema.
+// schema.sql is dumped from a database created by Nix, and contains
+// sqlite_sequence, which sqlite creates by itself, so it's left out.
+func (s *schema) createSQL() string {
+	lines := strings.Split(s.sql, "\n")
+	stmts := lines[:0]
+
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "CREATE TABLE sqlite_sequence") {
+			stmts = append(stmts, line)
+		}
+	}
+
+	return strings.Join(stmts, "\n")
+}
+
+// check returns an error if db doesn't have the expected schema.
+func (s *schema) check(db *sql.DB) error {
+	if s.userVersion {
+		var userVersion int
+		if err := db.QueryRow("PRAGMA user_version").Scan(&userVersion); err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+
+		// databases created by Nix itself don't record their version,
+		// it's part of their file name.
+		if userVersion != 0 && userVersion != s.version {
+			return fmt.Errorf("%s database has schema version %d, but only version %d is supported",
+				s.name, userVersion, s.version)
+		}
+	}
+
+	existing, err := s.existingTables(db)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+
+	for _, table := range s.tables {
+		if _, ok := existing[table]; !ok {
+			return fmt.Errorf("%s database is missing table %s, is it initialised?", s.name, table)
+		}
+	}
+
+	return nil
+}
+
+// existingTables returns the tables of the schema that exist in db.
+func (s *schema) existingTables(db *sql.DB) (map[string]struct{}, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wanted := make(map[string]struct{}, len(s.tables))
+	for _, table := range s.tables {
+		wanted[table] = struct{}{}
+	}
+
+	existing := make(map[string]struct{}, len(s.tables))
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		if _, ok := wanted[name]; ok {
+			existing[name] = struct{}{}
+		}
+	}
+
+	return existing, rows.Err()
+}
+
+// checkSchemaFile checks the schema file of s next to the Nix store database db.
+// In-memory databases have no schema file, and are not checked.
+func (s *schema) checkSchemaFile(db *sql.DB) error {
+	var (
+		seq        int
+		name, file string
+	)
+
+	if err := db.QueryRow("PRAGMA database_list").Scan(&seq, &name, &file); err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if file == "" {
+		return nil
+	}
+
+	return s.readSchemaFile(filepath.Join(filepath.Dir(file), s.schemaFile))
+}
+
+// readSchemaFile returns an error if the schema file at path doesn't contain
+// the supported version.
+func (s *schema) readSchemaFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("schema file %v of the %s database is missing, is it initialised?", path, s.name)
+		}
+
+		return err
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("schema file %v of the %s database is corrupt", path, s.name)
+	}
+
+	if version != s.version {
+		return fmt.Errorf("current %s schema is version %d, but only version %d is supported",
+			s.name, version, s.version)
+	}
+
+	return nil
+}
+
+// initSchemaFile creates the tables of s in the Nix store database db in dir,
+// and records its version in the schema file. An existing schema file is
+// checked before touching the database.
+func (s *schema) initSchemaFile(db *sql.DB, dir string) error {
+	path := filepath.Join(dir, s.schemaFile)
+
+	if _, err := os.Stat(path); err == nil {
+		if err := s.readSchemaFile(path); err != nil {
+			return err
+		}
+	}
+
+	if err := s.create(db); err != nil {
+		return err
+	}
+
+	if err := s.check(db); err != nil {
+		return err
+	}
+
+	// Nix writes the version without a trailing newline
+	return os.WriteFile(path, []byte(strconv.Itoa(s.version)), 0o644) //nolint:gosec
+}
@@ -3,6 +3,8 @@ package sqlite
 import (
 	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	// enable the sqlite3 driver.
 	_ "github.com/mattn/go-sqlite3"
@@ -12,38 +14,233 @@ import (
 	"github.com/nix-community/go-nix/pkg/sqlite/nix_v10"
 )
 
+// BinaryCacheV6 opens a binary cache database, like ~/.cache/nix/binary-cache-v6.sqlite.
+// The database isn't checked, see BinaryCacheV6Strict.
 func BinaryCacheV6(dsn string) (*sql.DB, *binary_cache_v6.Queries, error) {
-	db, err := sql.Open("sqlite3", dsn)
+	db, err := openLenient(dsn)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, nil, err
 	}
 
 	return db, binary_cache_v6.New(db), nil
 }
 
+// EvalCacheV5 opens an evaluation cache database, like ~/.cache/nix/eval-cache-v5/<fingerprint>.sqlite.
+// The database isn't checked, see EvalCacheV5Strict.
 func EvalCacheV5(dsn string) (*sql.DB, *eval_cache_v5.Queries, error) {
-	db, err := sql.Open("sqlite3", dsn)
+	db, err := openLenient(dsn)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, nil, err
 	}
 
 	return db, eval_cache_v5.New(db), nil
 }
 
+// FetcherCacheV2 opens a fetcher cache database, like ~/.cache/nix/fetcher-cache-v2.sqlite.
+// The database isn't checked, see FetcherCacheV2Strict.
 func FetcherCacheV2(dsn string) (*sql.DB, *fetcher_cache_v2.Queries, error) {
-	db, err := sql.Open("sqlite3", dsn)
+	db, err := openLenient(dsn)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, nil, err
 	}
 
 	return db, fetcher_cache_v2.New(db), nil
 }
 
+// NixV10 opens the database of a Nix store, like /nix/var/nix/db/db.sqlite.
+// The database isn't checked, see NixV10Strict.
 func NixV10(dsn string) (*sql.DB, *nix_v10.Queries, error) {
-	db, err := sql.Open("sqlite3", dsn)
+	db, err := openLenient(dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db, nix_v10.New(db), nil
+}
+
+// BinaryCacheV6Strict is like BinaryCacheV6, but checks the database has the
+// expected schema.
+func BinaryCacheV6Strict(dsn string) (*sql.DB, *binary_cache_v6.Queries, error) {
+	db, err := open(dsn, binaryCacheV6Schema, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db, binary_cache_v6.New(db), nil
+}
+
+// EvalCacheV5Strict is like EvalCacheV5, but checks the database has the
+// expected schema.
+func EvalCacheV5Strict(dsn string) (*sql.DB, *eval_cache_v5.Queries, error) {
+	db, err := open(dsn, evalCacheV5Schema, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db, eval_cache_v5.New(db), nil
+}
+
+// FetcherCacheV2Strict is like FetcherCacheV2, but checks the database has
+// the expected schema.
+func FetcherCacheV2Strict(dsn string) (*sql.DB, *fetcher_cache_v2.Queries, error) {
+	db, err := open(dsn, fetcherCacheV2Schema, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db, fetcher_cache_v2.New(db), nil
+}
+
+// NixV10Strict is like NixV10, but checks the database has the expected
+// schema, and the schema file next to it contains the expected version.
+func NixV10Strict(dsn string) (*sql.DB, *nix_v10.Queries, error) {
+	db, err := open(dsn, nixV10Schema, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := nixV10Schema.checkSchemaFile(db); err != nil {
+		db.Close()
+
+		return nil, nil, err
+	}
+
+	return db, nix_v10.New(db), nil
+}
+
+// InitBinaryCacheV6 is like BinaryCacheV6Strict, but creates the database if it's empty.
+func InitBinaryCacheV6(dsn string) (*sql.DB, *binary_cache_v6.Queries, error) {
+	db, err := open(dsn, binaryCacheV6Schema, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db, binary_cache_v6.New(db), nil
+}
+
+// InitEvalCacheV5 is like EvalCacheV5Strict, but creates the database if it's empty.
+func InitEvalCacheV5(dsn string) (*sql.DB, *eval_cache_v5.Queries, error) {
+	db, err := open(dsn, evalCacheV5Schema, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db, eval_cache_v5.New(db), nil
+}
+
+// InitFetcherCacheV2 is like FetcherCacheV2Strict, but creates the database if it's empty.
+func InitFetcherCacheV2(dsn string) (*sql.DB, *fetcher_cache_v2.Queries, error) {
+	db, err := open(dsn, fetcherCacheV2Schema, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db, fetcher_cache_v2.New(db), nil
+}
+
+// InitNixV10 opens the database of a Nix store in dir (like /nix/var/nix/db),
+// creating db.sqlite and the schema file if they don't exist yet.
+func InitNixV10(dir string) (*sql.DB, *nix_v10.Queries, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	schemaPath := filepath.Join(dir, nixV10Schema.schemaFile)
+
+	// check an existing schema file before touching the database
+	if _, err := os.Stat(schemaPath); err == nil {
+		if err := nixV10Schema.readSchemaFile(schemaPath); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	db, err := open(filepath.Join(dir, "db.sqlite"), nixV10Schema, true)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, nil, err
+	}
+
+	// Nix writes the version without a trailing newline
+	if err := os.WriteFile(schemaPath, []byte(fmt.Sprint(nixV10Schema.version)), 0o644); err != nil { //nolint:gosec
+		db.Close()
+
+		return nil, nil, err
 	}
 
 	return db, nix_v10.New(db), nil
 }
+
+// NixV10CA is like NixV10Strict, but also checks the database has the tables for
+// content-addressed derivations, and the ca-schema file next to it contains
+// the expected version.
+func NixV10CA(dsn string) (*sql.DB, *nix_v10.Queries, error) {
+	db, queries, err := NixV10Strict(dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := nixCASchema.check(db); err != nil {
+		db.Close()
+
+		return nil, nil, err
+	}
+
+	if err := nixCASchema.checkSchemaFile(db); err != nil {
+		db.Close()
+
+		return nil, nil, err
+	}
+
+	return db, queries, nil
+}
+
+// InitNixV10CA is like InitNixV10, but also creates the tables for
+// content-addressed derivations and the ca-schema file, if they don't exist yet.
+func InitNixV10CA(dir string) (*sql.DB, *nix_v10.Queries, error) {
+	db, queries, err := InitNixV10(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := nixCASchema.initSchemaFile(db, dir); err != nil {
+		db.Close()
+
+		return nil, nil, err
+	}
+
+	return db, queries, nil
+}
+
+// openLenient opens the database at dsn, without checking it.
+func openLenient(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	return db, nil
+}
+
+// open opens the database at dsn, and checks it has the given schema.
+// If create is set, the schema is created if the database is empty.
+func open(dsn string, s *schema, create bool) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if create {
+		err = s.create(db)
+	}
+
+	if err == nil {
+		err = s.check(db)
+	}
+
+	if err != nil {
+		db.Close()
+
+		return nil, err
+	}
+
+	return db, nil
+}
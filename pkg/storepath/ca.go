@@ -0,0 +1,154 @@
+package storepath
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/nixhash"
+)
+
+// ContentAddressMethod describes how the contents of a content-addressed
+// store path were hashed.
+type ContentAddressMethod uint8
+
+const (
+	// ContentAddressText is used for text files like .drv files, which are
+	// hashed flat with sha256, and may only refer to other store paths.
+	ContentAddressText = ContentAddressMethod(iota)
+	// ContentAddressFlat is used for fixed-output paths hashed flat.
+	ContentAddressFlat
+	// ContentAddressRecursive is used for fixed-output paths hashed
+	// recursively, by hashing their NAR serialisation.
+	ContentAddressRecursive
+)
+
+// ContentAddress is an assertion that a store path is content-addressed,
+// as found in the CA field of path infos and .narinfo files.
+// It's rendered like `text:sha256:…`, `fixed:sha256:…` or `fixed:r:sha256:…`,
+// with the hash in nixbase32.
+type ContentAddress struct {
+	Method ContentAddressMethod
+	Hash   *nixhash.Hash
+}
+
+// ParseContentAddress parses a content address in the format described above.
+func ParseContentAddress(s string) (*ContentAddress, error) {
+	var ca ContentAddress
+
+	prefix, rest, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid content address %v: missing prefix", s)
+	}
+
+	switch prefix {
+	case "text":
+		ca.Method = ContentAddressText
+	case "fixed":
+		ca.Method = ContentAddressFlat
+
+		if strings.HasPrefix(rest, "r:") {
+			ca.Method = ContentAddressRecursive
+			rest = rest[2:]
+		}
+	default:
+		return nil, fmt.Errorf("invalid content address %v: unknown prefix %v", s, prefix)
+	}
+
+	h, err := nixhash.ParseAny(rest, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content address %v: %w", s, err)
+	}
+
+	if ca.Method == ContentAddressText && h.Algo() != nixhash.SHA256 {
+		return nil, fmt.Errorf("invalid content address %v: text hashing needs sha256", s)
+	}
+
+	ca.Hash = &h.Hash
+
+	return &ca, nil
+}
+
+// String renders the content address, like Nix does.
+func (ca *ContentAddress) String() string {
+	var prefix string
+
+	switch ca.Method {
+	case ContentAddressText:
+		prefix = "text:"
+	case ContentAddressFlat:
+		prefix = "fixed:"
+	case ContentAddressRecursive:
+		prefix = "fixed:r:"
+	default:
+		panic(fmt.Sprintf("bug: unknown content address method %d", ca.Method))
+	}
+
+	return prefix + ca.Hash.Format(nixhash.NixBase32, true)
+}
+
+// StorePath calculates the store path with the given name and references
+// (absolute store paths) having this content address.
+// A reference to the path itself is passed by setting hasSelfReference,
+// which is only possible for paths recursively hashed with sha256.
+func (ca *ContentAddress) StorePath(name string, references []string, hasSelfReference bool) (*StorePath, error) {
+	switch ca.Method {
+	case ContentAddressText:
+		if hasSelfReference {
+			return nil, fmt.Errorf("text paths can't refer to themselves")
+		}
+
+		return MakeTextPath(ca.Hash, name, references)
+	case ContentAddressFlat:
+		if hasSelfReference {
+			return nil, fmt.Errorf("flat fixed-output paths can't refer to themselves")
+		}
+
+		return MakeFixedOutputPath(FileIngestionFlat, ca.Hash, name, references)
+	case ContentAddressRecursive:
+		if ca.Hash.Algo() == nixhash.SHA256 {
+			return MakeSourcePath(ca.Hash, name, references, hasSelfReference)
+		}
+
+		if hasSelfReference {
+			return nil, fmt.Errorf("fixed-output paths hashed with %v can't refer to themselves", ca.Hash.Algo())
+		}
+
+		return MakeFixedOutputPath(FileIngestionRecursive, ca.Hash, name, references)
+	default:
+		return nil, fmt.Errorf("unknown content address method %d", ca.Method)
+	}
+}
+
+// Check verifies p (an absolute store path) is the store path having this
+// content address and the given references, which may include p itself.
+func (ca *ContentAddress) Check(p string, references []string) error {
+	sp, err := FromAbsolutePath(p)
+	if err != nil {
+		return err
+	}
+
+	var (
+		refs             []string
+		hasSelfReference bool
+	)
+
+	for _, ref := range references {
+		if ref == p {
+			hasSelfReference = true
+		} else {
+			refs = append(refs, ref)
+		}
+	}
+
+	expected, err := ca.StorePath(sp.Name, refs, hasSelfReference)
+	if err != nil {
+		return err
+	}
+
+	if expected.Absolute() != path.Clean(p) {
+		return fmt.Errorf("path %v claims to be content-addressed with %v, but should be %v", p, ca, expected.Absolute())
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package storepath_test
+
+import (
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/storepath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentAddress(t *testing.T) {
+	cases := []struct {
+		Title    string
+		CA       *storepath.ContentAddress
+		Name     string
+		Expected string
+	}{
+		{
+			Title: "text",
+			CA: &storepath.ContentAddress{
+				Method: storepath.ContentAddressText,
+				Hash:   mustParseHash(nixhash.SHA256, "d772458796a3a457f931fceee5ca22c3a3596d065c59ab5f622bd163eb712056"),
+			},
+			Name:     "bar.drv",
+			Expected: "/nix/store/0hm2f1psjpcwg8fijsmr4wwxrx59s092-bar.drv",
+		},
+		{
+			Title: "flat sha256",
+			CA: &storepath.ContentAddress{
+				Method: storepath.ContentAddressFlat,
+				Hash:   mustParseHash(nixhash.SHA256, "4fec236f3fbd3d0c47b893fdfa9122142a474f6ef66c20ffb6c0f4864dd591b6"),
+			},
+			Name:     "bash44-023",
+			Expected: "/nix/store/x9cyj78gzd1wjf0xsiad1pa3ricbj566-bash44-023",
+		},
+		{
+			Title: "recursive sha256",
+			CA: &storepath.ContentAddress{
+				Method: storepath.ContentAddressRecursive,
+				Hash:   mustParseHash(nixhash.SHA256, "08813cbee9903c62be4c5027726a418a300da4500b2d369d3af9286f4815ceba"),
+			},
+			Name:     "bar",
+			Expected: "/nix/store/4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar",
+		},
+		{
+			Title: "recursive sha1",
+			CA: &storepath.ContentAddress{
+				Method: storepath.ContentAddressRecursive,
+				Hash:   mustParseHash(nixhash.SHA1, "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"),
+			},
+			Name:     "bar",
+			Expected: "/nix/store/mp57d33657rf34lzvlbpfa1gjfv5gmpg-bar",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			parsed, err := storepath.ParseContentAddress(c.CA.String())
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.CA, parsed, "should round-trip")
+			}
+
+			sp, err := c.CA.StorePath(c.Name, nil, false)
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.Expected, sp.Absolute())
+			}
+
+			assert.NoError(t, c.CA.Check(c.Expected, nil))
+			assert.Error(t, c.CA.Check(c.Expected, []string{c.Expected}), "self-references change the path")
+		})
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		ca, err := storepath.ParseContentAddress("fixed:r:sha256:1b8m03r63zqhnjf7l5wnldhh7c134ap5vpj0850ymkq1iyzicy5s")
+		if assert.NoError(t, err) {
+			assert.Equal(t, storepath.ContentAddressRecursive, ca.Method)
+			assert.Equal(t, nixhash.SHA256, ca.Hash.Algo())
+			assert.Equal(t, "fixed:r:sha256:1b8m03r63zqhnjf7l5wnldhh7c134ap5vpj0850ymkq1iyzicy5s", ca.String())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{
+			"",
+			"sha256:1b8m03r63zqhnjf7l5wnldhh7c134ap5vpj0850ymkq1iyzicy5s",
+			"foo:sha256:1b8m03r63zqhnjf7l5wnldhh7c134ap5vpj0850ymkq1iyzicy5s",
+			"fixed:sha256:invalid",
+			"text:sha1:0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33",
+		} {
+			_, err := storepath.ParseContentAddress(s)
+			assert.Error(t, err, s)
+		}
+	})
+
+	t.Run("self-reference on flat path", func(t *testing.T) {
+		_, err := cases[1].CA.StorePath("bash44-023", nil, true)
+		assert.Error(t, err)
+	})
+}
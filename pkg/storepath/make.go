@@ -0,0 +1,154 @@
+package storepath
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/nixhash"
+)
+
+// FileIngestionMethod describes how the contents of a store path were hashed.
+type FileIngestionMethod uint8
+
+const (
+	// FileIngestionFlat hashes the contents of a single regular file.
+	FileIngestionFlat = FileIngestionMethod(iota)
+	// FileIngestionRecursive hashes the NAR serialisation of a path.
+	FileIngestionRecursive
+)
+
+// Prefix returns the prefix Nix uses in front of the hash algorithm
+// for this method, like in the "r:sha256" output hash algorithm of a .drv.
+func (m FileIngestionMethod) Prefix() string {
+	switch m {
+	case FileIngestionFlat:
+		return ""
+	case FileIngestionRecursive:
+		return "r:"
+	default:
+		panic(fmt.Sprintf("bug: unknown file ingestion method %d", m))
+	}
+}
+
+// MakeType assembles the type part of a store path fingerprint.
+// It consists of pathType, followed by all references (absolute store paths,
+// sorted lexicographically), and ":self" if the path refers to itself,
+// all separated by a colon.
+func MakeType(pathType string, references []string, hasSelfReference bool) string {
+	refs := make([]string, len(references))
+	copy(refs, references)
+	sort.Strings(refs)
+
+	var sb strings.Builder
+
+	sb.WriteString(pathType)
+
+	for _, ref := range refs {
+		sb.WriteByte(':')
+		sb.WriteString(ref)
+	}
+
+	if hasSelfReference {
+		sb.WriteString(":self")
+	}
+
+	return sb.String()
+}
+
+// MakeStorePath calculates a store path from its type, an inner hash and a name.
+// This is the primitive all other store path calculations are built on.
+// The fingerprint is constructed like this:
+//
+//	$pathType:$algo:$base16Digest:$StoreDir:$name
+//
+// It is then hashed with sha256, compressed to PathHashSize bytes,
+// and used as the digest of the store path.
+func MakeStorePath(pathType string, hash *nixhash.Hash, name string) (*StorePath, error) {
+	fingerprint := pathType + ":" +
+		hash.Format(nixhash.Base16, true) + ":" +
+		StoreDir + ":" +
+		name
+
+	digest := sha256.Sum256([]byte(fingerprint))
+
+	sp := &StorePath{
+		Name:   name,
+		Digest: nixhash.CompressHash(digest[:], PathHashSize),
+	}
+
+	if err := sp.Validate(); err != nil {
+		return nil, err
+	}
+
+	return sp, nil
+}
+
+// MakeOutputPath calculates the store path of an output of an
+// input-addressed derivation.
+// hash is the hash of the derivation modulo fixed-output derivations,
+// drvName the name of the derivation (without .drv suffix).
+// The name of the resulting store path has "-$outputName" appended,
+// except for the "out" output.
+func MakeOutputPath(outputName string, hash *nixhash.Hash, drvName string) (*StorePath, error) {
+	name := drvName
+	if outputName != "out" {
+		name += "-" + outputName
+	}
+
+	return MakeStorePath("output:"+outputName, hash, name)
+}
+
+// MakeSourcePath calculates the store path of a path added to the store
+// by recursively hashing its NAR serialisation with sha256,
+// which is what `nix-store --add` and builtins.path do.
+// references is a list of absolute store paths referred to by the contents,
+// hasSelfReference specifies whether the contents refer to the path itself.
+func MakeSourcePath(hash *nixhash.Hash, name string, references []string, hasSelfReference bool) (*StorePath, error) {
+	if hash.Algo() != nixhash.SHA256 {
+		return nil, fmt.Errorf("source paths need a sha256 hash, got %v", hash.Algo())
+	}
+
+	return MakeStorePath(MakeType("source", references, hasSelfReference), hash, name)
+}
+
+// MakeTextPath calculates the store path of a text file added to the store,
+// like the ones created by builtins.toFile, or .drv files.
+// hash is the sha256 hash of the contents,
+// references a list of absolute store paths referred to by the contents.
+func MakeTextPath(hash *nixhash.Hash, name string, references []string) (*StorePath, error) {
+	if hash.Algo() != nixhash.SHA256 {
+		return nil, fmt.Errorf("text paths need a sha256 hash, got %v", hash.Algo())
+	}
+
+	return MakeStorePath(MakeType("text", references, false), hash, name)
+}
+
+// MakeFixedOutputPath calculates the store path of a fixed-output path,
+// for example the output of a fixed-output derivation.
+//
+// Paths recursively hashed with sha256 are treated like source paths,
+// and may carry references.
+// All other combinations hash a "fixed:out:…" fingerprint first,
+// and may not have any references.
+func MakeFixedOutputPath(
+	method FileIngestionMethod,
+	hash *nixhash.Hash,
+	name string,
+	references []string,
+) (*StorePath, error) {
+	if method == FileIngestionRecursive && hash.Algo() == nixhash.SHA256 {
+		return MakeSourcePath(hash, name, references, false)
+	}
+
+	if len(references) != 0 {
+		return nil, fmt.Errorf("fixed-output paths hashed with %v%v can't have references", method.Prefix(), hash.Algo())
+	}
+
+	innerDigest := sha256.Sum256([]byte(
+		"fixed:out:" + method.Prefix() + hash.Format(nixhash.Base16, true) + ":",
+	))
+
+	return MakeStorePath("output:out", nixhash.MustNewHash(nixhash.SHA256, innerDigest[:]), name)
+}
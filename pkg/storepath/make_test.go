@@ -0,0 +1,163 @@
+package storepath_test
+
+import (
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/storepath"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustParseHash(algo nixhash.Algorithm, s string) *nixhash.Hash {
+	h, err := nixhash.ParseAny(s, &algo)
+	if err != nil {
+		panic(err)
+	}
+
+	return &h.Hash
+}
+
+func TestMakeType(t *testing.T) {
+	assert.Equal(t, "source", storepath.MakeType("source", nil, false))
+	assert.Equal(t, "source:self", storepath.MakeType("source", nil, true))
+	assert.Equal(t,
+		"text:/nix/store/0hm2f1psjpcwg8fijsmr4wwxrx59s092-bar.drv:/nix/store/gy295yl6dvm27wv7rsa6gswiq14zk3za-foofile",
+		storepath.MakeType("text", []string{
+			"/nix/store/gy295yl6dvm27wv7rsa6gswiq14zk3za-foofile",
+			"/nix/store/0hm2f1psjpcwg8fijsmr4wwxrx59s092-bar.drv",
+		}, false),
+		"references should be sorted",
+	)
+}
+
+// The expected paths below are taken from the derivations in test/testdata,
+// which have been produced by Nix.
+func TestMakeFixedOutputPath(t *testing.T) {
+	cases := []struct {
+		Title    string
+		Method   storepath.FileIngestionMethod
+		Hash     *nixhash.Hash
+		Name     string
+		Expected string
+	}{
+		{
+			Title:    "flat sha256",
+			Method:   storepath.FileIngestionFlat,
+			Hash:     mustParseHash(nixhash.SHA256, "4fec236f3fbd3d0c47b893fdfa9122142a474f6ef66c20ffb6c0f4864dd591b6"),
+			Name:     "bash44-023",
+			Expected: "/nix/store/x9cyj78gzd1wjf0xsiad1pa3ricbj566-bash44-023",
+		},
+		{
+			Title:    "recursive sha256",
+			Method:   storepath.FileIngestionRecursive,
+			Hash:     mustParseHash(nixhash.SHA256, "08813cbee9903c62be4c5027726a418a300da4500b2d369d3af9286f4815ceba"),
+			Name:     "bar",
+			Expected: "/nix/store/4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar",
+		},
+		{
+			Title:    "recursive sha1",
+			Method:   storepath.FileIngestionRecursive,
+			Hash:     mustParseHash(nixhash.SHA1, "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"),
+			Name:     "bar",
+			Expected: "/nix/store/mp57d33657rf34lzvlbpfa1gjfv5gmpg-bar",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			sp, err := storepath.MakeFixedOutputPath(c.Method, c.Hash, c.Name, nil)
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.Expected, sp.Absolute())
+			}
+		})
+	}
+
+	t.Run("references on flat path", func(t *testing.T) {
+		_, err := storepath.MakeFixedOutputPath(
+			storepath.FileIngestionFlat,
+			cases[0].Hash,
+			"bash44-023",
+			[]string{"/nix/store/x9cyj78gzd1wjf0xsiad1pa3ricbj566-bash44-023"},
+		)
+		assert.Error(t, err)
+	})
+}
+
+// .drv files are text paths, their expected paths and contents are in test/testdata.
+func TestMakeTextPath(t *testing.T) {
+	cases := []struct {
+		Title      string
+		Hash       *nixhash.Hash
+		Name       string
+		References []string
+		Expected   string
+	}{
+		{
+			Title:    "no references",
+			Hash:     mustParseHash(nixhash.SHA256, "d772458796a3a457f931fceee5ca22c3a3596d065c59ab5f622bd163eb712056"),
+			Name:     "bar.drv",
+			Expected: "/nix/store/0hm2f1psjpcwg8fijsmr4wwxrx59s092-bar.drv",
+		},
+		{
+			Title:      "drv reference",
+			Hash:       mustParseHash(nixhash.SHA256, "7e3e384481309af458ed2200ae9d6d83823fefc1eab0f5d8e24bec8e26af46ba"),
+			Name:       "foo.drv",
+			References: []string{"/nix/store/0hm2f1psjpcwg8fijsmr4wwxrx59s092-bar.drv"},
+			Expected:   "/nix/store/4wvvbi4jwn0prsdxb7vs673qa5h9gr7x-foo.drv",
+		},
+		{
+			Title:      "source reference",
+			Hash:       mustParseHash(nixhash.SHA256, "336f0730de3e0f901a39e3f9ed5b14c56e0cbd99a94d7e3caa338704976b13f1"),
+			Name:       "foo-file.drv",
+			References: []string{"/nix/store/gy295yl6dvm27wv7rsa6gswiq14zk3za-foofile"},
+			Expected:   "/nix/store/385bniikgs469345jfsbw24kjfhxrsi0-foo-file.drv",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			sp, err := storepath.MakeTextPath(c.Hash, c.Name, c.References)
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.Expected, sp.Absolute())
+			}
+		})
+	}
+
+	t.Run("sha1", func(t *testing.T) {
+		_, err := storepath.MakeTextPath(
+			mustParseHash(nixhash.SHA1, "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33"),
+			"foo",
+			nil,
+		)
+		assert.Error(t, err)
+	})
+}
+
+func TestMakeSourcePath(t *testing.T) {
+	h := mustParseHash(nixhash.SHA256, "08813cbee9903c62be4c5027726a418a300da4500b2d369d3af9286f4815ceba")
+
+	sp, err := storepath.MakeSourcePath(h, "bar", nil, false)
+	if assert.NoError(t, err) {
+		// the same as a fixed-output path with recursive sha256
+		assert.Equal(t, "/nix/store/4q0pg5zpfmznxscq3avycvf9xdvx50n3-bar", sp.Absolute())
+	}
+
+	spSelf, err := storepath.MakeSourcePath(h, "bar", nil, true)
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, sp.Absolute(), spSelf.Absolute(), "self-references should change the path")
+	}
+
+	_, err = storepath.MakeSourcePath(h, "invalid/name", nil, false)
+	assert.Error(t, err)
+}
+
+func BenchmarkMakeStorePath(b *testing.B) {
+	h := mustParseHash(nixhash.SHA256, "08813cbee9903c62be4c5027726a418a300da4500b2d369d3af9286f4815ceba")
+
+	for i := 0; i < b.N; i++ {
+		_, err := storepath.MakeStorePath("source", h, "bar")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package references
+
+import (
+	"bufio"
+	"io"
+	"sort"
+
+	"github.com/nix-community/go-nix/pkg/nar"
+)
+
+// narEntry describes the position of an entry inside a NAR.
+// For regular files, [start, end) contains the file contents.
+// For all other entries, start and end point to the end of the entry header.
+type narEntry struct {
+	path  string
+	start int64
+	end   int64
+}
+
+// ScanNAR reads a NAR from rd, and scans all of it, like Write would.
+// Additionally, if hits are recorded, it sets the Path of all hits found in
+// this NAR to the path of the entry containing them, which is the file
+// for hits in file contents, and the entry itself for hits in entry names
+// and symlink targets.
+// The NAR is validated while reading.
+func (r *ReferenceScanner) ScanNAR(rd io.Reader) error {
+	firstHit := len(r.hitList)
+
+	// every byte consumed by the NAR reader is written to the scanner,
+	// so the scanners offset is the position inside the NAR.
+	nr, err := nar.NewReader(io.TeeReader(bufio.NewReader(rd), r))
+	if err != nil {
+		return err
+	}
+	defer nr.Close()
+
+	var entries []narEntry
+
+	for {
+		hdr, err := nr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return err
+		}
+
+		entry := narEntry{
+			path:  hdr.Path,
+			start: r.offset,
+			end:   r.offset,
+		}
+
+		if hdr.Type == nar.TypeRegular {
+			n, err := io.Copy(io.Discard, nr)
+			if err != nil {
+				return err
+			}
+
+			entry.end += n
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	for i := firstHit; i < len(r.hitList); i++ {
+		hit := &r.hitList[i]
+
+		// find the first entry not ending before the hit
+		j := sort.Search(len(entries), func(j int) bool {
+			return entries[j].end > hit.Offset
+		})
+
+		// after the last entry, only closing tokens follow.
+		if j == len(entries) {
+			j--
+		}
+
+		// the hit is either inside the contents of a regular file,
+		// or part of its header.
+		hit.Path = entries[j].path
+	}
+
+	return nil
+}
@@ -14,7 +14,35 @@ const (
 	refLength         = len(nixbase32.Alphabet) // Store path hash prefix length
 )
 
+// isBase32 is a lookup table for nixbase32.Is, which is faster in the hot loop.
+//
+//nolint:gochecknoglobals
+var isBase32 = func() (t [256]bool) {
+	for i := range t {
+		t[i] = nixbase32.Is(byte(i))
+	}
+
+	return t
+}()
+
+// Hit describes a single occurrence of a store path hash in the scanned data.
+type Hit struct {
+	// StorePath is the (candidate) store path that was found.
+	StorePath string
+	// Path is the path of the file inside the NAR containing the hit.
+	// It's only set when scanning with ScanNAR.
+	Path string
+	// Offset is the position of the hash in all data written to the scanner so far.
+	Offset int64
+}
+
 // ReferenceScanner scans a stream of data for references to store paths to extract run time dependencies.
+//
+// It looks for the nixbase32-encoded hash part of all candidate store paths.
+// Data is scanned in windows of the length of a hash. Windows are checked
+// back to front, and as soon as a character outside the nixbase32 alphabet is
+// encountered, the window is moved past it. Only windows consisting
+// entirely of nixbase32 characters are looked up in the set of candidates.
 type ReferenceScanner struct {
 	// Map of store path hashes to full store paths.
 	hashes map[string]string
@@ -22,16 +50,23 @@ type ReferenceScanner struct {
 	// Set of hits.
 	hits map[string]struct{}
 
-	// Buffer for current partial hit.
-	buf [refLength]byte
+	// If set, every single hit is recorded in hitList.
+	recordHits bool
+	hitList    []Hit
+
+	// The last (up to refLength-1) bytes of the previous write,
+	// followed by the start of the current write.
+	// A reference might span multiple writes.
+	buf [2 * (refLength - 1)]byte
 
-	// How far into buf is currently written.
+	// How far into buf the tail of the previous write is stored.
 	n int
+
+	// The total number of bytes written so far.
+	offset int64
 }
 
 func NewReferenceScanner(storePathCandidates []string) (*ReferenceScanner, error) {
-	var buf [refLength]byte
-
 	hashes := make(map[string]string)
 
 	for _, storePath := range storePathCandidates {
@@ -50,11 +85,23 @@ func NewReferenceScanner(storePathCandidates []string) (*ReferenceScanner, error
 	return &ReferenceScanner{
 		hits:   make(map[string]struct{}),
 		hashes: hashes,
-		buf:    buf,
-		n:      0,
 	}, nil
 }
 
+// RecordHits enables recording of every single hit, including its offset.
+// It needs to be called before writing any data.
+// Recorded hits can be retrieved with Hits().
+func (r *ReferenceScanner) RecordHits() {
+	r.recordHits = true
+}
+
+// Hits returns all hits recorded so far, ordered by their offset.
+// It returns nil if RecordHits() wasn't called.
+func (r *ReferenceScanner) Hits() []Hit {
+	return r.hitList
+}
+
+// References returns all store paths found so far, sorted lexicographically.
 func (r *ReferenceScanner) References() []string {
 	paths := make([]string, len(r.hits))
 
@@ -71,25 +118,82 @@ func (r *ReferenceScanner) References() []string {
 }
 
 func (r *ReferenceScanner) Write(s []byte) (int, error) {
-	for _, c := range s {
-		if !nixbase32.Is(c) {
-			r.n = 0
+	// A reference might span the tail of the previous and the start of the current write,
+	// so search the concatenation of both first.
+	// As both are shorter than a reference, every match found there
+	// spans both, and won't be found again when searching s itself.
+	if r.n > 0 {
+		head := len(s)
+		if head > refLength-1 {
+			head = refLength - 1
+		}
 
-			continue
+		copy(r.buf[r.n:], s[:head])
+		r.search(r.buf[:r.n+head], r.offset-int64(r.n))
+	}
+
+	r.search(s, r.offset)
+
+	r.offset += int64(len(s))
+
+	// keep the last refLength-1 bytes of what we've seen for the next write.
+	if len(s) >= refLength-1 {
+		r.n = copy(r.buf[:], s[len(s)-(refLength-1):])
+	} else {
+		keep := refLength - 1 - len(s)
+		if keep > r.n {
+			keep = r.n
 		}
 
-		r.buf[r.n] = c
-		r.n++
+		copy(r.buf[:], r.buf[r.n-keep:r.n])
+		r.n = keep + copy(r.buf[keep:], s)
+	}
+
+	return len(s), nil
+}
 
-		if r.n == refLength {
-			hash := string(r.buf[:])
-			if _, ok := r.hashes[hash]; ok {
-				r.hits[hash] = struct{}{}
+// search looks for references in s, which starts at offset in the stream.
+func (r *ReferenceScanner) search(s []byte, offset int64) {
+	// all bytes in s[i:checked] are known to be nixbase32 characters.
+	checked := 0
+
+	for i := 0; i+refLength <= len(s); {
+		if checked < i {
+			checked = i
+		}
+
+		// check the window back to front, until the part we already checked
+		j := i + refLength - 1
+		for ; j >= checked; j-- {
+			if !isBase32[s[j]] {
+				break
 			}
+		}
+
+		if j >= checked {
+			// s[j] can't be part of a reference, move past it.
+			i = j + 1
+
+			continue
+		}
+
+		checked = i + refLength
 
-			r.n = 0
+		if storePath, ok := r.hashes[string(s[i:i+refLength])]; ok {
+			r.hit(string(s[i:i+refLength]), storePath, offset+int64(i))
 		}
+
+		i++
 	}
+}
 
-	return len(s), nil
+func (r *ReferenceScanner) hit(hash string, storePath string, offset int64) {
+	r.hits[hash] = struct{}{}
+
+	if r.recordHits {
+		r.hitList = append(r.hitList, Hit{
+			StorePath: storePath,
+			Offset:    offset,
+		})
+	}
 }
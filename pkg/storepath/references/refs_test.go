@@ -1,17 +1,32 @@
 package references_test
 
 import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
 	"testing"
 
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/nix-community/go-nix/pkg/storepath"
 	"github.com/nix-community/go-nix/pkg/storepath/references"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	pathHello = "/nix/store/knn6wc1a89c47yb70qwv56rmxylia6wx-hello-2.12"
+	pathGo    = "/nix/store/c4pcgriqgiwz8vxrjxg7p38q3y7w3ni3-go-1.18.2"
 )
 
 //nolint:gochecknoglobals
 var cases = []struct {
-	Title    string
-	Chunks   []string
-	Expected []string
+	Title      string
+	Chunks     []string
+	Candidates []string
+	Expected   []string
 }{
 	{
 		Title: "Basic",
@@ -32,6 +47,15 @@ var cases = []struct {
 			"/nix/store/knn6wc1a89c47yb70qwv56rmxylia6wx-hello-2.12",
 		},
 	},
+	{
+		Title: "TinyWrites",
+		Chunks: []string{
+			"/nix/store/knn6wc1a89", "c", "4", "7", "yb70", "qwv56rmxylia6wx-hello-2.12/bin/hello",
+		},
+		Expected: []string{
+			pathHello,
+		},
+	},
 	{
 		Title: "IgnoredPaths",
 		Chunks: []string{
@@ -42,13 +66,43 @@ var cases = []struct {
 			"/nix/store/knn6wc1a89c47yb70qwv56rmxylia6wx-hello-2.12",
 		},
 	},
+	{
+		Title: "Unaligned",
+		Chunks: []string{
+			// the hash is preceded by other nixbase32 characters
+			"abc0knn6wc1a89c47yb70qwv56rmxylia6wx-hello",
+		},
+		Expected: []string{
+			pathHello,
+		},
+	},
+	{
+		Title: "Multiple",
+		Chunks: []string{
+			"PATH=" + pathGo + "/bin:" + pathHello + "/bin\x00",
+		},
+		Candidates: []string{
+			pathHello,
+			pathGo,
+			"/nix/store/7gx4kiv5m0i7d7qkixq2cwzbr10lvxwc-glibc-2.27",
+		},
+		Expected: []string{
+			pathGo,
+			pathHello,
+		},
+	},
 }
 
 func TestReferences(t *testing.T) {
 	t.Run("ScanReferences", func(t *testing.T) {
 		for _, c := range cases {
 			t.Run(c.Title, func(t *testing.T) {
-				refScanner, err := references.NewReferenceScanner(c.Expected)
+				candidates := c.Candidates
+				if candidates == nil {
+					candidates = c.Expected
+				}
+
+				refScanner, err := references.NewReferenceScanner(candidates)
 				if err != nil {
 					panic(err)
 				}
@@ -64,6 +118,168 @@ func TestReferences(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("Hits", func(t *testing.T) {
+		refScanner, err := references.NewReferenceScanner([]string{pathHello, pathGo})
+		if err != nil {
+			panic(err)
+		}
+
+		refScanner.RecordHits()
+
+		for _, chunk := range []string{"foo ", pathHello[:20], pathHello[20:], " bar ", pathHello, "/" + pathGo} {
+			_, err = refScanner.Write([]byte(chunk))
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		assert.Equal(t, []references.Hit{
+			{StorePath: pathHello, Offset: 4 + 11},
+			{StorePath: pathHello, Offset: 4 + int64(len(pathHello)) + 5 + 11},
+			{StorePath: pathGo, Offset: 4 + 2*int64(len(pathHello)) + 5 + 1 + 11},
+		}, refScanner.Hits())
+	})
+}
+
+func TestScanNAR(t *testing.T) {
+	var buf bytes.Buffer
+
+	nw, err := nar.NewWriter(&buf)
+	require.NoError(t, err)
+
+	for _, e := range []struct {
+		hdr      nar.Header
+		contents string
+	}{
+		{hdr: nar.Header{Path: "/", Type: nar.TypeDirectory}},
+		{hdr: nar.Header{Path: "/bin", Type: nar.TypeDirectory}},
+		{
+			hdr:      nar.Header{Path: "/bin/hello", Type: nar.TypeRegular, Executable: true},
+			contents: "#!" + pathGo + "/bin/go\nexec " + pathHello + "/bin/.hello-wrapped",
+		},
+		{hdr: nar.Header{Path: "/lib", Type: nar.TypeSymlink, LinkTarget: pathHello + "/lib"}},
+		{hdr: nar.Header{Path: "/share", Type: nar.TypeRegular}, contents: "nothing to see here"},
+	} {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.contents))
+
+		require.NoError(t, nw.WriteHeader(&hdr))
+
+		_, err = nw.Write([]byte(e.contents))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, nw.Close())
+
+	refScanner, err := references.NewReferenceScanner([]string{pathHello, pathGo})
+	if err != nil {
+		panic(err)
+	}
+
+	refScanner.RecordHits()
+
+	require.NoError(t, refScanner.ScanNAR(bytes.NewReader(buf.Bytes())))
+
+	assert.Equal(t, []string{pathGo, pathHello}, refScanner.References())
+
+	hits := refScanner.Hits()
+	if assert.Len(t, hits, 3) {
+		assert.Equal(t, pathGo, hits[0].StorePath)
+		assert.Equal(t, "/bin/hello", hits[0].Path)
+		assert.Equal(t, pathHello, hits[1].StorePath)
+		assert.Equal(t, "/bin/hello", hits[1].Path)
+		assert.Equal(t, pathHello, hits[2].StorePath)
+		assert.Equal(t, "/lib", hits[2].Path)
+
+		// offsets point to the hash inside the NAR
+		for _, hit := range hits {
+			assert.Equal(t,
+				hit.StorePath[len(storepath.StoreDir)+1:len(storepath.StoreDir)+1+32],
+				string(buf.Bytes()[hit.Offset:hit.Offset+32]),
+			)
+		}
+	}
+
+	t.Run("invalid NAR", func(t *testing.T) {
+		refScanner, err := references.NewReferenceScanner([]string{pathHello})
+		if err != nil {
+			panic(err)
+		}
+
+		assert.Error(t, refScanner.ScanNAR(bytes.NewReader(buf.Bytes()[:buf.Len()/2])))
+	})
+}
+
+// legacyReferenceScanner is the previous implementation of the ReferenceScanner,
+// which checks every single byte. It's kept here to benchmark against.
+type legacyReferenceScanner struct {
+	hashes map[string]string
+	hits   map[string]struct{}
+	buf    [32]byte
+	n      int
+}
+
+func (r *legacyReferenceScanner) Write(s []byte) (int, error) {
+	for _, c := range s {
+		if !nixbase32.Is(c) {
+			r.n = 0
+
+			continue
+		}
+
+		r.buf[r.n] = c
+		r.n++
+
+		if r.n == len(r.buf) {
+			hash := string(r.buf[:])
+			if _, ok := r.hashes[hash]; ok {
+				r.hits[hash] = struct{}{}
+			}
+
+			r.n = 0
+		}
+	}
+
+	return len(s), nil
+}
+
+// generateCandidates returns n random store paths, sorted.
+func generateCandidates(rnd *rand.Rand, n int) []string {
+	candidates := make([]string, n)
+
+	for i := range candidates {
+		digest := make([]byte, storepath.PathHashSize)
+		rnd.Read(digest)
+
+		sp := storepath.StorePath{Name: fmt.Sprintf("pkg-%d", i), Digest: digest}
+		candidates[i] = sp.Absolute()
+	}
+
+	sort.Strings(candidates)
+
+	return candidates
+}
+
+// generateData returns size bytes of data, which is mostly random binary
+// or text data (depending on text), with some of the candidates sprinkled in.
+func generateData(rnd *rand.Rand, size int, text bool, candidates []string) []byte {
+	data := make([]byte, size)
+
+	if text {
+		const alphabet = "abcdefghijklmnopqrstuvwxyz     \n/.-_ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		for i := range data {
+			data[i] = alphabet[rnd.Intn(len(alphabet))]
+		}
+	} else {
+		rnd.Read(data)
+	}
+
+	for i := 0; i < 100; i++ {
+		copy(data[rnd.Intn(size-100):], candidates[rnd.Intn(len(candidates))])
+	}
+
+	return data
 }
 
 func BenchmarkReferences(b *testing.B) {
@@ -93,4 +309,94 @@ func BenchmarkReferences(b *testing.B) {
 			assert.Equal(b, c.Expected, refScanner.References())
 		})
 	}
+
+	// Scan 8 MiB of data against 20k candidates, with both implementations.
+	rnd := rand.New(rand.NewSource(42)) //nolint:gosec
+	candidates := generateCandidates(rnd, 20000)
+
+	for _, text := range []bool{false, true} {
+		kind := "Binary"
+		if text {
+			kind = "Text"
+		}
+
+		data := generateData(rnd, 8<<20, text, candidates)
+
+		b.Run(kind+"/Scanner", func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+
+			for i := 0; i < b.N; i++ {
+				refScanner, err := references.NewReferenceScanner(candidates)
+				if err != nil {
+					panic(err)
+				}
+
+				for off := 0; off < len(data); off += 64 << 10 {
+					_, _ = refScanner.Write(data[off : off+64<<10])
+				}
+			}
+		})
+
+		b.Run(kind+"/Legacy", func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+
+			hashes := make(map[string]string, len(candidates))
+			for _, c := range candidates {
+				hashes[c[len(storepath.StoreDir)+1:len(storepath.StoreDir)+33]] = c
+			}
+
+			for i := 0; i < b.N; i++ {
+				refScanner := &legacyReferenceScanner{hashes: hashes, hits: make(map[string]struct{})}
+
+				for off := 0; off < len(data); off += 64 << 10 {
+					_, _ = refScanner.Write(data[off : off+64<<10])
+				}
+			}
+		})
+	}
+}
+
+func TestReferencesRandomChunks(t *testing.T) {
+	// Write the same data in random chunk sizes, and ensure the result is always the same.
+	rnd := rand.New(rand.NewSource(23)) //nolint:gosec
+	candidates := generateCandidates(rnd, 1000)
+	data := generateData(rnd, 64<<10, true, candidates)
+
+	expected, err := references.NewReferenceScanner(candidates)
+	if err != nil {
+		panic(err)
+	}
+
+	expected.RecordHits()
+
+	_, _ = expected.Write(data)
+
+	assert.NotEmpty(t, expected.References())
+
+	for i := 0; i < 20; i++ {
+		refScanner, err := references.NewReferenceScanner(candidates)
+		if err != nil {
+			panic(err)
+		}
+
+		refScanner.RecordHits()
+
+		for off := 0; off < len(data); {
+			n := rnd.Intn(70)
+			if off+n > len(data) {
+				n = len(data) - off
+			}
+
+			_, _ = refScanner.Write(data[off : off+n])
+			off += n
+		}
+
+		assert.Equal(t, expected.References(), refScanner.References())
+		assert.Equal(t, expected.Hits(), refScanner.Hits())
+	}
+
+	// all hits need to point to the hash
+	for _, hit := range expected.Hits() {
+		assert.True(t, strings.HasPrefix(hit.StorePath[len(storepath.StoreDir)+1:], string(data[hit.Offset:hit.Offset+32])))
+	}
 }
@@ -0,0 +1,207 @@
+package wire
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// Decoder reads Go values in Nix wire format.
+// See Encoder for the layout of the supported types, and the struct tags.
+type Decoder struct {
+	r        io.Reader
+	maxBytes uint64
+}
+
+// NewDecoder returns a decoder reading from r.
+// Strings and bytes are limited to maxBytes, unless a field specifies
+// a different limit.
+func NewDecoder(r io.Reader, maxBytes uint64) *Decoder {
+	return &Decoder{
+		r:        r,
+		maxBytes: maxBytes,
+	}
+}
+
+// Decode reads into the value pointed to by v.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("unable to decode into non-pointer or nil %T", v)
+	}
+
+	return d.decode(rv.Elem(), fieldOpts{})
+}
+
+//nolint:cyclop,exhaustive
+func (d *Decoder) decode(v reflect.Value, opts fieldOpts) error {
+	t := v.Type()
+
+	maxBytes := d.maxBytes
+	if opts.maxBytes != 0 {
+		maxBytes = opts.maxBytes
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := ReadBool(d.r)
+		if err != nil {
+			return err
+		}
+
+		v.SetBool(b)
+
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := ReadUint64(d.r)
+		if err != nil {
+			return err
+		}
+
+		if v.OverflowUint(n) {
+			return fmt.Errorf("value %v overflows %v", n, t)
+		}
+
+		v.SetUint(n)
+
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := ReadUint64(d.r)
+		if err != nil {
+			return err
+		}
+
+		if n > math.MaxInt64 || v.OverflowInt(int64(n)) {
+			return fmt.Errorf("value %v overflows %v", n, t)
+		}
+
+		v.SetInt(int64(n))
+
+		return nil
+
+	case reflect.String:
+		s, err := ReadString(d.r, maxBytes)
+		if err != nil {
+			return err
+		}
+
+		v.SetString(s)
+
+		return nil
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			buf, err := ReadBytesFull(d.r, maxBytes)
+			if err != nil {
+				return err
+			}
+
+			v.SetBytes(buf)
+
+			return nil
+		}
+
+		if opts.set && t.Elem().Kind() != reflect.String {
+			return fmt.Errorf("unable to decode %v as set", t)
+		}
+
+		n, err := ReadUint64(d.r)
+		if err != nil {
+			return err
+		}
+
+		s := reflect.MakeSlice(t, 0, CapHint(n))
+
+		for i := uint64(0); i < n; i++ {
+			elem := reflect.New(t.Elem()).Elem()
+			if err := d.decode(elem, opts.elemOpts()); err != nil {
+				return err
+			}
+
+			s = reflect.Append(s, elem)
+		}
+
+		v.Set(s)
+
+		return nil
+
+	case reflect.Map:
+		if err := checkMapKey(t); err != nil {
+			return err
+		}
+
+		n, err := ReadUint64(d.r)
+		if err != nil {
+			return err
+		}
+
+		m := reflect.MakeMapWithSize(t, CapHint(n))
+
+		for i := uint64(0); i < n; i++ {
+			k := reflect.New(t.Key()).Elem()
+			if err := d.decode(k, opts.elemOpts()); err != nil {
+				return err
+			}
+
+			elem := reflect.New(t.Elem()).Elem()
+
+			if !isSet(t) {
+				if err := d.decode(elem, opts.elemOpts()); err != nil {
+					return err
+				}
+			}
+
+			m.SetMapIndex(k, elem)
+		}
+
+		v.Set(m)
+
+		return nil
+
+	case reflect.Ptr:
+		present, err := ReadBool(d.r)
+		if err != nil {
+			return err
+		}
+
+		if !present {
+			v.Set(reflect.Zero(t))
+
+			return nil
+		}
+
+		p := reflect.New(t.Elem())
+		if err := d.decode(p.Elem(), opts); err != nil {
+			return err
+		}
+
+		v.Set(p)
+
+		return nil
+
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			fieldOpts, ok, err := structField(t, i)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				continue
+			}
+
+			if err := d.decode(v.Field(i), fieldOpts); err != nil {
+				return fmt.Errorf("field %v: %w", t.Field(i).Name, err)
+			}
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported type %v", t)
+	}
+}
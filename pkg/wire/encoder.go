@@ -0,0 +1,173 @@
+package wire
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// Encoder writes Go values in the layout Nix uses for its protocol messages:
+//
+//   - bool is written as an uint64 field, 0 or 1.
+//   - All integer types are written as an uint64 field.
+//   - string and []byte are written as a bytes packet.
+//   - Other slices are written as lists: the number of elements, followed by
+//     the elements.
+//   - map[string]struct{} is written as a set: like a list of the keys, in
+//     sorted order.
+//   - Other maps with string keys are written as the number of entries,
+//     followed by all key-value pairs, in order of their keys.
+//   - Pointers are written as optional values: an uint64 field 0 for nil,
+//     or 1 followed by the value pointed to.
+//   - Structs are written as all their exported fields in order.
+//
+// Struct fields can be annotated with a `wire` tag, containing a
+// comma-separated list of options:
+//
+//   - "-" skips the field.
+//   - "set" writes a []string field as a set, in sorted order.
+//   - "maxbytes=N" limits strings and bytes (including those contained in the
+//     field) to N bytes when decoding, instead of the decoder's default.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes v. If v is a pointer, the value pointed to is written,
+// not an optional value.
+func (e *Encoder) Encode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return fmt.Errorf("unable to encode nil %T", v)
+		}
+
+		rv = rv.Elem()
+	}
+
+	return e.encode(rv, fieldOpts{})
+}
+
+//nolint:cyclop,exhaustive
+func (e *Encoder) encode(v reflect.Value, opts fieldOpts) error {
+	t := v.Type()
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return WriteBool(e.w, v.Bool())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return WriteUint64(e.w, v.Uint())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		if n < 0 {
+			return fmt.Errorf("unable to encode negative value %v", n)
+		}
+
+		return WriteUint64(e.w, uint64(n))
+
+	case reflect.String:
+		return WriteString(e.w, v.String())
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return WriteBytes(e.w, v.Bytes())
+		}
+
+		if opts.set {
+			if t.Elem().Kind() != reflect.String {
+				return fmt.Errorf("unable to encode %v as set", t)
+			}
+
+			strs := make([]string, v.Len())
+			for i := range strs {
+				strs[i] = v.Index(i).String()
+			}
+
+			sort.Strings(strs)
+
+			v = reflect.ValueOf(strs)
+		}
+
+		if err := WriteUint64(e.w, uint64(v.Len())); err != nil {
+			return err
+		}
+
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i), opts.elemOpts()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case reflect.Map:
+		if err := checkMapKey(t); err != nil {
+			return err
+		}
+
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		if err := WriteUint64(e.w, uint64(len(keys))); err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := e.encode(k, opts.elemOpts()); err != nil {
+				return err
+			}
+
+			if isSet(t) {
+				continue
+			}
+
+			if err := e.encode(v.MapIndex(k), opts.elemOpts()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return WriteBool(e.w, false)
+		}
+
+		if err := WriteBool(e.w, true); err != nil {
+			return err
+		}
+
+		return e.encode(v.Elem(), opts)
+
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			fieldOpts, ok, err := structField(t, i)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				continue
+			}
+
+			if err := e.encode(v.Field(i), fieldOpts); err != nil {
+				return fmt.Errorf("field %v: %w", t.Field(i).Name, err)
+			}
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported type %v", t)
+	}
+}
@@ -0,0 +1,94 @@
+package wire
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// fieldOpts are the options parsed from a struct field's `wire` tag.
+type fieldOpts struct {
+	skip     bool
+	set      bool
+	maxBytes uint64 // 0 means the decoder's default
+}
+
+func parseTag(tag string) (fieldOpts, error) {
+	var opts fieldOpts
+
+	if tag == "" {
+		return opts, nil
+	}
+
+	if tag == "-" {
+		opts.skip = true
+
+		return opts, nil
+	}
+
+	for _, opt := range strings.Split(tag, ",") {
+		switch {
+		case opt == "set":
+			opts.set = true
+		case strings.HasPrefix(opt, "maxbytes="):
+			n, err := strconv.ParseUint(strings.TrimPrefix(opt, "maxbytes="), 10, 64)
+			if err != nil || n == 0 {
+				return opts, fmt.Errorf("invalid maxbytes option: %v", opt)
+			}
+
+			opts.maxBytes = n
+		default:
+			return opts, fmt.Errorf("unknown option: %v", opt)
+		}
+	}
+
+	return opts, nil
+}
+
+// structField returns the options for the i-th field of the struct type t,
+// and whether it should be serialized at all.
+func structField(t reflect.Type, i int) (fieldOpts, bool, error) {
+	f := t.Field(i)
+
+	// skip unexported fields
+	if f.PkgPath != "" {
+		return fieldOpts{}, false, nil
+	}
+
+	opts, err := parseTag(f.Tag.Get("wire"))
+	if err != nil {
+		return opts, false, fmt.Errorf("field %v: %w", f.Name, err)
+	}
+
+	return opts, !opts.skip, nil
+}
+
+// isSet returns true if t is a map serialized as a set.
+func isSet(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0
+}
+
+// checkMapKey ensures t is a map with keys we can serialize in a defined order.
+func checkMapKey(t reflect.Type) error {
+	if t.Key().Kind() != reflect.String {
+		return fmt.Errorf("unsupported map key type %v", t.Key())
+	}
+
+	return nil
+}
+
+// elemOpts returns the options applying to elements contained in a field.
+func (opts fieldOpts) elemOpts() fieldOpts {
+	return fieldOpts{maxBytes: opts.maxBytes}
+}
+
+// CapHint returns a capacity to allocate for a list of n elements read from
+// the wire, without trusting the other side too much.
+func CapHint(n uint64) int {
+	if n > 1024 {
+		return 1024
+	}
+
+	return int(n)
+}
@@ -0,0 +1,197 @@
+package wire_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testBuildResult struct {
+	Status   uint8
+	ErrorMsg string
+	Success  bool
+}
+
+type testMessage struct {
+	Name       string
+	Count      int
+	References []string
+	Sigs       []string `wire:"set"`
+	Outputs    map[string]struct{}
+	Env        map[string]string
+	Deriver    *string
+	Result     *testBuildResult
+	Nested     testBuildResult
+	Data       []byte `wire:"maxbytes=16"`
+	Ignored    string `wire:"-"`
+	unexported string
+}
+
+//nolint:gochecknoglobals
+var (
+	wireStringBar = []byte{
+		3, 0, 0, 0, 0, 0, 0, 0, // length field - 3 bytes
+		'B', 'a', 'r', 0, 0, 0, 0, 0, // contents, Bar, then 5 bytes padding
+	}
+
+	wireStringEmpty = []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, // length field - 0 bytes
+	}
+)
+
+// concat concatenates byte slices, to assemble fixtures.
+func concat(bs ...[]byte) []byte {
+	return bytes.Join(bs, nil)
+}
+
+func genTestMessage() *testMessage {
+	deriver := "Bar"
+
+	return &testMessage{
+		Name:       "Foo",
+		Count:      2,
+		References: []string{"Foo", "Bar"},
+		Sigs:       []string{"Foo", "Bar"},
+		Outputs:    map[string]struct{}{"Foo": {}, "Bar": {}},
+		Env:        map[string]string{"Foo": "Bar", "Bar": ""},
+		Deriver:    &deriver,
+		Result:     nil,
+		Nested:     testBuildResult{Status: 1, ErrorMsg: "Foo", Success: true},
+		Data:       contents10Bytes,
+	}
+}
+
+//nolint:gochecknoglobals
+var wireTestMessage = concat(
+	wireStringFoo,                  // Name
+	[]byte{2, 0, 0, 0, 0, 0, 0, 0}, // Count
+	[]byte{2, 0, 0, 0, 0, 0, 0, 0}, // References: 2 elements,
+	wireStringFoo, wireStringBar,   // in order
+	[]byte{2, 0, 0, 0, 0, 0, 0, 0}, // Sigs: 2 elements,
+	wireStringBar, wireStringFoo,   // sorted
+	[]byte{2, 0, 0, 0, 0, 0, 0, 0}, // Outputs: 2 elements,
+	wireStringBar, wireStringFoo,   // sorted
+	[]byte{2, 0, 0, 0, 0, 0, 0, 0}, // Env: 2 entries, sorted by key
+	wireStringBar, wireStringEmpty, // Bar=
+	wireStringFoo, wireStringBar, // Foo=Bar
+	wireBytesTrue, wireStringBar, // Deriver: present, Bar
+	wireBytesFalse,                 // Result: not present
+	[]byte{1, 0, 0, 0, 0, 0, 0, 0}, // Nested.Status
+	wireStringFoo,                  // Nested.ErrorMsg
+	wireBytesTrue,                  // Nested.Success
+	wire10Bytes,                    // Data
+)
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+
+	msg := genTestMessage()
+	msg.Ignored = "ignored"
+	msg.unexported = "unexported"
+
+	require.NoError(t, wire.NewEncoder(&buf).Encode(msg))
+	assert.Equal(t, wireTestMessage, buf.Bytes())
+
+	t.Run("values", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		enc := wire.NewEncoder(&buf)
+
+		require.NoError(t, enc.Encode(true))
+		require.NoError(t, enc.Encode(uint64(8)))
+		require.NoError(t, enc.Encode("Foo"))
+		require.NoError(t, enc.Encode(contents8Bytes))
+		require.NoError(t, enc.Encode([]string{}))
+
+		assert.Equal(t, concat(
+			wireBytesTrue,
+			[]byte{8, 0, 0, 0, 0, 0, 0, 0},
+			wireStringFoo,
+			wire8Bytes,
+			wireBytesFalse,
+		), buf.Bytes())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		enc := wire.NewEncoder(io.Discard)
+
+		assert.ErrorContains(t, enc.Encode(-1), "negative")
+		assert.ErrorContains(t, enc.Encode(1.5), "unsupported type")
+		assert.ErrorContains(t, enc.Encode(map[int]string{}), "unsupported map key type")
+		assert.ErrorContains(t, enc.Encode((*testMessage)(nil)), "nil")
+		assert.ErrorContains(t, enc.Encode(struct {
+			Foo []int `wire:"set"`
+		}{}), "field Foo")
+		assert.ErrorContains(t, enc.Encode(struct {
+			Foo string `wire:"bar"`
+		}{}), "unknown option")
+	})
+}
+
+func TestDecoder(t *testing.T) {
+	var msg testMessage
+
+	require.NoError(t, wire.NewDecoder(bytes.NewReader(wireTestMessage), 1024).Decode(&msg))
+
+	expected := genTestMessage()
+	expected.Sigs = []string{"Bar", "Foo"}
+
+	assert.Equal(t, expected, &msg)
+
+	t.Run("roundtrip", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		require.NoError(t, wire.NewEncoder(&buf).Encode(msg))
+		assert.Equal(t, wireTestMessage, buf.Bytes())
+	})
+
+	t.Run("maxBytes", func(t *testing.T) {
+		var msg testMessage
+
+		// strings are limited by the decoder
+		err := wire.NewDecoder(bytes.NewReader(wireTestMessage), 2).Decode(&msg)
+		assert.ErrorContains(t, err, "field Name: content length of 3 bytes exceeds maximum of 2 bytes")
+
+		// Data is limited by its tag
+		data := concat(wireTestMessage[:len(wireTestMessage)-len(wire10Bytes)], []byte{
+			17, 0, 0, 0, 0, 0, 0, 0,
+		})
+
+		err = wire.NewDecoder(bytes.NewReader(data), 1024).Decode(&msg)
+		assert.ErrorContains(t, err, "field Data: content length of 17 bytes exceeds maximum of 16 bytes")
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		for i := 0; i < len(wireTestMessage); i += 8 {
+			var msg testMessage
+
+			err := wire.NewDecoder(bytes.NewReader(wireTestMessage[:i]), 1024).Decode(&msg)
+			assert.Error(t, err, "decoding %d bytes should fail", i)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		var (
+			b   bool
+			u8  uint8
+			i   int
+			msg testMessage
+		)
+
+		dec := wire.NewDecoder(bytes.NewReader(wireBytesInvalidBool), 1024)
+		assert.ErrorContains(t, dec.Decode(&b), "invalid value for boolean")
+
+		dec = wire.NewDecoder(bytes.NewReader([]byte{0, 1, 0, 0, 0, 0, 0, 0}), 1024)
+		assert.ErrorContains(t, dec.Decode(&u8), "overflows uint8")
+
+		dec = wire.NewDecoder(bytes.NewReader([]byte{0, 0, 0, 0, 0, 0, 0, 0xff}), 1024)
+		assert.ErrorContains(t, dec.Decode(&i), "overflows int")
+
+		dec = wire.NewDecoder(bytes.NewReader(wireTestMessage), 1024)
+		assert.ErrorContains(t, dec.Decode(msg), "non-pointer")
+	})
+}
@@ -0,0 +1,106 @@
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// FramedReader implements io.ReadCloser.
+var _ io.ReadCloser = &FramedReader{}
+
+// FramedReader implements reading framed data.
+// Framed data is a sequence of frames, each consisting of a length field
+// and that many bytes (without padding), terminated by an empty frame.
+// Reading returns io.EOF once the terminating frame has been read.
+// Closing the reader will skip over all remaining frames.
+type FramedReader struct {
+	r         io.Reader
+	maxBytes  uint64 // the maximum number of bytes accepted in total
+	bytesRead uint64 // the total number of bytes read so far (without length fields)
+	remaining uint64 // the number of bytes left in the current frame
+	err       error  // sticky error, io.EOF once the terminating frame has been read
+}
+
+// NewFramedReader constructs a reader of framed data.
+// A maximum number of bytes in total can be specified in maxBytes.
+func NewFramedReader(r io.Reader, maxBytes uint64) *FramedReader {
+	return &FramedReader{
+		r:        r,
+		maxBytes: maxBytes,
+	}
+}
+
+// nextFrame reads the length field of the next non-empty frame.
+func (fr *FramedReader) nextFrame() error {
+	n, err := ReadUint64(fr.r)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	if n == 0 {
+		return io.EOF
+	}
+
+	if n > fr.maxBytes-fr.bytesRead {
+		return fmt.Errorf("framed data exceeds maximum of %v bytes", fr.maxBytes)
+	}
+
+	fr.remaining = n
+
+	return nil
+}
+
+// Read reads from the current frame, or the next one if the current frame has been read.
+func (fr *FramedReader) Read(b []byte) (int, error) {
+	if fr.err != nil {
+		return 0, fr.err
+	}
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	if fr.remaining == 0 {
+		if fr.err = fr.nextFrame(); fr.err != nil {
+			return 0, fr.err
+		}
+	}
+
+	if uint64(len(b)) > fr.remaining {
+		b = b[:fr.remaining]
+	}
+
+	n, err := fr.r.Read(b)
+	fr.remaining -= uint64(n) //nolint:gosec
+	fr.bytesRead += uint64(n) //nolint:gosec
+
+	if err != nil {
+		// the underlying reader ending inside a frame is unexpected.
+		if err == io.EOF {
+			if fr.remaining == 0 {
+				// but it might have returned io.EOF together with the last bytes.
+				// We'll find out when reading the next length field.
+				return n, nil
+			}
+
+			err = io.ErrUnexpectedEOF
+		}
+
+		fr.err = err
+	}
+
+	return n, err
+}
+
+// Close skips over all remaining frames, including the terminating one.
+// It returns an error if the data is malformed, or reading failed.
+// It's fine to not close, in case you don't want to seek to the end.
+func (fr *FramedReader) Close() error {
+	_, err := io.Copy(io.Discard, fr)
+
+	return err
+}
@@ -0,0 +1,303 @@
+package wire_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nolint:gochecknoglobals
+var wireFramedHelloWorld = []byte{
+	4, 0, 0, 0, 0, 0, 0, 0, // length field - 4 bytes
+	'h', 'e', 'l', 'l',
+	4, 0, 0, 0, 0, 0, 0, 0, // length field - 4 bytes
+	'o', 'w', 'o', 'r',
+	3, 0, 0, 0, 0, 0, 0, 0, // length field - 3 bytes
+	'l', 'd', '!',
+	0, 0, 0, 0, 0, 0, 0, 0, // terminating empty frame
+}
+
+// errWriter fails once more than n bytes have been written.
+type errWriter struct {
+	n int
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+
+		return n, errors.New("write failed")
+	}
+
+	w.n -= len(p)
+
+	return len(p), nil
+}
+
+func TestFramedWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	fw := wire.NewFramedWriter(&buf, 4)
+
+	n, err := fw.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	n, err = fw.Write([]byte("world!"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+
+	assert.NoError(t, fw.Close())
+	assert.Equal(t, wireFramedHelloWorld, buf.Bytes())
+
+	// closing twice is fine
+	assert.NoError(t, fw.Close())
+
+	// writing after closing is not
+	_, err = fw.Write([]byte("foo"))
+	assert.Error(t, err)
+
+	t.Run("empty", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		fw := wire.NewFramedWriter(&buf, 0)
+		assert.NoError(t, fw.Close())
+		assert.Equal(t, wireBytesFalse, buf.Bytes())
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		fw := wire.NewFramedWriter(&errWriter{n: 10}, 4)
+
+		_, err := fw.Write([]byte("hello world!"))
+		assert.ErrorContains(t, err, "write failed")
+
+		// errors are sticky
+		_, err = fw.Write([]byte("foo"))
+		assert.ErrorContains(t, err, "write failed")
+		assert.ErrorContains(t, fw.Close(), "write failed")
+	})
+}
+
+func TestFramedReader(t *testing.T) {
+	fr := wire.NewFramedReader(bytes.NewReader(wireFramedHelloWorld), math.MaxUint64)
+
+	contents, err := io.ReadAll(fr)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("helloworld!"), contents)
+
+	// subsequent reads keep returning io.EOF
+	n, err := fr.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+
+	assert.NoError(t, fr.Close())
+
+	t.Run("slow reader", func(t *testing.T) {
+		hr := &hesitantReader{}
+		for _, b := range wireFramedHelloWorld {
+			hr.data = append(hr.data, []byte{b})
+		}
+
+		contents, err := io.ReadAll(wire.NewFramedReader(hr, math.MaxUint64))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("helloworld!"), contents)
+	})
+
+	t.Run("trailing data", func(t *testing.T) {
+		r := bytes.NewReader(append(append([]byte{}, wireFramedHelloWorld...), wireStringFoo...))
+
+		fr := wire.NewFramedReader(r, math.MaxUint64)
+		assert.NoError(t, fr.Close())
+
+		// the underlying reader is positioned right after the framed data
+		s, err := wire.ReadString(r, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, "Foo", s)
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		readErr := errors.New("read failed")
+		fr := wire.NewFramedReader(io.MultiReader(
+			bytes.NewReader(wireFramedHelloWorld[:10]),
+			&errorReader{readErr},
+		), math.MaxUint64)
+
+		_, err := io.ReadAll(fr)
+		assert.ErrorIs(t, err, readErr)
+
+		// errors are sticky
+		_, err = fr.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, readErr)
+		assert.ErrorIs(t, fr.Close(), readErr)
+	})
+}
+
+// errorReader always returns err.
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestFramedReaderMalformed(t *testing.T) {
+	cases := []struct {
+		Title    string
+		Data     []byte
+		MaxBytes uint64
+		Err      error
+		ErrMsg   string
+	}{
+		{
+			Title: "empty",
+			Data:  []byte{},
+			Err:   io.ErrUnexpectedEOF,
+		},
+		{
+			Title: "truncated length",
+			Data:  wireFramedHelloWorld[:4],
+			Err:   io.ErrUnexpectedEOF,
+		},
+		{
+			Title: "truncated frame",
+			Data:  wireFramedHelloWorld[:10],
+			Err:   io.ErrUnexpectedEOF,
+		},
+		{
+			Title: "missing terminator",
+			Data:  wireFramedHelloWorld[:len(wireFramedHelloWorld)-8],
+			Err:   io.ErrUnexpectedEOF,
+		},
+		{
+			Title:    "exceeds maximum",
+			Data:     wireFramedHelloWorld,
+			MaxBytes: 10,
+			ErrMsg:   "exceeds maximum of 10 bytes",
+		},
+		{
+			Title:    "huge frame",
+			Data:     []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'a'},
+			MaxBytes: 1 << 20,
+			ErrMsg:   "exceeds maximum",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			maxBytes := c.MaxBytes
+			if maxBytes == 0 {
+				maxBytes = math.MaxUint64
+			}
+
+			fr := wire.NewFramedReader(bytes.NewReader(c.Data), maxBytes)
+
+			contents, err := io.ReadAll(fr)
+			if c.Err != nil {
+				assert.ErrorIs(t, err, c.Err)
+			} else {
+				assert.ErrorContains(t, err, c.ErrMsg)
+			}
+
+			assert.LessOrEqual(t, uint64(len(contents)), maxBytes)
+
+			// Close returns the same error
+			assert.Equal(t, err, fr.Close())
+		})
+	}
+}
+
+// TestFramedRoundtrip writes random data with random frame and write sizes,
+// and reads it back with random read sizes.
+func TestFramedRoundtrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42)) //nolint:gosec
+
+	for i := 0; i < 100; i++ {
+		data := make([]byte, rnd.Intn(1<<16))
+		rnd.Read(data)
+
+		var buf bytes.Buffer
+
+		fw := wire.NewFramedWriter(&buf, 1+rnd.Intn(1<<12))
+
+		for p := data; len(p) > 0; {
+			n := rnd.Intn(len(p) + 1)
+
+			_, err := fw.Write(p[:n])
+			require.NoError(t, err)
+
+			p = p[n:]
+		}
+
+		require.NoError(t, fw.Close())
+
+		fr := wire.NewFramedReader(&buf, uint64(len(data)))
+
+		var contents []byte
+
+		for {
+			p := make([]byte, rnd.Intn(1<<12))
+
+			n, err := fr.Read(p)
+			contents = append(contents, p[:n]...)
+
+			if err == io.EOF {
+				break
+			}
+
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, data, contents)
+		assert.Equal(t, 0, buf.Len(), "all data should be consumed")
+	}
+}
+
+// FuzzFramedReader ensures the reader doesn't panic or exceed the maximum on arbitrary
+// input, and that everything it accepts survives a round trip through the writer.
+func FuzzFramedReader(f *testing.F) {
+	f.Add(wireFramedHelloWorld, uint64(100), 3)
+	f.Add(wireFramedHelloWorld[:20], uint64(100), 1)
+	f.Add(wireBytesFalse, uint64(0), 0)
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(1), 8)
+	f.Add(append(append([]byte{}, wire10Bytes...), wireBytesFalse...), uint64(10), 7)
+
+	f.Fuzz(func(t *testing.T, data []byte, maxBytes uint64, frameSize int) {
+		contents, err := io.ReadAll(wire.NewFramedReader(bytes.NewReader(data), maxBytes))
+		if uint64(len(contents)) > maxBytes {
+			t.Fatalf("read %d bytes, exceeding the maximum of %d", len(contents), maxBytes)
+		}
+
+		if err != nil {
+			return
+		}
+
+		var buf bytes.Buffer
+
+		fw := wire.NewFramedWriter(&buf, frameSize%(1<<16))
+		if _, err := fw.Write(contents); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		roundtripped, err := io.ReadAll(wire.NewFramedReader(&buf, maxBytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(contents, roundtripped) {
+			t.Fatalf("round trip mismatch")
+		}
+	})
+}
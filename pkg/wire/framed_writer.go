@@ -0,0 +1,123 @@
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// DefaultFrameSize is the frame size used by FramedWriter if none is specified.
+const DefaultFrameSize = 32 << 10
+
+var _ io.WriteCloser = &FramedWriter{}
+
+// FramedWriter implements writing framed data, see FramedReader for its structure.
+// Writes are buffered, and sent as frames of (up to) the configured frame size.
+// On Close(), the remaining data and the terminating empty frame is written.
+type FramedWriter struct {
+	w      io.Writer
+	buf    []byte // data not written yet, up to the frame size
+	err    error  // sticky error
+	closed bool
+}
+
+// NewFramedWriter constructs a writer of framed data.
+// If frameSize is 0, DefaultFrameSize is used.
+func NewFramedWriter(w io.Writer, frameSize int) *FramedWriter {
+	if frameSize <= 0 {
+		frameSize = DefaultFrameSize
+	}
+
+	return &FramedWriter{
+		w:   w,
+		buf: make([]byte, 0, frameSize),
+	}
+}
+
+// writeFrame writes a single frame.
+func (fw *FramedWriter) writeFrame(p []byte) error {
+	if err := WriteUint64(fw.w, uint64(len(p))); err != nil {
+		return err
+	}
+
+	_, err := fw.w.Write(p)
+
+	return err
+}
+
+// Write buffers p, and writes all full frames.
+func (fw *FramedWriter) Write(p []byte) (int, error) {
+	if fw.err != nil {
+		return 0, fw.err
+	}
+
+	frameSize := cap(fw.buf)
+	written := 0
+
+	for len(p) > 0 {
+		// write full frames directly, if there's nothing buffered.
+		if len(fw.buf) == 0 && len(p) >= frameSize {
+			if fw.err = fw.writeFrame(p[:frameSize]); fw.err != nil {
+				return written, fw.err
+			}
+
+			p = p[frameSize:]
+			written += frameSize
+
+			continue
+		}
+
+		n := copy(fw.buf[len(fw.buf):frameSize], p)
+		fw.buf = fw.buf[:len(fw.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(fw.buf) == frameSize {
+			if fw.err = fw.writeFrame(fw.buf); fw.err != nil {
+				return written, fw.err
+			}
+
+			fw.buf = fw.buf[:0]
+		}
+	}
+
+	return written, nil
+}
+
+// Flush writes all buffered data as a frame.
+func (fw *FramedWriter) Flush() error {
+	if fw.err != nil {
+		return fw.err
+	}
+
+	if len(fw.buf) > 0 {
+		if fw.err = fw.writeFrame(fw.buf); fw.err != nil {
+			return fw.err
+		}
+
+		fw.buf = fw.buf[:0]
+	}
+
+	return nil
+}
+
+// Close writes all buffered data, then the terminating empty frame.
+// It doesn't close the underlying writer.
+func (fw *FramedWriter) Close() error {
+	// if we already closed once, don't close again
+	if fw.closed {
+		return nil
+	}
+
+	if err := fw.Flush(); err != nil {
+		return err
+	}
+
+	if fw.err = WriteUint64(fw.w, 0); fw.err != nil {
+		return fw.err
+	}
+
+	fw.closed = true
+	fw.err = fmt.Errorf("framed writer already closed")
+
+	return nil
+}